@@ -0,0 +1,274 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"kafka_test/admin"
+
+	"github.com/Shopify/sarama"
+)
+
+// Binding is one ACL: a principal allowed or denied an operation on a resource
+type Binding struct {
+	Principal    string `json:"principal" yaml:"principal"`
+	Host         string `json:"host" yaml:"host"`
+	ResourceType string `json:"resource_type" yaml:"resource_type"`
+	ResourceName string `json:"resource_name" yaml:"resource_name"`
+	PatternType  string `json:"pattern_type" yaml:"pattern_type"`
+	Operation    string `json:"operation" yaml:"operation"`
+	Permission   string `json:"permission" yaml:"permission"`
+}
+
+// String returns the binding in a form close to the Kafka CLI output
+func (b Binding) String() string {
+	return fmt.Sprintf("%s %s %s from %s on %s:%s:%s",
+		b.Principal, b.Permission, b.Operation, b.Host, b.ResourceType, b.PatternType, b.ResourceName)
+}
+
+// key identifies a binding regardless of the case of its enum names
+func (b Binding) key() string {
+	return strings.ToLower(strings.Join([]string{
+		b.Principal, b.Host, b.ResourceType, b.ResourceName, b.PatternType, b.Operation, b.Permission,
+	}, "\x00"))
+}
+
+// Bindings is a sorted list of ACLs
+type Bindings struct {
+	Acls []Binding `json:"acls" yaml:"acls"`
+}
+
+// Filter selects ACLs; empty fields match anything
+type Filter struct {
+	Principal    string
+	Host         string
+	ResourceType string
+	ResourceName string
+	PatternType  string
+	Operation    string
+	Permission   string
+}
+
+// aclFilter converts the filter to its sarama form
+func (f Filter) aclFilter() (sarama.AclFilter, error) {
+	filter := sarama.AclFilter{
+		ResourceType:              sarama.AclResourceAny,
+		ResourcePatternTypeFilter: sarama.AclPatternAny,
+		Operation:                 sarama.AclOperationAny,
+		PermissionType:            sarama.AclPermissionAny,
+	}
+	if f.Principal != "" {
+		filter.Principal = &f.Principal
+	}
+	if f.Host != "" {
+		filter.Host = &f.Host
+	}
+	if f.ResourceName != "" {
+		filter.ResourceName = &f.ResourceName
+	}
+	if err := unmarshalIfSet(&filter.ResourceType, f.ResourceType); err != nil {
+		return filter, err
+	}
+	if err := unmarshalIfSet(&filter.ResourcePatternTypeFilter, f.PatternType); err != nil {
+		return filter, err
+	}
+	if err := unmarshalIfSet(&filter.Operation, f.Operation); err != nil {
+		return filter, err
+	}
+	if err := unmarshalIfSet(&filter.PermissionType, f.Permission); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// exactFilter returns the filter matching only this binding
+func (b Binding) exactFilter() Filter {
+	return Filter{
+		Principal:    b.Principal,
+		Host:         b.Host,
+		ResourceType: b.ResourceType,
+		ResourceName: b.ResourceName,
+		PatternType:  b.PatternType,
+		Operation:    b.Operation,
+		Permission:   b.Permission,
+	}
+}
+
+type textUnmarshaler interface {
+	UnmarshalText(text []byte) error
+}
+
+func unmarshalIfSet(target textUnmarshaler, value string) error {
+	if value == "" {
+		return nil
+	}
+	return target.UnmarshalText([]byte(value))
+}
+
+// Normalize validates the enum names of a binding and rewrites them in their
+// canonical case, defaulting the host to "*", the pattern to Literal and the
+// permission to Allow
+func (b *Binding) Normalize() error {
+	if b.Principal == "" || b.ResourceType == "" || b.Operation == "" {
+		return fmt.Errorf("acl needs a principal, a resource type and an operation: %s", b)
+	}
+	if b.Host == "" {
+		b.Host = "*"
+	}
+	if b.PatternType == "" {
+		b.PatternType = "Literal"
+	}
+	if b.Permission == "" {
+		b.Permission = "Allow"
+	}
+	if b.ResourceName == "" && strings.EqualFold(b.ResourceType, "cluster") {
+		b.ResourceName = "kafka-cluster"
+	}
+	if b.ResourceName == "" {
+		return fmt.Errorf("acl needs a resource name: %s", b)
+	}
+
+	resource, acl, err := b.parse()
+	if err != nil {
+		return err
+	}
+	if resource.ResourcePatternType != sarama.AclPatternLiteral && resource.ResourcePatternType != sarama.AclPatternPrefixed {
+		return fmt.Errorf("acl pattern type must be Literal or Prefixed: %s", b)
+	}
+	*b = newBinding(acl.Principal, acl.Host, resource.ResourceType, resource.ResourceName,
+		resource.ResourcePatternType, acl.Operation, acl.PermissionType)
+	return nil
+}
+
+// parse converts the binding to its sarama form
+func (b Binding) parse() (sarama.Resource, *sarama.Acl, error) {
+	resource := sarama.Resource{ResourceName: b.ResourceName}
+	acl := &sarama.Acl{Principal: b.Principal, Host: b.Host}
+	if err := resource.ResourceType.UnmarshalText([]byte(b.ResourceType)); err != nil {
+		return resource, nil, err
+	}
+	if err := resource.ResourcePatternType.UnmarshalText([]byte(b.PatternType)); err != nil {
+		return resource, nil, err
+	}
+	if err := acl.Operation.UnmarshalText([]byte(b.Operation)); err != nil {
+		return resource, nil, err
+	}
+	if err := acl.PermissionType.UnmarshalText([]byte(b.Permission)); err != nil {
+		return resource, nil, err
+	}
+	return resource, acl, nil
+}
+
+func newBinding(principal, host string, resourceType sarama.AclResourceType, name string,
+	patternType sarama.AclResourcePatternType, operation sarama.AclOperation, permission sarama.AclPermissionType) Binding {
+	return Binding{
+		Principal:    principal,
+		Host:         host,
+		ResourceType: resourceType.String(),
+		ResourceName: name,
+		PatternType:  patternType.String(),
+		Operation:    operation.String(),
+		Permission:   permission.String(),
+	}
+}
+
+// List returns the ACLs matching the filter, sorted by principal and resource
+func List(conn *admin.Conn, f Filter) (*Bindings, error) {
+	filter, err := f.aclFilter()
+	if err != nil {
+		return nil, err
+	}
+	resources, err := conn.Admin.ListAcls(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list acls: %w", err)
+	}
+
+	bindings := &Bindings{Acls: []Binding{}}
+	for _, resource := range resources {
+		for _, acl := range resource.Acls {
+			bindings.Acls = append(bindings.Acls, newBinding(acl.Principal, acl.Host, resource.ResourceType,
+				resource.ResourceName, resource.ResourcePatternType, acl.Operation, acl.PermissionType))
+		}
+	}
+	sortBindings(bindings.Acls)
+	return bindings, nil
+}
+
+// Create creates the given ACLs
+func Create(conn *admin.Conn, bindings []Binding) error {
+	if len(bindings) == 0 {
+		return nil
+	}
+
+	var resources []*sarama.ResourceAcls
+	for _, binding := range bindings {
+		resource, acl, err := binding.parse()
+		if err != nil {
+			return err
+		}
+		resources = append(resources, &sarama.ResourceAcls{Resource: resource, Acls: []*sarama.Acl{acl}})
+	}
+
+	if err := conn.Admin.CreateACLs(resources); err != nil {
+		return fmt.Errorf("failed to create acls: %w", err)
+	}
+	return nil
+}
+
+// Delete deletes the ACLs matching the filter and returns them
+func Delete(conn *admin.Conn, f Filter) (*Bindings, error) {
+	filter, err := f.aclFilter()
+	if err != nil {
+		return nil, err
+	}
+	matches, err := conn.Admin.DeleteACL(filter, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete acls: %w", err)
+	}
+
+	deleted := &Bindings{Acls: []Binding{}}
+	for _, match := range matches {
+		if match.Err != sarama.ErrNoError {
+			return nil, fmt.Errorf("failed to delete acl: %w", match.Err)
+		}
+		deleted.Acls = append(deleted.Acls, newBinding(match.Principal, match.Host, match.ResourceType,
+			match.ResourceName, match.ResourcePatternType, match.Operation, match.PermissionType))
+	}
+	sortBindings(deleted.Acls)
+	return deleted, nil
+}
+
+// WriteTable prints one line per ACL
+func (b *Bindings) WriteTable(tw *tabwriter.Writer) {
+	if len(b.Acls) == 0 {
+		fmt.Fprintln(tw, "No ACLs found.")
+		return
+	}
+	admin.Row(tw, "PRINCIPAL", "HOST", "RESOURCE TYPE", "PATTERN", "RESOURCE", "OPERATION", "PERMISSION")
+	for _, binding := range b.Acls {
+		admin.Row(tw, binding.Principal, binding.Host, binding.ResourceType, binding.PatternType,
+			binding.ResourceName, binding.Operation, binding.Permission)
+	}
+}
+
+func sortBindings(bindings []Binding) {
+	sort.Slice(bindings, func(i, j int) bool {
+		a, b := bindings[i], bindings[j]
+		for _, pair := range [][2]string{
+			{a.Principal, b.Principal},
+			{a.ResourceType, b.ResourceType},
+			{a.ResourceName, b.ResourceName},
+			{a.PatternType, b.PatternType},
+			{a.Operation, b.Operation},
+			{a.Permission, b.Permission},
+			{a.Host, b.Host},
+		} {
+			if pair[0] != pair[1] {
+				return pair[0] < pair[1]
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,209 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"kafka_test/admin"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrDestructive is returned when applying a plan that removes ACLs without allowing it
+var ErrDestructive = errors.New("plan removes acls")
+
+// PrincipalSpec lists the rights of one principal. Resource names ending in
+// "*" are prefixed patterns, e.g. "cdc-*"; a single "*" matches every resource.
+type PrincipalSpec struct {
+	// Host restricts the ACLs to one client host, "*" by default
+	Host string `yaml:"host"`
+	// Read grants Read and Describe on topics, for consumers
+	Read []string `yaml:"read"`
+	// Write grants Write and Describe on topics, for producers
+	Write []string `yaml:"write"`
+	// Groups grants Read on consumer groups
+	Groups []string `yaml:"groups"`
+	// TransactionalIDs grants Write and Describe on transactional IDs
+	TransactionalIDs []string `yaml:"transactional_ids"`
+	// Acls lists further ACLs verbatim; their principal defaults to this one
+	Acls []Binding `yaml:"acls"`
+}
+
+// Spec is the desired set of ACLs by principal, e.g. "User:change-data-consumer".
+// ACLs of principals not in the spec are left alone.
+type Spec struct {
+	Principals map[string]PrincipalSpec `yaml:"principals"`
+}
+
+// LoadSpec reads an ACL spec file
+func LoadSpec(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read acl spec: %w", err)
+	}
+
+	var spec Spec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse acl spec %s: %w", path, err)
+	}
+	for principal := range spec.Principals {
+		if !strings.Contains(principal, ":") {
+			return nil, fmt.Errorf("invalid acl spec %s: principal %q must have a type, e.g. User:%s", path, principal, principal)
+		}
+	}
+	return &spec, nil
+}
+
+// Bindings expands the spec into normalized ACLs
+func (s *Spec) Bindings() ([]Binding, error) {
+	var bindings []Binding
+	for principal, rights := range s.Principals {
+		host := rights.Host
+		if host == "" {
+			host = "*"
+		}
+
+		expand := func(resourceType string, names []string, operations ...string) {
+			for _, name := range names {
+				pattern := "Literal"
+				if name != "*" && strings.HasSuffix(name, "*") {
+					pattern, name = "Prefixed", strings.TrimSuffix(name, "*")
+				}
+				for _, operation := range operations {
+					bindings = append(bindings, Binding{
+						Principal:    principal,
+						Host:         host,
+						ResourceType: resourceType,
+						ResourceName: name,
+						PatternType:  pattern,
+						Operation:    operation,
+						Permission:   "Allow",
+					})
+				}
+			}
+		}
+		expand("Topic", rights.Read, "Read", "Describe")
+		expand("Topic", rights.Write, "Write", "Describe")
+		expand("Group", rights.Groups, "Read")
+		expand("TransactionalID", rights.TransactionalIDs, "Write", "Describe")
+
+		for _, binding := range rights.Acls {
+			if binding.Principal == "" {
+				binding.Principal = principal
+			}
+			if binding.Host == "" {
+				binding.Host = host
+			}
+			bindings = append(bindings, binding)
+		}
+	}
+
+	unique := make(map[string]Binding, len(bindings))
+	for i := range bindings {
+		if err := bindings[i].Normalize(); err != nil {
+			return nil, err
+		}
+		unique[bindings[i].key()] = bindings[i]
+	}
+	result := make([]Binding, 0, len(unique))
+	for _, binding := range unique {
+		result = append(result, binding)
+	}
+	sortBindings(result)
+	return result, nil
+}
+
+// Plan lists the ACLs to create and delete to match a spec
+type Plan struct {
+	Create  []Binding `json:"create" yaml:"create"`
+	Delete  []Binding `json:"delete" yaml:"delete"`
+	Applied bool      `json:"applied" yaml:"applied"`
+}
+
+// ComputePlan diffs the spec against the ACLs of its principals in the cluster
+func ComputePlan(conn *admin.Conn, spec *Spec) (*Plan, error) {
+	desired, err := spec.Bindings()
+	if err != nil {
+		return nil, err
+	}
+
+	principals := make([]string, 0, len(spec.Principals))
+	for principal := range spec.Principals {
+		principals = append(principals, principal)
+	}
+	sort.Strings(principals)
+
+	existing := make(map[string]Binding)
+	for _, principal := range principals {
+		current, err := List(conn, Filter{Principal: principal})
+		if err != nil {
+			return nil, err
+		}
+		for _, binding := range current.Acls {
+			existing[binding.key()] = binding
+		}
+	}
+
+	plan := &Plan{Create: []Binding{}, Delete: []Binding{}}
+	wanted := make(map[string]bool, len(desired))
+	for _, binding := range desired {
+		wanted[binding.key()] = true
+		if _, ok := existing[binding.key()]; !ok {
+			plan.Create = append(plan.Create, binding)
+		}
+	}
+	for key, binding := range existing {
+		if !wanted[key] {
+			plan.Delete = append(plan.Delete, binding)
+		}
+	}
+	sortBindings(plan.Delete)
+	return plan, nil
+}
+
+// Apply creates the missing ACLs and, with allowDelete, deletes the extra ones.
+// Creations come first so a principal never loses a right it keeps.
+func Apply(conn *admin.Conn, plan *Plan, allowDelete bool) error {
+	if len(plan.Delete) > 0 && !allowDelete {
+		return fmt.Errorf("%w: %d acl(s) would be deleted, rerun with -allow-destructive to apply them", ErrDestructive, len(plan.Delete))
+	}
+
+	if err := Create(conn, plan.Create); err != nil {
+		return err
+	}
+	for _, binding := range plan.Create {
+		log.Printf("Created acl: %s", binding)
+	}
+
+	for _, binding := range plan.Delete {
+		if _, err := Delete(conn, binding.exactFilter()); err != nil {
+			return err
+		}
+		log.Printf("Deleted acl: %s", binding)
+	}
+	plan.Applied = true
+	return nil
+}
+
+// WriteTable prints the ACLs to create and delete
+func (p *Plan) WriteTable(tw *tabwriter.Writer) {
+	if len(p.Create) == 0 && len(p.Delete) == 0 {
+		fmt.Fprintln(tw, "No changes. The cluster matches the spec.")
+		return
+	}
+	admin.Row(tw, "ACTION", "PRINCIPAL", "HOST", "RESOURCE TYPE", "PATTERN", "RESOURCE", "OPERATION", "PERMISSION")
+	for _, group := range []struct {
+		action   string
+		bindings []Binding
+	}{{"create", p.Create}, {"delete", p.Delete}} {
+		for _, binding := range group.bindings {
+			admin.Row(tw, group.action, binding.Principal, binding.Host, binding.ResourceType, binding.PatternType,
+				binding.ResourceName, binding.Operation, binding.Permission)
+		}
+	}
+}
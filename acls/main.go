@@ -0,0 +1,228 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"kafka_test/acls/internal"
+	"kafka_test/admin"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: acls <command> [flags]
+
+Commands:
+  list    List ACLs, optionally filtered by principal, resource and operation
+  create  Create ACLs for a principal on a resource
+  delete  Delete the ACLs matching a filter (dry run unless -confirm)
+  plan    Show the ACLs to create and delete to match a YAML file
+  apply   Create and delete ACLs to match a YAML file
+
+Run "acls <command> -h" for command flags.
+`)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	command, args := os.Args[1], os.Args[2:]
+
+	var err error
+	switch command {
+	case "list":
+		err = runList(args)
+	case "create":
+		err = runCreate(args)
+	case "delete":
+		err = runDelete(args)
+	case "plan":
+		err = runPlan(args, false)
+	case "apply":
+		err = runPlan(args, true)
+	case "help", "-h", "-help", "--help":
+		usage()
+		return
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("acls %s failed: %v", command, err)
+	}
+}
+
+// filterFlags registers the flags selecting ACLs
+func filterFlags(fs *flag.FlagSet) *internal.Filter {
+	filter := &internal.Filter{}
+	fs.StringVar(&filter.Principal, "principal", "", "principal, e.g. User:change-data-consumer")
+	fs.StringVar(&filter.Host, "host", "", "client host")
+	fs.StringVar(&filter.ResourceType, "resource-type", "", "resource type: topic, group, cluster or transactionalid")
+	fs.StringVar(&filter.ResourceName, "resource", "", "resource name")
+	fs.StringVar(&filter.PatternType, "pattern", "", "pattern type: literal, prefixed or match")
+	fs.StringVar(&filter.Operation, "operation", "", "operation, e.g. read, write or describe")
+	fs.StringVar(&filter.Permission, "permission", "", "permission: allow or deny")
+	return filter
+}
+
+// runList prints the ACLs matching the filter flags
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	filter := filterFlags(fs)
+	format := fs.String("format", admin.FormatTable, "output format: table, json or yaml")
+	fs.Parse(args)
+
+	if err := admin.ValidateFormat(*format); err != nil {
+		return err
+	}
+
+	conn, err := admin.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	bindings, err := internal.List(conn, *filter)
+	if err != nil {
+		return err
+	}
+	return admin.Write(os.Stdout, *format, bindings)
+}
+
+// runCreate creates one ACL per operation for a principal on a resource
+func runCreate(args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	principal := fs.String("principal", "", "principal, e.g. User:change-data-consumer (required)")
+	host := fs.String("host", "*", "client host")
+	resourceType := fs.String("resource-type", "", "resource type: topic, group, cluster or transactionalid (required)")
+	resource := fs.String("resource", "", "resource name (required except for cluster)")
+	pattern := fs.String("pattern", "literal", "pattern type: literal or prefixed")
+	operations := fs.String("operation", "", "comma separated operations, e.g. read,describe (required)")
+	permission := fs.String("permission", "allow", "permission: allow or deny")
+	format := fs.String("format", admin.FormatTable, "output format: table, json or yaml")
+	fs.Parse(args)
+
+	if err := admin.ValidateFormat(*format); err != nil {
+		return err
+	}
+	if *operations == "" {
+		return fmt.Errorf("-operation is required")
+	}
+
+	created := &internal.Bindings{}
+	for _, operation := range strings.Split(*operations, ",") {
+		binding := internal.Binding{
+			Principal:    *principal,
+			Host:         *host,
+			ResourceType: *resourceType,
+			ResourceName: *resource,
+			PatternType:  *pattern,
+			Operation:    strings.TrimSpace(operation),
+			Permission:   *permission,
+		}
+		if err := binding.Normalize(); err != nil {
+			return err
+		}
+		created.Acls = append(created.Acls, binding)
+	}
+
+	conn, err := admin.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := internal.Create(conn, created.Acls); err != nil {
+		return err
+	}
+	return admin.Write(os.Stdout, *format, created)
+}
+
+// runDelete deletes the ACLs matching the filter flags, or only lists them without -confirm
+func runDelete(args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ExitOnError)
+	filter := filterFlags(fs)
+	confirm := fs.Bool("confirm", false, "delete the matching ACLs instead of only listing them")
+	format := fs.String("format", admin.FormatTable, "output format: table, json or yaml")
+	fs.Parse(args)
+
+	if err := admin.ValidateFormat(*format); err != nil {
+		return err
+	}
+	if *filter == (internal.Filter{}) {
+		return fmt.Errorf("at least one filter flag is required, e.g. -principal")
+	}
+
+	conn, err := admin.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if !*confirm {
+		matches, err := internal.List(conn, *filter)
+		if err != nil {
+			return err
+		}
+		if err := admin.Write(os.Stdout, *format, matches); err != nil {
+			return err
+		}
+		log.Printf("Dry run: pass -confirm to delete the %d ACL(s) above", len(matches.Acls))
+		return nil
+	}
+
+	deleted, err := internal.Delete(conn, *filter)
+	if err != nil {
+		return err
+	}
+	return admin.Write(os.Stdout, *format, deleted)
+}
+
+// runPlan diffs an ACL file against the cluster and, for apply, executes the plan
+func runPlan(args []string, apply bool) error {
+	name := "plan"
+	if apply {
+		name = "apply"
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	specPath := fs.String("file", "acls.yaml", "ACL spec YAML file")
+	format := fs.String("format", admin.FormatTable, "output format: table, json or yaml")
+	var allowDestructive *bool
+	if apply {
+		allowDestructive = fs.Bool("allow-destructive", false, "delete ACLs of managed principals that are not in the file")
+	}
+	fs.Parse(args)
+
+	if err := admin.ValidateFormat(*format); err != nil {
+		return err
+	}
+
+	spec, err := internal.LoadSpec(*specPath)
+	if err != nil {
+		return err
+	}
+
+	conn, err := admin.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	plan, err := internal.ComputePlan(conn, spec)
+	if err != nil {
+		return err
+	}
+
+	if apply {
+		if err := internal.Apply(conn, plan, *allowDestructive); err != nil {
+			admin.Write(os.Stdout, *format, plan)
+			return err
+		}
+	}
+	return admin.Write(os.Stdout, *format, plan)
+}
@@ -0,0 +1,86 @@
+package admin
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"kafka_test/config"
+
+	"github.com/Shopify/sarama"
+)
+
+// Conn bundles a client and a cluster admin sharing the same connections
+type Conn struct {
+	Client sarama.Client
+	Admin  sarama.ClusterAdmin
+}
+
+// Connect connects to the configured brokers using the shared security config
+func Connect() (*Conn, error) {
+	return ConnectWith(config.GetProducerConfig())
+}
+
+// ConnectWith connects to the configured brokers with the given config, e.g. with shorter timeouts
+func ConnectWith(cfg *sarama.Config) (*Conn, error) {
+	brokers := config.GetBrokers()
+	log.Printf("Connecting to brokers: %v", brokers)
+
+	client, err := sarama.NewClient(brokers, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+
+	clusterAdmin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create admin client: %w", err)
+	}
+
+	return &Conn{Client: client, Admin: clusterAdmin}, nil
+}
+
+// Close closes the cluster admin, which also closes the client
+func (c *Conn) Close() error {
+	return c.Admin.Close()
+}
+
+// LogEndOffsets returns the earliest and latest offsets of the given partitions.
+// Partitions whose offsets cannot be read, e.g. without a leader, are missing from
+// earliest and latest and have their error in errs, so one does not fail the others.
+func (c *Conn) LogEndOffsets(topic string, partitions []int32) (earliest, latest map[int32]int64, errs map[int32]error) {
+	earliest = make(map[int32]int64, len(partitions))
+	latest = make(map[int32]int64, len(partitions))
+	errs = make(map[int32]error)
+	for _, partition := range partitions {
+		oldest, err := c.Client.GetOffset(topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			errs[partition] = fmt.Errorf("failed to get earliest offset of %s/%d: %w", topic, partition, err)
+			continue
+		}
+		newest, err := c.Client.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			errs[partition] = fmt.Errorf("failed to get latest offset of %s/%d: %w", topic, partition, err)
+			continue
+		}
+		earliest[partition], latest[partition] = oldest, newest
+	}
+	return earliest, latest, errs
+}
+
+// ParsePartitions parses a comma-separated list of partitions, nil for an empty list
+func ParsePartitions(list string) ([]int32, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	var partitions []int32
+	for _, part := range strings.Split(list, ",") {
+		partition, err := strconv.ParseInt(strings.TrimSpace(part), 10, 32)
+		if err != nil || partition < 0 {
+			return nil, fmt.Errorf("invalid partition %q", part)
+		}
+		partitions = append(partitions, int32(partition))
+	}
+	return partitions, nil
+}
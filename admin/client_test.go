@@ -0,0 +1,41 @@
+package admin
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestLogEndOffsetsOfLeaderlessPartition(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("cdc", 0, broker.BrokerID()).
+			SetLeader("cdc", 1, -1),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("cdc", 0, sarama.OffsetOldest, 3).
+			SetOffset("cdc", 0, sarama.OffsetNewest, 10),
+	})
+
+	conf := sarama.NewConfig()
+	conf.Metadata.Retry.Max = 0
+	client, err := sarama.NewClient([]string{broker.Addr()}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	earliest, latest, errs := (&Conn{Client: client}).LogEndOffsets("cdc", []int32{0, 1})
+	if earliest[0] != 3 || latest[0] != 10 {
+		t.Fatalf("offsets of partition 0 are %d-%d, want 3-10", earliest[0], latest[0])
+	}
+	if _, ok := latest[1]; ok || !errors.Is(errs[1], sarama.ErrLeaderNotAvailable) {
+		t.Fatalf("partition 1 has latest offset %d and error %v, want only ErrLeaderNotAvailable", latest[1], errs[1])
+	}
+	if errs[0] != nil {
+		t.Fatalf("partition 0 has error %v", errs[0])
+	}
+}
@@ -0,0 +1,89 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats supported by Write
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatYAML  = "yaml"
+)
+
+// Formats lists the supported output formats
+var Formats = []string{FormatTable, FormatJSON, FormatYAML}
+
+// Tabler is a result that can print itself as a human readable table
+type Tabler interface {
+	WriteTable(w *tabwriter.Writer)
+}
+
+// Write prints a result in the given format. JSON and YAML use the struct tags of the result.
+func Write(w io.Writer, format string, result Tabler) error {
+	switch format {
+	case FormatTable:
+		tw := NewTabWriter(w)
+		result.WriteTable(tw)
+		return tw.Flush()
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case FormatYAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(result); err != nil {
+			return err
+		}
+		return encoder.Close()
+	default:
+		return unknownFormat(format)
+	}
+}
+
+// ValidateFormat checks an output format flag before any work is done
+func ValidateFormat(format string) error {
+	for _, known := range Formats {
+		if format == known {
+			return nil
+		}
+	}
+	return unknownFormat(format)
+}
+
+func unknownFormat(format string) error {
+	return fmt.Errorf("unknown output format %q, expected one of %s", format, strings.Join(Formats, ", "))
+}
+
+// NewTabWriter creates the tabwriter used for table output
+func NewTabWriter(w io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+}
+
+// Row writes tab separated cells followed by a newline
+func Row(w io.Writer, cells ...any) {
+	parts := make([]string, len(cells))
+	for i, cell := range cells {
+		parts[i] = fmt.Sprint(cell)
+	}
+	fmt.Fprintln(w, strings.Join(parts, "\t"))
+}
+
+// JoinInt32 formats a list of broker or partition IDs as "1,2,3"
+func JoinInt32(ids []int32) string {
+	if len(ids) == 0 {
+		return "-"
+	}
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprint(id)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,211 @@
+package adminhttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"kafka_test/config"
+
+	"github.com/Shopify/sarama"
+)
+
+// redacted replaces the values of secrets in /config
+const redacted = "REDACTED"
+
+// secretMarkers are the parts of environment variable names holding secrets
+var secretMarkers = []string{"PASSWORD", "SECRET", "TOKEN", "CREDENTIAL", "HEADERS"}
+
+// ClientConfig is the effective client configuration of the consumer, with secrets redacted
+type ClientConfig struct {
+	GroupID              string            `json:"group_id"`
+	Brokers              []string          `json:"brokers"`
+	ClientID             string            `json:"client_id,omitempty"`
+	Version              string            `json:"version,omitempty"`
+	TLS                  bool              `json:"tls"`
+	SASL                 bool              `json:"sasl"`
+	SASLMechanism        string            `json:"sasl_mechanism,omitempty"`
+	SASLUser             string            `json:"sasl_user,omitempty"`
+	SASLPassword         string            `json:"sasl_password,omitempty"`
+	AssignmentStrategies []string          `json:"assignment_strategies,omitempty"`
+	GroupInstanceID      string            `json:"group_instance_id,omitempty"`
+	InitialOffset        string            `json:"initial_offset,omitempty"`
+	AutoCommit           bool              `json:"auto_commit"`
+	SessionTimeout       string            `json:"session_timeout,omitempty"`
+	HeartbeatInterval    string            `json:"heartbeat_interval,omitempty"`
+	Environment          map[string]string `json:"environment"`
+}
+
+// Handler returns the admin endpoints of the consumer
+func Handler(state *State) http.Handler {
+	mux := http.NewServeMux()
+
+	// Liveness: the process serves requests
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+
+	// Readiness: the group session is live with partitions assigned
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if ready, reason := state.Ready(); !ready {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready", "reason": reason})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	})
+
+	mux.HandleFunc("GET /assignments", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, state.Assignments())
+	})
+
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, r *http.Request) {
+		pauseOrResume(w, r, state, "Paused", state.Pause)
+	})
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, r *http.Request) {
+		pauseOrResume(w, r, state, "Resumed", state.Resume)
+	})
+
+	mux.HandleFunc("GET /config", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, state.clientConfig())
+	})
+
+	return mux
+}
+
+// pauseOrResume applies a pause or resume to the topic and partition query parameters,
+// every partition if neither is given, and responds with what is paused
+func pauseOrResume(w http.ResponseWriter, r *http.Request, state *State, action string, apply func(string, int32) error) {
+	topic := r.URL.Query().Get("topic")
+	partition := AllPartitions
+	if value := r.URL.Query().Get("partition"); value != "" {
+		if topic == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("partition requires a topic"))
+			return
+		}
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid partition %q", value))
+			return
+		}
+		partition = int32(n)
+	}
+
+	if err := apply(topic, partition); err != nil {
+		status := http.StatusConflict
+		if errors.Is(err, errNoGroup) {
+			status = http.StatusServiceUnavailable
+		}
+		writeError(w, status, err)
+		return
+	}
+	slog.Info(action+" consumption", "topic", topic, "partition", partition)
+	writeJSON(w, http.StatusOK, state.Pauses())
+}
+
+// clientConfig returns the configuration the consumer group was created with
+func (s *State) clientConfig() ClientConfig {
+	s.mu.Lock()
+	cfg := s.config
+	s.mu.Unlock()
+
+	view := ClientConfig{GroupID: s.groupID, Brokers: config.GetBrokers(), Environment: environment()}
+	if cfg == nil {
+		return view
+	}
+
+	view.ClientID = cfg.ClientID
+	view.Version = cfg.Version.String()
+	view.TLS = cfg.Net.TLS.Enable
+	view.SASL = cfg.Net.SASL.Enable
+	if cfg.Net.SASL.Enable {
+		view.SASLMechanism = string(cfg.Net.SASL.Mechanism)
+		view.SASLUser = cfg.Net.SASL.User
+		if cfg.Net.SASL.Password != "" {
+			view.SASLPassword = redacted
+		}
+	}
+	for _, strategy := range cfg.Consumer.Group.Rebalance.GroupStrategies {
+		view.AssignmentStrategies = append(view.AssignmentStrategies, strategy.Name())
+	}
+	view.GroupInstanceID = cfg.Consumer.Group.InstanceId
+	switch cfg.Consumer.Offsets.Initial {
+	case sarama.OffsetOldest:
+		view.InitialOffset = "oldest"
+	case sarama.OffsetNewest:
+		view.InitialOffset = "newest"
+	}
+	view.AutoCommit = cfg.Consumer.Offsets.AutoCommit.Enable
+	view.SessionTimeout = cfg.Consumer.Group.Session.Timeout.String()
+	view.HeartbeatInterval = cfg.Consumer.Group.Heartbeat.Interval.String()
+	return view
+}
+
+// environment returns the KAFKA_ and OTEL_ environment variables with secrets redacted
+func environment() map[string]string {
+	env := make(map[string]string)
+	for _, entry := range os.Environ() {
+		name, value, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(name, "KAFKA_") && !strings.HasPrefix(name, "OTEL_") {
+			continue
+		}
+		for _, marker := range secretMarkers {
+			if strings.Contains(name, marker) && value != "" {
+				value = redacted
+				break
+			}
+		}
+		env[name] = value
+	}
+	return env
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// Serve serves the admin endpoints on addr until the context is cancelled. An
+// empty address disables the server.
+func Serve(ctx context.Context, addr string, state *State) error {
+	if addr == "" {
+		return nil
+	}
+
+	server := &http.Server{Addr: addr, Handler: Handler(state), ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("Serving admin endpoints", "url", "http://"+addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve admin endpoints: %w", err)
+	}
+	return nil
+}
+
+// Start serves the admin endpoints in the background, logging if the server fails
+func Start(ctx context.Context, addr string, state *State) {
+	go func() {
+		if err := Serve(ctx, addr, state); err != nil {
+			slog.Error("Admin server error", "error", err)
+		}
+	}()
+}
@@ -0,0 +1,213 @@
+// Package adminhttp serves the admin HTTP endpoints of a consumer: liveness and
+// readiness probes, the current assignment, pausing and resuming partitions and
+// the effective configuration
+package adminhttp
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// errNoGroup is returned by Pause and Resume before the consumer group is created
+var errNoGroup = errors.New("consumer group not created")
+
+// AllPartitions pauses or resumes every partition of a topic
+const AllPartitions int32 = -1
+
+// Assignments is the group membership and the partitions this member claims
+type Assignments struct {
+	GroupID      string             `json:"group_id"`
+	MemberID     string             `json:"member_id,omitempty"`
+	GenerationID int32              `json:"generation_id,omitempty"`
+	Live         bool               `json:"live"`
+	Claims       map[string][]int32 `json:"claims"`
+	Paused       map[string][]int32 `json:"paused"`
+}
+
+// Pauses is what is paused: everything, or partitions by topic with AllPartitions for whole topics
+type Pauses struct {
+	All    bool               `json:"all"`
+	Topics map[string][]int32 `json:"topics"`
+}
+
+// State tracks the consumer group session of a consumer for the admin endpoints.
+// Consumers report sessions and claims from their sarama.ConsumerGroupHandler.
+type State struct {
+	groupID string
+
+	mu      sync.Mutex
+	group   sarama.ConsumerGroup
+	config  *sarama.Config
+	session sarama.ConsumerGroupSession
+
+	// paused holds the paused partitions by topic, AllPartitions for a whole topic;
+	// pausedAll is set by a pause without topic. Pauses are reapplied to new claims,
+	// sarama forgets them when partitions are reassigned.
+	paused    map[string]map[int32]bool
+	pausedAll bool
+}
+
+// NewState creates the state of a consumer of the group
+func NewState(groupID string) *State {
+	return &State{groupID: groupID, paused: make(map[string]map[int32]bool)}
+}
+
+// Attach sets the consumer group and its configuration once it is created
+func (s *State) Attach(group sarama.ConsumerGroup, config *sarama.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.group = group
+	s.config = config
+}
+
+// SessionStarted records a new session, call it from Setup
+func (s *State) SessionStarted(session sarama.ConsumerGroupSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.session = session
+}
+
+// SessionEnded records the end of the session, call it from Cleanup
+func (s *State) SessionEnded() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.session = nil
+}
+
+// ClaimStarted pauses a newly claimed partition again if it was paused, call it
+// at the start of ConsumeClaim, once sarama consumes the partition
+func (s *State) ClaimStarted(topic string, partition int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.group != nil && s.isPaused(topic, partition) {
+		s.group.Pause(map[string][]int32{topic: {partition}})
+	}
+}
+
+// Ready reports whether the session is live with partitions assigned, and why not
+func (s *State) Ready() (bool, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.group == nil:
+		return false, errNoGroup.Error()
+	case !s.live():
+		return false, "no live group session"
+	case len(s.session.Claims()) == 0:
+		return false, "no partitions assigned"
+	default:
+		return true, ""
+	}
+}
+
+// Assignments returns the current group membership and claims
+func (s *State) Assignments() Assignments {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	assignments := Assignments{GroupID: s.groupID, Claims: map[string][]int32{}, Paused: map[string][]int32{}}
+	if s.live() {
+		assignments.MemberID = s.session.MemberID()
+		assignments.GenerationID = s.session.GenerationID()
+		assignments.Live = true
+		for topic, partitions := range s.session.Claims() {
+			assignments.Claims[topic] = slices.Sorted(slices.Values(partitions))
+			for _, partition := range partitions {
+				if s.isPaused(topic, partition) {
+					assignments.Paused[topic] = append(assignments.Paused[topic], partition)
+				}
+			}
+		}
+	}
+	for _, partitions := range assignments.Paused {
+		slices.Sort(partitions)
+	}
+	return assignments
+}
+
+// Pause stops fetching from a partition, every partition of a topic with
+// AllPartitions, or every partition with an empty topic
+func (s *State) Pause(topic string, partition int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.group == nil {
+		return errNoGroup
+	}
+
+	if topic == "" {
+		s.pausedAll = true
+		s.group.PauseAll()
+		return nil
+	}
+	if s.paused[topic] == nil {
+		s.paused[topic] = make(map[int32]bool)
+	}
+	s.paused[topic][partition] = true
+	s.group.Pause(s.claimed(topic, partition))
+	return nil
+}
+
+// Resume resumes what Pause paused. A single partition of a paused topic cannot be
+// resumed on its own, resume the topic instead.
+func (s *State) Resume(topic string, partition int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.group == nil {
+		return errNoGroup
+	}
+
+	if topic == "" {
+		s.pausedAll = false
+		clear(s.paused)
+		s.group.ResumeAll()
+		return nil
+	}
+	if s.pausedAll {
+		return fmt.Errorf("all partitions are paused, resume them all instead")
+	}
+	if partition != AllPartitions && s.paused[topic][AllPartitions] {
+		return fmt.Errorf("topic %s is paused, resume the topic instead", topic)
+	}
+	if partition == AllPartitions {
+		delete(s.paused, topic)
+	} else {
+		delete(s.paused[topic], partition)
+	}
+	s.group.Resume(s.claimed(topic, partition))
+	return nil
+}
+
+// claimed returns the claimed partitions a pause or resume applies to
+func (s *State) claimed(topic string, partition int32) map[string][]int32 {
+	if partition != AllPartitions {
+		return map[string][]int32{topic: {partition}}
+	}
+	if !s.live() {
+		return nil
+	}
+	return map[string][]int32{topic: s.session.Claims()[topic]}
+}
+
+func (s *State) isPaused(topic string, partition int32) bool {
+	return s.pausedAll || s.paused[topic][AllPartitions] || s.paused[topic][partition]
+}
+
+func (s *State) live() bool {
+	return s.session != nil && s.session.Context().Err() == nil
+}
+
+// Pauses returns what is paused, whether or not it is claimed by this member
+func (s *State) Pauses() Pauses {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pauses := Pauses{All: s.pausedAll, Topics: make(map[string][]int32, len(s.paused))}
+	for topic, paused := range s.paused {
+		pauses.Topics[topic] = slices.Sorted(maps.Keys(paused))
+	}
+	return pauses
+}
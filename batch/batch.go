@@ -0,0 +1,428 @@
+// Package batch consumes messages in batches for handlers writing downstream in bulk: it
+// collects messages per partition or across partitions, hands them to the handler at once
+// and commits the offsets of a batch only once it was handled
+package batch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"kafka_test/adminhttp"
+	"kafka_test/config"
+	"kafka_test/lifecycle"
+	"kafka_test/metrics"
+	"kafka_test/rebalance"
+	"kafka_test/tracing"
+
+	"github.com/Shopify/sarama"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Failure policies: what happens to a batch the handler failed
+const (
+	// Retry hands the whole batch to the handler again until it succeeds
+	Retry = "retry"
+	// Bisect splits a failed batch in halves and handles each on its own, committing the
+	// halves that succeed, down to the failing messages, which are retried
+	Bisect = "bisect"
+	// DeadLetter bisects like Bisect and sends the failing messages to the dead letter
+	// topic instead of retrying them
+	DeadLetter = "dlq"
+)
+
+// Envelope is a consumed message handed to a batch handler
+type Envelope struct {
+	*sarama.ConsumerMessage
+	// Context carries the span of the message, continuing the producer's trace
+	Context context.Context
+
+	span trace.Span
+	done bool
+}
+
+// finish ends the span of the message once, with the error it was abandoned with if any
+func (e *Envelope) finish(err error) {
+	if !e.done {
+		e.done = true
+		tracing.End(e.span, err)
+	}
+}
+
+// Handler handles batches of messages
+type Handler interface {
+	// HandleBatch handles the messages of a batch, in offset order per partition. An error
+	// fails the whole batch; return Failed to point at the message that failed. Messages
+	// may be handed to the handler again, so it must be idempotent.
+	HandleBatch(ctx context.Context, batch []*Envelope) error
+}
+
+// HandlerFunc adapts a function to a Handler
+type HandlerFunc func(ctx context.Context, batch []*Envelope) error
+
+// HandleBatch calls f
+func (f HandlerFunc) HandleBatch(ctx context.Context, batch []*Envelope) error {
+	return f(ctx, batch)
+}
+
+// ItemError reports the message of a batch the handler failed on, so the Bisect and
+// DeadLetter policies split the batch around it instead of in halves
+type ItemError struct {
+	Index int
+	Err   error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("message %d of the batch: %v", e.Index, e.Err)
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// Failed returns an error reporting that the message at index of the batch failed
+func Failed(index int, err error) error {
+	return &ItemError{Index: index, Err: err}
+}
+
+// Options configures a batch consumer
+type Options struct {
+	GroupID string
+	Topics  []string
+	// Size is the most messages in a batch
+	Size int
+	// Timeout hands a partial batch to the handler once its first message waited this long
+	Timeout time.Duration
+	// AcrossPartitions collects one batch from all claimed partitions instead of one per partition
+	AcrossPartitions bool
+	// OnFailure is the failure policy: Retry, Bisect or DeadLetter
+	OnFailure string
+	// DeadLetterTopic receives the failing messages with DeadLetter
+	DeadLetterTopic string
+	// RetryBackoff is the wait before handing a failed batch or message to the handler again
+	RetryBackoff time.Duration
+}
+
+// Consumer consumes the topics of a group in batches
+type Consumer struct {
+	// Hooks are called when partitions are assigned and revoked
+	rebalance.Hooks
+
+	opts    Options
+	handler Handler
+	state   *adminhttp.State
+	// producer sends to the dead letter topic, nil unless the policy is DeadLetter
+	producer sarama.SyncProducer
+
+	// merged receives the messages of every claim when collecting across partitions, and
+	// collected is closed once the collector of the session has handled them
+	merged    chan *sarama.ConsumerMessage
+	collected chan struct{}
+}
+
+// New creates a batch consumer handing batches to the handler
+func New(opts Options, handler Handler) (*Consumer, error) {
+	if opts.Size <= 0 {
+		opts.Size = 100
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Second
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = time.Second
+	}
+	if opts.OnFailure == "" {
+		opts.OnFailure = Retry
+	}
+
+	c := &Consumer{
+		opts:    opts,
+		handler: handler,
+		state:   adminhttp.NewState(opts.GroupID),
+	}
+
+	switch opts.OnFailure {
+	case Retry, Bisect:
+	case DeadLetter:
+		if opts.DeadLetterTopic == "" {
+			return nil, errors.New("the dlq failure policy needs a dead letter topic")
+		}
+		producerConfig := config.GetProducerConfig()
+		metrics.RegisterSarama("producer", producerConfig.MetricRegistry)
+		producer, err := sarama.NewSyncProducer(config.GetBrokers(), producerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dead letter producer: %w", err)
+		}
+		c.producer = producer
+	default:
+		return nil, fmt.Errorf("unknown batch failure policy %q: must be retry, bisect or dlq", opts.OnFailure)
+	}
+	return c, nil
+}
+
+// Close closes the dead letter producer if one was created
+func (c *Consumer) Close() error {
+	if c.producer != nil {
+		return c.producer.Close()
+	}
+	return nil
+}
+
+// State returns the state served by the admin endpoints
+func (c *Consumer) State() *adminhttp.State {
+	return c.state
+}
+
+// Setup is run at the beginning of a new session, before ConsumeClaim
+func (c *Consumer) Setup(session sarama.ConsumerGroupSession) error {
+	metrics.SessionStarted(c.opts.GroupID)
+	c.state.SessionStarted(session)
+
+	if c.opts.AcrossPartitions {
+		c.merged = make(chan *sarama.ConsumerMessage)
+		c.collected = make(chan struct{})
+		go func() {
+			defer close(c.collected)
+			c.collect(session, c.merged, nil)
+		}()
+	}
+	return c.Assigned(session)
+}
+
+// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
+func (c *Consumer) Cleanup(session sarama.ConsumerGroupSession) error {
+	// Let the collector across partitions handle its last batch
+	if c.merged != nil {
+		close(c.merged)
+		<-c.collected
+		c.merged = nil
+	}
+	metrics.SessionEnded(c.opts.GroupID, session.Claims())
+	c.state.SessionEnded()
+	return c.Revoked(session)
+}
+
+// ConsumeClaim collects the messages of a partition into batches, or forwards them to
+// the collector across partitions
+func (c *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	c.state.ClaimStarted(claim.Topic(), claim.Partition())
+	observe := func(message *sarama.ConsumerMessage) {
+		metrics.ObserveConsume(c.opts.GroupID, message.Topic, message.Partition, message.Offset, claim.HighWaterMarkOffset())
+	}
+
+	if c.merged == nil {
+		c.collect(session, claim.Messages(), observe)
+		return nil
+	}
+
+	for {
+		select {
+		case message := <-claim.Messages():
+			if message == nil || session.Context().Err() != nil {
+				return nil
+			}
+			observe(message)
+			select {
+			case c.merged <- message:
+			case <-session.Context().Done():
+				return nil
+			}
+
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
+
+// collect batches the messages received until the channel closes or the session ends,
+// handing a batch to the handler once it is full or its first message waited for the
+// timeout. Messages are observed as received, unless observe is nil.
+func (c *Consumer) collect(session sarama.ConsumerGroupSession, messages <-chan *sarama.ConsumerMessage, observe func(*sarama.ConsumerMessage)) {
+	batch := make([]*Envelope, 0, c.opts.Size)
+	linger := time.NewTimer(c.opts.Timeout)
+	linger.Stop()
+	defer linger.Stop()
+
+	for {
+		select {
+		case message := <-messages:
+			// Handle what was collected before the partitions are released; messages
+			// received after the session ended are fetched again by the next owner
+			if message == nil || session.Context().Err() != nil {
+				c.process(session, batch)
+				return
+			}
+			if observe != nil {
+				observe(message)
+			}
+			if len(batch) == 0 {
+				linger.Reset(c.opts.Timeout)
+			}
+			// The handler may still use the context of a message after the session ended
+			ctx, span := tracing.StartConsume(context.WithoutCancel(session.Context()), c.opts.GroupID, message)
+			batch = append(batch, &Envelope{ConsumerMessage: message, Context: ctx, span: span})
+			if len(batch) < c.opts.Size {
+				continue
+			}
+
+		case <-linger.C:
+			if len(batch) == 0 {
+				continue
+			}
+
+		case <-session.Context().Done():
+			c.process(session, batch)
+			return
+		}
+
+		linger.Stop()
+		c.process(session, batch)
+		batch = make([]*Envelope, 0, c.opts.Size)
+	}
+}
+
+// process handles a batch with the failure policy. A batch still failing when the session
+// ends is not committed, so it is consumed again by the next session.
+func (c *Consumer) process(session sarama.ConsumerGroupSession, batch []*Envelope) {
+	if len(batch) == 0 {
+		return
+	}
+	err := c.handle(session, batch)
+	if err != nil {
+		metrics.ConsumeError(c.opts.GroupID, batch[0].Topic)
+		slog.Error("Batch abandoned at the end of the session, it is consumed again", "messages", len(batch), "error", err)
+	}
+	for _, envelope := range batch {
+		envelope.finish(err)
+	}
+}
+
+// handle hands a batch to the handler and commits it once handled. A failed batch is
+// retried, or split and its parts handled on their own, until the session ends.
+func (c *Consumer) handle(session sarama.ConsumerGroupSession, batch []*Envelope) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	for {
+		err := c.handleBatch(session.Context(), batch)
+		if err == nil {
+			c.commit(session, batch)
+			return nil
+		}
+
+		if c.opts.OnFailure != Retry && len(batch) > 1 {
+			return c.bisect(session, batch, err)
+		}
+		if c.opts.OnFailure == DeadLetter {
+			dlqErr := c.deadLetter(batch[0], err)
+			if dlqErr == nil {
+				c.commit(session, batch)
+				return nil
+			}
+			err = errors.Join(err, dlqErr)
+		}
+
+		slog.Warn("Batch failed, retrying", "messages", len(batch), "from_topic", batch[0].Topic,
+			"from_partition", batch[0].Partition, "from_offset", batch[0].Offset, "error", err)
+		select {
+		case <-time.After(c.opts.RetryBackoff):
+		case <-session.Context().Done():
+			return err
+		}
+	}
+}
+
+// bisect handles the parts of a failed batch in order: the messages before and after the
+// failing one if the handler reported it, or else the two halves
+func (c *Consumer) bisect(session sarama.ConsumerGroupSession, batch []*Envelope, err error) error {
+	var parts [][]*Envelope
+	var itemErr *ItemError
+	if errors.As(err, &itemErr) && itemErr.Index >= 0 && itemErr.Index < len(batch) {
+		i := itemErr.Index
+		parts = [][]*Envelope{batch[:i], batch[i : i+1], batch[i+1:]}
+	} else {
+		half := len(batch) / 2
+		parts = [][]*Envelope{batch[:half], batch[half:]}
+	}
+
+	slog.Warn("Batch failed, splitting it", "messages", len(batch), "from_topic", batch[0].Topic,
+		"from_partition", batch[0].Partition, "from_offset", batch[0].Offset, "error", err)
+	for _, part := range parts {
+		// Stop at the first part failing for good, the later ones must not be committed before it
+		if err := c.handle(session, part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleBatch hands a batch to the handler, letting it finish when the session ends
+func (c *Consumer) handleBatch(ctx context.Context, batch []*Envelope) (err error) {
+	start := time.Now()
+	defer func() { metrics.ObserveBatch(c.opts.GroupID, len(batch), start, err) }()
+	return c.handler.HandleBatch(context.WithoutCancel(ctx), batch)
+}
+
+// commit marks the messages of a handled batch and commits their offsets
+func (c *Consumer) commit(session sarama.ConsumerGroupSession, batch []*Envelope) {
+	for _, envelope := range batch {
+		session.MarkMessage(envelope.ConsumerMessage, "")
+		envelope.finish(nil)
+	}
+	start := time.Now()
+	session.Commit()
+	metrics.ObserveCommit(c.opts.GroupID, start)
+}
+
+// deadLetter sends a failing message to the dead letter topic with the error and where it was consumed from
+func (c *Consumer) deadLetter(envelope *Envelope, cause error) error {
+	headers := make([]sarama.RecordHeader, 0, len(envelope.Headers)+3)
+	for _, header := range envelope.Headers {
+		headers = append(headers, *header)
+	}
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte("dlq-error"), Value: []byte(cause.Error())},
+		sarama.RecordHeader{Key: []byte("source-topic"), Value: []byte(envelope.Topic)},
+		sarama.RecordHeader{Key: []byte("source-offset"), Value: []byte(fmt.Sprintf("%d/%d", envelope.Partition, envelope.Offset))},
+	)
+	msg := &sarama.ProducerMessage{
+		Topic:   c.opts.DeadLetterTopic,
+		Key:     sarama.ByteEncoder(envelope.Key),
+		Value:   sarama.ByteEncoder(envelope.Value),
+		Headers: headers,
+	}
+
+	_, span := tracing.StartProduce(envelope.Context, msg)
+	start := time.Now()
+	partition, offset, err := c.producer.SendMessage(msg)
+	metrics.ObserveProduce(msg.Topic, start, err)
+	tracing.EndProduce(span, partition, offset, err)
+	if err != nil {
+		return fmt.Errorf("failed to send to dead letter topic %s: %w", c.opts.DeadLetterTopic, err)
+	}
+
+	metrics.DeadLettered(c.opts.GroupID, envelope.Topic)
+	slog.Warn("Message sent to the dead letter topic", "topic", envelope.Topic, "partition", envelope.Partition,
+		"offset", envelope.Offset, "dlq_topic", c.opts.DeadLetterTopic, "dlq_partition", partition, "dlq_offset", offset, "error", cause)
+	return nil
+}
+
+// Run consumes until the context is cancelled, then handles the batches collected so far and leaves the group
+func (c *Consumer) Run(ctx context.Context) error {
+	kafkaConfig := config.GetConsumerConfig()
+	metrics.RegisterSarama("consumer", kafkaConfig.MetricRegistry)
+
+	group, err := sarama.NewConsumerGroup(config.GetBrokers(), c.opts.GroupID, kafkaConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create consumer group: %w", err)
+	}
+	c.state.Attach(group, kafkaConfig)
+
+	slog.Info("Batch consumer started", "topics", c.opts.Topics, "group", c.opts.GroupID,
+		"size", c.opts.Size, "timeout", c.opts.Timeout.String(), "across_partitions", c.opts.AcrossPartitions,
+		"on_failure", c.opts.OnFailure)
+
+	return lifecycle.ConsumeGroup(ctx, group, c.opts.GroupID, c.opts.Topics, c, c.opts.RetryBackoff)
+}
@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"kafka_test/config"
+	"kafka_test/models"
+	"kafka_test/partitionrange"
+
+	"github.com/Shopify/sarama"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxDumpLineSize bounds a single ChangeDataMessage line in a dump file
+const maxDumpLineSize = 16 * 1024 * 1024
+
+// MessageHandler is called for every ChangeDataMessage read from a topic or dump
+type MessageHandler func(message *sarama.ConsumerMessage, changeDataMsg *models.ChangeDataMessage) error
+
+// ReadTopic reads every message currently in the topic, partition by partition,
+// stopping at the high watermark observed when the read started.
+// Partitions missing from start are read from the oldest available offset.
+// Messages that are not protobuf ChangeDataMessages are skipped.
+// It returns the next offset to read for every partition, the offset the read reached.
+func ReadTopic(ctx context.Context, topic string, start map[int32]int64, handler MessageHandler) (map[int32]int64, error) {
+	client, err := sarama.NewClient(config.GetBrokers(), config.GetConsumerConfig())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	defer client.Close()
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get partitions for topic %s: %w", topic, err)
+	}
+
+	reader := partitionrange.NewReader(client)
+	next := make(map[int32]int64, len(partitions))
+	for _, partition := range partitions {
+		oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get oldest offset for partition %d: %w", partition, err)
+		}
+		newest, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get newest offset for partition %d: %w", partition, err)
+		}
+		next[partition] = newest
+
+		from := oldest
+		if offset, ok := start[partition]; ok {
+			if offset < oldest {
+				log.Printf("Offset %d of partition %d has been deleted, reading from %d", offset, partition, oldest)
+			} else {
+				from = offset
+			}
+		}
+		if from >= newest {
+			continue
+		}
+
+		if next[partition], err = readPartition(ctx, reader, consumer, topic, partition, from, newest, handler); err != nil {
+			return nil, err
+		}
+	}
+
+	return next, nil
+}
+
+// readPartition consumes [from, to) from a single partition and returns the next offset to
+// read, to once the whole range was read
+func readPartition(ctx context.Context, reader *partitionrange.Reader, consumer sarama.Consumer, topic string, partition int32, from, to int64, handler MessageHandler) (int64, error) {
+	partitionConsumer, err := consumer.ConsumePartition(topic, partition, from)
+	if err != nil {
+		return from, fmt.Errorf("failed to consume partition %d: %w", partition, err)
+	}
+	defer partitionConsumer.Close()
+
+	log.Printf("Reading partition %d from offset %d to %d", partition, from, to)
+
+	rng := partitionrange.Range{Topic: topic, Partition: partition, From: from, To: to}
+	next, err := reader.Read(ctx, partitionConsumer, rng, func(message *sarama.ConsumerMessage) error {
+		if !isChangeDataMessage(message) {
+			return nil
+		}
+		var changeDataMsg models.ChangeDataMessage
+		if err := proto.Unmarshal(message.Value, &changeDataMsg); err != nil {
+			log.Printf("Failed to parse ChangeDataMessage at partition %d, offset %d: %v", partition, message.Offset, err)
+			return nil
+		}
+		return handler(message, &changeDataMsg)
+	})
+	if err != nil {
+		return next, err
+	}
+	if next < to {
+		log.Printf("Read of partition %d ended at offset %d before %d", partition, next, to)
+	}
+	return next, nil
+}
+
+// isChangeDataMessage reports whether the message carries a protobuf payload
+func isChangeDataMessage(message *sarama.ConsumerMessage) bool {
+	for _, header := range message.Headers {
+		if string(header.Key) == "content-type" {
+			return string(header.Value) == "application/x-protobuf"
+		}
+	}
+	return false
+}
+
+// DumpWriter writes ChangeDataMessages as JSON Lines, one protojson message per line
+type DumpWriter struct {
+	w *bufio.Writer
+}
+
+// NewDumpWriter creates a new DumpWriter
+func NewDumpWriter(w io.Writer) *DumpWriter {
+	return &DumpWriter{w: bufio.NewWriter(w)}
+}
+
+// Write appends a message to the dump
+func (d *DumpWriter) Write(msg *models.ChangeDataMessage) error {
+	data, err := protojson.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ChangeDataMessage: %w", err)
+	}
+	if _, err := d.w.Write(data); err != nil {
+		return err
+	}
+	return d.w.WriteByte('\n')
+}
+
+// Flush flushes buffered lines to the underlying writer
+func (d *DumpWriter) Flush() error {
+	return d.w.Flush()
+}
+
+// ReadDump reads a JSON Lines dump and calls handler for every message.
+// The ConsumerMessage passed to the handler is nil.
+func ReadDump(r io.Reader, handler MessageHandler) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxDumpLineSize)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var changeDataMsg models.ChangeDataMessage
+		if err := protojson.Unmarshal(scanner.Bytes(), &changeDataMsg); err != nil {
+			return fmt.Errorf("failed to parse dump line %d: %w", line, err)
+		}
+		if err := handler(nil, &changeDataMsg); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"kafka_test/models"
+	"kafka_test/partitionrange"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"google.golang.org/protobuf/proto"
+)
+
+func changeDataMessage(t *testing.T, requestID string) *sarama.ConsumerMessage {
+	t.Helper()
+	value, err := proto.Marshal(&models.ChangeDataMessage{RequestId: requestID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &sarama.ConsumerMessage{
+		Topic:   "cdc",
+		Value:   value,
+		Headers: []*sarama.RecordHeader{{Key: []byte("content-type"), Value: []byte("application/x-protobuf")}},
+	}
+}
+
+func TestReadPartitionSkipsOtherMessages(t *testing.T) {
+	consumer := mocks.NewConsumer(t, nil)
+	pc := consumer.ExpectConsumePartition("cdc", 0, 4)
+	pc.YieldMessage(changeDataMessage(t, "a"))
+	pc.YieldMessage(&sarama.ConsumerMessage{Topic: "cdc", Value: []byte(`{"request_id":"json"}`)})
+	pc.YieldMessage(changeDataMessage(t, "c"))
+
+	var read []string
+	next, err := readPartition(context.Background(), partitionrange.NewReader(nil), consumer, "cdc", 0, 4, 7,
+		func(_ *sarama.ConsumerMessage, msg *models.ChangeDataMessage) error {
+			read = append(read, msg.RequestId)
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != 7 || fmt.Sprint(read) != "[a c]" {
+		t.Fatalf("read %v up to %d, want [a c] up to 7", read, next)
+	}
+}
+
+func TestReadPartitionReturnsOffsetReached(t *testing.T) {
+	consumer := mocks.NewConsumer(t, nil)
+	pc := consumer.ExpectConsumePartition("cdc", 0, 0)
+	pc.YieldMessage(changeDataMessage(t, "a"))
+	pc.YieldMessage(changeDataMessage(t, "b"))
+
+	stop := errors.New("stop")
+	next, err := readPartition(context.Background(), partitionrange.NewReader(nil), consumer, "cdc", 0, 0, 5,
+		func(_ *sarama.ConsumerMessage, msg *models.ChangeDataMessage) error {
+			if msg.RequestId == "b" {
+				return stop
+			}
+			return nil
+		})
+	if !errors.Is(err, stop) || next != 1 {
+		t.Fatalf("read up to %d (%v), want up to 1 with the handler error", next, err)
+	}
+}
@@ -0,0 +1,374 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"kafka_test/models"
+
+	"github.com/Shopify/sarama"
+)
+
+// Exported table names
+const (
+	TableDepartments                   = "departments"
+	TableAggregationPatterns           = "aggregation_patterns"
+	TableAggregationPatternDepartments = "aggregation_pattern_departments"
+)
+
+// Store holds the materialized current state of a CDC topic. Deleted departments and
+// aggregation patterns are kept as tombstones holding the deleted version, so an older
+// record read later from another partition does not bring them back.
+type Store struct {
+	departments        map[string]*models.Department
+	patterns           map[string]*models.AggregationPattern
+	links              map[string]*models.AggregationPatternDepartment
+	deletedDepartments map[string]*models.Department
+	deletedPatterns    map[string]*models.AggregationPattern
+}
+
+// NewStore creates an empty store
+func NewStore() *Store {
+	return &Store{
+		departments:        make(map[string]*models.Department),
+		patterns:           make(map[string]*models.AggregationPattern),
+		links:              make(map[string]*models.AggregationPatternDepartment),
+		deletedDepartments: make(map[string]*models.Department),
+		deletedPatterns:    make(map[string]*models.AggregationPattern),
+	}
+}
+
+// Apply applies the records of a message and returns the records that changed
+// the state. Department and aggregation pattern records older than the stored
+// version, or not newer than their deletion, are ignored, since partitions are
+// not read in a global order.
+func (s *Store) Apply(msg *models.ChangeDataMessage) []*models.Record {
+	var applied []*models.Record
+	for _, record := range msg.Records {
+		if s.applyRecord(record) {
+			applied = append(applied, record)
+		}
+	}
+	return applied
+}
+
+func (s *Store) applyRecord(record *models.Record) bool {
+	deleted := record.OperationType == models.Record_DELETE
+
+	switch data := record.Data.(type) {
+	case *models.Record_Department:
+		dept := data.Department
+		key := fmt.Sprintf("%d/%s", dept.TenantUid, dept.Biid)
+		if current, ok := s.departments[key]; ok && dept.Version < current.Version {
+			return false
+		}
+		if tombstone, ok := s.deletedDepartments[key]; ok && dept.Version <= tombstone.Version {
+			return false
+		}
+		if deleted {
+			delete(s.departments, key)
+			s.deletedDepartments[key] = dept
+		} else {
+			s.departments[key] = dept
+			delete(s.deletedDepartments, key)
+		}
+
+	case *models.Record_AggregationPattern:
+		pattern := data.AggregationPattern
+		key := fmt.Sprintf("%d/%s", pattern.TenantUid, pattern.Biid)
+		if current, ok := s.patterns[key]; ok && pattern.Version < current.Version {
+			return false
+		}
+		if tombstone, ok := s.deletedPatterns[key]; ok && pattern.Version <= tombstone.Version {
+			return false
+		}
+		if deleted {
+			delete(s.patterns, key)
+			s.deletedPatterns[key] = pattern
+		} else {
+			s.patterns[key] = pattern
+			delete(s.deletedPatterns, key)
+		}
+
+	case *models.Record_AggregationPatternDepartment:
+		link := data.AggregationPatternDepartment
+		key := fmt.Sprintf("%d/%s/%s", link.TenantUid, link.AggregationPatternId, link.DepartmentBiid)
+		if deleted {
+			delete(s.links, key)
+		} else {
+			s.links[key] = link
+		}
+
+	default:
+		return false
+	}
+
+	return true
+}
+
+// records returns the current state as CREATE records, sorted by key
+func (s *Store) records() []*models.Record {
+	var records []*models.Record
+	for _, key := range sortedKeys(s.departments) {
+		records = append(records, &models.Record{Data: &models.Record_Department{Department: s.departments[key]}})
+	}
+	for _, key := range sortedKeys(s.patterns) {
+		records = append(records, &models.Record{Data: &models.Record_AggregationPattern{AggregationPattern: s.patterns[key]}})
+	}
+	for _, key := range sortedKeys(s.links) {
+		records = append(records, &models.Record{Data: &models.Record_AggregationPatternDepartment{AggregationPatternDepartment: s.links[key]}})
+	}
+	return records
+}
+
+// tombstones returns the deleted departments and aggregation patterns as DELETE
+// records, sorted by key
+func (s *Store) tombstones() []*models.Record {
+	var records []*models.Record
+	for _, key := range sortedKeys(s.deletedDepartments) {
+		records = append(records, &models.Record{
+			OperationType: models.Record_DELETE,
+			Data:          &models.Record_Department{Department: s.deletedDepartments[key]},
+		})
+	}
+	for _, key := range sortedKeys(s.deletedPatterns) {
+		records = append(records, &models.Record{
+			OperationType: models.Record_DELETE,
+			Data:          &models.Record_AggregationPattern{AggregationPattern: s.deletedPatterns[key]},
+		})
+	}
+	return records
+}
+
+// Save writes the store as a dump file with one message per row, tombstones last
+func (s *Store) Save(path string) error {
+	return writeFileAtomic(path, func(file *os.File) error {
+		writer := NewDumpWriter(file)
+		for _, record := range append(s.records(), s.tombstones()...) {
+			msg := &models.ChangeDataMessage{
+				MessageNumber:     1,
+				TotalMessageCount: 1,
+				TenantUid:         recordTenantUID(record),
+				Records:           []*models.Record{record},
+			}
+			if err := writer.Write(msg); err != nil {
+				return err
+			}
+		}
+		return writer.Flush()
+	})
+}
+
+// LoadStore reads a store previously written by Save
+func LoadStore(path string) (*Store, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store: %w", err)
+	}
+	defer file.Close()
+
+	store := NewStore()
+	err = ReadDump(file, func(_ *sarama.ConsumerMessage, msg *models.ChangeDataMessage) error {
+		store.Apply(msg)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read store %s: %w", path, err)
+	}
+	return store, nil
+}
+
+// Checkpoint records how far a topic has been exported
+type Checkpoint struct {
+	Topic     string          `json:"topic"`
+	Offsets   map[int32]int64 `json:"offsets"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// LoadCheckpoint reads a checkpoint file, returning nil if it does not exist
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %w", path, err)
+	}
+	return &checkpoint, nil
+}
+
+// Save writes the checkpoint file
+func (c *Checkpoint) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, func(file *os.File) error {
+		_, err := file.Write(data)
+		return err
+	})
+}
+
+// Change is a record applied during an incremental export
+type Change struct {
+	Partition int32
+	Offset    int64
+	RequestID string
+	Record    *models.Record
+}
+
+// WriteSnapshot writes the current state tables to dir
+func WriteSnapshot(store *Store, dir, format string) error {
+	tables := newTableSet(dir, format, "", nil)
+	for _, record := range store.records() {
+		if err := tables.write(record, nil); err != nil {
+			tables.close()
+			return err
+		}
+	}
+	return tables.close()
+}
+
+// WriteChanges writes change files for the given changes to dir.
+// Change rows are prefixed with the operation type and source position.
+func WriteChanges(changes []Change, dir, format string, at time.Time) error {
+	changeColumns := []Column{
+		{Name: "operation_type"},
+		{Name: "partition", kind: kindInt},
+		{Name: "offset", kind: kindInt},
+		{Name: "request_id"},
+	}
+	suffix := "_changes_" + at.UTC().Format("20060102T150405Z")
+
+	tables := newTableSet(dir, format, suffix, changeColumns)
+	for _, change := range changes {
+		prefix := Row{
+			stringValue(change.Record.OperationType.String()),
+			intValue(int64(change.Partition)),
+			intValue(change.Offset),
+			stringValue(change.RequestID),
+		}
+		if err := tables.write(change.Record, prefix); err != nil {
+			tables.close()
+			return err
+		}
+	}
+	return tables.close()
+}
+
+// tableSet lazily opens one file per exported table
+type tableSet struct {
+	dir     string
+	format  string
+	suffix  string
+	prefix  []Column
+	files   map[string]*os.File
+	writers map[string]TableWriter
+}
+
+func newTableSet(dir, format, suffix string, prefix []Column) *tableSet {
+	return &tableSet{
+		dir:     dir,
+		format:  format,
+		suffix:  suffix,
+		prefix:  prefix,
+		files:   make(map[string]*os.File),
+		writers: make(map[string]TableWriter),
+	}
+}
+
+func (t *tableSet) write(record *models.Record, prefix Row) error {
+	var table string
+	var row Row
+	var columns []Column
+	switch data := record.Data.(type) {
+	case *models.Record_Department:
+		table, row, columns = TableDepartments, flatten(data.Department), columnsFor(data.Department)
+	case *models.Record_AggregationPattern:
+		table, row, columns = TableAggregationPatterns, flatten(data.AggregationPattern), columnsFor(data.AggregationPattern)
+	case *models.Record_AggregationPatternDepartment:
+		table, row, columns = TableAggregationPatternDepartments, flatten(data.AggregationPatternDepartment), columnsFor(data.AggregationPatternDepartment)
+	default:
+		return nil
+	}
+
+	writer, ok := t.writers[table]
+	if !ok {
+		path := filepath.Join(t.dir, table+t.suffix+"."+t.format)
+		file, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", path, err)
+		}
+		writer, err = NewTableWriter(t.format, file, append(append([]Column{}, t.prefix...), columns...))
+		if err != nil {
+			file.Close()
+			return err
+		}
+		t.files[table] = file
+		t.writers[table] = writer
+	}
+
+	return writer.WriteRow(append(append(Row{}, prefix...), row...))
+}
+
+func (t *tableSet) close() error {
+	var firstErr error
+	for table, writer := range t.writers {
+		if err := writer.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to write %s: %w", table, err)
+		}
+		if err := t.files[table].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// writeFileAtomic writes path through a temporary file so readers never see a partial file
+func writeFileAtomic(path string, write func(file *os.File) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func recordTenantUID(record *models.Record) uint64 {
+	switch data := record.Data.(type) {
+	case *models.Record_Department:
+		return data.Department.TenantUid
+	case *models.Record_AggregationPattern:
+		return data.AggregationPattern.TenantUid
+	case *models.Record_AggregationPatternDepartment:
+		return data.AggregationPatternDepartment.TenantUid
+	}
+	return 0
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
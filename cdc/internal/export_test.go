@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+
+	"kafka_test/models"
+)
+
+func departmentRecord(operation models.Record_OperationType, biid string, version uint32) *models.Record {
+	return &models.Record{
+		OperationType: operation,
+		Data: &models.Record_Department{Department: &models.Department{
+			TenantUid: 1,
+			Biid:      biid,
+			Version:   version,
+		}},
+	}
+}
+
+func patternRecord(operation models.Record_OperationType, biid string, version uint32) *models.Record {
+	return &models.Record{
+		OperationType: operation,
+		Data: &models.Record_AggregationPattern{AggregationPattern: &models.AggregationPattern{
+			TenantUid: 1,
+			Biid:      biid,
+			Version:   version,
+		}},
+	}
+}
+
+func apply(store *Store, records ...*models.Record) int {
+	return len(store.Apply(&models.ChangeDataMessage{Records: records}))
+}
+
+func TestStoreKeepsDeletions(t *testing.T) {
+	store := NewStore()
+	apply(store, departmentRecord(models.Record_CREATE, "d1", 1), patternRecord(models.Record_CREATE, "p1", 1))
+	apply(store, departmentRecord(models.Record_DELETE, "d1", 3), patternRecord(models.Record_DELETE, "p1", 3))
+
+	// Older records of another partition, read after the deletion
+	if n := apply(store, departmentRecord(models.Record_UPDATE, "d1", 2), patternRecord(models.Record_CREATE, "p1", 1)); n != 0 {
+		t.Fatalf("applied %d records older than their deletion", n)
+	}
+	if len(store.records()) != 0 {
+		t.Fatalf("deleted entities brought back: %v", store.records())
+	}
+
+	if n := apply(store, departmentRecord(models.Record_CREATE, "d1", 4)); n != 1 {
+		t.Fatal("record newer than the deletion not applied")
+	}
+	if len(store.records()) != 1 || len(store.tombstones()) != 1 {
+		t.Fatalf("store has %d records and %d tombstones, want 1 each", len(store.records()), len(store.tombstones()))
+	}
+}
+
+func TestStoreSavesTombstones(t *testing.T) {
+	store := NewStore()
+	apply(store, departmentRecord(models.Record_CREATE, "d1", 1), departmentRecord(models.Record_CREATE, "d2", 1))
+	apply(store, departmentRecord(models.Record_DELETE, "d2", 2))
+
+	path := filepath.Join(t.TempDir(), "store.jsonl")
+	if err := store.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.records()) != 1 || len(loaded.tombstones()) != 1 {
+		t.Fatalf("loaded %d records and %d tombstones, want 1 each", len(loaded.records()), len(loaded.tombstones()))
+	}
+	if n := apply(loaded, departmentRecord(models.Record_UPDATE, "d2", 1)); n != 0 {
+		t.Fatal("record older than a saved deletion applied")
+	}
+}
@@ -0,0 +1,199 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"kafka_test/render"
+
+	"github.com/xitongsys/parquet-go/writer"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Output formats supported by TableWriter
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+// columnKind is the type of a column in the exported files
+type columnKind int
+
+const (
+	kindString columnKind = iota
+	kindInt
+)
+
+// Column describes one column of an exported table
+type Column struct {
+	Name string
+	kind columnKind
+}
+
+// Row holds one value per column, nil for absent values
+type Row []*string
+
+// columnsFor returns the columns of a flattened protobuf message, in field order
+func columnsFor(msg proto.Message) []Column {
+	fields := msg.ProtoReflect().Descriptor().Fields()
+	columns := make([]Column, 0, fields.Len())
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		kind := kindString
+		switch fd.Kind() {
+		case protoreflect.Int32Kind, protoreflect.Int64Kind, protoreflect.Uint32Kind, protoreflect.Uint64Kind,
+			protoreflect.Sint32Kind, protoreflect.Sint64Kind, protoreflect.Fixed32Kind, protoreflect.Fixed64Kind:
+			kind = kindInt
+		}
+		columns = append(columns, Column{Name: string(fd.Name()), kind: kind})
+	}
+	return columns
+}
+
+// flatten converts a protobuf message into a row matching columnsFor.
+// Values are formatted like the CLI renderers, see render.FieldString.
+func flatten(msg proto.Message) Row {
+	m := msg.ProtoReflect()
+	fields := m.Descriptor().Fields()
+	row := make(Row, 0, fields.Len())
+	for i := 0; i < fields.Len(); i++ {
+		value, ok := render.FieldString(m, fields.Get(i))
+		if !ok {
+			row = append(row, nil)
+			continue
+		}
+		row = append(row, &value)
+	}
+	return row
+}
+
+// TableWriter writes rows of a single table to a file
+type TableWriter interface {
+	WriteRow(row Row) error
+	Close() error
+}
+
+// NewTableWriter creates a writer for the given format.
+// Close flushes the writer but does not close w.
+func NewTableWriter(format string, w io.Writer, columns []Column) (TableWriter, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVTableWriter(w, columns)
+	case FormatJSONL:
+		return &jsonlTableWriter{w: bufio.NewWriter(w), columns: columns}, nil
+	case FormatParquet:
+		return newParquetTableWriter(w, columns)
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected csv, jsonl or parquet", format)
+	}
+}
+
+type csvTableWriter struct {
+	w *csv.Writer
+}
+
+func newCSVTableWriter(w io.Writer, columns []Column) (*csvTableWriter, error) {
+	csvWriter := csv.NewWriter(w)
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.Name
+	}
+	if err := csvWriter.Write(header); err != nil {
+		return nil, err
+	}
+	return &csvTableWriter{w: csvWriter}, nil
+}
+
+func (c *csvTableWriter) WriteRow(row Row) error {
+	record := make([]string, len(row))
+	for i, value := range row {
+		if value != nil {
+			record[i] = *value
+		}
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvTableWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonlTableWriter struct {
+	w       *bufio.Writer
+	columns []Column
+}
+
+func (j *jsonlTableWriter) WriteRow(row Row) error {
+	object := make(map[string]any, len(row))
+	for i, value := range row {
+		column := j.columns[i]
+		switch {
+		case value == nil:
+			object[column.Name] = nil
+		case column.kind == kindInt:
+			object[column.Name] = json.Number(*value)
+		default:
+			object[column.Name] = *value
+		}
+	}
+
+	data, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	if _, err := j.w.Write(data); err != nil {
+		return err
+	}
+	return j.w.WriteByte('\n')
+}
+
+func (j *jsonlTableWriter) Close() error {
+	return j.w.Flush()
+}
+
+type parquetTableWriter struct {
+	w *writer.CSVWriter
+}
+
+func newParquetTableWriter(w io.Writer, columns []Column) (*parquetTableWriter, error) {
+	schema := make([]string, len(columns))
+	for i, column := range columns {
+		switch column.kind {
+		case kindInt:
+			schema[i] = fmt.Sprintf("name=%s, type=INT64, repetitiontype=OPTIONAL", column.Name)
+		default:
+			schema[i] = fmt.Sprintf("name=%s, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL", column.Name)
+		}
+	}
+
+	parquetWriter, err := writer.NewCSVWriterFromWriter(schema, w, 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create parquet writer: %w", err)
+	}
+	return &parquetTableWriter{w: parquetWriter}, nil
+}
+
+func (p *parquetTableWriter) WriteRow(row Row) error {
+	return p.w.WriteString([]*string(row))
+}
+
+func (p *parquetTableWriter) Close() error {
+	return p.w.WriteStop()
+}
+
+// stringValue returns a pointer to a copy of s, for building rows
+func stringValue(s string) *string {
+	return &s
+}
+
+// intValue returns a pointer to the decimal form of n, for building rows
+func intValue(n int64) *string {
+	return stringValue(strconv.FormatInt(n, 10))
+}
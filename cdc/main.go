@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"kafka_test/cdc/internal"
+	"kafka_test/config"
+	"kafka_test/fixtures"
+	"kafka_test/models"
+	"kafka_test/render"
+	"kafka_test/validation"
+
+	"github.com/Shopify/sarama"
+)
+
+// errViolations signals that validation completed but found violations
+var errViolations = errors.New("validation violations found")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: cdc <command> [flags]
+
+Commands:
+  dump      Write every ChangeDataMessage in a topic to a JSON Lines dump file
+  export    Export current-state tables, then incremental change files on later runs
+  generate  Write generated fixture ChangeDataMessages to a JSON Lines dump file
+  print     Render the ChangeDataMessages of a dump file
+  validate  Evaluate validation rules over a dump file
+
+Run "cdc <command> -h" for command flags.
+`)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	// Handle graceful shutdown
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	var err error
+	switch os.Args[1] {
+	case "dump":
+		err = runDump(ctx, os.Args[2:])
+	case "export":
+		err = runExport(ctx, os.Args[2:])
+	case "generate":
+		err = runGenerate(os.Args[2:])
+	case "print":
+		err = runPrint(os.Args[2:])
+	case "validate":
+		err = runValidate(os.Args[2:])
+	case "-h", "--help", "help":
+		usage()
+		return
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if errors.Is(err, errViolations) {
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatalf("cdc %s failed: %v", os.Args[1], err)
+	}
+}
+
+// runDump reads the topic from the beginning and writes a dump file
+func runDump(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	topic := fs.String("topic", config.GetTopicName(), "Topic to read")
+	output := fs.String("output", "", "Dump file to write (default stdout)")
+	fs.Parse(args)
+
+	out := os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create dump file: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	writer := internal.NewDumpWriter(out)
+	count := 0
+	_, err := internal.ReadTopic(ctx, *topic, nil, func(_ *sarama.ConsumerMessage, msg *models.ChangeDataMessage) error {
+		count++
+		return writer.Write(msg)
+	})
+	if err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write dump file: %w", err)
+	}
+
+	log.Printf("Dumped %d ChangeDataMessage(s) from topic %s", count, *topic)
+	return nil
+}
+
+// runExport writes a snapshot of the materialized tables on the first run and
+// change files from the stored checkpoint on later runs
+func runExport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	topic := fs.String("topic", config.GetTopicName(), "Topic to read")
+	dir := fs.String("dir", "export", "Output directory, also holds the checkpoint and materialized store")
+	format := fs.String("format", internal.FormatCSV, "Output format: csv, jsonl or parquet")
+	full := fs.Bool("full", false, "Ignore the checkpoint and write a new snapshot from the beginning of the topic")
+	fromStore := fs.Bool("from-store", false, "Write a snapshot from the materialized store without reading Kafka")
+	fs.Parse(args)
+
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	checkpointPath := filepath.Join(*dir, "checkpoint.json")
+	storePath := filepath.Join(*dir, "store.jsonl")
+
+	if *fromStore {
+		store, err := internal.LoadStore(storePath)
+		if err != nil {
+			return err
+		}
+		log.Printf("Writing snapshot from store %s", storePath)
+		return internal.WriteSnapshot(store, *dir, *format)
+	}
+
+	checkpoint, err := internal.LoadCheckpoint(checkpointPath)
+	if err != nil {
+		return err
+	}
+	if checkpoint != nil && checkpoint.Topic != *topic {
+		return fmt.Errorf("checkpoint in %s belongs to topic %s, use another -dir or -full", *dir, checkpoint.Topic)
+	}
+
+	var offsets map[int32]int64
+	if checkpoint == nil || *full {
+		// Snapshot: materialize the whole topic
+		store := internal.NewStore()
+		offsets, err = internal.ReadTopic(ctx, *topic, nil, func(_ *sarama.ConsumerMessage, msg *models.ChangeDataMessage) error {
+			store.Apply(msg)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := internal.WriteSnapshot(store, *dir, *format); err != nil {
+			return err
+		}
+		if err := store.Save(storePath); err != nil {
+			return err
+		}
+		log.Printf("Snapshot of topic %s written to %s", *topic, *dir)
+	} else {
+		// Incremental: apply what was produced since the checkpoint
+		store, err := internal.LoadStore(storePath)
+		if err != nil {
+			return err
+		}
+
+		var changes []internal.Change
+		offsets, err = internal.ReadTopic(ctx, *topic, checkpoint.Offsets, func(message *sarama.ConsumerMessage, msg *models.ChangeDataMessage) error {
+			for _, record := range store.Apply(msg) {
+				changes = append(changes, internal.Change{
+					Partition: message.Partition,
+					Offset:    message.Offset,
+					RequestID: msg.RequestId,
+					Record:    record,
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(changes) == 0 {
+			log.Printf("No changes since the last checkpoint")
+		} else if err := internal.WriteChanges(changes, *dir, *format, time.Now()); err != nil {
+			return err
+		} else {
+			log.Printf("%d change(s) of topic %s written to %s", len(changes), *topic, *dir)
+		}
+		if err := store.Save(storePath); err != nil {
+			return err
+		}
+	}
+
+	checkpoint = &internal.Checkpoint{Topic: *topic, Offsets: offsets, UpdatedAt: time.Now()}
+	return checkpoint.Save(checkpointPath)
+}
+
+// runGenerate writes the messages of a fixture scenario to a dump file without touching Kafka
+func runGenerate(args []string) error {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	scenarioPath := fs.String("scenario", config.GetScenarioPath(), "Scenario YAML file (default built-in scenario)")
+	requests := fs.Int("requests", 100, "Number of requests to generate")
+	output := fs.String("output", "", "Dump file to write (default stdout)")
+	fs.Parse(args)
+
+	scenario := fixtures.DefaultScenario()
+	if *scenarioPath != "" {
+		var err error
+		scenario, err = fixtures.LoadScenario(*scenarioPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	generator, err := fixtures.NewGenerator(scenario)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create dump file: %w", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	writer := internal.NewDumpWriter(out)
+	for i := 0; i < *requests; i++ {
+		for _, msg := range generator.NextRequest() {
+			if err := writer.Write(msg); err != nil {
+				return err
+			}
+		}
+	}
+	return writer.Flush()
+}
+
+// runPrint renders every message of a dump file
+func runPrint(args []string) error {
+	fs := flag.NewFlagSet("print", flag.ExitOnError)
+	input := fs.String("input", "", "Dump file to print (default stdin)")
+	format := fs.String("format", config.GetRenderFormat(), "Render format: "+strings.Join(render.Formats, ", "))
+	fields := fs.String("fields", strings.Join(config.GetRenderFields(), ","), "Comma-separated field paths to show, e.g. request_id,records.department.code")
+	defaults := fs.Bool("defaults", config.GetRenderDefaults(), "Also show fields holding default values")
+	fs.Parse(args)
+
+	var fieldList []string
+	if *fields != "" {
+		fieldList = strings.Split(*fields, ",")
+	}
+	renderer, err := render.New(*format, render.Options{Fields: fieldList, EmitDefaults: *defaults})
+	if err != nil {
+		return err
+	}
+
+	in := os.Stdin
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return fmt.Errorf("failed to open dump file: %w", err)
+		}
+		defer file.Close()
+		in = file
+	}
+
+	return internal.ReadDump(in, func(_ *sarama.ConsumerMessage, msg *models.ChangeDataMessage) error {
+		return renderer.Render(os.Stdout, msg)
+	})
+}
+
+// runValidate evaluates the rules over a dump file and exits non-zero on violations that are not warnings
+func runValidate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	rulesPath := fs.String("rules", config.GetValidationRulesPath(), "Validation rules YAML file")
+	input := fs.String("input", "", "Dump file to validate (default stdin)")
+	format := fs.String("format", "text", "Report format: text or json")
+	fs.Parse(args)
+
+	if *rulesPath == "" {
+		return fmt.Errorf("no rules file given, use -rules or KAFKA_CDC_VALIDATION_RULES")
+	}
+
+	rules, err := validation.LoadRules(*rulesPath)
+	if err != nil {
+		return err
+	}
+	// A dump is read once from its start, so the validator remembers every entity of it
+	validator, err := validation.NewValidator(rules, validation.Options{})
+	if err != nil {
+		return err
+	}
+
+	in := os.Stdin
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return fmt.Errorf("failed to open dump file: %w", err)
+		}
+		defer file.Close()
+		in = file
+	}
+
+	report := validation.NewReport()
+	err = internal.ReadDump(in, func(_ *sarama.ConsumerMessage, msg *models.ChangeDataMessage) error {
+		report.Add(len(msg.Records), validator.Validate(msg))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "json":
+		err = report.WriteJSON(os.Stdout)
+	case "text":
+		err = report.WriteText(os.Stdout)
+	default:
+		return fmt.Errorf("unknown report format %q", *format)
+	}
+	if err != nil {
+		return err
+	}
+
+	if report.HasErrors() {
+		return errViolations
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"kafka_test/batch"
+	"kafka_test/logging"
+	"kafka_test/metrics"
+	"kafka_test/models"
+)
+
+// HandleBatch handles the ChangeDataMessages of a batch like processChangeDataMessage,
+// then writes their records downstream in one bulk write instead of one per message.
+// Duplicates are skipped, also when both copies are in the batch. A message that cannot
+// be decoded is logged and skipped like in the streaming path, retrying cannot fix it.
+// A message that cannot be quarantined fails the batch at its index, so the failure
+// policy retries it, splits the batch around it or sends it to the dead letter topic.
+func (c *ChangeDataConsumer) HandleBatch(ctx context.Context, envelopes []*batch.Envelope) error {
+	records := 0
+	var ids []string
+	// inBatch holds the identities of the batch, none of them is processed before the write
+	inBatch := make(map[string]bool)
+	for i, envelope := range envelopes {
+		id, duplicate := c.dedup.Duplicate(envelope.ConsumerMessage)
+		if duplicate {
+			continue
+		}
+		if id != "" {
+			if inBatch[id] {
+				metrics.DuplicateSkipped(c.groupID, envelope.Topic)
+				logging.Sampled().Info("Skipping duplicate message", append(logging.Message(envelope.ConsumerMessage), "id", id)...)
+				continue
+			}
+			inBatch[id] = true
+			ids = append(ids, id)
+		}
+
+		logger := logging.Sampled().With(logging.Message(envelope.ConsumerMessage)...)
+		logger.Debug("Received message", logging.Headers(envelope.ConsumerMessage))
+
+		var changeDataMsg *models.ChangeDataMessage
+		err := c.step(envelope.Context, "decode", func(context.Context) error {
+			var err error
+			changeDataMsg, err = decodeMessage(logger, envelope.ConsumerMessage)
+			return err
+		})
+		if err != nil || changeDataMsg == nil {
+			continue
+		}
+		logger = logger.With(logging.ChangeData(changeDataMsg)...)
+
+		err = c.step(envelope.Context, "handle", func(ctx context.Context) error {
+			c.logChangeDataMessage(logger, changeDataMsg)
+			err := c.validateChangeDataMessage(ctx, logger, envelope.ConsumerMessage, changeDataMsg)
+			c.diffChangeDataMessage(ctx, logger, envelope.ConsumerMessage, changeDataMsg)
+			return err
+		})
+		if err != nil {
+			return batch.Failed(i, err)
+		}
+		records += len(changeDataMsg.Records)
+	}
+
+	// Simulate one bulk write of the records of the batch
+	start := time.Now()
+	time.Sleep(500 * time.Millisecond)
+	metrics.ObserveStep(c.groupID, "write", time.Since(start))
+
+	for _, id := range ids {
+		c.dedup.Processed(id)
+	}
+	logging.Sampled().Info("Batch processed", "messages", len(envelopes), "records", records)
+	return nil
+}
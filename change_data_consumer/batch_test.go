@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"kafka_test/adminhttp"
+	"kafka_test/batch"
+	"kafka_test/dedup"
+	"kafka_test/models"
+	"kafka_test/validation"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"google.golang.org/protobuf/proto"
+)
+
+// newTestConsumer creates a consumer deduplicating on the message-id header that
+// quarantines every department, as none has a code
+func newTestConsumer(t *testing.T) (*ChangeDataConsumer, *mocks.SyncProducer) {
+	t.Helper()
+	filter, err := dedup.New("group", dedup.Options{Key: "header", Header: "message-id", Store: "memory", Size: 100, TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator, err := validation.NewValidator(&validation.Rules{Department: validation.EntityRules{Required: []string{"code"}}}, validation.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	producer := mocks.NewSyncProducer(t, nil)
+	t.Cleanup(func() { producer.Close() })
+	return &ChangeDataConsumer{
+		groupID:         "group",
+		topic:           "cdc",
+		state:           adminhttp.NewState("group"),
+		validator:       validator,
+		dedup:           filter,
+		producer:        producer,
+		quarantineTopic: "quarantine",
+	}, producer
+}
+
+func envelope(t *testing.T, offset int64, messageID string, value []byte) *batch.Envelope {
+	t.Helper()
+	if value == nil {
+		var err error
+		value, err = proto.Marshal(&models.ChangeDataMessage{
+			RequestId: messageID,
+			Records:   []*models.Record{{Data: &models.Record_Department{Department: &models.Department{Biid: messageID}}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &batch.Envelope{
+		ConsumerMessage: &sarama.ConsumerMessage{
+			Topic:  "cdc",
+			Offset: offset,
+			Value:  value,
+			Headers: []*sarama.RecordHeader{
+				{Key: []byte("content-type"), Value: []byte("application/x-protobuf")},
+				{Key: []byte("message-id"), Value: []byte(messageID)},
+			},
+		},
+		Context: context.Background(),
+	}
+}
+
+func TestHandleBatchSkipsDuplicatesInTheBatch(t *testing.T) {
+	c, producer := newTestConsumer(t)
+	// One quarantine per distinct message, a second send fails the mock
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndSucceed()
+
+	err := c.HandleBatch(context.Background(), []*batch.Envelope{
+		envelope(t, 0, "m1", nil),
+		envelope(t, 1, "m1", nil),
+		envelope(t, 2, "m2", nil),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both are processed now, their redeliveries are skipped
+	if err := c.HandleBatch(context.Background(), []*batch.Envelope{envelope(t, 3, "m1", nil), envelope(t, 4, "m2", nil)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleBatchFailsAtTheMessageNotQuarantined(t *testing.T) {
+	c, producer := newTestConsumer(t)
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndFail(sarama.ErrNotEnoughReplicas)
+
+	err := c.HandleBatch(context.Background(), []*batch.Envelope{
+		envelope(t, 0, "m1", nil),
+		envelope(t, 1, "m2", nil),
+		envelope(t, 2, "m3", nil),
+	})
+	var itemErr *batch.ItemError
+	if !errors.As(err, &itemErr) || itemErr.Index != 1 {
+		t.Fatalf("got %v, want the failure of message 1", err)
+	}
+
+	// Nothing of a failed batch is remembered as processed
+	producer.ExpectSendMessageAndSucceed()
+	if err := c.HandleBatch(context.Background(), []*batch.Envelope{envelope(t, 0, "m1", nil)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleBatchSkipsMessagesNotDecoded(t *testing.T) {
+	c, producer := newTestConsumer(t)
+	// m1 and m3 are quarantined, m2 is skipped
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndSucceed()
+
+	err := c.HandleBatch(context.Background(), []*batch.Envelope{
+		envelope(t, 0, "m1", nil),
+		envelope(t, 1, "m2", []byte{0xff, 0xff}),
+		envelope(t, 2, "m3", nil),
+	})
+	if err != nil {
+		t.Fatalf("got %v, want the undecodable message skipped", err)
+	}
+}
@@ -3,59 +3,211 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
+	"kafka_test/adminhttp"
+	"kafka_test/batch"
 	"kafka_test/config"
+	"kafka_test/dedup"
+	"kafka_test/diff"
+	"kafka_test/lifecycle"
+	"kafka_test/logging"
+	"kafka_test/metrics"
 	"kafka_test/models"
+	"kafka_test/rebalance"
+	"kafka_test/render"
+	"kafka_test/replay"
+	"kafka_test/tracing"
+	"kafka_test/validation"
 
 	"github.com/Shopify/sarama"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 )
 
 // ChangeDataConsumer represents a Sarama consumer group consumer for ChangeDataMessage
 type ChangeDataConsumer struct {
-	groupID string
-	topic   string
+	// Hooks are called when partitions are assigned and revoked
+	rebalance.Hooks
+
+	groupID  string
+	topic    string
+	renderer render.Renderer
+	state    *adminhttp.State
+
+	// validator is nil when no validation rules are configured
+	validator *validation.Validator
+	// tracker keeps the previous version of each entity, nil when diffing is disabled
+	tracker *diff.Tracker
+	// dedup skips messages processed already, nil when deduplication is off
+	dedup *dedup.Filter
+
+	// producer publishes quarantined messages and diff events, nil when neither is enabled
+	producer        sarama.SyncProducer
+	quarantineTopic string
+	diffTopic       string
 }
 
-// NewChangeDataConsumer creates a new ChangeDataMessage consumer
-func NewChangeDataConsumer() (*ChangeDataConsumer, error) {
-	return &ChangeDataConsumer{
-		groupID: config.GetChangeDataGroupID(),
-		topic:   config.GetTopicName(),
-	}, nil
+// retryBackoff is the wait before consuming again a message that could not be processed,
+// and before a new group session after a failed one
+const retryBackoff = time.Second
+
+// diffEvent is the payload published to the diff topic: the diff enriched with the record itself
+type diffEvent struct {
+	*diff.Event
+	SourceTopic  string          `json:"source_topic"`
+	SourceOffset string          `json:"source_offset"`
+	Record       json.RawMessage `json:"record"`
+}
+
+// NewChangeDataConsumer creates a new ChangeDataMessage consumer. A consumer replaying a
+// range does not deduplicate, it reprocesses messages processed already on purpose.
+func NewChangeDataConsumer(replaying bool) (*ChangeDataConsumer, error) {
+	renderer, err := render.New(config.GetRenderFormat(), render.Options{
+		Fields:       config.GetRenderFields(),
+		EmitDefaults: config.GetRenderDefaults(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create renderer: %w", err)
+	}
+
+	groupID := config.GetChangeDataGroupID()
+	c := &ChangeDataConsumer{
+		groupID:         groupID,
+		topic:           config.GetTopicName(),
+		renderer:        renderer,
+		state:           adminhttp.NewState(groupID),
+		quarantineTopic: config.GetQuarantineTopic(),
+	}
+
+	if !replaying {
+		c.dedup, err = dedup.New(groupID, dedup.Options{
+			Key:    config.GetDedupKey(),
+			Header: config.GetDedupHeader(),
+			Store:  config.GetDedupStore(),
+			Path:   config.GetDedupPath(),
+			Size:   config.GetDedupSize(),
+			TTL:    config.GetDedupTTL(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create deduplication filter: %w", err)
+		}
+	}
+
+	if config.GetDiffEnabled() {
+		c.tracker = diff.NewTracker(diff.Options{
+			Size:    config.GetDiffSize(),
+			TTL:     config.GetDiffTTL(),
+			Unknown: config.GetDiffUnknown(),
+		})
+		c.diffTopic = config.GetDiffTopic()
+	}
+
+	if rulesPath := config.GetValidationRulesPath(); rulesPath != "" {
+		rules, err := validation.LoadRules(rulesPath)
+		if err != nil {
+			return nil, err
+		}
+		c.validator, err = validation.NewValidator(rules, validation.Options{
+			Size: config.GetValidationSize(),
+			TTL:  config.GetValidationTTL(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("Validating ChangeDataMessages", "rules", rulesPath)
+	}
+
+	if c.validator == nil {
+		c.quarantineTopic = ""
+	}
+
+	if c.quarantineTopic != "" || c.diffTopic != "" {
+		producerConfig := config.GetProducerConfig()
+		metrics.RegisterSarama("producer", producerConfig.MetricRegistry)
+		producer, err := sarama.NewSyncProducer(config.GetBrokers(), producerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create producer: %w", err)
+		}
+		c.producer = producer
+	}
+	if c.quarantineTopic != "" {
+		slog.Info("Quarantining invalid ChangeDataMessages", "topic", c.quarantineTopic)
+	}
+	if c.diffTopic != "" {
+		slog.Info("Publishing record diffs", "topic", c.diffTopic)
+	}
+
+	return c, nil
+}
+
+// Close closes the producer if one was created and the deduplication store
+func (c *ChangeDataConsumer) Close() error {
+	var err error
+	if c.producer != nil {
+		err = c.producer.Close()
+	}
+	return errors.Join(err, c.dedup.Close())
 }
 
 // Setup is run at the beginning of a new session, before ConsumeClaim
-func (c *ChangeDataConsumer) Setup(sarama.ConsumerGroupSession) error {
-	log.Println("ChangeDataConsumer setup completed")
-	return nil
+func (c *ChangeDataConsumer) Setup(session sarama.ConsumerGroupSession) error {
+	metrics.SessionStarted(c.groupID)
+	c.state.SessionStarted(session)
+	return c.Assigned(session)
 }
 
 // Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
-func (c *ChangeDataConsumer) Cleanup(sarama.ConsumerGroupSession) error {
-	log.Println("ChangeDataConsumer cleanup completed")
-	return nil
+func (c *ChangeDataConsumer) Cleanup(session sarama.ConsumerGroupSession) error {
+	metrics.SessionEnded(c.groupID, session.Claims())
+	c.state.SessionEnded()
+	// Let the hooks flush their state, then commit the offsets marked since the last
+	// commit before the partitions are released
+	err := c.Revoked(session)
+	session.Commit()
+	return err
 }
 
 // ConsumeClaim must start a consumer loop of ConsumerGroupClaim's Messages()
 func (c *ChangeDataConsumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	c.state.ClaimStarted(claim.Topic(), claim.Partition())
 	for {
 		select {
 		case message := <-claim.Messages():
 			if message == nil {
 				return nil
 			}
+			// Stop taking messages once the session ends, they are fetched again by the next owner
+			if session.Context().Err() != nil {
+				return nil
+			}
+			metrics.ObserveConsume(c.groupID, message.Topic, message.Partition, message.Offset, claim.HighWaterMarkOffset())
+
+			// Skip messages processed already, only moving the offset past them
+			id, duplicate := c.dedup.Duplicate(message)
+			if duplicate {
+				session.MarkMessage(message, "")
+				continue
+			}
 
 			if err := c.processChangeDataMessage(session, message); err != nil {
-				log.Printf("Error processing ChangeDataMessage: %v", err)
-				// Continue processing other messages even if one fails
+				metrics.ConsumeError(c.groupID, message.Topic)
+				slog.Error("Error processing ChangeDataMessage, consuming it again from the last committed offset",
+					append(logging.Message(message), "error", err)...)
+				// Ending the claim ends the session; the next session starts from the committed offsets
+				select {
+				case <-time.After(retryBackoff):
+				case <-session.Context().Done():
+				}
+				return nil
 			}
+			c.dedup.Processed(id)
 
 		case <-session.Context().Done():
 			return nil
@@ -63,26 +215,57 @@ func (c *ChangeDataConsumer) ConsumeClaim(session sarama.ConsumerGroupSession, c
 	}
 }
 
-// processChangeDataMessage processes a single ChangeDataMessage
-func (c *ChangeDataConsumer) processChangeDataMessage(session sarama.ConsumerGroupSession, message *sarama.ConsumerMessage) error {
-	// Step 1: Log message details
-	step1Start := time.Now()
-	log.Printf("Received message - Topic: %s, Partition: %d, Offset: %d, Key: %s",
-		message.Topic, message.Partition, message.Offset, string(message.Key))
-	calculateElapsedTime(step1Start, "Log message details")
+// processChangeDataMessage processes a single ChangeDataMessage in a span continuing the
+// producer's trace, with child spans for the decode, handle and commit steps
+func (c *ChangeDataConsumer) processChangeDataMessage(session sarama.ConsumerGroupSession, message *sarama.ConsumerMessage) (err error) {
+	ctx, span := tracing.StartConsume(session.Context(), c.groupID, message)
+	defer func() { tracing.End(span, err) }()
+
+	logger := logging.Sampled().With(logging.Message(message)...)
+	logger.Debug("Received message", logging.Headers(message))
+
+	// Decode: parse the message based on its content type. A message that
+	// cannot be parsed is still marked as processed, the error is on the span.
+	var changeDataMsg *models.ChangeDataMessage
+	c.step(ctx, "decode", func(context.Context) error {
+		var err error
+		changeDataMsg, err = decodeMessage(logger, message)
+		return err
+	})
+	if changeDataMsg != nil {
+		logger = logger.With(logging.ChangeData(changeDataMsg)...)
+	}
 
-	// Step 2: Print headers if available
-	step2Start := time.Now()
-	if len(message.Headers) > 0 {
-		log.Printf("Message headers:")
-		for _, header := range message.Headers {
-			log.Printf("  %s: %s", string(header.Key), string(header.Value))
+	// Handle: log, validate and diff the ChangeDataMessage, then simulate processing time.
+	// A message that could not be quarantined is not marked, so it is consumed again.
+	err = c.step(ctx, "handle", func(ctx context.Context) error {
+		var err error
+		if changeDataMsg != nil {
+			c.logChangeDataMessage(logger, changeDataMsg)
+			err = c.validateChangeDataMessage(ctx, logger, message, changeDataMsg)
+			c.diffChangeDataMessage(ctx, logger, message, changeDataMsg)
 		}
+		time.Sleep(500 * time.Millisecond)
+		return err
+	})
+	if err != nil {
+		return err
 	}
-	calculateElapsedTime(step2Start, "Print headers")
 
-	// Step 3: Check content type
-	step3Start := time.Now()
+	// Commit: mark the message as processed and commit immediately for consistency
+	return c.step(ctx, "commit", func(context.Context) error {
+		session.MarkMessage(message, "")
+		logger.Info("Message processed")
+		commitStart := time.Now()
+		session.Commit()
+		metrics.ObserveCommit(c.groupID, commitStart)
+		return nil
+	})
+}
+
+// decodeMessage parses a ChangeDataMessage from a protobuf message; other
+// messages are logged as JSON or plain text and return nil
+func decodeMessage(logger *slog.Logger, message *sarama.ConsumerMessage) (*models.ChangeDataMessage, error) {
 	contentType := ""
 	for _, header := range message.Headers {
 		if string(header.Key) == "content-type" {
@@ -90,162 +273,252 @@ func (c *ChangeDataConsumer) processChangeDataMessage(session sarama.ConsumerGro
 			break
 		}
 	}
-	calculateElapsedTime(step3Start, "Check content type")
 
-	// Step 4: Parse message based on content type
-	step4Start := time.Now()
 	if contentType == "application/x-protobuf" {
-		// Try to parse as ChangeDataMessage
 		var changeDataMsg models.ChangeDataMessage
 		if err := proto.Unmarshal(message.Value, &changeDataMsg); err != nil {
-			log.Printf("Failed to parse ChangeDataMessage: %v", err)
-			log.Printf("Raw message value: %s", string(message.Value))
-		} else {
-			c.logChangeDataMessage(&changeDataMsg)
+			logger.Warn("Failed to parse ChangeDataMessage", "error", err, logging.Payload(message.Value))
+			return nil, fmt.Errorf("failed to parse ChangeDataMessage: %w", err)
 		}
+		return &changeDataMsg, nil
+	}
+
+	// Try to parse as JSON message
+	var msg models.Message
+	if err := json.Unmarshal(message.Value, &msg); err != nil {
+		// If JSON parsing fails, treat as plain text message
+		logger.Info("Received plain text message", logging.Payload(message.Value))
 	} else {
-		// Try to parse as JSON message
-		var msg models.Message
-		if err := json.Unmarshal(message.Value, &msg); err != nil {
-			// If JSON parsing fails, treat as plain text message
-			log.Printf("Message is plain text: %s", string(message.Value))
-		} else {
-			log.Printf("Parsed JSON message: %s", msg.String())
-		}
+		logger.Info("Received JSON message", "message_id", msg.ID, logging.Payload(message.Value))
 	}
-	calculateElapsedTime(step4Start, "Parse message")
+	return nil, nil
+}
 
-	// Step 5: Simulate processing time
-	step5Start := time.Now()
-	time.Sleep(500 * time.Millisecond)
-	calculateElapsedTime(step5Start, "Simulate processing")
+// step runs one step of processing a message in a child span and records its duration
+func (c *ChangeDataConsumer) step(ctx context.Context, name string, fn func(context.Context) error) error {
+	ctx, span := tracing.Start(ctx, name)
+	start := time.Now()
+	err := fn(ctx)
+	metrics.ObserveStep(c.groupID, name, time.Since(start))
+	tracing.End(span, err)
+	return err
+}
 
-	// Step 6: Mark message as processed
-	step6Start := time.Now()
-	session.MarkMessage(message, "")
-	log.Printf("Message processed - Partition: %d, Offset: %d \n", message.Partition, message.Offset)
-	calculateElapsedTime(step6Start, "Mark message")
+// logChangeDataMessage logs a summary of a ChangeDataMessage, and its details using the
+// configured renderer if payload logging is enabled
+func (c *ChangeDataConsumer) logChangeDataMessage(logger *slog.Logger, msg *models.ChangeDataMessage) {
+	if !logging.Payloads() {
+		logger.Info("Received ChangeDataMessage", "records", len(msg.Records))
+		return
+	}
+	output, err := render.RenderString(c.renderer, msg)
+	if err != nil {
+		logger.Warn("Failed to render ChangeDataMessage", "error", err)
+		return
+	}
+	logger.Info("Received ChangeDataMessage", "records", len(msg.Records), "payload", output)
+}
 
-	// Step 7: Commit immediately for consistency
-	step7Start := time.Now()
-	session.Commit()
-	calculateElapsedTime(step7Start, "Commit")
+// validateChangeDataMessage logs rule violations and forwards invalid messages to the quarantine
+// topic. It returns an error if an invalid message could not be quarantined.
+func (c *ChangeDataConsumer) validateChangeDataMessage(ctx context.Context, logger *slog.Logger, message *sarama.ConsumerMessage, msg *models.ChangeDataMessage) error {
+	if c.validator == nil {
+		return nil
+	}
+
+	violations := c.validator.Validate(msg)
+	if len(violations) == 0 {
+		return nil
+	}
 
-	// Total execution time
-	totalTime := time.Since(step1Start)
-	log.Printf("Total processChangeDataMessage execution time: %v", totalTime)
+	descriptions := make([]string, len(violations))
+	for i, violation := range violations {
+		descriptions[i] = violation.String()
+	}
+	logger.Warn("ChangeDataMessage failed validation", "violations", descriptions)
 
+	// Violations of rules configured as warnings are only logged
+	if c.quarantineTopic == "" || !validation.HasErrors(violations) {
+		return nil
+	}
+
+	violationsJSON, err := json.Marshal(violations)
+	if err != nil {
+		logger.Error("Failed to marshal violations", "error", err)
+		return fmt.Errorf("failed to marshal violations: %w", err)
+	}
+
+	headers := make([]sarama.RecordHeader, 0, len(message.Headers)+3)
+	for _, header := range message.Headers {
+		headers = append(headers, *header)
+	}
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte("validation-violations"), Value: violationsJSON},
+		sarama.RecordHeader{Key: []byte("source-topic"), Value: []byte(message.Topic)},
+		sarama.RecordHeader{Key: []byte("source-offset"), Value: []byte(fmt.Sprintf("%d/%d", message.Partition, message.Offset))},
+	)
+
+	partition, offset, err := c.send(ctx, &sarama.ProducerMessage{
+		Topic:   c.quarantineTopic,
+		Key:     sarama.ByteEncoder(message.Key),
+		Value:   sarama.ByteEncoder(message.Value),
+		Headers: headers,
+	})
+	if err != nil {
+		logger.Error("Failed to quarantine message", "error", err)
+		return fmt.Errorf("failed to quarantine message: %w", err)
+	}
+	logger.Info("Message quarantined", "quarantine_topic", c.quarantineTopic,
+		"quarantine_partition", partition, "quarantine_offset", offset, "violations", len(violations))
 	return nil
 }
 
-// logChangeDataMessage logs the details of a ChangeDataMessage
-func (c *ChangeDataConsumer) logChangeDataMessage(msg *models.ChangeDataMessage) {
-	log.Printf("=== ChangeDataMessage ===")
-	log.Printf("Request ID: %s", msg.RequestId)
-	log.Printf("Message Number: %d", msg.MessageNumber)
-	log.Printf("Total Message Count: %d", msg.TotalMessageCount)
-	log.Printf("Tenant UID: %d", msg.TenantUid)
-	log.Printf("Records Count: %d", len(msg.Records))
-
-	for i, record := range msg.Records {
-		log.Printf("--- Record %d ---", i+1)
-		log.Printf("Operation Type: %s", record.OperationType.String())
-
-		switch data := record.Data.(type) {
-		case *models.Record_Department:
-			dept := data.Department
-			log.Printf("Department:")
-			log.Printf("  ID: %s", dept.Id)
-			log.Printf("  BIID: %s", dept.Biid)
-			log.Printf("  Code: %s", dept.Code)
-			log.Printf("  Name: %s", dept.Name)
-			log.Printf("  Short Name: %s", dept.ShortName)
-			log.Printf("  Type: %s", dept.Type)
-			log.Printf("  Version: %d", dept.Version)
-
-		case *models.Record_AggregationPattern:
-			agg := data.AggregationPattern
-			log.Printf("Aggregation Pattern:")
-			log.Printf("  ID: %s", agg.Id)
-			log.Printf("  BIID: %s", agg.Biid)
-			log.Printf("  Name: %s", agg.Name)
-			log.Printf("  Version: %d", agg.Version)
-
-		case *models.Record_AggregationPatternDepartment:
-			aggDept := data.AggregationPatternDepartment
-			log.Printf("Aggregation Pattern Department:")
-			log.Printf("  Aggregation Pattern ID: %s", aggDept.AggregationPatternId)
-			log.Printf("  Department BIID: %s", aggDept.DepartmentBiid)
-			log.Printf("  Path: %s", aggDept.Path)
-
-		default:
-			log.Printf("Unknown record type")
+// diffChangeDataMessage logs what changed in each record compared to the previous
+// version of its entity and publishes the diffs to the diff topic if configured
+func (c *ChangeDataConsumer) diffChangeDataMessage(ctx context.Context, logger *slog.Logger, message *sarama.ConsumerMessage, msg *models.ChangeDataMessage) {
+	if c.tracker == nil {
+		return
+	}
+
+	for _, record := range msg.Records {
+		event := c.tracker.Observe(msg.RequestId, record)
+		if event == nil {
+			continue
+		}
+
+		logger.Info("Record diff", "biid", event.Biid, "diff", event.String())
+
+		if c.diffTopic != "" {
+			c.publishDiff(ctx, logger, message, record, event)
 		}
 	}
-	log.Printf("========================")
 }
 
-// calculateElapsedTime calculates and logs the elapsed time for a given step
-func calculateElapsedTime(startTime time.Time, stepName string) {
-	elapsed := time.Since(startTime)
-	log.Printf("Step %s execution time: %v", stepName, elapsed)
+// publishDiff sends a diff event enriched with the record to the diff topic
+func (c *ChangeDataConsumer) publishDiff(ctx context.Context, logger *slog.Logger, message *sarama.ConsumerMessage, record *models.Record, event *diff.Event) {
+	recordJSON, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(record)
+	if err != nil {
+		logger.Error("Failed to marshal record", "error", err)
+		return
+	}
+
+	value, err := json.Marshal(diffEvent{
+		Event:        event,
+		SourceTopic:  message.Topic,
+		SourceOffset: fmt.Sprintf("%d/%d", message.Partition, message.Offset),
+		Record:       recordJSON,
+	})
+	if err != nil {
+		logger.Error("Failed to marshal diff event", "error", err)
+		return
+	}
+
+	partition, offset, err := c.send(ctx, &sarama.ProducerMessage{
+		Topic: c.diffTopic,
+		Key:   sarama.StringEncoder(event.Biid),
+		Value: sarama.ByteEncoder(value),
+		Headers: []sarama.RecordHeader{
+			{Key: []byte("content-type"), Value: []byte("application/json")},
+			{Key: []byte("request-id"), Value: []byte(event.RequestID)},
+		},
+	})
+	if err != nil {
+		logger.Error("Failed to publish diff event", "error", err)
+		return
+	}
+	logger.Debug("Diff event published", "diff_topic", c.diffTopic, "diff_partition", partition, "diff_offset", offset)
+}
+
+// send publishes a message derived from a consumed one, recording its metrics and a
+// producer span whose context replaces the trace headers copied from the consumed message
+func (c *ChangeDataConsumer) send(ctx context.Context, msg *sarama.ProducerMessage) (int32, int64, error) {
+	_, span := tracing.StartProduce(ctx, msg)
+	start := time.Now()
+	partition, offset, err := c.producer.SendMessage(msg)
+	metrics.ObserveProduce(msg.Topic, start, err)
+	tracing.EndProduce(span, partition, offset, err)
+	return partition, offset, err
 }
 
-// Start starts the consumer
+// State returns the state served by the admin endpoints
+func (c *ChangeDataConsumer) State() *adminhttp.State {
+	return c.state
+}
+
+// Start consumes until the context is cancelled, then drains and leaves the group
 func (c *ChangeDataConsumer) Start(ctx context.Context) error {
 	kafkaConfig := config.GetConsumerConfig()
+	metrics.RegisterSarama("consumer", kafkaConfig.MetricRegistry)
 
 	// Create consumer group
 	group, err := sarama.NewConsumerGroup(config.GetBrokers(), c.groupID, kafkaConfig)
 	if err != nil {
 		return fmt.Errorf("failed to create consumer group: %w", err)
 	}
-	defer group.Close()
-
-	log.Printf("ChangeDataConsumer started. Consuming messages from topic: %s with group: %s", c.topic, c.groupID)
+	c.state.Attach(group, kafkaConfig)
 
-	// Start consuming in a goroutine
-	go func() {
-		for {
-			topics := []string{c.topic}
-			err := group.Consume(ctx, topics, c)
-			if err != nil {
-				log.Printf("Error from consumer: %v", err)
-			}
-			if ctx.Err() != nil {
-				return
-			}
-		}
-	}()
-
-	// Wait for context cancellation
-	<-ctx.Done()
-	log.Println("ChangeDataConsumer context cancelled, stopping...")
-	return nil
+	slog.Info("ChangeDataConsumer started", "topic", c.topic, "group", c.groupID)
+	return lifecycle.ConsumeGroup(ctx, group, c.groupID, []string{c.topic}, c, retryBackoff)
 }
 
 func main() {
+	os.Exit(run())
+}
+
+// run runs the change data consumer until SIGINT or SIGTERM, or replays a range of the
+// topic, and returns the exit code of draining it
+func run() int {
+	replayOpts := replay.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	if err := logging.SetupFromEnv("change_data_consumer"); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
 	// Create consumer
-	c, err := NewChangeDataConsumer()
+	c, err := NewChangeDataConsumer(replayOpts.Enabled())
 	if err != nil {
 		log.Fatalf("Failed to create consumer: %v", err)
 	}
 
-	// Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Start consumer in a goroutine
-	go func() {
-		if err := c.Start(ctx); err != nil {
-			log.Printf("Consumer error: %v", err)
+	// Consume one message at a time, or in batches handed to HandleBatch
+	service, state := c.Start, c.State()
+	var handler sarama.ConsumerGroupHandler = c
+	closers := []lifecycle.Closer{{Name: "producer and deduplication store", Closer: c}}
+	if size := config.GetBatchSize(); size > 0 {
+		b, err := batch.New(batch.Options{
+			GroupID:          c.groupID,
+			Topics:           []string{c.topic},
+			Size:             size,
+			Timeout:          config.GetBatchTimeout(),
+			AcrossPartitions: config.GetBatchAcrossPartitions(),
+			OnFailure:        config.GetBatchOnFailure(),
+			DeadLetterTopic:  config.GetDeadLetterTopic(),
+		}, c)
+		if err != nil {
+			log.Fatalf("Failed to create batch consumer: %v", err)
 		}
-	}()
+		service, state, handler = b.Run, b.State(), b
+		closers = append(closers, lifecycle.Closer{Name: "dead letter producer", Closer: b})
+	}
+
+	// Replay reads the partitions directly instead of joining the group
+	if replayOpts.Enabled() {
+		replayOpts.Topic, replayOpts.GroupID = c.topic, c.groupID
+		service = func(ctx context.Context) error { return replay.Run(ctx, *replayOpts, handler) }
+	}
+
+	metrics.Start(ctx, config.GetMetricsAddr(":9104"))
+	adminhttp.Start(ctx, config.GetAdminAddr(":9204"), state)
+
+	shutdownTracing, err := tracing.Setup(ctx, "change_data_consumer", tracing.Options{Exporter: config.GetTracingExporter(), File: config.GetTracingFile()})
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
 
-	// Wait for shutdown signal
-	<-sigChan
-	log.Println("Shutting down change data consumer...")
+	return lifecycle.Run(ctx, config.GetDrainTimeout(), service, closers...)
 }
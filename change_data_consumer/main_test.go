@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+// fakeSession records the offsets marked and the commits of a consumer group session
+type fakeSession struct {
+	ctx       context.Context
+	marked    []int64
+	committed int
+}
+
+func (s *fakeSession) Claims() map[string][]int32 { return map[string][]int32{"cdc": {0}} }
+func (s *fakeSession) MemberID() string           { return "member" }
+func (s *fakeSession) GenerationID() int32        { return 1 }
+func (s *fakeSession) Context() context.Context   { return s.ctx }
+func (s *fakeSession) Commit()                    { s.committed++ }
+
+func (s *fakeSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.marked = append(s.marked, offset)
+}
+
+func (s *fakeSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {}
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.marked = append(s.marked, msg.Offset+1)
+}
+
+// fakeClaim hands over the given messages of partition 0, then ends
+type fakeClaim struct {
+	messages chan *sarama.ConsumerMessage
+}
+
+func newFakeClaim(messages ...*sarama.ConsumerMessage) *fakeClaim {
+	c := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, len(messages))}
+	for _, message := range messages {
+		c.messages <- message
+	}
+	close(c.messages)
+	return c
+}
+
+func (c *fakeClaim) Topic() string                            { return "cdc" }
+func (c *fakeClaim) Partition() int32                         { return 0 }
+func (c *fakeClaim) InitialOffset() int64                     { return 0 }
+func (c *fakeClaim) HighWaterMarkOffset() int64               { return int64(cap(c.messages)) }
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func TestConsumeClaimCommitsQuarantinedMessages(t *testing.T) {
+	c, producer := newTestConsumer(t)
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndSucceed()
+
+	session := &fakeSession{ctx: context.Background()}
+	claim := newFakeClaim(envelope(t, 0, "m1", nil).ConsumerMessage, envelope(t, 1, "m2", nil).ConsumerMessage)
+	if err := c.ConsumeClaim(session, claim); err != nil {
+		t.Fatal(err)
+	}
+	if len(session.marked) != 2 || session.marked[1] != 2 || session.committed != 2 {
+		t.Fatalf("marked %v with %d commits, want [1 2] with 2", session.marked, session.committed)
+	}
+}
+
+func TestConsumeClaimStopsAtTheMessageNotQuarantined(t *testing.T) {
+	c, producer := newTestConsumer(t)
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndFail(sarama.ErrNotEnoughReplicas)
+
+	session := &fakeSession{ctx: context.Background()}
+	claim := newFakeClaim(
+		envelope(t, 0, "m1", nil).ConsumerMessage,
+		envelope(t, 1, "m2", nil).ConsumerMessage,
+		envelope(t, 2, "m3", nil).ConsumerMessage,
+	)
+	if err := c.ConsumeClaim(session, claim); err != nil {
+		t.Fatal(err)
+	}
+	// m2 is neither marked nor committed, so the next session consumes it again
+	if len(session.marked) != 1 || session.marked[0] != 1 || session.committed != 1 {
+		t.Fatalf("marked %v with %d commits, want [1] with 1", session.marked, session.committed)
+	}
+	if len(claim.messages) != 1 {
+		t.Fatalf("%d messages left in the claim, want m3 left", len(claim.messages))
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"kafka_test/adminhttp"
+	"kafka_test/config"
+	"kafka_test/lifecycle"
+	"kafka_test/logging"
+	"kafka_test/metrics"
+	"kafka_test/models"
+	"kafka_test/pipeline"
+	"kafka_test/tracing"
+
+	"github.com/Shopify/sarama"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// splitRecords returns a transform writing each record of a ChangeDataMessage as JSON to
+// the output topic. Other messages are skipped, only their offsets are committed.
+func splitRecords(outputTopic string) pipeline.Transform {
+	marshal := protojson.MarshalOptions{UseProtoNames: true}
+
+	return func(ctx context.Context, message *sarama.ConsumerMessage) ([]*sarama.ProducerMessage, error) {
+		logger := logging.Sampled().With(logging.Message(message)...)
+		if contentType(message) != "application/x-protobuf" {
+			logger.Warn("Skipping message that is not a ChangeDataMessage")
+			return nil, nil
+		}
+
+		var msg models.ChangeDataMessage
+		if err := proto.Unmarshal(message.Value, &msg); err != nil {
+			// Retrying cannot fix a malformed message, skip it instead of blocking the partition
+			logger.Warn("Skipping undecodable ChangeDataMessage", "error", err, logging.Payload(message.Value))
+			return nil, nil
+		}
+
+		source := fmt.Sprintf("%s/%d/%d", message.Topic, message.Partition, message.Offset)
+		outputs := make([]*sarama.ProducerMessage, 0, len(msg.Records))
+		for _, record := range msg.Records {
+			value, err := marshal.Marshal(record)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal record: %w", err)
+			}
+			outputs = append(outputs, &sarama.ProducerMessage{
+				Topic: outputTopic,
+				Key:   sarama.StringEncoder(recordKey(&msg, record)),
+				Value: sarama.ByteEncoder(value),
+				Headers: []sarama.RecordHeader{
+					{Key: []byte("content-type"), Value: []byte("application/json")},
+					{Key: []byte("request-id"), Value: []byte(msg.RequestId)},
+					{Key: []byte("tenant-uid"), Value: []byte(strconv.FormatUint(msg.TenantUid, 10))},
+					{Key: []byte("source-offset"), Value: []byte(source)},
+				},
+			})
+		}
+		logger.Debug("Split ChangeDataMessage", append(logging.ChangeData(&msg), "records", len(outputs))...)
+		return outputs, nil
+	}
+}
+
+// recordKey keys a record by the BIID of its entity, so the versions of an entity stay
+// in order, or by the request for records without one
+func recordKey(msg *models.ChangeDataMessage, record *models.Record) string {
+	var entity interface{ GetBiid() string }
+	switch data := record.Data.(type) {
+	case *models.Record_Department:
+		entity = data.Department
+	case *models.Record_AggregationPattern:
+		entity = data.AggregationPattern
+	}
+	if entity != nil && entity.GetBiid() != "" {
+		return entity.GetBiid()
+	}
+	return msg.RequestId
+}
+
+func contentType(message *sarama.ConsumerMessage) string {
+	for _, header := range message.Headers {
+		if string(header.Key) == "content-type" {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+func main() {
+	os.Exit(run())
+}
+
+// run runs the pipeline until SIGINT or SIGTERM and returns the exit code of draining it
+func run() int {
+	if err := logging.SetupFromEnv("change_data_pipeline"); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
+	outputTopic := config.GetPipelineOutputTopic()
+	p := pipeline.New(pipeline.Options{
+		GroupID:         config.GetPipelineGroupID(),
+		Topics:          []string{config.GetTopicName()},
+		TransactionalID: config.GetTransactionalID(),
+	}, splitRecords(outputTopic))
+	slog.Info("Writing records", "output_topic", outputTopic)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	metrics.Start(ctx, config.GetMetricsAddr(":9105"))
+	adminhttp.Start(ctx, config.GetAdminAddr(":9205"), p.State())
+
+	shutdownTracing, err := tracing.Setup(ctx, "change_data_pipeline", tracing.Options{Exporter: config.GetTracingExporter(), File: config.GetTracingFile()})
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	return lifecycle.Run(ctx, config.GetDrainTimeout(), p.Run)
+}
@@ -4,32 +4,51 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
-	"os/signal"
 	"strconv"
-	"syscall"
 	"time"
 
 	"kafka_test/config"
+	"kafka_test/fixtures"
+	"kafka_test/lifecycle"
+	"kafka_test/logging"
+	"kafka_test/metrics"
 	"kafka_test/models"
+	"kafka_test/tracing"
 
 	"github.com/Shopify/sarama"
-	"github.com/google/uuid"
-	"google.golang.org/genproto/googleapis/type/date"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/proto"
-	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // ChangeDataProducer represents a Kafka producer for ChangeDataMessage
 type ChangeDataProducer struct {
-	producer sarama.SyncProducer
-	topic    string
-	counter  uint32
+	producer  sarama.SyncProducer
+	topic     string
+	generator *fixtures.Generator
 }
 
 // NewChangeDataProducer creates a new ChangeDataMessage producer
 func NewChangeDataProducer() (*ChangeDataProducer, error) {
+	scenario := fixtures.DefaultScenario()
+	if scenarioPath := config.GetScenarioPath(); scenarioPath != "" {
+		var err error
+		scenario, err = fixtures.LoadScenario(scenarioPath)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("Generating ChangeDataMessages", "scenario", scenarioPath)
+	}
+
+	generator, err := fixtures.NewGenerator(scenario)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create generator: %w", err)
+	}
+
 	kafkaConfig := config.GetProducerConfig()
+	metrics.RegisterSarama("producer", kafkaConfig.MetricRegistry)
 
 	producer, err := sarama.NewSyncProducer(config.GetBrokers(), kafkaConfig)
 	if err != nil {
@@ -37,9 +56,9 @@ func NewChangeDataProducer() (*ChangeDataProducer, error) {
 	}
 
 	return &ChangeDataProducer{
-		producer: producer,
-		topic:    config.GetTopicName(),
-		counter:  0,
+		producer:  producer,
+		topic:     config.GetTopicName(),
+		generator: generator,
 	}, nil
 }
 
@@ -48,99 +67,28 @@ func (p *ChangeDataProducer) Close() error {
 	return p.producer.Close()
 }
 
-// createSampleDepartment creates a sample department record
-func (p *ChangeDataProducer) createSampleDepartment() *models.Department {
-	now := time.Now()
-	today := &date.Date{
-		Year:  int32(now.Year()),
-		Month: int32(now.Month()),
-		Day:   int32(now.Day()),
-	}
-
-	return &models.Department{
-		Id:                    fmt.Sprintf("dept-%d", p.counter),
-		Biid:                  fmt.Sprintf("biid-%d", p.counter),
-		Type:                  "department",
-		TenantUid:             12345,
-		Code:                  fmt.Sprintf("DEPT%03d", p.counter),
-		Name:                  fmt.Sprintf("Department %d", p.counter),
-		ShortName:             fmt.Sprintf("Dept%d", p.counter),
-		ValidFrom:             today,
-		ValidTo:               nil, // No end date
-		TransactionFrom:       timestamppb.Now(),
-		TransactionTo:         nil,
-		TransactionFromBy:     1001,
-		TransactionToBy:       0,
-		TransactionFromByName: "System User",
-		TransactionToByName:   "",
-		TransactionFromBySrv:  "kafka-producer",
-		TransactionToBySrv:    "",
-		DispOrder:             p.counter,
-		SearchKey:             fmt.Sprintf("dept%d", p.counter),
-		Version:               1,
-		ParentBiid:            nil,
-		ParentCode:            nil,
-		ParentName:            nil,
-	}
-}
-
-// createSampleAggregationPattern creates a sample aggregation pattern
-func (p *ChangeDataProducer) createSampleAggregationPattern() *models.AggregationPattern {
-	return &models.AggregationPattern{
-		Id:                    fmt.Sprintf("agg-%d", p.counter),
-		Biid:                  fmt.Sprintf("agg-biid-%d", p.counter),
-		TenantUid:             12345,
-		Name:                  fmt.Sprintf("Aggregation Pattern %d", p.counter),
-		TransactionFrom:       timestamppb.Now(),
-		TransactionTo:         nil,
-		TransactionFromBy:     1001,
-		TransactionToBy:       0,
-		TransactionFromByName: "System User",
-		TransactionToByName:   "",
-		TransactionFromBySrv:  "kafka-producer",
-		TransactionToBySrv:    "",
-		Version:               1,
+// SendChangeDataMessage generates the next request and sends all of its message parts to Kafka,
+// in one span so the parts of a request share a trace
+func (p *ChangeDataProducer) SendChangeDataMessage(ctx context.Context) (err error) {
+	parts := p.generator.NextRequest()
+	if len(parts) == 0 {
+		return nil
 	}
-}
-
-// SendChangeDataMessage sends a ChangeDataMessage to Kafka
-func (p *ChangeDataProducer) SendChangeDataMessage(ctx context.Context) error {
-	p.counter++
 
-	// Create sample records
-	var records []*models.Record
+	ctx, span := tracing.Start(ctx, "ChangeDataMessage request",
+		trace.WithAttributes(attribute.String("request_id", parts[0].RequestId), attribute.Int("parts", len(parts))))
+	defer func() { tracing.End(span, err) }()
 
-	// Add a department record
-	dept := p.createSampleDepartment()
-	deptRecord := &models.Record{
-		OperationType: models.Record_CREATE,
-		Data: &models.Record_Department{
-			Department: dept,
-		},
-	}
-	records = append(records, deptRecord)
-
-	// Add an aggregation pattern record (every 3rd message)
-	if p.counter%3 == 0 {
-		agg := p.createSampleAggregationPattern()
-		aggRecord := &models.Record{
-			OperationType: models.Record_UPDATE,
-			Data: &models.Record_AggregationPattern{
-				AggregationPattern: agg,
-			},
+	for _, changeDataMsg := range parts {
+		if err := p.sendMessagePart(ctx, changeDataMsg); err != nil {
+			return err
 		}
-		records = append(records, aggRecord)
-	}
-
-	// Create ChangeDataMessage
-	changeDataMsg := &models.ChangeDataMessage{
-		RequestId:         uuid.New().String(),
-		MessageNumber:     p.counter,
-		TotalMessageCount: p.counter + 1,
-		TenantUid:         12345,
-		Records:           records,
 	}
+	return nil
+}
 
+// sendMessagePart sends a single ChangeDataMessage part
+func (p *ChangeDataProducer) sendMessagePart(ctx context.Context, changeDataMsg *models.ChangeDataMessage) error {
 	// Serialize to protobuf
 	protoData, err := proto.Marshal(changeDataMsg)
 	if err != nil {
@@ -155,6 +103,7 @@ func (p *ChangeDataProducer) SendChangeDataMessage(ctx context.Context) error {
 		Headers: []sarama.RecordHeader{
 			{Key: []byte("request-id"), Value: []byte(changeDataMsg.RequestId)},
 			{Key: []byte("message-number"), Value: []byte(strconv.FormatUint(uint64(changeDataMsg.MessageNumber), 10))},
+			{Key: []byte("total-message-count"), Value: []byte(strconv.FormatUint(uint64(changeDataMsg.TotalMessageCount), 10))},
 			{Key: []byte("tenant-uid"), Value: []byte(strconv.FormatUint(changeDataMsg.TenantUid, 10))},
 			{Key: []byte("content-type"), Value: []byte("application/x-protobuf")},
 			{Key: []byte("message-type"), Value: []byte("ChangeDataMessage")},
@@ -162,20 +111,24 @@ func (p *ChangeDataProducer) SendChangeDataMessage(ctx context.Context) error {
 	}
 
 	// Send the message
+	_, span := tracing.StartProduce(ctx, kafkaMsg)
+	start := time.Now()
 	partition, offset, err := p.producer.SendMessage(kafkaMsg)
+	metrics.ObserveProduce(p.topic, start, err)
+	tracing.EndProduce(span, partition, offset, err)
 	if err != nil {
 		return fmt.Errorf("failed to send message: %w", err)
 	}
 
-	log.Printf("ChangeDataMessage sent successfully - Partition: %d, Offset: %d, RequestID: %s, Records: %d, MessageNumber: %d, TotalMessageCount: %d",
-		partition, offset, changeDataMsg.RequestId, len(changeDataMsg.Records), changeDataMsg.MessageNumber, changeDataMsg.TotalMessageCount)
+	logging.Sampled().Info("ChangeDataMessage sent", append(append(logging.Produced(p.topic, partition, offset),
+		logging.ChangeData(changeDataMsg)...), "records", len(changeDataMsg.Records))...)
 
 	return nil
 }
 
 // Start starts the producer loop
 func (p *ChangeDataProducer) Start(ctx context.Context, interval time.Duration) error {
-	log.Printf("ChangeDataProducer started. Sending messages to topic: %s every %v", p.topic, interval)
+	slog.Info("ChangeDataProducer started", "topic", p.topic, "interval", interval)
 
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
@@ -183,39 +136,42 @@ func (p *ChangeDataProducer) Start(ctx context.Context, interval time.Duration)
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("ChangeDataProducer context cancelled, stopping...")
+			slog.Info("ChangeDataProducer context cancelled, stopping")
 			return nil
 		case <-ticker.C:
 			if err := p.SendChangeDataMessage(ctx); err != nil {
-				log.Printf("Error sending ChangeDataMessage: %v", err)
+				slog.Error("Error sending ChangeDataMessage", "error", err)
 			}
 		}
 	}
 }
 
 func main() {
+	os.Exit(run())
+}
+
+// run runs the change data producer until SIGINT or SIGTERM and returns the exit code of draining it
+func run() int {
+	if err := logging.SetupFromEnv("change_data_producer"); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
 	// Create producer
 	p, err := NewChangeDataProducer()
 	if err != nil {
 		log.Fatalf("Failed to create producer: %v", err)
 	}
-	defer p.Close()
-
-	// Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Start producer in a goroutine
-	go func() {
-		if err := p.Start(ctx, 1*time.Second); err != nil {
-			log.Printf("Producer error: %v", err)
-		}
-	}()
+	metrics.Start(ctx, config.GetMetricsAddr(":9103"))
+
+	shutdownTracing, err := tracing.Setup(ctx, "change_data_producer", tracing.Options{Exporter: config.GetTracingExporter(), File: config.GetTracingFile()})
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
 
-	// Wait for shutdown signal
-	<-sigChan
-	log.Println("Shutting down change data producer...")
+	return lifecycle.Run(ctx, config.GetDrainTimeout(), func(ctx context.Context) error { return p.Start(ctx, 1*time.Second) }, lifecycle.Closer{Name: "producer", Closer: p})
 }
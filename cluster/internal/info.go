@@ -0,0 +1,255 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"kafka_test/admin"
+	"kafka_test/config"
+
+	"github.com/Shopify/sarama"
+)
+
+// metadataVersion is the oldest Metadata request returning racks, the controller and the cluster ID
+const metadataVersion = 2
+
+// ConfigValue is an effective broker config entry
+type ConfigValue struct {
+	Name      string `json:"name" yaml:"name"`
+	Value     string `json:"value" yaml:"value"`
+	Source    string `json:"source" yaml:"source"`
+	Default   bool   `json:"default" yaml:"default"`
+	ReadOnly  bool   `json:"read_only" yaml:"read_only"`
+	Sensitive bool   `json:"sensitive" yaml:"sensitive"`
+}
+
+// APIVersion is the range of versions of one API a broker, or every broker, supports
+type APIVersion struct {
+	Key  int16  `json:"key" yaml:"key"`
+	Name string `json:"name" yaml:"name"`
+	Min  int16  `json:"min" yaml:"min"`
+	Max  int16  `json:"max" yaml:"max"`
+}
+
+// BrokerInfo describes one broker of the cluster
+type BrokerInfo struct {
+	ID         int32         `json:"id" yaml:"id"`
+	Address    string        `json:"address" yaml:"address"`
+	Rack       string        `json:"rack,omitempty" yaml:"rack,omitempty"`
+	Controller bool          `json:"controller" yaml:"controller"`
+	Version    string        `json:"version" yaml:"version"`
+	Configs    []ConfigValue `json:"configs,omitempty" yaml:"configs,omitempty"`
+	Error      string        `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// ClusterInfo describes the cluster the bootstrap brokers belong to. Version
+// is the newest protocol version every broker and the client support, which
+// is what KAFKA_VERSION=auto selects.
+type ClusterInfo struct {
+	ClusterID         string       `json:"cluster_id" yaml:"cluster_id"`
+	Controller        int32        `json:"controller" yaml:"controller"`
+	Bootstrap         []string     `json:"bootstrap" yaml:"bootstrap"`
+	ConfiguredVersion string       `json:"configured_version" yaml:"configured_version"`
+	Version           string       `json:"version" yaml:"version"`
+	Brokers           []BrokerInfo `json:"brokers" yaml:"brokers"`
+	APIs              []APIVersion `json:"apis,omitempty" yaml:"apis,omitempty"`
+}
+
+// Options selects the optional parts of the cluster description
+type Options struct {
+	// Configs adds the effective configs of every broker
+	Configs bool
+	// APIs adds the API versions every broker supports
+	APIs bool
+}
+
+// Describe returns the brokers, controller and cluster ID from a metadata
+// request, and asks every broker for its API versions and, optionally, configs.
+// A broker that does not answer is reported with its error.
+func Describe(conn *admin.Conn, opts Options) (*ClusterInfo, error) {
+	broker, err := conn.Client.Controller()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the controller: %w", err)
+	}
+	metadata, err := broker.GetMetadata(&sarama.MetadataRequest{Version: metadataVersion})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metadata: %w", err)
+	}
+
+	info := &ClusterInfo{
+		Controller:        metadata.ControllerID,
+		Bootstrap:         config.GetBrokers(),
+		ConfiguredVersion: conn.Client.Config().Version.String(),
+		Brokers:           []BrokerInfo{},
+	}
+	if metadata.ClusterID != nil {
+		info.ClusterID = *metadata.ClusterID
+	}
+	if config.GetVersion() == config.VersionAuto {
+		info.ConfiguredVersion += " (auto)"
+	}
+
+	clusterVersion := sarama.MaxVersion
+	var versions [][]sarama.ApiVersionsResponseKey
+	for _, b := range metadata.Brokers {
+		brokerInfo := BrokerInfo{ID: b.ID(), Address: b.Addr(), Rack: b.Rack(), Controller: b.ID() == metadata.ControllerID}
+
+		keys, err := apiVersions(conn, b.ID())
+		if err != nil {
+			brokerInfo.Error = err.Error()
+			info.Brokers = append(info.Brokers, brokerInfo)
+			continue
+		}
+		version := config.VersionFromAPIs(keys)
+		if !version.IsAtLeast(clusterVersion) {
+			clusterVersion = version
+		}
+		brokerInfo.Version = version.String()
+		versions = append(versions, keys)
+
+		if opts.Configs {
+			if brokerInfo.Configs, err = brokerConfigs(conn, b.ID()); err != nil {
+				brokerInfo.Error = err.Error()
+			}
+		}
+		info.Brokers = append(info.Brokers, brokerInfo)
+	}
+	sort.Slice(info.Brokers, func(i, j int) bool { return info.Brokers[i].ID < info.Brokers[j].ID })
+
+	if len(versions) > 0 {
+		info.Version = clusterVersion.String()
+	}
+	if opts.APIs {
+		info.APIs = commonAPIs(versions)
+	}
+	return info, nil
+}
+
+// apiVersions asks one broker, over the client's connection, which API versions it supports
+func apiVersions(conn *admin.Conn, id int32) ([]sarama.ApiVersionsResponseKey, error) {
+	broker, err := conn.Client.Broker(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to broker %d: %w", id, err)
+	}
+	response, err := broker.ApiVersions(&sarama.ApiVersionsRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API versions of broker %d: %w", id, err)
+	}
+	if response.ErrorCode != 0 {
+		return nil, fmt.Errorf("failed to get API versions of broker %d: %w", id, sarama.KError(response.ErrorCode))
+	}
+	return response.ApiKeys, nil
+}
+
+// brokerConfigs returns the effective configs of one broker, sorted by name
+func brokerConfigs(conn *admin.Conn, id int32) ([]ConfigValue, error) {
+	entries, err := conn.Admin.DescribeConfig(sarama.ConfigResource{Type: sarama.BrokerResource, Name: strconv.Itoa(int(id))})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe configs of broker %d: %w", id, err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	configs := make([]ConfigValue, 0, len(entries))
+	for _, entry := range entries {
+		configs = append(configs, ConfigValue{
+			Name:      entry.Name,
+			Value:     entry.Value,
+			Source:    entry.Source.String(),
+			Default:   entry.Default || entry.Source == sarama.SourceDefault,
+			ReadOnly:  entry.ReadOnly,
+			Sensitive: entry.Sensitive,
+		})
+	}
+	return configs, nil
+}
+
+func apiRanges(keys []sarama.ApiVersionsResponseKey) []APIVersion {
+	apis := make([]APIVersion, 0, len(keys))
+	for _, key := range keys {
+		apis = append(apis, APIVersion{Key: key.ApiKey, Name: apiName(key.ApiKey), Min: key.MinVersion, Max: key.MaxVersion})
+	}
+	sort.Slice(apis, func(i, j int) bool { return apis[i].Key < apis[j].Key })
+	return apis
+}
+
+// commonAPIs returns the APIs every broker supports, with the versions all of them accept
+func commonAPIs(versions [][]sarama.ApiVersionsResponseKey) []APIVersion {
+	if len(versions) == 0 {
+		return nil
+	}
+	common := apiRanges(versions[0])
+	for _, keys := range versions[1:] {
+		byKey := make(map[int16]sarama.ApiVersionsResponseKey, len(keys))
+		for _, key := range keys {
+			byKey[key.ApiKey] = key
+		}
+		kept := common[:0]
+		for _, api := range common {
+			key, ok := byKey[api.Key]
+			if !ok {
+				continue
+			}
+			api.Min = max(api.Min, key.MinVersion)
+			api.Max = min(api.Max, key.MaxVersion)
+			if api.Min <= api.Max {
+				kept = append(kept, api)
+			}
+		}
+		common = kept
+	}
+	return common
+}
+
+// WriteTable prints the cluster, its brokers with their non-default configs and the common API versions
+func (c *ClusterInfo) WriteTable(tw *tabwriter.Writer) {
+	fmt.Fprintf(tw, "Cluster ID: %s  Controller: %d  Version: %s  Configured version: %s\n\n",
+		orDash(c.ClusterID), c.Controller, orDash(c.Version), c.ConfiguredVersion)
+
+	admin.Row(tw, "ID", "ADDRESS", "RACK", "CONTROLLER", "VERSION", "ERROR")
+	for _, broker := range c.Brokers {
+		controller := ""
+		if broker.Controller {
+			controller = "yes"
+		}
+		admin.Row(tw, broker.ID, broker.Address, orDash(broker.Rack), controller, orDash(broker.Version), broker.Error)
+	}
+
+	for _, broker := range c.Brokers {
+		if len(broker.Configs) == 0 {
+			continue
+		}
+		defaults := 0
+		var overrides []ConfigValue
+		for _, entry := range broker.Configs {
+			if entry.Default {
+				defaults++
+			} else {
+				overrides = append(overrides, entry)
+			}
+		}
+		fmt.Fprintf(tw, "\nBroker %d configs: %d non-default, %d default\n", broker.ID, len(overrides), defaults)
+		for _, entry := range overrides {
+			value := entry.Value
+			if entry.Sensitive {
+				value = "(sensitive)"
+			}
+			admin.Row(tw, "  "+entry.Name, value, entry.Source)
+		}
+	}
+
+	if len(c.APIs) > 0 {
+		fmt.Fprintln(tw)
+		admin.Row(tw, "KEY", "API", "MIN", "MAX")
+		for _, api := range c.APIs {
+			admin.Row(tw, api.Key, api.Name, api.Min, api.Max)
+		}
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
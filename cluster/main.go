@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"kafka_test/admin"
+	"kafka_test/cluster/internal"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: cluster <command> [flags]
+
+Commands:
+  info    Show the cluster ID, controller, brokers with their racks, configs and supported versions
+
+Run "cluster <command> -h" for command flags.
+`)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "info":
+		err = runInfo(os.Args[2:])
+	case "-h", "--help", "help":
+		usage()
+		return
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("cluster %s failed: %v", os.Args[1], err)
+	}
+}
+
+// runInfo prints the brokers the bootstrap list resolved to and what they support
+func runInfo(args []string) error {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	configs := fs.Bool("configs", true, "include the effective configs of every broker")
+	apis := fs.Bool("api-versions", false, "list the API versions every broker supports")
+	format := fs.String("format", admin.FormatTable, "output format: table, json or yaml")
+	fs.Parse(args)
+
+	if err := admin.ValidateFormat(*format); err != nil {
+		return err
+	}
+
+	conn, err := admin.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	info, err := internal.Describe(conn, internal.Options{Configs: *configs, APIs: *apis})
+	if err != nil {
+		return err
+	}
+	return admin.Write(os.Stdout, *format, info)
+}
@@ -1,14 +1,37 @@
 package config
 
 import (
+	"log/slog"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/joho/godotenv"
 	"github.com/xdg-go/scram"
 )
 
+// defaultDrainTimeout leaves some of the default 30s Kubernetes termination grace period to exit
+const defaultDrainTimeout = 25 * time.Second
+
+const (
+	// defaultDedupSize bounds the memory deduplication store to a few tens of MB
+	defaultDedupSize = 100000
+	// defaultDedupTTL outlasts a restart, a redelivery after a rebalance and the producer retries
+	defaultDedupTTL = 24 * time.Hour
+	// defaultBatchTimeout bounds the latency a batch adds to a quiet partition
+	defaultBatchTimeout = time.Second
+	// defaultDiffSize bounds the entities the diff tracker remembers, a few KB each
+	defaultDiffSize = 10000
+	// defaultDiffTTL forgets entities that have not changed for a week
+	defaultDiffTTL = 7 * 24 * time.Hour
+	// defaultValidationSize bounds the versions and departments the validator remembers, a few dozen bytes each
+	defaultValidationSize = 100000
+	// defaultValidationTTL forgets entities that have not changed for a week
+	defaultValidationTTL = 7 * 24 * time.Hour
+)
+
 func init() {
 	// Load .env file if it exists
 	godotenv.Load()
@@ -63,12 +86,381 @@ func GetChangeDataGroupID() string {
 	return "change-data-consumer-group"
 }
 
-// GetTopicEmptierGroupID returns the topic emptier consumer group ID from environment variable or default
-func GetTopicEmptierGroupID() string {
-	if groupID := os.Getenv("KAFKA_TOPIC_EMPTIER_GROUP_ID"); groupID != "" {
+// GetPipelineGroupID returns the consumer group of the change data pipeline from environment variable or default
+func GetPipelineGroupID() string {
+	if groupID := os.Getenv("KAFKA_PIPELINE_GROUP_ID"); groupID != "" {
 		return groupID
 	}
-	return "topic-emptier"
+	return "change-data-pipeline"
+}
+
+// GetPipelineOutputTopic returns the topic the change data pipeline writes records to from environment variable or default
+func GetPipelineOutputTopic() string {
+	if topic := os.Getenv("KAFKA_PIPELINE_OUTPUT_TOPIC"); topic != "" {
+		return topic
+	}
+	return GetTopicName() + "-records"
+}
+
+// GetTransactionalID returns the prefix of the transactional IDs of the change data pipeline
+// from environment variable or default
+func GetTransactionalID() string {
+	if id := os.Getenv("KAFKA_TRANSACTIONAL_ID"); id != "" {
+		return id
+	}
+	return "change-data-pipeline"
+}
+
+// GetValidationRulesPath returns the CDC validation rules file from environment variable, empty if validation is disabled
+func GetValidationRulesPath() string {
+	return os.Getenv("KAFKA_CDC_VALIDATION_RULES")
+}
+
+// GetValidationSize returns how many versions and departments the validator remembers from environment variable or default
+func GetValidationSize() int {
+	if size := os.Getenv("KAFKA_CDC_VALIDATION_SIZE"); size != "" {
+		if n, err := strconv.Atoi(size); err == nil && n > 0 {
+			return n
+		}
+		slog.Warn("Invalid KAFKA_CDC_VALIDATION_SIZE", "value", size, "using", defaultValidationSize)
+	}
+	return defaultValidationSize
+}
+
+// GetValidationTTL returns how long the validator remembers an entity not seen again from environment variable or default
+func GetValidationTTL() time.Duration {
+	if ttl := os.Getenv("KAFKA_CDC_VALIDATION_TTL"); ttl != "" {
+		if d, err := time.ParseDuration(ttl); err == nil && d > 0 {
+			return d
+		}
+		slog.Warn("Invalid KAFKA_CDC_VALIDATION_TTL", "value", ttl, "using", defaultValidationTTL.String())
+	}
+	return defaultValidationTTL
+}
+
+// GetQuarantineTopic returns the topic receiving CDC messages that fail validation, empty if quarantine is disabled
+func GetQuarantineTopic() string {
+	return os.Getenv("KAFKA_CDC_QUARANTINE_TOPIC")
+}
+
+// GetDiffEnabled reports whether the change data consumer diffs records against their previous version
+func GetDiffEnabled() bool {
+	return os.Getenv("KAFKA_CDC_DIFF") == "true"
+}
+
+// GetDiffUnknown reports whether records without a known previous version are diffed against an empty record
+func GetDiffUnknown() bool {
+	return os.Getenv("KAFKA_CDC_DIFF_UNKNOWN") == "true"
+}
+
+// GetDiffSize returns how many entities the diff tracker remembers from environment variable or default
+func GetDiffSize() int {
+	if size := os.Getenv("KAFKA_CDC_DIFF_SIZE"); size != "" {
+		if n, err := strconv.Atoi(size); err == nil && n > 0 {
+			return n
+		}
+		slog.Warn("Invalid KAFKA_CDC_DIFF_SIZE", "value", size, "using", defaultDiffSize)
+	}
+	return defaultDiffSize
+}
+
+// GetDiffTTL returns how long the diff tracker remembers an entity not seen again from environment variable or default
+func GetDiffTTL() time.Duration {
+	if ttl := os.Getenv("KAFKA_CDC_DIFF_TTL"); ttl != "" {
+		if d, err := time.ParseDuration(ttl); err == nil && d > 0 {
+			return d
+		}
+		slog.Warn("Invalid KAFKA_CDC_DIFF_TTL", "value", ttl, "using", defaultDiffTTL.String())
+	}
+	return defaultDiffTTL
+}
+
+// GetDiffTopic returns the topic receiving record diff events, empty if publishing diffs is disabled
+func GetDiffTopic() string {
+	return os.Getenv("KAFKA_CDC_DIFF_TOPIC")
+}
+
+// GetScenarioPath returns the CDC fixture scenario file from environment variable, empty to use the default scenario
+func GetScenarioPath() string {
+	return os.Getenv("KAFKA_CDC_SCENARIO")
+}
+
+// GetRenderFormat returns how consumers print ChangeDataMessages from environment variable or default
+func GetRenderFormat() string {
+	if format := os.Getenv("KAFKA_CDC_RENDER_FORMAT"); format != "" {
+		return format
+	}
+	return "table"
+}
+
+// GetRenderFields returns the field paths consumers print from environment variable, empty for all fields
+func GetRenderFields() []string {
+	fields := os.Getenv("KAFKA_CDC_RENDER_FIELDS")
+	if fields == "" {
+		return nil
+	}
+	fieldList := strings.Split(fields, ",")
+	for i, field := range fieldList {
+		fieldList[i] = strings.TrimSpace(field)
+	}
+	return fieldList
+}
+
+// GetRenderDefaults returns whether consumers print fields holding default values
+func GetRenderDefaults() bool {
+	return os.Getenv("KAFKA_CDC_RENDER_DEFAULTS") == "true"
+}
+
+// GetMetricsAddr returns the address serving /metrics from environment variable or the given
+// default, empty if KAFKA_METRICS_ADDR is "off"
+func GetMetricsAddr(defaultAddr string) string {
+	switch addr := os.Getenv("KAFKA_METRICS_ADDR"); addr {
+	case "":
+		return defaultAddr
+	case "off":
+		return ""
+	default:
+		return addr
+	}
+}
+
+// GetAdminAddr returns the address serving the admin endpoints of consumers from environment
+// variable or the given default, empty if KAFKA_ADMIN_ADDR is "off"
+func GetAdminAddr(defaultAddr string) string {
+	switch addr := os.Getenv("KAFKA_ADMIN_ADDR"); addr {
+	case "":
+		return defaultAddr
+	case "off":
+		return ""
+	default:
+		return addr
+	}
+}
+
+// GetTracingExporter returns where spans are exported from environment variable or default:
+// none, otlp, stdout or file
+func GetTracingExporter() string {
+	if exporter := os.Getenv("KAFKA_TRACING_EXPORTER"); exporter != "" {
+		return exporter
+	}
+	return "none"
+}
+
+// GetTracingFile returns the file receiving spans with the file exporter from environment variable or default
+func GetTracingFile() string {
+	if file := os.Getenv("KAFKA_TRACING_FILE"); file != "" {
+		return file
+	}
+	return "traces.jsonl"
+}
+
+// GetLogFormat returns the log format from environment variable or default: text or json
+func GetLogFormat() string {
+	if format := os.Getenv("KAFKA_LOG_FORMAT"); format != "" {
+		return format
+	}
+	return "text"
+}
+
+// GetLogLevel returns the minimum log level from environment variable or default: debug, info, warn or error
+func GetLogLevel() string {
+	if level := os.Getenv("KAFKA_LOG_LEVEL"); level != "" {
+		return level
+	}
+	return "info"
+}
+
+// GetLogPayloads reports whether message values are logged
+func GetLogPayloads() bool {
+	return os.Getenv("KAFKA_LOG_PAYLOADS") == "true"
+}
+
+// GetLogSampling returns how many per-message records with the same message are logged each
+// second before sampling and every how many are logged after that, from environment variable
+// as "first/thereafter" or default; first is 0 if KAFKA_LOG_SAMPLING is "off"
+func GetLogSampling() (first, thereafter int) {
+	first, thereafter = 10, 100
+	setting := os.Getenv("KAFKA_LOG_SAMPLING")
+	if setting == "off" {
+		return 0, 0
+	}
+	if f, t, ok := strings.Cut(setting, "/"); ok {
+		if n, err := strconv.Atoi(strings.TrimSpace(f)); err == nil && n >= 0 {
+			first = n
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(t)); err == nil && n >= 0 {
+			thereafter = n
+		}
+	}
+	return first, thereafter
+}
+
+// GetAssignmentStrategies returns the partition assignment strategies consumers offer, in
+// priority order, from the comma-separated environment variable or default: range, roundrobin
+// or sticky. Unknown strategies are skipped with a warning.
+func GetAssignmentStrategies() []sarama.BalanceStrategy {
+	setting := os.Getenv("KAFKA_ASSIGNMENT_STRATEGY")
+	if setting == "" {
+		return []sarama.BalanceStrategy{sarama.BalanceStrategyRoundRobin}
+	}
+
+	var strategies []sarama.BalanceStrategy
+	for _, name := range strings.Split(setting, ",") {
+		switch name = strings.ToLower(strings.TrimSpace(name)); name {
+		case sarama.RangeBalanceStrategyName:
+			strategies = append(strategies, sarama.BalanceStrategyRange)
+		case sarama.RoundRobinBalanceStrategyName, "round-robin":
+			strategies = append(strategies, sarama.BalanceStrategyRoundRobin)
+		case sarama.StickyBalanceStrategyName:
+			strategies = append(strategies, sarama.BalanceStrategySticky)
+		case "cooperative-sticky":
+			// Sarama only implements the eager rebalance protocol
+			slog.Warn("Cooperative rebalancing is not supported, using sticky", "strategy", name)
+			strategies = append(strategies, sarama.BalanceStrategySticky)
+		default:
+			slog.Warn("Unknown assignment strategy in KAFKA_ASSIGNMENT_STRATEGY", "strategy", name)
+		}
+	}
+	if len(strategies) == 0 {
+		return []sarama.BalanceStrategy{sarama.BalanceStrategyRoundRobin}
+	}
+	return strategies
+}
+
+// GetGroupInstanceID returns the static group membership ID of consumers from environment
+// variable, empty for dynamic membership
+func GetGroupInstanceID() string {
+	return os.Getenv("KAFKA_GROUP_INSTANCE_ID")
+}
+
+// GetSessionTimeout returns how long the group waits for a consumer to heartbeat before it is
+// removed from the group, from environment variable, 0 for the Sarama default
+func GetSessionTimeout() time.Duration {
+	timeout := os.Getenv("KAFKA_SESSION_TIMEOUT")
+	if timeout == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil || d <= 0 {
+		slog.Warn("Invalid KAFKA_SESSION_TIMEOUT, using the default", "value", timeout)
+		return 0
+	}
+	return d
+}
+
+// GetDrainTimeout returns how long producers and consumers may take to finish in-flight messages
+// and close when asked to stop, from environment variable or default
+func GetDrainTimeout() time.Duration {
+	if timeout := os.Getenv("KAFKA_DRAIN_TIMEOUT"); timeout != "" {
+		if d, err := time.ParseDuration(timeout); err == nil && d > 0 {
+			return d
+		}
+		slog.Warn("Invalid KAFKA_DRAIN_TIMEOUT", "value", timeout, "using", defaultDrainTimeout.String())
+	}
+	return defaultDrainTimeout
+}
+
+// GetDedupKey returns the identity consumers deduplicate messages by from environment
+// variable or default: off, header, request or hash
+func GetDedupKey() string {
+	if key := os.Getenv("KAFKA_DEDUP_KEY"); key != "" {
+		return key
+	}
+	return "off"
+}
+
+// GetDedupHeader returns the header holding the message identity for the header key from environment variable or default
+func GetDedupHeader() string {
+	if header := os.Getenv("KAFKA_DEDUP_HEADER"); header != "" {
+		return header
+	}
+	return "message-id"
+}
+
+// GetDedupStore returns where the identities of processed messages are kept from environment
+// variable or default: memory or bolt
+func GetDedupStore() string {
+	if store := os.Getenv("KAFKA_DEDUP_STORE"); store != "" {
+		return store
+	}
+	return "memory"
+}
+
+// GetDedupPath returns the file of the bolt deduplication store from environment variable or default
+func GetDedupPath() string {
+	if path := os.Getenv("KAFKA_DEDUP_PATH"); path != "" {
+		return path
+	}
+	return "dedup.db"
+}
+
+// GetDedupSize returns how many identities the memory deduplication store keeps from environment variable or default
+func GetDedupSize() int {
+	if size := os.Getenv("KAFKA_DEDUP_SIZE"); size != "" {
+		if n, err := strconv.Atoi(size); err == nil && n > 0 {
+			return n
+		}
+		slog.Warn("Invalid KAFKA_DEDUP_SIZE", "value", size, "using", defaultDedupSize)
+	}
+	return defaultDedupSize
+}
+
+// GetDedupTTL returns how long the identity of a processed message is kept from environment variable or default
+func GetDedupTTL() time.Duration {
+	if ttl := os.Getenv("KAFKA_DEDUP_TTL"); ttl != "" {
+		if d, err := time.ParseDuration(ttl); err == nil && d > 0 {
+			return d
+		}
+		slog.Warn("Invalid KAFKA_DEDUP_TTL", "value", ttl, "using", defaultDedupTTL.String())
+	}
+	return defaultDedupTTL
+}
+
+// GetBatchSize returns the most messages change_data_consumer hands to its batch handler at
+// once from environment variable or default, 0 to handle messages one by one
+func GetBatchSize() int {
+	if size := os.Getenv("KAFKA_BATCH_SIZE"); size != "" {
+		if n, err := strconv.Atoi(size); err == nil && n >= 0 {
+			return n
+		}
+		slog.Warn("Invalid KAFKA_BATCH_SIZE, handling messages one by one", "value", size)
+	}
+	return 0
+}
+
+// GetBatchTimeout returns how long the first message of a partial batch waits for more from
+// environment variable or default
+func GetBatchTimeout() time.Duration {
+	if timeout := os.Getenv("KAFKA_BATCH_TIMEOUT"); timeout != "" {
+		if d, err := time.ParseDuration(timeout); err == nil && d > 0 {
+			return d
+		}
+		slog.Warn("Invalid KAFKA_BATCH_TIMEOUT", "value", timeout, "using", defaultBatchTimeout.String())
+	}
+	return defaultBatchTimeout
+}
+
+// GetBatchAcrossPartitions reports whether batches collect messages across the claimed
+// partitions, if KAFKA_BATCH_SCOPE is "all", instead of per partition
+func GetBatchAcrossPartitions() bool {
+	return os.Getenv("KAFKA_BATCH_SCOPE") == "all"
+}
+
+// GetBatchOnFailure returns what happens to a failed batch from environment variable or
+// default: retry, bisect or dlq
+func GetBatchOnFailure() string {
+	if policy := os.Getenv("KAFKA_BATCH_ON_FAILURE"); policy != "" {
+		return policy
+	}
+	return "retry"
+}
+
+// GetDeadLetterTopic returns the topic receiving messages that failed batch handling from environment variable or default
+func GetDeadLetterTopic() string {
+	if topic := os.Getenv("KAFKA_DLQ_TOPIC"); topic != "" {
+		return topic
+	}
+	return GetTopicName() + "-dlq"
 }
 
 // GetProducerConfig returns the Kafka producer configuration
@@ -101,7 +493,6 @@ func GetProducerConfigWithSecurity(securityProtocol, saslMechanism, username, pa
 	config.Producer.Return.Successes = true
 	config.Producer.RequiredAcks = sarama.WaitForAll
 	config.Producer.Retry.Max = 5
-	config.Version = sarama.V2_8_0_0
 
 	// Configure security protocol
 	switch strings.ToUpper(securityProtocol) {
@@ -162,6 +553,25 @@ func GetProducerConfigWithSecurity(securityProtocol, saslMechanism, username, pa
 		config.Net.TLS.Config = nil
 	}
 
+	// Resolve the protocol version last, detecting it needs the security settings
+	config.Version = resolveVersion(config)
+
+	return config
+}
+
+// GetTransactionalProducerConfig returns the configuration of a transactional producer. Its
+// messages and consumer offsets are committed or aborted together; Kafka fences older
+// producers using the same transactional ID.
+func GetTransactionalProducerConfig(transactionalID string) *sarama.Config {
+	config := GetProducerConfig()
+	config.Producer.Idempotent = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Transaction.ID = transactionalID
+	// Failed messages fail the transaction, nothing reads the successes
+	config.Producer.Return.Successes = false
+	config.Producer.Return.Errors = false
+	// Idempotence requires a single in-flight request per broker to keep the order
+	config.Net.MaxOpenRequests = 1
 	return config
 }
 
@@ -211,7 +621,11 @@ func GetConsumerConfig() *sarama.Config {
 	config := GetProducerConfigWithSecurity(securityProtocol, saslMechanism, username, password, sslVerify)
 
 	// Override with consumer-specific settings
-	config.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
+	config.Consumer.Group.Rebalance.GroupStrategies = GetAssignmentStrategies()
+	config.Consumer.Group.InstanceId = GetGroupInstanceID()
+	if timeout := GetSessionTimeout(); timeout > 0 {
+		config.Consumer.Group.Session.Timeout = timeout
+	}
 	config.Consumer.Offsets.Initial = sarama.OffsetOldest
 	config.Consumer.Offsets.AutoCommit.Enable = false // Disable auto-commit for manual commit
 
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// VersionAuto makes the clients detect the protocol version from the brokers
+const VersionAuto = "auto"
+
+// defaultVersion is the protocol version used when KAFKA_VERSION is not set
+var defaultVersion = sarama.V2_8_0_0
+
+// detectRetryInterval is how long the default version is used after a failed
+// detection before the brokers are probed again
+const detectRetryInterval = time.Minute
+
+// versionSignatures maps Kafka releases, newest first, to an API version they
+// introduced. Sarama picks no newer request versions for releases after 3.1,
+// so newer brokers are detected as 3.1.
+var versionSignatures = []struct {
+	version    sarama.KafkaVersion
+	apiKey     int16
+	maxVersion int16
+}{
+	{sarama.V3_1_0_0, 1, 13},  // Fetch v13, topic IDs
+	{sarama.V3_0_0_0, 66, 0},  // ListTransactions
+	{sarama.V2_8_0_0, 60, 0},  // DescribeCluster
+	{sarama.V2_7_0_0, 51, 0},  // AlterUserScramCredentials
+	{sarama.V2_6_0_0, 48, 0},  // DescribeClientQuotas
+	{sarama.V2_4_0_0, 46, 0},  // ListPartitionReassignments
+	{sarama.V2_3_0_0, 44, 0},  // IncrementalAlterConfigs
+	{sarama.V2_2_0_0, 43, 0},  // ElectLeaders
+	{sarama.V2_1_0_0, 1, 10},  // Fetch v10
+	{sarama.V2_0_0_0, 1, 8},   // Fetch v8
+	{sarama.V1_1_0_0, 1, 7},   // Fetch v7, incremental fetch sessions
+	{sarama.V1_0_0_0, 1, 6},   // Fetch v6
+	{sarama.V0_11_0_0, 22, 0}, // InitProducerId
+	{sarama.V0_10_1_0, 1, 3},  // Fetch v3
+}
+
+var (
+	detectedMu        sync.Mutex
+	detectedVersion   *sarama.KafkaVersion
+	detectFailedUntil time.Time
+)
+
+// GetVersion returns the Kafka protocol version from environment variable, empty for the default
+func GetVersion() string {
+	return os.Getenv("KAFKA_VERSION")
+}
+
+// resolveVersion returns the configured protocol version, detecting it from
+// the brokers when KAFKA_VERSION is "auto". The version falls back to the
+// default, with a warning, when it is invalid or cannot be detected; after a
+// failed detection the brokers are not probed again for detectRetryInterval.
+func resolveVersion(config *sarama.Config) sarama.KafkaVersion {
+	switch setting := GetVersion(); setting {
+	case "":
+		return defaultVersion
+	case VersionAuto:
+		detectedMu.Lock()
+		defer detectedMu.Unlock()
+		if detectedVersion != nil {
+			return *detectedVersion
+		}
+		if time.Now().Before(detectFailedUntil) {
+			return defaultVersion
+		}
+		version, err := DetectVersion(GetBrokers(), config)
+		if err != nil {
+			detectFailedUntil = time.Now().Add(detectRetryInterval)
+			slog.Warn("Failed to detect the Kafka version", "using", defaultVersion.String(),
+				"retry_in", detectRetryInterval.String(), "error", err)
+			return defaultVersion
+		}
+		detectedVersion = &version
+		return version
+	default:
+		version, err := sarama.ParseKafkaVersion(setting)
+		if err != nil {
+			slog.Warn("Invalid KAFKA_VERSION", "value", setting, "using", defaultVersion.String(), "error", err)
+			return defaultVersion
+		}
+		return version
+	}
+}
+
+// DetectVersion asks every reachable broker for its API versions and returns
+// the highest protocol version that all of them and the client support
+func DetectVersion(brokers []string, config *sarama.Config) (sarama.KafkaVersion, error) {
+	probe := *config
+	probe.Version = sarama.V1_0_0_0
+	probe.Net.DialTimeout = min(probe.Net.DialTimeout, 10*time.Second)
+	probe.Net.ReadTimeout = min(probe.Net.ReadTimeout, 10*time.Second)
+	probe.Net.WriteTimeout = min(probe.Net.WriteTimeout, 10*time.Second)
+
+	version := sarama.MaxVersion
+	var lastErr error
+	reached := 0
+	for _, addr := range brokers {
+		keys, err := apiVersions(addr, &probe)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		reached++
+		if brokerVersion := VersionFromAPIs(keys); !brokerVersion.IsAtLeast(version) {
+			version = brokerVersion
+		}
+	}
+	if reached == 0 {
+		return defaultVersion, fmt.Errorf("no broker answered: %w", lastErr)
+	}
+	return version, nil
+}
+
+// VersionFromAPIs returns the newest Kafka release whose APIs a broker supports
+func VersionFromAPIs(keys []sarama.ApiVersionsResponseKey) sarama.KafkaVersion {
+	supported := make(map[int16]int16, len(keys))
+	for _, key := range keys {
+		supported[key.ApiKey] = key.MaxVersion
+	}
+	for _, signature := range versionSignatures {
+		if maxVersion, ok := supported[signature.apiKey]; ok && maxVersion >= signature.maxVersion {
+			return signature.version
+		}
+	}
+	// Every broker answering ApiVersions is at least 0.10.0
+	return sarama.V0_10_0_0
+}
+
+func apiVersions(addr string, config *sarama.Config) ([]sarama.ApiVersionsResponseKey, error) {
+	broker := sarama.NewBroker(addr)
+	if err := broker.Open(config); err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer broker.Close()
+
+	response, err := broker.ApiVersions(&sarama.ApiVersionsRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API versions from %s: %w", addr, err)
+	}
+	if response.ErrorCode != 0 {
+		return nil, fmt.Errorf("failed to get API versions from %s: %w", addr, sarama.KError(response.ErrorCode))
+	}
+	return response.ApiKeys, nil
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestFailedDetectionIsNotRepeated(t *testing.T) {
+	// A broker closing every connection fails the detection
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var connections atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connections.Add(1)
+			conn.Close()
+		}
+	}()
+
+	t.Setenv("KAFKA_VERSION", VersionAuto)
+	t.Setenv("KAFKA_BROKERS", listener.Addr().String())
+	t.Cleanup(func() { detectedVersion, detectFailedUntil = nil, time.Time{} })
+
+	for i := 0; i < 3; i++ {
+		if version := resolveVersion(sarama.NewConfig()); version != defaultVersion {
+			t.Fatalf("resolved %s, want the default %s", version, defaultVersion)
+		}
+	}
+	if n := connections.Load(); n != 1 {
+		t.Fatalf("probed the broker %d times, want once", n)
+	}
+
+	// Once the interval has passed the brokers are probed again
+	detectFailedUntil = time.Now()
+	resolveVersion(sarama.NewConfig())
+	if n := connections.Load(); n != 2 {
+		t.Fatalf("probed the broker %d times after the retry interval, want twice", n)
+	}
+}
@@ -4,54 +4,124 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
+	"kafka_test/adminhttp"
 	"kafka_test/config"
+	"kafka_test/dedup"
+	"kafka_test/lifecycle"
+	"kafka_test/logging"
+	"kafka_test/metrics"
 	"kafka_test/models"
+	"kafka_test/rebalance"
+	"kafka_test/tracing"
 
 	"github.com/Shopify/sarama"
 )
 
+// retryBackoff is the wait before a new group session after a failed one
+const retryBackoff = time.Second
+
 // Consumer represents a Sarama consumer group consumer
 type Consumer struct {
+	// Hooks are called when partitions are assigned and revoked
+	rebalance.Hooks
+
 	groupID string
 	topic   string
+	state   *adminhttp.State
+	// dedup skips messages processed already, nil when deduplication is off
+	dedup *dedup.Filter
 }
 
-// NewConsumer creates a new Kafka consumer
-func NewConsumer() (*Consumer, error) {
-	return &Consumer{
-		groupID: config.GetGroupID(),
+// NewConsumer creates a new Kafka consumer. A consumer replaying a range does not
+// deduplicate, it reprocesses messages processed already on purpose.
+func NewConsumer(replaying bool) (*Consumer, error) {
+	groupID := config.GetGroupID()
+	c := &Consumer{
+		groupID: groupID,
 		topic:   config.GetTopicName(),
-	}, nil
+		state:   adminhttp.NewState(groupID),
+	}
+	if !replaying {
+		filter, err := newDedupFilter(groupID)
+		if err != nil {
+			return nil, err
+		}
+		c.dedup = filter
+	}
+	return c, nil
+}
+
+// newDedupFilter creates the deduplication filter configured for the group, nil if it is off
+func newDedupFilter(groupID string) (*dedup.Filter, error) {
+	filter, err := dedup.New(groupID, dedup.Options{
+		Key:    config.GetDedupKey(),
+		Header: config.GetDedupHeader(),
+		Store:  config.GetDedupStore(),
+		Path:   config.GetDedupPath(),
+		Size:   config.GetDedupSize(),
+		TTL:    config.GetDedupTTL(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create deduplication filter: %w", err)
+	}
+	return filter, nil
+}
+
+// Close closes the deduplication store
+func (c *Consumer) Close() error {
+	return c.dedup.Close()
 }
 
 // Setup is run at the beginning of a new session, before ConsumeClaim
-func (c *Consumer) Setup(sarama.ConsumerGroupSession) error {
-	log.Println("Consumer setup completed")
-	return nil
+func (c *Consumer) Setup(session sarama.ConsumerGroupSession) error {
+	metrics.SessionStarted(c.groupID)
+	c.state.SessionStarted(session)
+	return c.Assigned(session)
 }
 
 // Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
-func (c *Consumer) Cleanup(sarama.ConsumerGroupSession) error {
-	log.Println("Consumer cleanup completed")
-	return nil
+func (c *Consumer) Cleanup(session sarama.ConsumerGroupSession) error {
+	metrics.SessionEnded(c.groupID, session.Claims())
+	c.state.SessionEnded()
+	// Let the hooks flush their state, then commit the offsets marked since the last
+	// commit before the partitions are released
+	err := c.Revoked(session)
+	session.Commit()
+	return err
 }
 
 // ConsumeClaim must start a consumer loop of ConsumerGroupClaim's Messages()
 func (c *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	c.state.ClaimStarted(claim.Topic(), claim.Partition())
 	for {
 		select {
 		case message := <-claim.Messages():
 			if message == nil {
 				return nil
 			}
+			// Stop taking messages once the session ends, they are fetched again by the next owner
+			if session.Context().Err() != nil {
+				return nil
+			}
+			metrics.ObserveConsume(c.groupID, message.Topic, message.Partition, message.Offset, claim.HighWaterMarkOffset())
+
+			// Skip messages processed already, only moving the offset past them
+			id, duplicate := c.dedup.Duplicate(message)
+			if duplicate {
+				session.MarkMessage(message, "")
+				continue
+			}
 
 			if err := c.processMessage(session, message); err != nil {
-				log.Printf("Error processing message: %v", err)
+				metrics.ConsumeError(c.groupID, message.Topic)
+				slog.Error("Error processing message", append(logging.Message(message), "error", err)...)
 				// Continue processing other messages even if one fails
+				continue
 			}
+			c.dedup.Processed(id)
 
 		case <-session.Context().Done():
 			return nil
@@ -59,70 +129,62 @@ func (c *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim saram
 	}
 }
 
-// processMessage processes a single Kafka message
+// processMessage processes a single Kafka message in a span continuing the producer's
+// trace, with child spans for the decode, handle and commit steps
 func (c *Consumer) processMessage(session sarama.ConsumerGroupSession, message *sarama.ConsumerMessage) error {
-	// Log message details
-	log.Printf("Received message - Topic: %s, Partition: %d, Offset: %d, Key: %s, Value: %s",
-		message.Topic, message.Partition, message.Offset, string(message.Key), string(message.Value))
-
-	// Print headers if available
-	if len(message.Headers) > 0 {
-		log.Printf("Message headers:")
-		for _, header := range message.Headers {
-			log.Printf("  %s: %s", string(header.Key), string(header.Value))
-		}
-	}
+	ctx, span := tracing.StartConsume(session.Context(), c.groupID, message)
+	defer span.End()
+
+	// Log message details, the value only if payload logging is enabled
+	logger := logging.Sampled().With(logging.Message(message)...)
+	logger.Debug("Received message", logging.Headers(message))
 
 	// Try to parse as JSON message first
+	_, decodeSpan := tracing.Start(ctx, "decode")
 	var msg models.Message
 	if err := json.Unmarshal(message.Value, &msg); err != nil {
 		// If JSON parsing fails, treat as plain text message
-		log.Printf("Message is plain text: %s", string(message.Value))
+		logger.Info("Received plain text message", logging.Payload(message.Value))
 	} else {
-		log.Printf("Parsed JSON message: %s", msg.String())
+		logger.Info("Received JSON message", "message_id", msg.ID, logging.Payload(message.Value))
 	}
+	decodeSpan.End()
 
 	// Simulate some processing time
+	_, handleSpan := tracing.Start(ctx, "handle")
 	time.Sleep(500 * time.Millisecond)
+	handleSpan.End()
 
 	// Mark message as processed
+	_, commitSpan := tracing.Start(ctx, "commit")
+	defer commitSpan.End()
 	session.MarkMessage(message, "")
-	log.Printf("Message processed - Partition: %d, Offset: %d \n", message.Partition, message.Offset)
+	logger.Info("Message processed")
 
 	// Commit immediately for consistency (you could also batch commits for better performance)
+	commitStart := time.Now()
 	session.Commit()
+	metrics.ObserveCommit(c.groupID, commitStart)
 	return nil
 }
 
-// Start starts the consumer
+// State returns the state served by the admin endpoints
+func (c *Consumer) State() *adminhttp.State {
+	return c.state
+}
+
+// Start consumes until the context is cancelled, then drains and leaves the group
 func (c *Consumer) Start(ctx context.Context) error {
 	kafkaConfig := config.GetConsumerConfig()
+	metrics.RegisterSarama("consumer", kafkaConfig.MetricRegistry)
 
 	// Create consumer group
 	group, err := sarama.NewConsumerGroup(config.GetBrokers(), c.groupID, kafkaConfig)
 	if err != nil {
 		return fmt.Errorf("failed to create consumer group: %w", err)
 	}
-	defer group.Close()
-
-	log.Printf("Consumer started. Consuming messages from topic: %s with group: %s", c.topic, c.groupID)
+	c.state.Attach(group, kafkaConfig)
 
-	// Start consuming in a goroutine
-	go func() {
-		for {
-			topics := []string{c.topic}
-			err := group.Consume(ctx, topics, c)
-			if err != nil {
-				log.Printf("Error from consumer: %v", err)
-			}
-			if ctx.Err() != nil {
-				return
-			}
-		}
-	}()
-
-	// Wait for context cancellation
-	<-ctx.Done()
-	log.Println("Consumer context cancelled, stopping...")
-	return nil
+	slog.Info("Consumer started", "topic", c.topic, "group", c.groupID)
+	return lifecycle.ConsumeGroup(ctx, group, c.groupID, []string{c.topic}, c, retryBackoff)
 }
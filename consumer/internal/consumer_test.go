@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"kafka_test/adminhttp"
+	"kafka_test/lifecycle"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+)
+
+// fakeGroup runs one session over a single claim like sarama does: Setup, ConsumeClaim
+// until the context is done, then Cleanup
+type fakeGroup struct {
+	claim   *fakeClaim
+	session *fakeSession
+
+	mu      sync.Mutex
+	paused  bool
+	closed  bool
+	cleaned bool
+}
+
+func (g *fakeGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	if err := handler.Setup(g.session); err != nil {
+		return err
+	}
+	err := handler.ConsumeClaim(g.session, g.claim)
+	if cleanupErr := handler.Cleanup(g.session); err == nil {
+		err = cleanupErr
+	}
+	g.mu.Lock()
+	g.cleaned = true
+	g.mu.Unlock()
+	return err
+}
+
+func (g *fakeGroup) Errors() <-chan error { return nil }
+
+func (g *fakeGroup) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closed = true
+	return nil
+}
+
+func (g *fakeGroup) Pause(map[string][]int32)  {}
+func (g *fakeGroup) Resume(map[string][]int32) {}
+
+func (g *fakeGroup) PauseAll() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.paused = true
+}
+
+func (g *fakeGroup) ResumeAll() {}
+
+// fakeSession records the offsets marked and committed
+type fakeSession struct {
+	ctx context.Context
+
+	mu        sync.Mutex
+	marked    int64
+	committed int64
+}
+
+func (s *fakeSession) Claims() map[string][]int32 { return map[string][]int32{"test-topic": {0}} }
+func (s *fakeSession) MemberID() string           { return "member" }
+func (s *fakeSession) GenerationID() int32        { return 1 }
+func (s *fakeSession) Context() context.Context   { return s.ctx }
+
+func (s *fakeSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marked = offset
+}
+
+func (s *fakeSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+	s.MarkOffset(topic, partition, offset, metadata)
+}
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+func (s *fakeSession) Commit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.committed = s.marked
+}
+
+// fakeClaim hands over the messages of a mock partition consumer one at a time,
+// reporting each message taken by the handler on handed
+type fakeClaim struct {
+	pc       sarama.PartitionConsumer
+	messages chan *sarama.ConsumerMessage
+	handed   chan *sarama.ConsumerMessage
+}
+
+func newFakeClaim(pc sarama.PartitionConsumer) *fakeClaim {
+	c := &fakeClaim{
+		pc:       pc,
+		messages: make(chan *sarama.ConsumerMessage),
+		handed:   make(chan *sarama.ConsumerMessage, 10),
+	}
+	go func() {
+		for message := range pc.Messages() {
+			c.messages <- message
+			c.handed <- message
+		}
+	}()
+	return c
+}
+
+func (c *fakeClaim) Topic() string                            { return "test-topic" }
+func (c *fakeClaim) Partition() int32                         { return 0 }
+func (c *fakeClaim) InitialOffset() int64                     { return sarama.OffsetOldest }
+func (c *fakeClaim) HighWaterMarkOffset() int64               { return c.pc.HighWaterMarkOffset() }
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func TestDrainWhileProcessing(t *testing.T) {
+	consumer := mocks.NewConsumer(t, nil)
+	defer consumer.Close()
+	consumer.ExpectConsumePartition("test-topic", 0, sarama.OffsetOldest).
+		YieldMessage(&sarama.ConsumerMessage{Topic: "test-topic", Value: []byte("first")}).
+		YieldMessage(&sarama.ConsumerMessage{Topic: "test-topic", Value: []byte("second")})
+	pc, err := consumer.ConsumePartition("test-topic", 0, sarama.OffsetOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	group := &fakeGroup{claim: newFakeClaim(pc), session: &fakeSession{ctx: ctx}}
+	c := &Consumer{groupID: "test-group", topic: "test-topic", state: adminhttp.NewState("test-group")}
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- lifecycle.ConsumeGroup(ctx, group, c.groupID, []string{c.topic}, c, retryBackoff) }()
+
+	// Stop while the first message is being handled, which takes 500ms
+	select {
+	case <-group.claim.handed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first message was not handed to the consumer")
+	}
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatalf("drain failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the consumer did not drain")
+	}
+
+	group.mu.Lock()
+	defer group.mu.Unlock()
+	if !group.paused || !group.cleaned || !group.closed {
+		t.Fatalf("paused %v, cleaned up %v, closed %v: want the session to end and the group to close",
+			group.paused, group.cleaned, group.closed)
+	}
+	session := group.session
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	// The in-flight message is finished and committed, the next one is left for the next owner
+	if session.marked != 1 || session.committed != 1 {
+		t.Fatalf("marked %d, committed %d, want 1 and 1", session.marked, session.committed)
+	}
+}
@@ -2,36 +2,58 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"os"
-	"os/signal"
-	"syscall"
 
+	"kafka_test/adminhttp"
+	"kafka_test/config"
 	"kafka_test/consumer/internal"
+	"kafka_test/lifecycle"
+	"kafka_test/logging"
+	"kafka_test/metrics"
+	"kafka_test/replay"
+	"kafka_test/tracing"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run runs the consumer until SIGINT or SIGTERM, or replays a range of the topic, and
+// returns the exit code of draining it
+func run() int {
+	replayOpts := replay.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	if err := logging.SetupFromEnv("consumer"); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
 	// Create consumer
-	c, err := internal.NewConsumer()
+	c, err := internal.NewConsumer(replayOpts.Enabled())
 	if err != nil {
 		log.Fatalf("Failed to create consumer: %v", err)
 	}
 
-	// Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Start consumer in a goroutine
-	go func() {
-		if err := c.Start(ctx); err != nil {
-			log.Printf("Consumer error: %v", err)
-		}
-	}()
+	metrics.Start(ctx, config.GetMetricsAddr(":9102"))
+	adminhttp.Start(ctx, config.GetAdminAddr(":9202"), c.State())
+
+	shutdownTracing, err := tracing.Setup(ctx, "consumer", tracing.Options{Exporter: config.GetTracingExporter(), File: config.GetTracingFile()})
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	// Replay reads the partitions directly instead of joining the group
+	service := c.Start
+	if replayOpts.Enabled() {
+		replayOpts.Topic, replayOpts.GroupID = config.GetTopicName(), config.GetGroupID()
+		service = func(ctx context.Context) error { return replay.Run(ctx, *replayOpts, c) }
+	}
 
-	// Wait for shutdown signal
-	<-sigChan
-	log.Println("Shutting down consumer...")
+	return lifecycle.Run(ctx, config.GetDrainTimeout(), service, lifecycle.Closer{Name: "deduplication store", Closer: c})
 }
@@ -0,0 +1,115 @@
+package dedup
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// sweepInterval is how often expired identities are deleted from a bolt store
+const sweepInterval = time.Minute
+
+// boltStore keeps identities in a bolt file, one bucket per consumer group, so they
+// survive restarts. Each identity maps to its expiry in Unix nanoseconds.
+type boltStore struct {
+	db     *bolt.DB
+	bucket []byte
+	ttl    time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewBoltStore opens or creates the bolt file at path and keeps the identities of the
+// bucket for ttl each. Only one process can open the file at a time.
+func NewBoltStore(path, bucket string, ttl time.Duration) (Store, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open deduplication store %s: %w", path, err)
+	}
+	s := &boltStore{
+		db:     db,
+		bucket: []byte(bucket),
+		ttl:    ttl,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(s.bucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create deduplication bucket %s: %w", bucket, err)
+	}
+
+	go s.sweepLoop()
+	return s, nil
+}
+
+func (s *boltStore) Seen(id string) (bool, error) {
+	seen := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(s.bucket).Get([]byte(id))
+		seen = len(value) == 8 && time.Now().UnixNano() < int64(binary.BigEndian.Uint64(value))
+		return nil
+	})
+	return seen, err
+}
+
+func (s *boltStore) Add(id string) error {
+	expires := make([]byte, 8)
+	binary.BigEndian.PutUint64(expires, uint64(time.Now().Add(s.ttl).UnixNano()))
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Put([]byte(id), expires)
+	})
+}
+
+// sweepLoop deletes expired identities, once on open and then periodically
+func (s *boltStore) sweepLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.sweep(); err != nil {
+			slog.Warn("Failed to delete expired message identities", "error", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *boltStore) sweep() error {
+	now := uint64(time.Now().UnixNano())
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(s.bucket)
+		// Collect first, deleting under a cursor skips the key after each deleted one
+		var expired [][]byte
+		err := bucket.ForEach(func(id, value []byte) error {
+			if len(value) != 8 || binary.BigEndian.Uint64(value) <= now {
+				expired = append(expired, append([]byte(nil), id...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range expired {
+			if err := bucket.Delete(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Close() error {
+	close(s.stop)
+	<-s.done
+	return s.db.Close()
+}
@@ -0,0 +1,124 @@
+// Package dedup skips messages that were processed already, e.g. redelivered after a
+// restart or duplicated by producer retries, by remembering an identity of each message
+package dedup
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"kafka_test/logging"
+	"kafka_test/metrics"
+
+	"github.com/Shopify/sarama"
+)
+
+// Store remembers the identities of processed messages until they expire
+type Store interface {
+	// Seen reports whether the identity was added and has not expired
+	Seen(id string) (bool, error)
+	// Add remembers the identity
+	Add(id string) error
+	Close() error
+}
+
+// Options configures a Filter
+type Options struct {
+	// Key is the identity of messages: off, header, request or hash
+	Key string
+	// Header holds the identity for the header key
+	Header string
+	// Store keeps the identities: memory or bolt
+	Store string
+	// Path is the file of the bolt store
+	Path string
+	// Size is the most identities the memory store keeps, the least recently used go first
+	Size int
+	// TTL is how long an identity is kept
+	TTL time.Duration
+}
+
+// Filter skips the duplicates of a consumer group before they reach the handler.
+// A nil Filter deduplicates nothing.
+type Filter struct {
+	group    string
+	identity Identity
+	store    Store
+}
+
+// New creates the filter of a consumer group, nil if deduplication is off
+func New(group string, opts Options) (*Filter, error) {
+	var identity Identity
+	switch opts.Key {
+	case "", "off":
+		return nil, nil
+	case "header":
+		identity = HeaderIdentity(opts.Header)
+	case "request":
+		identity = RequestIdentity
+	case "hash":
+		identity = HashIdentity
+	default:
+		return nil, fmt.Errorf("unknown deduplication key %q: must be off, header, request or hash", opts.Key)
+	}
+
+	var store Store
+	switch opts.Store {
+	case "", "memory":
+		store = NewMemoryStore(opts.Size, opts.TTL)
+	case "bolt":
+		var err error
+		if store, err = NewBoltStore(opts.Path, group, opts.TTL); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown deduplication store %q: must be memory or bolt", opts.Store)
+	}
+
+	slog.Info("Deduplicating messages", "key", opts.Key, "store", opts.Store, "ttl", opts.TTL.String())
+	return &Filter{group: group, identity: identity, store: store}, nil
+}
+
+// Duplicate reports whether a message with the same identity was processed already,
+// counting it if so. It returns the identity to pass to Processed once the message was
+// handled, empty for messages without one. A failing store lets the message through.
+func (f *Filter) Duplicate(msg *sarama.ConsumerMessage) (id string, duplicate bool) {
+	if f == nil {
+		return "", false
+	}
+	id, ok := f.identity(msg)
+	if !ok {
+		return "", false
+	}
+	// Identities are unique per topic only
+	id = msg.Topic + "/" + id
+
+	seen, err := f.store.Seen(id)
+	if err != nil {
+		slog.Warn("Failed to look up message identity, processing it", append(logging.Message(msg), "id", id, "error", err)...)
+		return id, false
+	}
+	if seen {
+		metrics.DuplicateSkipped(f.group, msg.Topic)
+		logging.Sampled().Info("Skipping duplicate message", append(logging.Message(msg), "id", id)...)
+	}
+	return id, seen
+}
+
+// Processed remembers the identity of a handled message, so later copies are skipped
+func (f *Filter) Processed(id string) {
+	if f == nil || id == "" {
+		return
+	}
+	if err := f.store.Add(id); err != nil {
+		slog.Warn("Failed to remember message identity, its duplicates are processed again", "id", id, "error", err)
+	}
+}
+
+// Close closes the store
+func (f *Filter) Close() error {
+	if f == nil {
+		return nil
+	}
+	return f.store.Close()
+}
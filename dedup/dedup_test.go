@@ -0,0 +1,274 @@
+package dedup
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"kafka_test/models"
+
+	"github.com/Shopify/sarama"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+)
+
+func seen(t *testing.T, store Store, id string) bool {
+	t.Helper()
+	ok, err := store.Seen(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+func add(t *testing.T, store Store, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		if err := store.Add(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMemoryStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	store := NewMemoryStore(2, time.Hour)
+	add(t, store, "a", "b")
+
+	// Looking a up makes b the least recently used
+	if !seen(t, store, "a") {
+		t.Fatal("a not seen")
+	}
+	add(t, store, "c")
+
+	if seen(t, store, "b") {
+		t.Fatal("b seen after it was evicted")
+	}
+	if !seen(t, store, "a") || !seen(t, store, "c") {
+		t.Fatal("a or c evicted instead of b")
+	}
+}
+
+func TestMemoryStoreExpires(t *testing.T) {
+	store := NewMemoryStore(10, 10*time.Millisecond)
+	add(t, store, "a")
+	if !seen(t, store, "a") {
+		t.Fatal("a not seen before it expired")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if seen(t, store, "a") {
+		t.Fatal("a seen after it expired")
+	}
+	if n := store.(*memoryStore).order.Len(); n != 0 {
+		t.Fatalf("%d expired identities kept", n)
+	}
+}
+
+func TestBoltStoreSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.db")
+	store, err := NewBoltStore(path, "group-a", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	add(t, store, "a")
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewBoltStore(path, "group-a", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if !seen(t, store, "a") {
+		t.Fatal("identity lost on reopen")
+	}
+	if seen(t, store, "b") {
+		t.Fatal("identity never added seen")
+	}
+
+	other, err := NewBoltStore(filepath.Join(t.TempDir(), "other.db"), "group-a", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if seen(t, other, "a") {
+		t.Fatal("identity seen in another file")
+	}
+}
+
+func TestBoltStoreBucketPerGroup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.db")
+	store, err := NewBoltStore(path, "group-a", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	add(t, store, "a")
+	store.Close()
+
+	store, err = NewBoltStore(path, "group-b", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if seen(t, store, "a") {
+		t.Fatal("identity of another group seen")
+	}
+}
+
+func TestBoltStoreSweepsExpired(t *testing.T) {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "dedup.db"), "group", 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	s := store.(*boltStore)
+
+	add(t, store, "old")
+	time.Sleep(20 * time.Millisecond)
+	if seen(t, store, "old") {
+		t.Fatal("identity seen after it expired")
+	}
+	// A live identity, and a corrupt value which is swept as well
+	s.ttl = time.Hour
+	add(t, store, "new")
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Put([]byte("corrupt"), []byte{1})
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.sweep(); err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).ForEach(func(id, value []byte) error {
+			if len(value) == 8 && int64(binary.BigEndian.Uint64(value)) > time.Now().UnixNano() {
+				kept = append(kept, string(id))
+			} else {
+				kept = append(kept, string(id)+"(expired)")
+			}
+			return nil
+		})
+	})
+	if len(kept) != 1 || kept[0] != "new" {
+		t.Fatalf("kept %v after the sweep, want [new]", kept)
+	}
+}
+
+func changeDataValue(t *testing.T, requestID string, number uint32) []byte {
+	t.Helper()
+	value, err := proto.Marshal(&models.ChangeDataMessage{RequestId: requestID, MessageNumber: number, TotalMessageCount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func headers(pairs ...string) []*sarama.RecordHeader {
+	var result []*sarama.RecordHeader
+	for i := 0; i+1 < len(pairs); i += 2 {
+		result = append(result, &sarama.RecordHeader{Key: []byte(pairs[i]), Value: []byte(pairs[i+1])})
+	}
+	return result
+}
+
+func TestRequestIdentity(t *testing.T) {
+	protobuf := "application/x-protobuf"
+	tests := []struct {
+		name string
+		msg  *sarama.ConsumerMessage
+		want string
+		ok   bool
+	}{
+		{"headers", &sarama.ConsumerMessage{Headers: headers("request-id", "r1", "message-number", "2")}, "r1/2", true},
+		{"message", &sarama.ConsumerMessage{Headers: headers("content-type", protobuf), Value: changeDataValue(t, "r1", 2)}, "r1/2", true},
+		{"other part", &sarama.ConsumerMessage{Headers: headers("content-type", protobuf), Value: changeDataValue(t, "r1", 1)}, "r1/1", true},
+		{"headers first", &sarama.ConsumerMessage{
+			Headers: headers("request-id", "r2", "message-number", "1", "content-type", protobuf),
+			Value:   changeDataValue(t, "r1", 2),
+		}, "r2/1", true},
+		{"no request ID", &sarama.ConsumerMessage{Headers: headers("content-type", protobuf), Value: changeDataValue(t, "", 1)}, "", false},
+		{"not protobuf", &sarama.ConsumerMessage{Headers: headers("content-type", "application/json"), Value: []byte(`{"request_id":"r1"}`)}, "", false},
+		{"undecodable", &sarama.ConsumerMessage{Headers: headers("content-type", protobuf), Value: []byte{0xff, 0xff}}, "", false},
+	}
+	for _, test := range tests {
+		id, ok := RequestIdentity(test.msg)
+		if id != test.want || ok != test.ok {
+			t.Fatalf("%s: identity %q (%t), want %q (%t)", test.name, id, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestHashIdentity(t *testing.T) {
+	identity := func(key, value string) string {
+		id, ok := HashIdentity(&sarama.ConsumerMessage{Key: []byte(key), Value: []byte(value)})
+		if !ok {
+			t.Fatal("message without a hash identity")
+		}
+		return id
+	}
+
+	if identity("k", "value") != identity("k", "value") {
+		t.Fatal("same payload, different identities")
+	}
+	if identity("k", "value") == identity("k", "other") {
+		t.Fatal("different values, same identity")
+	}
+	if identity("ab", "c") == identity("a", "bc") {
+		t.Fatal("bytes moved between key and value, same identity")
+	}
+}
+
+func TestHeaderIdentity(t *testing.T) {
+	identity := HeaderIdentity("message-id")
+	if id, ok := identity(&sarama.ConsumerMessage{Headers: headers("message-id", "m1")}); id != "m1" || !ok {
+		t.Fatalf("identity %q (%t), want m1", id, ok)
+	}
+	if _, ok := identity(&sarama.ConsumerMessage{Headers: headers("other", "m1")}); ok {
+		t.Fatal("identity of a message without the header")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	filter, err := New("group", Options{Key: "hash", Store: "memory", Size: 10, TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer filter.Close()
+
+	msg := &sarama.ConsumerMessage{Topic: "a", Value: []byte("value")}
+	id, duplicate := filter.Duplicate(msg)
+	if duplicate || id == "" {
+		t.Fatalf("first message is a duplicate %t with identity %q", duplicate, id)
+	}
+	// Only processed messages are remembered
+	if _, duplicate := filter.Duplicate(msg); duplicate {
+		t.Fatal("message not processed yet is a duplicate")
+	}
+	filter.Processed(id)
+	if _, duplicate := filter.Duplicate(msg); !duplicate {
+		t.Fatal("processed message is not a duplicate")
+	}
+	// Identities are unique per topic
+	if _, duplicate := filter.Duplicate(&sarama.ConsumerMessage{Topic: "b", Value: []byte("value")}); duplicate {
+		t.Fatal("message of another topic is a duplicate")
+	}
+}
+
+func TestNew(t *testing.T) {
+	filter, err := New("group", Options{Key: "off"})
+	if err != nil || filter != nil {
+		t.Fatalf("filter %v (%v) with deduplication off, want none", filter, err)
+	}
+	if _, duplicate := filter.Duplicate(&sarama.ConsumerMessage{}); duplicate {
+		t.Fatal("nil filter found a duplicate")
+	}
+	if _, err := New("group", Options{Key: "nope"}); err == nil {
+		t.Fatal("unknown key accepted")
+	}
+	if _, err := New("group", Options{Key: "hash", Store: "nope"}); err == nil {
+		t.Fatal("unknown store accepted")
+	}
+}
@@ -0,0 +1,61 @@
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"kafka_test/models"
+
+	"github.com/Shopify/sarama"
+	"google.golang.org/protobuf/proto"
+)
+
+// Identity returns the identity of a message, false for messages without one, which
+// are never deduplicated
+type Identity func(msg *sarama.ConsumerMessage) (string, bool)
+
+// HeaderIdentity identifies messages by the value of a header, e.g. the message-id
+// header written by the producer
+func HeaderIdentity(name string) Identity {
+	return func(msg *sarama.ConsumerMessage) (string, bool) {
+		value := header(msg, name)
+		return value, value != ""
+	}
+}
+
+// RequestIdentity identifies a ChangeDataMessage by its request ID and message number,
+// from the headers written by change_data_producer or else from the message itself
+func RequestIdentity(msg *sarama.ConsumerMessage) (string, bool) {
+	if requestID, number := header(msg, "request-id"), header(msg, "message-number"); requestID != "" && number != "" {
+		return requestID + "/" + number, true
+	}
+	if header(msg, "content-type") != "application/x-protobuf" {
+		return "", false
+	}
+	var changeDataMsg models.ChangeDataMessage
+	if err := proto.Unmarshal(msg.Value, &changeDataMsg); err != nil || changeDataMsg.RequestId == "" {
+		return "", false
+	}
+	return fmt.Sprintf("%s/%d", changeDataMsg.RequestId, changeDataMsg.MessageNumber), true
+}
+
+// HashIdentity identifies messages by a SHA-256 hash of their key and value, so
+// messages with the same payload are duplicates
+func HashIdentity(msg *sarama.ConsumerMessage) (string, bool) {
+	hash := sha256.New()
+	hash.Write(msg.Key)
+	// Separate the key from the value, so moving bytes between them changes the hash
+	hash.Write([]byte{0})
+	hash.Write(msg.Value)
+	return hex.EncodeToString(hash.Sum(nil)), true
+}
+
+func header(msg *sarama.ConsumerMessage, name string) string {
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == name {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
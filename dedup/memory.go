@@ -0,0 +1,76 @@
+package dedup
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// memoryStore keeps identities in memory, evicting the least recently used beyond its size.
+// They are lost on restart.
+type memoryStore struct {
+	size int
+	ttl  time.Duration
+
+	mu sync.Mutex
+	// order holds *memoryEntry, the most recently used first
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	id      string
+	expires time.Time
+}
+
+// NewMemoryStore creates a store keeping up to size identities for ttl each
+func NewMemoryStore(size int, ttl time.Duration) Store {
+	return &memoryStore{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (s *memoryStore) Seen(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.entries[id]
+	if !ok {
+		return false, nil
+	}
+	if time.Now().After(element.Value.(*memoryEntry).expires) {
+		s.remove(element)
+		return false, nil
+	}
+	s.order.MoveToFront(element)
+	return true, nil
+}
+
+func (s *memoryStore) Add(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires := time.Now().Add(s.ttl)
+	if element, ok := s.entries[id]; ok {
+		element.Value.(*memoryEntry).expires = expires
+		s.order.MoveToFront(element)
+		return nil
+	}
+	s.entries[id] = s.order.PushFront(&memoryEntry{id: id, expires: expires})
+	for s.size > 0 && s.order.Len() > s.size {
+		s.remove(s.order.Back())
+	}
+	return nil
+}
+
+func (s *memoryStore) remove(element *list.Element) {
+	s.order.Remove(element)
+	delete(s.entries, element.Value.(*memoryEntry).id)
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,244 @@
+package diff
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"kafka_test/models"
+	"kafka_test/render"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// FieldChange describes the change of a single field between two versions.
+// Old and New are display values; Set flags tell unset fields from empty ones.
+type FieldChange struct {
+	Field  string `json:"field"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+	OldSet bool   `json:"old_set"`
+	NewSet bool   `json:"new_set"`
+}
+
+// Event is the structured diff of one CDC record against the previous
+// version of the same entity
+type Event struct {
+	RequestID     string `json:"request_id"`
+	OperationType string `json:"operation_type"`
+	Entity        string `json:"entity"`
+	TenantUID     uint64 `json:"tenant_uid"`
+	Biid          string `json:"biid"`
+	OldVersion    uint32 `json:"old_version,omitempty"`
+	NewVersion    uint32 `json:"new_version"`
+	// PreviousKnown is false when no earlier version has been seen, e.g. for
+	// CREATE records or updates of entities created before the consumer started
+	PreviousKnown bool          `json:"previous_known"`
+	Changes       []FieldChange `json:"changes"`
+}
+
+// String returns a one-line description of the event
+func (e *Event) String() string {
+	changes := make([]string, 0, len(e.Changes))
+	for _, change := range e.Changes {
+		changes = append(changes, change.String())
+	}
+
+	previous := fmt.Sprintf("v%d", e.OldVersion)
+	if !e.PreviousKnown {
+		previous = "unknown"
+	}
+	return fmt.Sprintf("%s %s %s (tenant %d) %s -> v%d: %s",
+		e.OperationType, e.Entity, e.Biid, e.TenantUID, previous, e.NewVersion, strings.Join(changes, ", "))
+}
+
+// String returns the change as `field: "old" -> "new"`
+func (c FieldChange) String() string {
+	format := func(value string, set bool) string {
+		if !set {
+			return "<unset>"
+		}
+		return fmt.Sprintf("%q", value)
+	}
+	return fmt.Sprintf("%s: %s -> %s", c.Field, format(c.Old, c.OldSet), format(c.New, c.NewSet))
+}
+
+// Compare returns the fields that differ between two messages of the same type.
+// Nested messages such as date.Date and timestamps are compared as a whole.
+// A nil old message is treated as empty.
+func Compare(old, new proto.Message) []FieldChange {
+	newMsg := new.ProtoReflect()
+	var oldMsg protoreflect.Message
+	if old != nil {
+		oldMsg = old.ProtoReflect()
+	} else {
+		oldMsg = newMsg.Type().Zero()
+	}
+
+	var changes []FieldChange
+	fields := newMsg.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		oldHas, newHas := oldMsg.Has(fd), newMsg.Has(fd)
+		if !oldHas && !newHas {
+			continue
+		}
+		if oldHas && newHas && oldMsg.Get(fd).Equal(newMsg.Get(fd)) {
+			continue
+		}
+
+		oldValue, oldSet := render.FieldString(oldMsg, fd)
+		newValue, newSet := render.FieldString(newMsg, fd)
+		changes = append(changes, FieldChange{
+			Field:  string(fd.Name()),
+			Old:    oldValue,
+			New:    newValue,
+			OldSet: oldSet,
+			NewSet: newSet,
+		})
+	}
+	return changes
+}
+
+// Options configures a Tracker
+type Options struct {
+	// Size is the most entities remembered, the least recently seen are forgotten beyond it
+	Size int
+	// TTL forgets an entity not seen for this long
+	TTL time.Duration
+	// Unknown also diffs records of entities without a known previous version, like
+	// CREATE records, against an empty record
+	Unknown bool
+}
+
+// Tracker remembers the last version of the departments and aggregation patterns
+// seen recently by tenant and BIID, and diffs new records against it
+type Tracker struct {
+	opts Options
+
+	mu sync.Mutex
+	// order holds *trackedEntity, the most recently seen first
+	order    *list.List
+	previous map[string]*list.Element
+}
+
+type trackedEntity struct {
+	key     string
+	record  versioned
+	expires time.Time
+}
+
+// versioned is the common shape of Department and AggregationPattern
+type versioned interface {
+	proto.Message
+	GetBiid() string
+	GetTenantUid() uint64
+	GetVersion() uint32
+}
+
+// NewTracker creates a new Tracker
+func NewTracker(opts Options) *Tracker {
+	return &Tracker{
+		opts:     opts,
+		order:    list.New(),
+		previous: make(map[string]*list.Element),
+	}
+}
+
+// Observe diffs a record against the previous version of its entity and
+// remembers it. It returns nil for records that are not versioned entities,
+// for records older than the version already seen, for records without changes
+// like redeliveries, and for entities without a known previous version unless
+// the tracker diffs them.
+func (t *Tracker) Observe(requestID string, record *models.Record) *Event {
+	var entity string
+	var current versioned
+	switch data := record.Data.(type) {
+	case *models.Record_Department:
+		entity, current = "department", data.Department
+	case *models.Record_AggregationPattern:
+		entity, current = "aggregation_pattern", data.AggregationPattern
+	default:
+		return nil
+	}
+
+	key := fmt.Sprintf("%s/%d/%s", entity, current.GetTenantUid(), current.GetBiid())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	previous := t.get(key)
+	if previous != nil && current.GetVersion() < previous.GetVersion() {
+		return nil
+	}
+
+	if record.OperationType == models.Record_DELETE {
+		t.remove(key)
+	} else {
+		t.put(key, current)
+	}
+
+	if previous == nil && !t.opts.Unknown {
+		return nil
+	}
+
+	event := &Event{
+		RequestID:     requestID,
+		OperationType: record.OperationType.String(),
+		Entity:        entity,
+		TenantUID:     current.GetTenantUid(),
+		Biid:          current.GetBiid(),
+		NewVersion:    current.GetVersion(),
+		PreviousKnown: previous != nil,
+	}
+	if previous != nil {
+		event.OldVersion = previous.GetVersion()
+		event.Changes = Compare(previous, current)
+	} else {
+		event.Changes = Compare(nil, current)
+	}
+	if len(event.Changes) == 0 {
+		return nil
+	}
+	return event
+}
+
+// get returns the remembered version of an entity, nil if unknown or expired
+func (t *Tracker) get(key string) versioned {
+	element, ok := t.previous[key]
+	if !ok {
+		return nil
+	}
+	entity := element.Value.(*trackedEntity)
+	if t.opts.TTL > 0 && time.Now().After(entity.expires) {
+		t.order.Remove(element)
+		delete(t.previous, key)
+		return nil
+	}
+	return entity.record
+}
+
+// put remembers the version of an entity, forgetting the least recently seen beyond the size
+func (t *Tracker) put(key string, record versioned) {
+	expires := time.Now().Add(t.opts.TTL)
+	if element, ok := t.previous[key]; ok {
+		entity := element.Value.(*trackedEntity)
+		entity.record, entity.expires = record, expires
+		t.order.MoveToFront(element)
+		return
+	}
+	t.previous[key] = t.order.PushFront(&trackedEntity{key: key, record: record, expires: expires})
+	for t.opts.Size > 0 && t.order.Len() > t.opts.Size {
+		t.remove(t.order.Back().Value.(*trackedEntity).key)
+	}
+}
+
+func (t *Tracker) remove(key string) {
+	if element, ok := t.previous[key]; ok {
+		t.order.Remove(element)
+		delete(t.previous, key)
+	}
+}
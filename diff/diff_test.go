@@ -0,0 +1,74 @@
+package diff
+
+import (
+	"testing"
+	"time"
+
+	"kafka_test/models"
+)
+
+func department(biid string, version uint32, name string) *models.Record {
+	return &models.Record{
+		OperationType: models.Record_UPDATE,
+		Data: &models.Record_Department{Department: &models.Department{
+			TenantUid: 1,
+			Biid:      biid,
+			Version:   version,
+			Name:      name,
+		}},
+	}
+}
+
+func TestTrackerDiffsKnownEntitiesOnly(t *testing.T) {
+	tracker := NewTracker(Options{Size: 10, TTL: time.Hour})
+
+	if event := tracker.Observe("r1", department("d1", 1, "One")); event != nil {
+		t.Fatalf("first sighting diffed: %s", event)
+	}
+	event := tracker.Observe("r2", department("d1", 2, "One (rev 2)"))
+	if event == nil || !event.PreviousKnown || event.OldVersion != 1 {
+		t.Fatalf("update not diffed against version 1: %v", event)
+	}
+	if event := tracker.Observe("r2", department("d1", 2, "One (rev 2)")); event != nil {
+		t.Fatalf("redelivery diffed: %s", event)
+	}
+	if event := tracker.Observe("r0", department("d1", 1, "One")); event != nil {
+		t.Fatalf("older version diffed: %s", event)
+	}
+}
+
+func TestTrackerDiffsUnknownEntities(t *testing.T) {
+	tracker := NewTracker(Options{Size: 10, TTL: time.Hour, Unknown: true})
+
+	event := tracker.Observe("r1", department("d1", 1, "One"))
+	if event == nil || event.PreviousKnown || len(event.Changes) == 0 {
+		t.Fatalf("first sighting not diffed against an empty record: %v", event)
+	}
+}
+
+func TestTrackerForgetsLeastRecentlySeen(t *testing.T) {
+	tracker := NewTracker(Options{Size: 2, TTL: time.Hour})
+	tracker.Observe("r1", department("d1", 1, "One"))
+	tracker.Observe("r2", department("d2", 1, "Two"))
+	tracker.Observe("r3", department("d3", 1, "Three"))
+
+	if len(tracker.previous) != 2 {
+		t.Fatalf("tracker remembers %d entities, want 2", len(tracker.previous))
+	}
+	if event := tracker.Observe("r4", department("d1", 2, "One (rev 2)")); event != nil {
+		t.Fatalf("forgotten entity diffed: %s", event)
+	}
+	if event := tracker.Observe("r5", department("d3", 2, "Three (rev 2)")); event == nil {
+		t.Fatal("remembered entity not diffed")
+	}
+}
+
+func TestTrackerForgetsExpired(t *testing.T) {
+	tracker := NewTracker(Options{Size: 10, TTL: time.Millisecond})
+	tracker.Observe("r1", department("d1", 1, "One"))
+	time.Sleep(5 * time.Millisecond)
+
+	if event := tracker.Observe("r2", department("d1", 2, "One (rev 2)")); event != nil {
+		t.Fatalf("expired entity diffed: %s", event)
+	}
+}
@@ -0,0 +1,404 @@
+package fixtures
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"time"
+
+	"kafka_test/models"
+
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/type/date"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	generatorUserID   = 1001
+	generatorUserName = "Fixture Generator"
+	generatorService  = "kafka-producer"
+)
+
+// revisionSuffix matches the suffix added to names by updates
+var revisionSuffix = regexp.MustCompile(`( \(rev \d+\)|-r\d+)$`)
+
+// Generator produces a deterministic stream of realistic ChangeDataMessages
+type Generator struct {
+	scenario Scenario
+	rng      *rand.Rand
+	clock    time.Time
+	tenants  []*tenantState
+}
+
+// tenantState holds the live master data of one simulated tenant
+type tenantState struct {
+	uid         uint64
+	nextID      int
+	departments []*models.Department
+	patterns    []*models.AggregationPattern
+	links       []*models.AggregationPatternDepartment
+}
+
+// NewGenerator creates a new Generator for the scenario
+func NewGenerator(scenario Scenario) (*Generator, error) {
+	if err := scenario.Validate(); err != nil {
+		return nil, err
+	}
+
+	start, _ := time.Parse(time.RFC3339, scenario.StartTime)
+
+	g := &Generator{
+		scenario: scenario,
+		rng:      rand.New(rand.NewSource(scenario.Seed)),
+		clock:    start,
+	}
+	for _, uid := range scenario.Tenants {
+		g.tenants = append(g.tenants, &tenantState{uid: uid})
+	}
+	return g, nil
+}
+
+// NextRequest generates the records of one request for a random tenant and
+// splits them into the ChangeDataMessage parts that make up the request
+func (g *Generator) NextRequest() []*models.ChangeDataMessage {
+	tenant := g.tenants[g.rng.Intn(len(g.tenants))]
+
+	recordCount := g.scenario.RecordsPerRequest.Min +
+		g.rng.Intn(g.scenario.RecordsPerRequest.Max-g.scenario.RecordsPerRequest.Min+1)
+
+	var records []*models.Record
+	for len(records) < recordCount {
+		if g.rng.Float64() < g.scenario.AggregationPatternRatio {
+			records = append(records, g.nextAggregationPatternRecords(tenant)...)
+		} else {
+			records = append(records, g.nextDepartmentRecords(tenant)...)
+		}
+	}
+
+	return g.split(tenant.uid, records)
+}
+
+// split divides a request's records into numbered message parts
+func (g *Generator) split(tenantUID uint64, records []*models.Record) []*models.ChangeDataMessage {
+	requestID := g.newUUID()
+	size := g.scenario.RecordsPerMessage
+	total := (len(records) + size - 1) / size
+
+	messages := make([]*models.ChangeDataMessage, 0, total)
+	for i := 0; i < total; i++ {
+		end := (i + 1) * size
+		if end > len(records) {
+			end = len(records)
+		}
+		messages = append(messages, &models.ChangeDataMessage{
+			RequestId:         requestID,
+			MessageNumber:     uint32(i + 1),
+			TotalMessageCount: uint32(total),
+			TenantUid:         tenantUID,
+			Records:           records[i*size : end],
+		})
+	}
+	return messages
+}
+
+// pickOperation chooses an operation by weight, falling back to CREATE when
+// there is nothing to update or delete and to UPDATE when the tenant is full
+func (g *Generator) pickOperation(live, max int) models.Record_OperationType {
+	weights := g.scenario.OperationWeights
+	var op models.Record_OperationType
+	switch n := g.rng.Intn(weights.Create + weights.Update + weights.Delete); {
+	case n < weights.Create:
+		op = models.Record_CREATE
+	case n < weights.Create+weights.Update:
+		op = models.Record_UPDATE
+	default:
+		op = models.Record_DELETE
+	}
+
+	if live == 0 {
+		return models.Record_CREATE
+	}
+	if op == models.Record_CREATE && live >= max {
+		return models.Record_UPDATE
+	}
+	return op
+}
+
+// nextDepartmentRecords returns a department change, preceded by the deletion of the
+// links to the department when it is deleted
+func (g *Generator) nextDepartmentRecords(tenant *tenantState) []*models.Record {
+	op := g.pickOperation(len(tenant.departments), g.scenario.MaxDepartmentsPerTenant)
+
+	var records []*models.Record
+	var dept *models.Department
+	switch op {
+	case models.Record_CREATE:
+		dept = g.createDepartment(tenant)
+		tenant.departments = append(tenant.departments, dept)
+	case models.Record_UPDATE:
+		i := g.rng.Intn(len(tenant.departments))
+		dept = g.updateDepartment(tenant.departments[i])
+		tenant.departments[i] = dept
+	case models.Record_DELETE:
+		i := g.pickLeafDepartment(tenant)
+		records = unlink(tenant, func(link *models.AggregationPatternDepartment) bool {
+			return link.DepartmentBiid == tenant.departments[i].Biid
+		})
+		dept = g.deleteDepartment(tenant.departments[i])
+		tenant.departments = append(tenant.departments[:i], tenant.departments[i+1:]...)
+	}
+
+	return append(records, &models.Record{
+		OperationType: op,
+		Data:          &models.Record_Department{Department: dept},
+	})
+}
+
+func (g *Generator) createDepartment(tenant *tenantState) *models.Department {
+	tenant.nextID++
+	n := tenant.nextID
+	now := g.tick()
+
+	dept := &models.Department{
+		Id:                    g.newUUID(),
+		Biid:                  g.newUUID(),
+		Type:                  "department",
+		TenantUid:             tenant.uid,
+		Code:                  fmt.Sprintf("DEPT%04d", n),
+		Name:                  fmt.Sprintf("Department %d", n),
+		ShortName:             fmt.Sprintf("Dept%d", n),
+		ValidFrom:             toDate(now),
+		TransactionFrom:       timestamppb.New(now),
+		TransactionFromBy:     generatorUserID,
+		TransactionFromByName: generatorUserName,
+		TransactionFromBySrv:  generatorService,
+		DispOrder:             uint32(n),
+		SearchKey:             fmt.Sprintf("dept%d", n),
+		Version:               1,
+	}
+
+	// Attach to a random parent that still has room below it
+	var candidates []*models.Department
+	for _, parent := range tenant.departments {
+		if departmentDepth(tenant, parent) < g.scenario.MaxDepth {
+			candidates = append(candidates, parent)
+		}
+	}
+	if len(candidates) > 0 && g.rng.Intn(4) != 0 {
+		parent := candidates[g.rng.Intn(len(candidates))]
+		dept.ParentBiid = proto.String(parent.Biid)
+		dept.ParentCode = proto.String(parent.Code)
+		dept.ParentName = proto.String(parent.Name)
+	}
+
+	return dept
+}
+
+func (g *Generator) updateDepartment(previous *models.Department) *models.Department {
+	dept := proto.Clone(previous).(*models.Department)
+	now := g.tick()
+
+	dept.Version++
+	dept.TransactionFrom = timestamppb.New(now)
+
+	if g.rng.Float64() < g.scenario.ValidTimeChangeRatio {
+		// Valid-time change: the new version becomes valid some days later
+		validFrom := fromDate(previous.ValidFrom).AddDate(0, 0, 1+g.rng.Intn(30))
+		dept.ValidFrom = toDate(validFrom)
+	} else {
+		dept.Name = fmt.Sprintf("%s (rev %d)", baseName(previous.Name), dept.Version)
+		dept.ShortName = fmt.Sprintf("%s-r%d", baseName(previous.ShortName), dept.Version)
+	}
+
+	return dept
+}
+
+func (g *Generator) deleteDepartment(previous *models.Department) *models.Department {
+	dept := proto.Clone(previous).(*models.Department)
+	now := g.tick()
+
+	dept.Version++
+	// A department whose valid time starts in the future ends the day it starts
+	validTo := now
+	if validFrom := fromDate(dept.ValidFrom); validFrom.After(validTo) {
+		validTo = validFrom
+	}
+	dept.ValidTo = toDate(validTo)
+	dept.TransactionTo = timestamppb.New(now)
+	dept.TransactionToBy = generatorUserID
+	dept.TransactionToByName = generatorUserName
+	dept.TransactionToBySrv = generatorService
+	return dept
+}
+
+// pickLeafDepartment returns the index of a random department without children,
+// so deletes never leave orphans behind
+func (g *Generator) pickLeafDepartment(tenant *tenantState) int {
+	parents := make(map[string]bool)
+	for _, dept := range tenant.departments {
+		if dept.ParentBiid != nil {
+			parents[*dept.ParentBiid] = true
+		}
+	}
+
+	var leaves []int
+	for i, dept := range tenant.departments {
+		if !parents[dept.Biid] {
+			leaves = append(leaves, i)
+		}
+	}
+	return leaves[g.rng.Intn(len(leaves))]
+}
+
+// nextAggregationPatternRecords returns a pattern change, followed by its
+// department links when the pattern is created and preceded by their deletion
+// when it is deleted
+func (g *Generator) nextAggregationPatternRecords(tenant *tenantState) []*models.Record {
+	op := g.pickOperation(len(tenant.patterns), g.scenario.MaxAggregationPatternsPerTenant)
+	now := g.tick()
+
+	var records []*models.Record
+	var pattern *models.AggregationPattern
+	switch op {
+	case models.Record_CREATE:
+		tenant.nextID++
+		pattern = &models.AggregationPattern{
+			Id:                    g.newUUID(),
+			Biid:                  g.newUUID(),
+			TenantUid:             tenant.uid,
+			Name:                  fmt.Sprintf("Aggregation Pattern %d", tenant.nextID),
+			TransactionFrom:       timestamppb.New(now),
+			TransactionFromBy:     generatorUserID,
+			TransactionFromByName: generatorUserName,
+			TransactionFromBySrv:  generatorService,
+			Version:               1,
+		}
+		tenant.patterns = append(tenant.patterns, pattern)
+	case models.Record_UPDATE:
+		i := g.rng.Intn(len(tenant.patterns))
+		pattern = proto.Clone(tenant.patterns[i]).(*models.AggregationPattern)
+		pattern.Version++
+		pattern.Name = fmt.Sprintf("%s (rev %d)", baseName(pattern.Name), pattern.Version)
+		pattern.TransactionFrom = timestamppb.New(now)
+		tenant.patterns[i] = pattern
+	case models.Record_DELETE:
+		i := g.rng.Intn(len(tenant.patterns))
+		records = unlink(tenant, func(link *models.AggregationPatternDepartment) bool {
+			return link.AggregationPatternId == tenant.patterns[i].Id
+		})
+		pattern = proto.Clone(tenant.patterns[i]).(*models.AggregationPattern)
+		pattern.Version++
+		pattern.TransactionTo = timestamppb.New(now)
+		pattern.TransactionToBy = generatorUserID
+		pattern.TransactionToByName = generatorUserName
+		pattern.TransactionToBySrv = generatorService
+		tenant.patterns = append(tenant.patterns[:i], tenant.patterns[i+1:]...)
+	}
+
+	records = append(records, &models.Record{
+		OperationType: op,
+		Data:          &models.Record_AggregationPattern{AggregationPattern: pattern},
+	})
+
+	if op == models.Record_CREATE {
+		for _, i := range g.rng.Perm(len(tenant.departments)) {
+			if len(records) > g.scenario.DepartmentsPerPattern {
+				break
+			}
+			dept := tenant.departments[i]
+			link := &models.AggregationPatternDepartment{
+				AggregationPatternId: pattern.Id,
+				DepartmentBiid:       dept.Biid,
+				Path:                 departmentPath(tenant, dept),
+				TenantUid:            tenant.uid,
+			}
+			tenant.links = append(tenant.links, link)
+			records = append(records, &models.Record{
+				OperationType: models.Record_CREATE,
+				Data:          &models.Record_AggregationPatternDepartment{AggregationPatternDepartment: link},
+			})
+		}
+	}
+
+	return records
+}
+
+// unlink forgets the links of a tenant matching the filter and returns their DELETE records
+func unlink(tenant *tenantState, match func(*models.AggregationPatternDepartment) bool) []*models.Record {
+	var records []*models.Record
+	kept := tenant.links[:0]
+	for _, link := range tenant.links {
+		if !match(link) {
+			kept = append(kept, link)
+			continue
+		}
+		records = append(records, &models.Record{
+			OperationType: models.Record_DELETE,
+			Data:          &models.Record_AggregationPatternDepartment{AggregationPatternDepartment: link},
+		})
+	}
+	tenant.links = kept
+	return records
+}
+
+// tick advances the simulated clock and returns the new time
+func (g *Generator) tick() time.Time {
+	g.clock = g.clock.Add(g.scenario.TransactionInterval)
+	return g.clock
+}
+
+// newUUID returns a UUID drawn from the seeded random source
+func (g *Generator) newUUID() string {
+	id, _ := uuid.NewRandomFromReader(g.rng)
+	return id.String()
+}
+
+func findDepartment(tenant *tenantState, biid string) *models.Department {
+	for _, dept := range tenant.departments {
+		if dept.Biid == biid {
+			return dept
+		}
+	}
+	return nil
+}
+
+func departmentDepth(tenant *tenantState, dept *models.Department) int {
+	depth := 1
+	for dept.ParentBiid != nil {
+		parent := findDepartment(tenant, *dept.ParentBiid)
+		if parent == nil {
+			break
+		}
+		dept = parent
+		depth++
+	}
+	return depth
+}
+
+// departmentPath returns the slash separated BIID path from the root to dept
+func departmentPath(tenant *tenantState, dept *models.Department) string {
+	path := "/" + dept.Biid
+	for dept.ParentBiid != nil {
+		parent := findDepartment(tenant, *dept.ParentBiid)
+		if parent == nil {
+			break
+		}
+		path = "/" + parent.Biid + path
+		dept = parent
+	}
+	return path
+}
+
+// baseName strips a previous " (rev N)" or "-rN" suffix
+func baseName(name string) string {
+	return revisionSuffix.ReplaceAllString(name, "")
+}
+
+func toDate(t time.Time) *date.Date {
+	return &date.Date{Year: int32(t.Year()), Month: int32(t.Month()), Day: int32(t.Day())}
+}
+
+func fromDate(d *date.Date) time.Time {
+	return time.Date(int(d.Year), time.Month(d.Month), int(d.Day), 0, 0, 0, 0, time.UTC)
+}
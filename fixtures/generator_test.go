@@ -0,0 +1,153 @@
+package fixtures
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"kafka_test/models"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// generate returns the messages of the first requests of a scenario
+func generate(t *testing.T, scenario Scenario, requests int) [][]*models.ChangeDataMessage {
+	t.Helper()
+	generator, err := NewGenerator(scenario)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make([][]*models.ChangeDataMessage, requests)
+	for i := range result {
+		result[i] = generator.NextRequest()
+	}
+	return result
+}
+
+// churn is a scenario deleting often, so departments with links get deleted
+func churn() Scenario {
+	scenario := DefaultScenario()
+	scenario.MaxDepartmentsPerTenant = 8
+	scenario.OperationWeights = OperationWeights{Create: 3, Update: 2, Delete: 3}
+	scenario.AggregationPatternRatio = 0.4
+	return scenario
+}
+
+func TestSameSeedSameMessages(t *testing.T) {
+	first := generate(t, DefaultScenario(), 200)
+	second := generate(t, DefaultScenario(), 200)
+	for i := range first {
+		if len(first[i]) != len(second[i]) {
+			t.Fatalf("request %d has %d and %d messages", i, len(first[i]), len(second[i]))
+		}
+		for j := range first[i] {
+			if !proto.Equal(first[i][j], second[i][j]) {
+				t.Fatalf("message %d of request %d differs:\n%v\n%v", j+1, i, first[i][j], second[i][j])
+			}
+		}
+	}
+
+	other := DefaultScenario()
+	other.Seed = 2
+	if proto.Equal(first[0][0], generate(t, other, 1)[0][0]) {
+		t.Fatal("another seed produced the same first message")
+	}
+}
+
+func TestRequestParts(t *testing.T) {
+	scenario := DefaultScenario()
+	for i, messages := range generate(t, scenario, 200) {
+		records := 0
+		for j, msg := range messages {
+			if msg.RequestId != messages[0].RequestId || msg.TenantUid != messages[0].TenantUid {
+				t.Fatalf("request %d: part %d belongs to request %s of tenant %d", i, j+1, msg.RequestId, msg.TenantUid)
+			}
+			if msg.MessageNumber != uint32(j+1) || msg.TotalMessageCount != uint32(len(messages)) {
+				t.Fatalf("request %d: part %d numbered %d of %d, want %d of %d",
+					i, j+1, msg.MessageNumber, msg.TotalMessageCount, j+1, len(messages))
+			}
+			if len(msg.Records) == 0 || len(msg.Records) > scenario.RecordsPerMessage {
+				t.Fatalf("request %d: part %d has %d records", i, j+1, len(msg.Records))
+			}
+			records += len(msg.Records)
+		}
+		if records < scenario.RecordsPerRequest.Min {
+			t.Fatalf("request %d has %d records, want at least %d", i, records, scenario.RecordsPerRequest.Min)
+		}
+	}
+}
+
+func TestLinksFollowDepartmentsAndPatterns(t *testing.T) {
+	departments := make(map[string]uint32)
+	patterns := make(map[string]bool)
+	links := make(map[string]bool)
+	deletedLinks := 0
+
+	for i, messages := range generate(t, churn(), 1000) {
+		for _, msg := range messages {
+			for _, record := range msg.Records {
+				deleted := record.OperationType == models.Record_DELETE
+				switch data := record.Data.(type) {
+				case *models.Record_Department:
+					dept := data.Department
+					key := fmt.Sprintf("%d/%s", dept.TenantUid, dept.Biid)
+					if previous, ok := departments[key]; ok && dept.Version <= previous {
+						t.Fatalf("request %d: department %s version %d after %d", i, dept.Biid, dept.Version, previous)
+					}
+					if !deleted {
+						departments[key] = dept.Version
+						continue
+					}
+					delete(departments, key)
+					for link := range links {
+						if linkDepartment(link) == key {
+							t.Fatalf("request %d: department %s deleted with link %s left", i, dept.Biid, link)
+						}
+					}
+
+				case *models.Record_AggregationPattern:
+					pattern := data.AggregationPattern
+					key := fmt.Sprintf("%d/%s", pattern.TenantUid, pattern.Id)
+					patterns[key] = !deleted
+					if deleted {
+						for link := range links {
+							if linkPattern(link) == key {
+								t.Fatalf("request %d: pattern %s deleted with link %s left", i, pattern.Id, link)
+							}
+						}
+					}
+
+				case *models.Record_AggregationPatternDepartment:
+					link := data.AggregationPatternDepartment
+					key := fmt.Sprintf("%d/%s|%d/%s", link.TenantUid, link.AggregationPatternId, link.TenantUid, link.DepartmentBiid)
+					if deleted {
+						if !links[key] {
+							t.Fatalf("request %d: unknown link %s deleted", i, key)
+						}
+						delete(links, key)
+						deletedLinks++
+						continue
+					}
+					if _, ok := departments[linkDepartment(key)]; !ok || !patterns[linkPattern(key)] {
+						t.Fatalf("request %d: link %s created to a missing department or pattern", i, key)
+					}
+					links[key] = true
+				}
+			}
+		}
+	}
+	if deletedLinks == 0 {
+		t.Fatal("no link deleted, the scenario does not exercise deletes")
+	}
+}
+
+// linkPattern and linkDepartment return the pattern and department keys of a link key
+func linkPattern(key string) string {
+	pattern, _, _ := strings.Cut(key, "|")
+	return pattern
+}
+
+func linkDepartment(key string) string {
+	_, department, _ := strings.Cut(key, "|")
+	return department
+}
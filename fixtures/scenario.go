@@ -0,0 +1,119 @@
+package fixtures
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario holds the parameters of a generated CDC data set.
+// The same scenario and seed always produce the same sequence of messages.
+type Scenario struct {
+	// Seed for the random generator
+	Seed int64 `yaml:"seed"`
+	// Tenants lists the tenant UIDs to simulate
+	Tenants []uint64 `yaml:"tenants"`
+	// StartTime is the first transaction time, RFC3339
+	StartTime string `yaml:"start_time"`
+	// TransactionInterval is how far the simulated clock advances per record
+	TransactionInterval time.Duration `yaml:"transaction_interval"`
+
+	// MaxDepartmentsPerTenant caps the number of live departments per tenant
+	MaxDepartmentsPerTenant int `yaml:"max_departments_per_tenant"`
+	// MaxDepth caps the depth of department trees, roots have depth 1
+	MaxDepth int `yaml:"max_depth"`
+	// MaxAggregationPatternsPerTenant caps the number of live aggregation patterns per tenant
+	MaxAggregationPatternsPerTenant int `yaml:"max_aggregation_patterns_per_tenant"`
+	// DepartmentsPerPattern is how many departments are linked to a new aggregation pattern
+	DepartmentsPerPattern int `yaml:"departments_per_pattern"`
+
+	// RecordsPerRequest is the range of records generated per request
+	RecordsPerRequest IntRange `yaml:"records_per_request"`
+	// RecordsPerMessage is how many records fit in one ChangeDataMessage part
+	RecordsPerMessage int `yaml:"records_per_message"`
+
+	// OperationWeights sets the relative frequency of CREATE, UPDATE and DELETE
+	OperationWeights OperationWeights `yaml:"operation_weights"`
+	// AggregationPatternRatio is the fraction of records about aggregation patterns
+	AggregationPatternRatio float64 `yaml:"aggregation_pattern_ratio"`
+	// ValidTimeChangeRatio is the fraction of department updates that move valid_from
+	ValidTimeChangeRatio float64 `yaml:"valid_time_change_ratio"`
+}
+
+// IntRange is an inclusive integer range
+type IntRange struct {
+	Min int `yaml:"min"`
+	Max int `yaml:"max"`
+}
+
+// OperationWeights sets the relative frequency of each operation type
+type OperationWeights struct {
+	Create int `yaml:"create"`
+	Update int `yaml:"update"`
+	Delete int `yaml:"delete"`
+}
+
+// DefaultScenario returns the scenario used when no scenario file is configured
+func DefaultScenario() Scenario {
+	return Scenario{
+		Seed:                            1,
+		Tenants:                         []uint64{12345, 23456, 34567},
+		StartTime:                       "2024-01-01T00:00:00Z",
+		TransactionInterval:             time.Minute,
+		MaxDepartmentsPerTenant:         50,
+		MaxDepth:                        4,
+		MaxAggregationPatternsPerTenant: 5,
+		DepartmentsPerPattern:           3,
+		RecordsPerRequest:               IntRange{Min: 1, Max: 8},
+		RecordsPerMessage:               3,
+		OperationWeights:                OperationWeights{Create: 5, Update: 4, Delete: 1},
+		AggregationPatternRatio:         0.2,
+		ValidTimeChangeRatio:            0.3,
+	}
+}
+
+// LoadScenario reads a scenario YAML file on top of the default scenario
+func LoadScenario(path string) (Scenario, error) {
+	scenario := DefaultScenario()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return scenario, fmt.Errorf("failed to read scenario file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &scenario); err != nil {
+		return scenario, fmt.Errorf("failed to parse scenario file %s: %w", path, err)
+	}
+	if err := scenario.Validate(); err != nil {
+		return scenario, fmt.Errorf("invalid scenario file %s: %w", path, err)
+	}
+	return scenario, nil
+}
+
+// Validate checks that the scenario parameters are usable
+func (s Scenario) Validate() error {
+	if len(s.Tenants) == 0 {
+		return fmt.Errorf("at least one tenant is required")
+	}
+	if _, err := time.Parse(time.RFC3339, s.StartTime); err != nil {
+		return fmt.Errorf("invalid start_time: %w", err)
+	}
+	if s.TransactionInterval <= 0 {
+		return fmt.Errorf("transaction_interval must be positive")
+	}
+	if s.MaxDepartmentsPerTenant < 1 || s.MaxDepth < 1 {
+		return fmt.Errorf("max_departments_per_tenant and max_depth must be at least 1")
+	}
+	if s.RecordsPerRequest.Min < 1 || s.RecordsPerRequest.Max < s.RecordsPerRequest.Min {
+		return fmt.Errorf("records_per_request must satisfy 1 <= min <= max")
+	}
+	if s.RecordsPerMessage < 1 {
+		return fmt.Errorf("records_per_message must be at least 1")
+	}
+	weights := s.OperationWeights
+	if weights.Create < 0 || weights.Update < 0 || weights.Delete < 0 || weights.Create+weights.Update+weights.Delete == 0 {
+		return fmt.Errorf("operation_weights must be non-negative and not all zero")
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"text/tabwriter"
+
+	"kafka_test/admin"
+
+	"github.com/Shopify/sarama"
+)
+
+// GroupSummary is one row of the group list
+type GroupSummary struct {
+	Group        string `json:"group" yaml:"group"`
+	ProtocolType string `json:"protocol_type" yaml:"protocol_type"`
+	State        string `json:"state" yaml:"state"`
+	Members      int    `json:"members" yaml:"members"`
+}
+
+// GroupList is the result of listing consumer groups
+type GroupList struct {
+	Groups []GroupSummary `json:"groups" yaml:"groups"`
+}
+
+// Member is a member of a consumer group with its assigned partitions
+type Member struct {
+	MemberID   string             `json:"member_id" yaml:"member_id"`
+	InstanceID string             `json:"instance_id,omitempty" yaml:"instance_id,omitempty"`
+	ClientID   string             `json:"client_id" yaml:"client_id"`
+	Host       string             `json:"host" yaml:"host"`
+	Assignment map[string][]int32 `json:"assignment" yaml:"assignment"`
+}
+
+// PartitionLag is the committed offset and lag of a group on one partition.
+// CommittedOffset is -1 when the group has not committed on the partition, in
+// which case Lag counts from the earliest offset, where our consumers start.
+type PartitionLag struct {
+	Topic           string `json:"topic" yaml:"topic"`
+	Partition       int32  `json:"partition" yaml:"partition"`
+	CommittedOffset int64  `json:"committed_offset" yaml:"committed_offset"`
+	LogEndOffset    int64  `json:"log_end_offset" yaml:"log_end_offset"`
+	Lag             int64  `json:"lag" yaml:"lag"`
+	MemberID        string `json:"member_id,omitempty" yaml:"member_id,omitempty"`
+	ClientID        string `json:"client_id,omitempty" yaml:"client_id,omitempty"`
+	Host            string `json:"host,omitempty" yaml:"host,omitempty"`
+	// Error is set when the log end offset could not be read, LogEndOffset is then -1
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// GroupDescription describes the members and lag of one consumer group
+type GroupDescription struct {
+	Group        string         `json:"group" yaml:"group"`
+	State        string         `json:"state" yaml:"state"`
+	ProtocolType string         `json:"protocol_type" yaml:"protocol_type"`
+	Protocol     string         `json:"protocol" yaml:"protocol"`
+	Members      []Member       `json:"members" yaml:"members"`
+	Partitions   []PartitionLag `json:"partitions" yaml:"partitions"`
+	TotalLag     int64          `json:"total_lag" yaml:"total_lag"`
+	// Trend is only set in watch mode, from the second refresh on
+	Trend *Trend `json:"trend,omitempty" yaml:"trend,omitempty"`
+}
+
+// Description is the result of describing consumer groups
+type Description struct {
+	Groups []*GroupDescription `json:"groups" yaml:"groups"`
+}
+
+// List lists every consumer group with its state and member count, sorted by name
+func List(conn *admin.Conn) (*GroupList, error) {
+	groups, err := conn.Admin.ListConsumerGroups()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list consumer groups: %w", err)
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := &GroupList{Groups: make([]GroupSummary, 0, len(names))}
+	if len(names) == 0 {
+		return list, nil
+	}
+
+	descriptions, err := conn.Admin.DescribeConsumerGroups(names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe consumer groups: %w", err)
+	}
+	byName := make(map[string]*sarama.GroupDescription, len(descriptions))
+	for _, description := range descriptions {
+		byName[description.GroupId] = description
+	}
+
+	for _, name := range names {
+		summary := GroupSummary{Group: name, ProtocolType: groups[name]}
+		if description := byName[name]; description != nil {
+			summary.State = description.State
+			summary.Members = len(description.Members)
+		}
+		list.Groups = append(list.Groups, summary)
+	}
+	return list, nil
+}
+
+// Describe describes the members, committed offsets and lag of the given groups
+func Describe(conn *admin.Conn, groups []string) (*Description, error) {
+	sort.Strings(groups)
+	descriptions, err := conn.Admin.DescribeConsumerGroups(groups)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe consumer groups: %w", err)
+	}
+	sort.Slice(descriptions, func(i, j int) bool { return descriptions[i].GroupId < descriptions[j].GroupId })
+
+	result := &Description{Groups: make([]*GroupDescription, 0, len(descriptions))}
+	for _, description := range descriptions {
+		if description.Err != sarama.ErrNoError {
+			return nil, fmt.Errorf("failed to describe consumer group %s: %w", description.GroupId, description.Err)
+		}
+		group, err := describeGroup(conn, description)
+		if err != nil {
+			return nil, err
+		}
+		result.Groups = append(result.Groups, group)
+	}
+	return result, nil
+}
+
+func describeGroup(conn *admin.Conn, description *sarama.GroupDescription) (*GroupDescription, error) {
+	group := &GroupDescription{
+		Group:        description.GroupId,
+		State:        description.State,
+		ProtocolType: description.ProtocolType,
+		Protocol:     description.Protocol,
+	}
+
+	// Partitions to report: every assigned partition plus every committed one
+	type topicPartition struct {
+		topic     string
+		partition int32
+	}
+	owners := make(map[topicPartition]*Member)
+	committed := make(map[topicPartition]int64)
+
+	memberIDs := make([]string, 0, len(description.Members))
+	for id := range description.Members {
+		memberIDs = append(memberIDs, id)
+	}
+	sort.Strings(memberIDs)
+
+	for _, id := range memberIDs {
+		memberDescription := description.Members[id]
+		member := Member{
+			MemberID: memberDescription.MemberId,
+			ClientID: memberDescription.ClientId,
+			Host:     memberDescription.ClientHost,
+		}
+		if memberDescription.GroupInstanceId != nil {
+			member.InstanceID = *memberDescription.GroupInstanceId
+		}
+		if description.ProtocolType == "consumer" {
+			assignment, err := memberDescription.GetMemberAssignment()
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode assignment of member %s: %w", member.MemberID, err)
+			}
+			if assignment != nil {
+				member.Assignment = assignment.Topics
+			}
+		}
+		group.Members = append(group.Members, member)
+	}
+	for i := range group.Members {
+		for topic, partitions := range group.Members[i].Assignment {
+			for _, partition := range partitions {
+				owners[topicPartition{topic, partition}] = &group.Members[i]
+			}
+		}
+	}
+
+	offsets, err := conn.Admin.ListConsumerGroupOffsets(group.Group, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list offsets of consumer group %s: %w", group.Group, err)
+	}
+	for topic, blocks := range offsets.Blocks {
+		for partition, block := range blocks {
+			if block.Err != sarama.ErrNoError || block.Offset < 0 {
+				continue
+			}
+			committed[topicPartition{topic, partition}] = block.Offset
+		}
+	}
+
+	partitionsByTopic := make(map[string][]int32)
+	for key := range owners {
+		partitionsByTopic[key.topic] = append(partitionsByTopic[key.topic], key.partition)
+	}
+	for key := range committed {
+		if _, owned := owners[key]; !owned {
+			partitionsByTopic[key.topic] = append(partitionsByTopic[key.topic], key.partition)
+		}
+	}
+
+	topics := make([]string, 0, len(partitionsByTopic))
+	for topic := range partitionsByTopic {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	for _, topic := range topics {
+		partitions := partitionsByTopic[topic]
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+
+		earliest, latest, offsetErrs := conn.LogEndOffsets(topic, partitions)
+
+		for _, partition := range partitions {
+			key := topicPartition{topic, partition}
+			lag := PartitionLag{
+				Topic:           topic,
+				Partition:       partition,
+				CommittedOffset: -1,
+				LogEndOffset:    latest[partition],
+			}
+			start := earliest[partition]
+			if offset, ok := committed[key]; ok {
+				lag.CommittedOffset = offset
+				start = offset
+			}
+			if err := offsetErrs[partition]; err != nil {
+				lag.LogEndOffset = -1
+				lag.Error = err.Error()
+			} else {
+				lag.Lag = max(lag.LogEndOffset-start, 0)
+			}
+			if owner := owners[key]; owner != nil {
+				lag.MemberID, lag.ClientID, lag.Host = owner.MemberID, owner.ClientID, owner.Host
+			}
+			group.TotalLag += lag.Lag
+			group.Partitions = append(group.Partitions, lag)
+		}
+	}
+	return group, nil
+}
+
+// WriteTable prints the group list
+func (l *GroupList) WriteTable(tw *tabwriter.Writer) {
+	if len(l.Groups) == 0 {
+		fmt.Fprintln(tw, "No consumer groups found.")
+		return
+	}
+	admin.Row(tw, "GROUP", "STATE", "PROTOCOL TYPE", "MEMBERS")
+	for _, group := range l.Groups {
+		admin.Row(tw, group.Group, group.State, group.ProtocolType, group.Members)
+	}
+}
+
+// WriteTable prints each group with its members and per partition lag
+func (d *Description) WriteTable(tw *tabwriter.Writer) {
+	for i, group := range d.Groups {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "Group: %s  State: %s  Protocol: %s  Members: %d  Total lag: %d\n",
+			group.Group, group.State, group.Protocol, len(group.Members), group.TotalLag)
+		if group.Trend != nil {
+			fmt.Fprintf(tw, "Trend: %s\n", group.Trend)
+		}
+
+		if len(group.Partitions) == 0 {
+			fmt.Fprintln(tw, "No assigned or committed partitions.")
+			continue
+		}
+		admin.Row(tw, "TOPIC", "PARTITION", "COMMITTED", "LOG END", "LAG", "MEMBER", "CLIENT", "HOST")
+		for _, partition := range group.Partitions {
+			committed := fmt.Sprint(partition.CommittedOffset)
+			if partition.CommittedOffset < 0 {
+				committed = "-"
+			}
+			logEnd, lag := fmt.Sprint(partition.LogEndOffset), fmt.Sprint(partition.Lag)
+			if partition.Error != "" {
+				logEnd, lag = "-", "-"
+			}
+			admin.Row(tw, partition.Topic, partition.Partition, committed, logEnd, lag,
+				orDash(partition.MemberID), orDash(partition.ClientID), orDash(partition.Host))
+		}
+		for _, partition := range group.Partitions {
+			if partition.Error != "" {
+				fmt.Fprintf(tw, "Lag of %s/%d unknown: %s\n", partition.Topic, partition.Partition, partition.Error)
+			}
+		}
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
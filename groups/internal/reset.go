@@ -0,0 +1,365 @@
+package internal
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"kafka_test/admin"
+
+	"github.com/Shopify/sarama"
+)
+
+// ErrGroupActive is returned when offsets of a group with members would be reset
+var ErrGroupActive = errors.New("consumer group has active members")
+
+type targetKind int
+
+const (
+	targetEarliest targetKind = iota
+	targetLatest
+	targetOffset
+	targetShiftBy
+	targetTime
+	targetFile
+)
+
+// Target selects the new offset of each partition
+type Target struct {
+	kind    targetKind
+	offset  int64
+	time    time.Time
+	offsets map[string]map[int32]int64
+}
+
+// ToEarliest resets to the earliest available offset
+func ToEarliest() Target { return Target{kind: targetEarliest} }
+
+// ToLatest resets to the log end offset, skipping every pending message
+func ToLatest() Target { return Target{kind: targetLatest} }
+
+// ToOffset resets every selected partition to the same offset
+func ToOffset(offset int64) Target { return Target{kind: targetOffset, offset: offset} }
+
+// ShiftBy moves the committed offset by n, which may be negative
+func ShiftBy(n int64) Target { return Target{kind: targetShiftBy, offset: n} }
+
+// ToTime resets to the first offset whose timestamp is at or after t
+func ToTime(t time.Time) Target { return Target{kind: targetTime, time: t} }
+
+// FromOffsets resets to per partition offsets, e.g. loaded with LoadOffsetsFile
+func FromOffsets(offsets map[string]map[int32]int64) Target {
+	return Target{kind: targetFile, offsets: offsets}
+}
+
+// OffsetChange is the planned reset of one partition.
+// Current is -1 when the group has no committed offset on the partition.
+// Error is set when the offsets of the partition could not be read; such a
+// partition keeps its offset and is left out of the reset.
+type OffsetChange struct {
+	Topic     string `json:"topic" yaml:"topic"`
+	Partition int32  `json:"partition" yaml:"partition"`
+	Earliest  int64  `json:"earliest" yaml:"earliest"`
+	Latest    int64  `json:"latest" yaml:"latest"`
+	Current   int64  `json:"current" yaml:"current"`
+	Target    int64  `json:"target" yaml:"target"`
+	Error     string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// ResetPlan lists the offset changes of a reset before or after it is executed
+type ResetPlan struct {
+	Group    string         `json:"group" yaml:"group"`
+	State    string         `json:"state" yaml:"state"`
+	Members  int            `json:"members" yaml:"members"`
+	Executed bool           `json:"executed" yaml:"executed"`
+	Changes  []OffsetChange `json:"changes" yaml:"changes"`
+}
+
+// PlanReset computes the new offsets of the group on the given partitions.
+// A topic mapped to no partitions selects all of its partitions, or those in
+// the offsets of a FromOffsets target; with such a target a nil topics map
+// selects every partition in the offsets.
+// Targets outside the available offsets are clamped to earliest and latest.
+func PlanReset(conn *admin.Conn, group string, topics map[string][]int32, target Target) (*ResetPlan, error) {
+	if target.kind == targetFile && topics == nil {
+		topics = make(map[string][]int32, len(target.offsets))
+		for topic, partitions := range target.offsets {
+			for partition := range partitions {
+				topics[topic] = append(topics[topic], partition)
+			}
+		}
+	}
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("no topics selected")
+	}
+
+	plan := &ResetPlan{Group: group}
+	if err := plan.refreshState(conn); err != nil {
+		return nil, err
+	}
+
+	offsets, err := conn.Admin.ListConsumerGroupOffsets(group, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list offsets of consumer group %s: %w", group, err)
+	}
+
+	names := make([]string, 0, len(topics))
+	for topic := range topics {
+		names = append(names, topic)
+	}
+	sort.Strings(names)
+
+	for _, topic := range names {
+		partitions := topics[topic]
+		if len(partitions) == 0 && target.kind == targetFile {
+			for partition := range target.offsets[topic] {
+				partitions = append(partitions, partition)
+			}
+		}
+		if len(partitions) == 0 {
+			if partitions, err = conn.Client.Partitions(topic); err != nil {
+				return nil, fmt.Errorf("failed to get partitions of topic %s: %w", topic, err)
+			}
+		}
+		partitions = append([]int32(nil), partitions...)
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+
+		earliest, latest, offsetErrs := conn.LogEndOffsets(topic, partitions)
+
+		for _, partition := range partitions {
+			change := OffsetChange{
+				Topic:     topic,
+				Partition: partition,
+				Earliest:  earliest[partition],
+				Latest:    latest[partition],
+				Current:   -1,
+			}
+			if block := offsets.GetBlock(topic, partition); block != nil && block.Err == sarama.ErrNoError && block.Offset >= 0 {
+				change.Current = block.Offset
+			}
+			if err := offsetErrs[partition]; err != nil {
+				change.Earliest, change.Latest, change.Target = -1, -1, change.Current
+				change.Error = err.Error()
+				plan.Changes = append(plan.Changes, change)
+				continue
+			}
+
+			change.Target, err = resolveTarget(conn, target, change)
+			if err != nil {
+				return nil, err
+			}
+			change.Target = min(max(change.Target, change.Earliest), change.Latest)
+			plan.Changes = append(plan.Changes, change)
+		}
+	}
+	return plan, nil
+}
+
+// resolveTarget returns the unclamped target offset of one partition
+func resolveTarget(conn *admin.Conn, target Target, change OffsetChange) (int64, error) {
+	switch target.kind {
+	case targetEarliest:
+		return change.Earliest, nil
+	case targetLatest:
+		return change.Latest, nil
+	case targetOffset:
+		return target.offset, nil
+	case targetShiftBy:
+		base := change.Current
+		if base < 0 {
+			base = change.Earliest
+		}
+		return base + target.offset, nil
+	case targetTime:
+		offset, err := conn.Client.GetOffset(change.Topic, change.Partition, target.time.UnixMilli())
+		if err != nil {
+			return 0, fmt.Errorf("failed to get offset of %s/%d at %s: %w", change.Topic, change.Partition, target.time.Format(time.RFC3339), err)
+		}
+		if offset < 0 {
+			// No message at or after the timestamp
+			return change.Latest, nil
+		}
+		return offset, nil
+	case targetFile:
+		offset, ok := target.offsets[change.Topic][change.Partition]
+		if !ok {
+			return 0, fmt.Errorf("no offset for %s/%d in offsets file", change.Topic, change.Partition)
+		}
+		return offset, nil
+	default:
+		return 0, fmt.Errorf("unknown reset target")
+	}
+}
+
+// refreshState reads the current state and member count of the group
+func (p *ResetPlan) refreshState(conn *admin.Conn) error {
+	descriptions, err := conn.Admin.DescribeConsumerGroups([]string{p.Group})
+	if err != nil {
+		return fmt.Errorf("failed to describe consumer group %s: %w", p.Group, err)
+	}
+	for _, description := range descriptions {
+		if description.GroupId == p.Group {
+			p.State = description.State
+			p.Members = len(description.Members)
+		}
+	}
+	return nil
+}
+
+// ExecuteReset commits the planned offsets. It refuses to run while the group
+// has members, since they would overwrite the offsets with their own commits.
+func ExecuteReset(conn *admin.Conn, plan *ResetPlan) error {
+	if err := plan.refreshState(conn); err != nil {
+		return err
+	}
+	if plan.Members > 0 {
+		return fmt.Errorf("%w: %s is %s with %d member(s), stop its consumers first", ErrGroupActive, plan.Group, plan.State, plan.Members)
+	}
+
+	offsetManager, err := sarama.NewOffsetManagerFromClient(plan.Group, conn.Client)
+	if err != nil {
+		return fmt.Errorf("failed to create offset manager: %w", err)
+	}
+	defer offsetManager.Close()
+
+	for _, change := range plan.Changes {
+		if change.Error != "" {
+			continue
+		}
+		partitionManager, err := offsetManager.ManagePartition(change.Topic, change.Partition)
+		if err != nil {
+			return fmt.Errorf("failed to manage partition %s/%d: %w", change.Topic, change.Partition, err)
+		}
+		// MarkOffset only moves forward and ResetOffset only moves backward
+		partitionManager.MarkOffset(change.Target, "")
+		partitionManager.ResetOffset(change.Target, "")
+		defer partitionManager.AsyncClose()
+	}
+	offsetManager.Commit()
+
+	// Commit reports no errors, so read the offsets back
+	offsets, err := conn.Admin.ListConsumerGroupOffsets(plan.Group, nil)
+	if err != nil {
+		return fmt.Errorf("failed to verify offsets of consumer group %s: %w", plan.Group, err)
+	}
+	for _, change := range plan.Changes {
+		if change.Error != "" {
+			continue
+		}
+		block := offsets.GetBlock(change.Topic, change.Partition)
+		if block == nil || block.Offset != change.Target {
+			return fmt.Errorf("offset of %s/%d was not reset to %d", change.Topic, change.Partition, change.Target)
+		}
+	}
+	plan.Executed = true
+	return nil
+}
+
+// LoadOffsetsFile reads per partition offsets from a CSV file of
+// "topic,partition,offset" lines, as written by WriteCSV, or from a
+// cdc export checkpoint.json
+func LoadOffsetsFile(path string) (map[string]map[int32]int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open offsets file: %w", err)
+	}
+	defer file.Close()
+
+	offsets := make(map[string]map[int32]int64)
+	if filepath.Ext(path) == ".json" {
+		var checkpoint struct {
+			Topic   string          `json:"topic"`
+			Offsets map[int32]int64 `json:"offsets"`
+		}
+		if err := json.NewDecoder(file).Decode(&checkpoint); err != nil {
+			return nil, fmt.Errorf("failed to parse offsets file %s: %w", path, err)
+		}
+		if checkpoint.Topic == "" {
+			return nil, fmt.Errorf("offsets file %s has no topic", path)
+		}
+		offsets[checkpoint.Topic] = checkpoint.Offsets
+		return offsets, nil
+	}
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 3
+	reader.Comment = '#'
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse offsets file %s: %w", path, err)
+		}
+		partition, err := strconv.ParseInt(record[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("offsets file %s line %d: invalid partition %q", path, line, record[1])
+		}
+		offset, err := strconv.ParseInt(record[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("offsets file %s line %d: invalid offset %q", path, line, record[2])
+		}
+		if offsets[record[0]] == nil {
+			offsets[record[0]] = make(map[int32]int64)
+		}
+		offsets[record[0]][int32(partition)] = offset
+	}
+	return offsets, nil
+}
+
+// WriteCSV writes the target offsets in the format read by LoadOffsetsFile
+func (p *ResetPlan) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	for _, change := range p.Changes {
+		if change.Error != "" {
+			continue
+		}
+		if err := writer.Write([]string{
+			change.Topic,
+			strconv.FormatInt(int64(change.Partition), 10),
+			strconv.FormatInt(change.Target, 10),
+		}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteTable prints the offsets of each partition before and after the reset
+func (p *ResetPlan) WriteTable(tw *tabwriter.Writer) {
+	status := "DRY RUN"
+	if p.Executed {
+		status = "EXECUTED"
+	}
+	fmt.Fprintf(tw, "Group: %s  State: %s  Members: %d  %s\n", p.Group, p.State, p.Members, status)
+
+	admin.Row(tw, "TOPIC", "PARTITION", "EARLIEST", "LATEST", "CURRENT", "NEW", "CHANGE", "NEW LAG")
+	for _, change := range p.Changes {
+		current, delta := "-", "-"
+		if change.Current >= 0 {
+			current = fmt.Sprint(change.Current)
+			delta = fmt.Sprintf("%+d", change.Target-change.Current)
+		}
+		if change.Error != "" {
+			admin.Row(tw, change.Topic, change.Partition, "-", "-", current, "-", "-", "-")
+			continue
+		}
+		admin.Row(tw, change.Topic, change.Partition, change.Earliest, change.Latest, current, change.Target, delta,
+			change.Latest-change.Target)
+	}
+	for _, change := range p.Changes {
+		if change.Error != "" {
+			fmt.Fprintf(tw, "Not resetting %s/%d: %s\n", change.Topic, change.Partition, change.Error)
+		}
+	}
+}
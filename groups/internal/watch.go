@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"kafka_test/admin"
+)
+
+// Trend compares the lag of a group with the previous refresh
+type Trend struct {
+	Interval string `json:"interval" yaml:"interval"`
+	// LagDelta is the change of the total lag since the previous refresh
+	LagDelta int64 `json:"lag_delta" yaml:"lag_delta"`
+	// ConsumeRate and ProduceRate are in messages per second
+	ConsumeRate float64 `json:"consume_rate" yaml:"consume_rate"`
+	ProduceRate float64 `json:"produce_rate" yaml:"produce_rate"`
+	// ETA is the estimated time to catch up at the current rates
+	ETA string `json:"eta" yaml:"eta"`
+}
+
+// String returns a one-line description of the trend
+func (t *Trend) String() string {
+	return fmt.Sprintf("lag %+d over %s, consuming %.1f msg/s, producing %.1f msg/s, ETA %s",
+		t.LagDelta, t.Interval, t.ConsumeRate, t.ProduceRate, t.ETA)
+}
+
+// newTrend computes the trend of a group between two refreshes
+func newTrend(previous, current *GroupDescription, elapsed time.Duration) *Trend {
+	type key struct {
+		topic     string
+		partition int32
+	}
+	before := make(map[key]PartitionLag, len(previous.Partitions))
+	for _, partition := range previous.Partitions {
+		before[key{partition.Topic, partition.Partition}] = partition
+	}
+
+	var consumed, produced int64
+	for _, partition := range current.Partitions {
+		old, ok := before[key{partition.Topic, partition.Partition}]
+		if !ok || partition.Error != "" || old.Error != "" {
+			continue
+		}
+		produced += partition.LogEndOffset - old.LogEndOffset
+		if partition.CommittedOffset >= 0 && old.CommittedOffset >= 0 {
+			consumed += partition.CommittedOffset - old.CommittedOffset
+		}
+	}
+
+	seconds := elapsed.Seconds()
+	trend := &Trend{
+		Interval:    elapsed.Round(time.Second).String(),
+		LagDelta:    current.TotalLag - previous.TotalLag,
+		ConsumeRate: float64(consumed) / seconds,
+		ProduceRate: float64(produced) / seconds,
+	}
+
+	net := trend.ConsumeRate - trend.ProduceRate
+	switch {
+	case current.TotalLag == 0:
+		trend.ETA = "caught up"
+	case net > 0:
+		trend.ETA = time.Duration(float64(current.TotalLag) / net * float64(time.Second)).Round(time.Second).String()
+	case consumed == 0:
+		trend.ETA = "stalled"
+	default:
+		trend.ETA = "not catching up"
+	}
+	return trend
+}
+
+// Watch describes the groups every interval until ctx is cancelled, calling
+// report with each result. From the second refresh on each group has a Trend.
+func Watch(ctx context.Context, conn *admin.Conn, groups []string, interval time.Duration, report func(*Description) error) error {
+	previous := make(map[string]*GroupDescription)
+	var previousAt time.Time
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		description, err := Describe(conn, groups)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+
+		for _, group := range description.Groups {
+			if old, ok := previous[group.Group]; ok {
+				group.Trend = newTrend(old, group, now.Sub(previousAt))
+			}
+			previous[group.Group] = group
+		}
+		previousAt = now
+
+		if err := report(description); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"kafka_test/admin"
+	"kafka_test/config"
+	"kafka_test/groups/internal"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: groups <command> [flags]
+
+Commands:
+  list           List consumer groups with their state and member count
+  describe       Show members, committed offsets and lag of consumer groups
+  reset-offsets  Move the committed offsets of a consumer group
+
+Run "groups <command> -h" for command flags.
+`)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	// Handle graceful shutdown
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	var err error
+	switch os.Args[1] {
+	case "list":
+		err = runList(os.Args[2:])
+	case "describe":
+		err = runDescribe(ctx, os.Args[2:])
+	case "reset-offsets":
+		err = runResetOffsets(os.Args[2:])
+	case "-h", "--help", "help":
+		usage()
+		return
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("groups %s failed: %v", os.Args[1], err)
+	}
+}
+
+// runList prints every consumer group
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	format := fs.String("format", admin.FormatTable, "output format: table, json or yaml")
+	fs.Parse(args)
+
+	if err := admin.ValidateFormat(*format); err != nil {
+		return err
+	}
+
+	conn, err := admin.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	list, err := internal.List(conn)
+	if err != nil {
+		return err
+	}
+	return admin.Write(os.Stdout, *format, list)
+}
+
+// runDescribe prints the members and lag of the given groups, once or repeatedly
+func runDescribe(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("describe", flag.ExitOnError)
+	format := fs.String("format", admin.FormatTable, "output format: table, json or yaml")
+	watch := fs.Bool("watch", false, "refresh until interrupted, showing the lag trend and time to catch up")
+	interval := fs.Duration("interval", 5*time.Second, "refresh interval in watch mode")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: groups describe [flags] [group...]\n\nWithout groups, describes KAFKA_GROUP_ID and KAFKA_CHANGE_DATA_GROUP_ID.\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if err := admin.ValidateFormat(*format); err != nil {
+		return err
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("-interval must be positive")
+	}
+
+	groups := fs.Args()
+	if len(groups) == 0 {
+		groups = []string{config.GetGroupID(), config.GetChangeDataGroupID()}
+	}
+
+	conn, err := admin.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if !*watch {
+		description, err := internal.Describe(conn, groups)
+		if err != nil {
+			return err
+		}
+		return admin.Write(os.Stdout, *format, description)
+	}
+
+	return internal.Watch(ctx, conn, groups, *interval, func(description *internal.Description) error {
+		if *format == admin.FormatTable {
+			fmt.Printf("--- %s\n", time.Now().Format(time.RFC3339))
+		}
+		return admin.Write(os.Stdout, *format, description)
+	})
+}
+
+// runResetOffsets plans a reset of the committed offsets of a group and executes it with -execute
+func runResetOffsets(args []string) error {
+	fs := flag.NewFlagSet("reset-offsets", flag.ExitOnError)
+	group := fs.String("group", "", "consumer group to reset (required)")
+	topic := fs.String("topic", "", "topic to reset, required unless -from-file is used")
+	partitionsFlag := fs.String("partitions", "", "comma-separated partitions of -topic (default all)")
+	toEarliest := fs.Bool("to-earliest", false, "reset to the earliest offset")
+	toLatest := fs.Bool("to-latest", false, "reset to the log end offset")
+	toOffset := fs.Int64("to-offset", -1, "reset to this offset")
+	shiftBy := fs.Int64("shift-by", 0, "move the committed offset by N, negative to re-consume")
+	toDatetime := fs.String("to-datetime", "", "reset to the first offset at or after this RFC3339 time")
+	fromFile := fs.String("from-file", "", "reset to offsets from a topic,partition,offset CSV file or a cdc export checkpoint.json")
+	execute := fs.Bool("execute", false, "commit the new offsets; without it only the plan is printed")
+	export := fs.String("export", "", "also write the new offsets as CSV to this file, for use with -from-file")
+	format := fs.String("format", admin.FormatTable, "output format: table, json or yaml")
+	fs.Parse(args)
+
+	if err := admin.ValidateFormat(*format); err != nil {
+		return err
+	}
+	if *group == "" {
+		return fmt.Errorf("-group is required")
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var targets []internal.Target
+	if *toEarliest {
+		targets = append(targets, internal.ToEarliest())
+	}
+	if *toLatest {
+		targets = append(targets, internal.ToLatest())
+	}
+	if set["to-offset"] {
+		if *toOffset < 0 {
+			return fmt.Errorf("-to-offset must not be negative")
+		}
+		targets = append(targets, internal.ToOffset(*toOffset))
+	}
+	if set["shift-by"] {
+		targets = append(targets, internal.ShiftBy(*shiftBy))
+	}
+	if *toDatetime != "" {
+		at, err := time.Parse(time.RFC3339, *toDatetime)
+		if err != nil {
+			return fmt.Errorf("invalid -to-datetime: %w", err)
+		}
+		targets = append(targets, internal.ToTime(at))
+	}
+	if *fromFile != "" {
+		offsets, err := internal.LoadOffsetsFile(*fromFile)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, internal.FromOffsets(offsets))
+	}
+	if len(targets) != 1 {
+		return fmt.Errorf("exactly one of -to-earliest, -to-latest, -to-offset, -shift-by, -to-datetime or -from-file is required")
+	}
+
+	var topics map[string][]int32
+	if *topic != "" {
+		partitions, err := admin.ParsePartitions(*partitionsFlag)
+		if err != nil {
+			return err
+		}
+		topics = map[string][]int32{*topic: partitions}
+	} else if *fromFile == "" {
+		return fmt.Errorf("-topic is required")
+	}
+
+	conn, err := admin.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	plan, err := internal.PlanReset(conn, *group, topics, targets[0])
+	if err != nil {
+		return err
+	}
+
+	if *export != "" {
+		file, err := os.Create(*export)
+		if err != nil {
+			return fmt.Errorf("failed to create export file: %w", err)
+		}
+		defer file.Close()
+		if err := plan.WriteCSV(file); err != nil {
+			return fmt.Errorf("failed to write export file: %w", err)
+		}
+	}
+
+	if *execute {
+		if err := internal.ExecuteReset(conn, plan); err != nil {
+			return err
+		}
+	} else if plan.Members > 0 {
+		log.Printf("Warning: group %s has %d active member(s); -execute will refuse to run until they stop", plan.Group, plan.Members)
+	}
+
+	if err := admin.Write(os.Stdout, *format, plan); err != nil {
+		return err
+	}
+	if !*execute && *format == admin.FormatTable {
+		fmt.Println("Dry run: pass -execute to commit the new offsets.")
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"kafka_test/admin"
+	"kafka_test/config"
+
+	"github.com/Shopify/sarama"
+)
+
+// Check results
+const (
+	StatusOK      = "ok"
+	StatusWarn    = "warn"
+	StatusFail    = "fail"
+	StatusSkipped = "skipped"
+)
+
+// Overall cluster states
+const (
+	Healthy   = "healthy"
+	Degraded  = "degraded"
+	Unhealthy = "unhealthy"
+)
+
+// Check is the result of one health check
+type Check struct {
+	Name     string `json:"name" yaml:"name"`
+	Status   string `json:"status" yaml:"status"`
+	Message  string `json:"message" yaml:"message"`
+	Duration string `json:"duration,omitempty" yaml:"duration,omitempty"`
+}
+
+// Report is the result of every health check. Status is unhealthy when a
+// check failed and degraded when a check only warned.
+type Report struct {
+	Status     string    `json:"status" yaml:"status"`
+	CheckedAt  time.Time `json:"checked_at" yaml:"checked_at"`
+	Brokers    []string  `json:"brokers" yaml:"brokers"`
+	Controller int32     `json:"controller" yaml:"controller"`
+	Checks     []Check   `json:"checks" yaml:"checks"`
+}
+
+// Options selects what the health check requires
+type Options struct {
+	// Timeout bounds dialing and every request to the brokers
+	Timeout time.Duration
+	// SlowMetadata is the metadata refresh time above which the check warns
+	SlowMetadata time.Duration
+	// Topics and Groups must exist
+	Topics []string
+	Groups []string
+}
+
+// Run checks the cluster and returns a report, an unreachable cluster is
+// reported as unhealthy rather than as an error
+func Run(opts Options) *Report {
+	report := &Report{CheckedAt: time.Now().UTC(), Brokers: config.GetBrokers(), Controller: -1}
+
+	var conn *admin.Conn
+	report.run("connect", func() (string, string) {
+		var err error
+		if conn, err = admin.ConnectWith(clientConfig(opts.Timeout)); err != nil {
+			return StatusFail, fmt.Sprintf("%v; %s", err, probeBootstrap(report.Brokers, opts.Timeout))
+		}
+		return StatusOK, fmt.Sprintf("connected to %s", strings.Join(report.Brokers, ","))
+	})
+	if conn == nil {
+		for _, name := range []string{"brokers", "controller", "metadata", "partitions", "topics", "groups"} {
+			report.Checks = append(report.Checks, Check{Name: name, Status: StatusSkipped, Message: "not connected"})
+		}
+		report.finish()
+		return report
+	}
+	defer conn.Close()
+
+	report.run("brokers", func() (string, string) { return checkBrokers(conn, opts.Timeout) })
+	report.run("controller", func() (string, string) {
+		controller, err := conn.Client.Controller()
+		if err != nil {
+			return StatusFail, fmt.Sprintf("no controller: %v", err)
+		}
+		report.Controller = controller.ID()
+		return StatusOK, fmt.Sprintf("broker %d (%s)", controller.ID(), controller.Addr())
+	})
+	report.run("metadata", func() (string, string) {
+		start := time.Now()
+		if err := conn.Client.RefreshMetadata(); err != nil {
+			return StatusFail, fmt.Sprintf("failed to refresh metadata: %v", err)
+		}
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if opts.SlowMetadata > 0 && elapsed > opts.SlowMetadata {
+			return StatusWarn, fmt.Sprintf("metadata refresh took %s, more than %s", elapsed, opts.SlowMetadata)
+		}
+		return StatusOK, fmt.Sprintf("metadata refreshed in %s", elapsed)
+	})
+	report.run("partitions", func() (string, string) { return checkPartitions(conn) })
+	report.run("topics", func() (string, string) { return checkTopics(conn, opts.Topics) })
+	report.run("groups", func() (string, string) { return checkGroups(conn, opts.Groups) })
+
+	report.finish()
+	return report
+}
+
+// clientConfig returns the shared config with short timeouts and no retries,
+// so an unreachable cluster is reported quickly
+func clientConfig(timeout time.Duration) *sarama.Config {
+	cfg := config.GetProducerConfig()
+	cfg.Net.DialTimeout = timeout
+	cfg.Net.ReadTimeout = timeout
+	cfg.Net.WriteTimeout = timeout
+	cfg.Metadata.Retry.Max = 0
+	cfg.Admin.Timeout = timeout
+	return cfg
+}
+
+func (r *Report) run(name string, check func() (status, message string)) {
+	start := time.Now()
+	status, message := check()
+	r.Checks = append(r.Checks, Check{
+		Name:     name,
+		Status:   status,
+		Message:  message,
+		Duration: time.Since(start).Round(time.Millisecond).String(),
+	})
+}
+
+func (r *Report) finish() {
+	r.Status = Healthy
+	for _, check := range r.Checks {
+		switch check.Status {
+		case StatusFail:
+			r.Status = Unhealthy
+			return
+		case StatusWarn:
+			r.Status = Degraded
+		}
+	}
+}
+
+// probe connects to one broker and sends a request, which fails when the
+// broker is unreachable or rejects the credentials
+func probe(addr string, cfg *sarama.Config) error {
+	broker := sarama.NewBroker(addr)
+	if err := broker.Open(cfg); err != nil {
+		return err
+	}
+	defer broker.Close()
+	if _, err := broker.ApiVersions(&sarama.ApiVersionsRequest{}); err != nil {
+		return err
+	}
+	return nil
+}
+
+// describeProbeError tells authentication failures apart from network errors
+func describeProbeError(addr string, err error) string {
+	if errors.Is(err, sarama.ErrSASLAuthenticationFailed) {
+		return fmt.Sprintf("%s: authentication failed, check KAFKA_USERNAME, KAFKA_PASSWORD and KAFKA_SASL_MECHANISM", addr)
+	}
+	return fmt.Sprintf("%s: %v", addr, err)
+}
+
+// probeBootstrap explains why the bootstrap brokers could not be used
+func probeBootstrap(addrs []string, timeout time.Duration) string {
+	cfg := clientConfig(timeout)
+	var problems []string
+	for _, addr := range addrs {
+		if err := probe(addr, cfg); err != nil {
+			problems = append(problems, describeProbeError(addr, err))
+		}
+	}
+	if len(problems) == 0 {
+		return "the bootstrap brokers answer, but metadata could not be fetched"
+	}
+	return strings.Join(problems, "; ")
+}
+
+// checkBrokers connects to every broker advertised in the metadata, which is
+// where clients go after bootstrapping
+func checkBrokers(conn *admin.Conn, timeout time.Duration) (string, string) {
+	brokers := conn.Client.Brokers()
+	if len(brokers) == 0 {
+		return StatusFail, "the metadata lists no brokers"
+	}
+	sort.Slice(brokers, func(i, j int) bool { return brokers[i].ID() < brokers[j].ID() })
+
+	cfg := clientConfig(timeout)
+	var problems []string
+	for _, broker := range brokers {
+		if err := probe(broker.Addr(), cfg); err != nil {
+			problems = append(problems, fmt.Sprintf("broker %d %s", broker.ID(), describeProbeError(broker.Addr(), err)))
+		}
+	}
+	switch {
+	case len(problems) == len(brokers):
+		return StatusFail, strings.Join(problems, "; ")
+	case len(problems) > 0:
+		return StatusWarn, fmt.Sprintf("%d of %d brokers unreachable: %s", len(problems), len(brokers), strings.Join(problems, "; "))
+	}
+	return StatusOK, fmt.Sprintf("%d broker(s) reachable", len(brokers))
+}
+
+// checkPartitions fails on partitions without a leader and warns on under-replicated ones
+func checkPartitions(conn *admin.Conn) (string, string) {
+	names, err := conn.Client.Topics()
+	if err != nil {
+		return StatusFail, fmt.Sprintf("failed to list topics: %v", err)
+	}
+	if len(names) == 0 {
+		return StatusOK, "no topics"
+	}
+	metadata, err := conn.Admin.DescribeTopics(names)
+	if err != nil {
+		return StatusFail, fmt.Sprintf("failed to describe topics: %v", err)
+	}
+
+	var offline, underReplicated []string
+	total := 0
+	for _, topic := range metadata {
+		for _, partition := range topic.Partitions {
+			total++
+			name := fmt.Sprintf("%s/%d", topic.Name, partition.ID)
+			switch {
+			case partition.Leader < 0 || partition.Err == sarama.ErrLeaderNotAvailable:
+				offline = append(offline, name)
+			case len(partition.Isr) < len(partition.Replicas):
+				underReplicated = append(underReplicated, name)
+			}
+		}
+	}
+	sort.Strings(offline)
+	sort.Strings(underReplicated)
+
+	switch {
+	case len(offline) > 0:
+		return StatusFail, fmt.Sprintf("%d offline partition(s): %s", len(offline), strings.Join(offline, ", "))
+	case len(underReplicated) > 0:
+		return StatusWarn, fmt.Sprintf("%d under-replicated partition(s): %s", len(underReplicated), strings.Join(underReplicated, ", "))
+	}
+	return StatusOK, fmt.Sprintf("%d partition(s) in %d topic(s) online and fully replicated", total, len(metadata))
+}
+
+// checkTopics fails when a required topic does not exist
+func checkTopics(conn *admin.Conn, required []string) (string, string) {
+	if len(required) == 0 {
+		return StatusSkipped, "no required topics"
+	}
+	topics, err := conn.Client.Topics()
+	if err != nil {
+		return StatusFail, fmt.Sprintf("failed to list topics: %v", err)
+	}
+	var missing []string
+	for _, name := range required {
+		if !slices.Contains(topics, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return StatusFail, fmt.Sprintf("missing topic(s): %s", strings.Join(missing, ", "))
+	}
+	return StatusOK, fmt.Sprintf("found %s", strings.Join(required, ", "))
+}
+
+// checkGroups fails when a required consumer group does not exist
+func checkGroups(conn *admin.Conn, required []string) (string, string) {
+	if len(required) == 0 {
+		return StatusSkipped, "no required groups"
+	}
+	groups, err := conn.Admin.ListConsumerGroups()
+	if err != nil {
+		return StatusFail, fmt.Sprintf("failed to list consumer groups: %v", err)
+	}
+	var missing []string
+	for _, name := range required {
+		if _, ok := groups[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return StatusFail, fmt.Sprintf("missing consumer group(s): %s", strings.Join(missing, ", "))
+	}
+	return StatusOK, fmt.Sprintf("found %s", strings.Join(required, ", "))
+}
+
+// WriteTable prints one line per check
+func (r *Report) WriteTable(tw *tabwriter.Writer) {
+	fmt.Fprintf(tw, "Status: %s\n\n", r.Status)
+	admin.Row(tw, "CHECK", "STATUS", "DURATION", "MESSAGE")
+	for _, check := range r.Checks {
+		duration := check.Duration
+		if duration == "" {
+			duration = "-"
+		}
+		admin.Row(tw, check.Name, check.Status, duration, check.Message)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"kafka_test/admin"
+	"kafka_test/health/internal"
+)
+
+// Exit codes, so scripts can tell a degraded cluster from an unreachable one
+const (
+	exitHealthy   = 0
+	exitDegraded  = 1
+	exitUnhealthy = 2
+	exitNotReady  = 3
+	exitUsage     = 64
+)
+
+func main() {
+	fs := flag.NewFlagSet("health", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: health [flags]
+
+Checks broker reachability and authentication, the controller, metadata
+latency, offline and under-replicated partitions and required topics and
+groups, then prints a report.
+
+Exit codes: 0 healthy, 1 degraded, 2 unhealthy, 3 not ready before -timeout, 64 usage error.
+With -wait-until-ready a degraded cluster is ready and exits 0.
+
+Flags:
+`)
+		fs.PrintDefaults()
+	}
+	topics := fs.String("topics", "", "comma-separated topics that must exist")
+	groups := fs.String("groups", "", "comma-separated consumer groups that must exist")
+	format := fs.String("format", admin.FormatJSON, "output format: table, json or yaml")
+	waitUntilReady := fs.Bool("wait-until-ready", false, "retry until no check fails, for use before starting clients")
+	timeout := fs.Duration("timeout", 60*time.Second, "how long to wait with -wait-until-ready")
+	interval := fs.Duration("interval", 2*time.Second, "delay between attempts with -wait-until-ready")
+	requestTimeout := fs.Duration("request-timeout", 5*time.Second, "dial and request timeout per broker")
+	slow := fs.Duration("slow-metadata", 2*time.Second, "metadata refresh time above which the cluster is degraded, 0 to disable")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		os.Exit(exitUsage)
+	}
+
+	if err := validate(*format, *timeout, *interval, *requestTimeout); err != nil {
+		log.Printf("health failed: %v", err)
+		os.Exit(exitUsage)
+	}
+
+	opts := internal.Options{
+		Timeout:      *requestTimeout,
+		SlowMetadata: *slow,
+		Topics:       splitList(*topics),
+		Groups:       splitList(*groups),
+	}
+
+	// Handle graceful shutdown
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	var report *internal.Report
+	var code int
+	if *waitUntilReady {
+		report, code = waitForReady(ctx, opts, *timeout, *interval)
+	} else {
+		report = internal.Run(opts)
+		code = exitCode(report)
+	}
+
+	if err := admin.Write(os.Stdout, *format, report); err != nil {
+		log.Printf("health failed: %v", err)
+		os.Exit(exitUsage)
+	}
+	os.Exit(code)
+}
+
+func validate(format string, timeout, interval, requestTimeout time.Duration) error {
+	if err := admin.ValidateFormat(format); err != nil {
+		return err
+	}
+	if timeout <= 0 || interval <= 0 || requestTimeout <= 0 {
+		return fmt.Errorf("-timeout, -interval and -request-timeout must be positive")
+	}
+	return nil
+}
+
+// waitForReady runs the checks until none fails, the timeout expires or the
+// context is cancelled, and returns the last report. A degraded cluster is
+// ready, clients can use it, so it exits with exitHealthy.
+func waitForReady(ctx context.Context, opts internal.Options, timeout, interval time.Duration) (*internal.Report, int) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for attempt := 1; ; attempt++ {
+		report := internal.Run(opts)
+		if report.Status != internal.Unhealthy {
+			log.Printf("Cluster ready after %d attempt(s): %s", attempt, report.Status)
+			return report, exitHealthy
+		}
+		log.Printf("Cluster not ready (attempt %d): %s", attempt, failures(report))
+
+		select {
+		case <-ctx.Done():
+			log.Printf("Gave up waiting for the cluster after %s", timeout)
+			return report, exitNotReady
+		case <-time.After(interval):
+		}
+	}
+}
+
+func exitCode(report *internal.Report) int {
+	switch report.Status {
+	case internal.Healthy:
+		return exitHealthy
+	case internal.Degraded:
+		return exitDegraded
+	}
+	return exitUnhealthy
+}
+
+// failures summarizes the failed checks for the wait log
+func failures(report *internal.Report) string {
+	var failed []string
+	for _, check := range report.Checks {
+		if check.Status == internal.StatusFail {
+			failed = append(failed, fmt.Sprintf("%s: %s", check.Name, check.Message))
+		}
+	}
+	return strings.Join(failed, "; ")
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
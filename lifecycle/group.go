@@ -0,0 +1,48 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"kafka_test/metrics"
+
+	"github.com/Shopify/sarama"
+)
+
+// ConsumeGroup consumes the topics with the handler until the context is cancelled,
+// each call to Consume being one group session. A failed session is retried after
+// retryBackoff. Once the context is cancelled it drains: it stops fetching, waits for
+// the in-flight messages to be processed and the session to end with a final commit,
+// then leaves the group.
+func ConsumeGroup(ctx context.Context, group sarama.ConsumerGroup, groupID string, topics []string, handler sarama.ConsumerGroupHandler, retryBackoff time.Duration) error {
+	consumed := make(chan struct{})
+	go func() {
+		defer close(consumed)
+		for {
+			if err := group.Consume(ctx, topics, handler); err != nil {
+				metrics.ConsumeError(groupID, strings.Join(topics, ","))
+				slog.Error("Error from consumer", "group", groupID, "error", err)
+				select {
+				case <-time.After(retryBackoff):
+				case <-ctx.Done():
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+
+	<-ctx.Done()
+	slog.Info("Consumer group draining", "group", groupID)
+	group.PauseAll()
+	<-consumed
+	if err := group.Close(); err != nil {
+		return fmt.Errorf("failed to leave consumer group: %w", err)
+	}
+	slog.Info("Consumer group stopped", "group", groupID)
+	return nil
+}
@@ -0,0 +1,92 @@
+// Package lifecycle runs a producer or consumer until it is asked to stop and
+// drains it within a deadline
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Exit codes
+const (
+	// ExitOK: the service stopped and drained cleanly
+	ExitOK = 0
+	// ExitFailed: the service failed, or failed to drain or close
+	ExitFailed = 1
+	// ExitDrainIncomplete: the drain did not complete within the deadline or a
+	// second signal cut it short, in-flight messages may be processed again
+	ExitDrainIncomplete = 2
+)
+
+// Closer is a resource closed after the service returned, e.g. a producer whose
+// buffered messages are flushed on close
+type Closer struct {
+	Name string
+	io.Closer
+}
+
+// Run runs the service until it returns or SIGINT or SIGTERM arrives. On a signal it
+// cancels the service context and waits up to timeout for the service to return and
+// the closers to close, in order, before returning the exit code. A second signal
+// stops waiting. Run does not cancel ctx itself, so servers started with it, such
+// as the metrics and admin endpoints, outlive the drain and stay available until exit.
+func Run(ctx context.Context, timeout time.Duration, service func(context.Context) error, closers ...Closer) int {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	serviceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		err := service(serviceCtx)
+		for _, closer := range closers {
+			if closeErr := closer.Close(); closeErr != nil {
+				err = errors.Join(err, fmt.Errorf("failed to close %s: %w", closer.Name, closeErr))
+			}
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			slog.Error("Service failed", "error", err)
+			return ExitFailed
+		}
+		return ExitOK
+	case sig := <-signals:
+		slog.Info("Draining", "signal", sig.String(), "timeout", timeout)
+	case <-ctx.Done():
+		slog.Info("Draining", "timeout", timeout)
+	}
+	cancel()
+
+	start := time.Now()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			slog.Error("Drain failed", "elapsed", time.Since(start), "error", err)
+			return ExitFailed
+		}
+		slog.Info("Drained", "elapsed", time.Since(start))
+		return ExitOK
+	case <-deadline.C:
+		slog.Error("Drain did not complete in time", "timeout", timeout)
+		return ExitDrainIncomplete
+	case sig := <-signals:
+		slog.Error("Drain interrupted", "signal", sig.String(), "elapsed", time.Since(start))
+		return ExitDrainIncomplete
+	}
+}
@@ -0,0 +1,164 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeCloser records the order it was closed in
+type fakeCloser struct {
+	name   string
+	err    error
+	closed *[]string
+	mu     *sync.Mutex
+}
+
+func (c fakeCloser) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.closed = append(*c.closed, c.name)
+	return c.err
+}
+
+func closers(names ...string) ([]Closer, func() []string) {
+	var mu sync.Mutex
+	var closed []string
+	list := make([]Closer, 0, len(names))
+	for _, name := range names {
+		list = append(list, Closer{Name: name, Closer: fakeCloser{name: name, closed: &closed, mu: &mu}})
+	}
+	return list, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), closed...)
+	}
+}
+
+// runAsync runs the service with Run and returns the exit code on a channel, once the
+// service started so signals reach Run instead of the default handler
+func runAsync(t *testing.T, timeout time.Duration, service func(context.Context) error, closers ...Closer) <-chan int {
+	t.Helper()
+	started := make(chan struct{})
+	code := make(chan int, 1)
+	go func() {
+		code <- Run(context.Background(), timeout, func(ctx context.Context) error {
+			close(started)
+			return service(ctx)
+		}, closers...)
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the service did not start")
+	}
+	return code
+}
+
+func sendSignal(t *testing.T) {
+	t.Helper()
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exitCode(t *testing.T, code <-chan int) int {
+	t.Helper()
+	select {
+	case c := <-code:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+		return -1
+	}
+}
+
+func TestDrainInTime(t *testing.T) {
+	list, closed := closers("producer", "store")
+	drained := make(chan struct{})
+	code := runAsync(t, 2*time.Second, func(ctx context.Context) error {
+		<-ctx.Done()
+		// Finish the in-flight message
+		time.Sleep(50 * time.Millisecond)
+		close(drained)
+		return nil
+	}, list...)
+
+	sendSignal(t)
+	if c := exitCode(t, code); c != ExitOK {
+		t.Fatalf("exit code %d, want %d", c, ExitOK)
+	}
+	select {
+	case <-drained:
+	default:
+		t.Fatal("Run returned before the service drained")
+	}
+	if got := closed(); len(got) != 2 || got[0] != "producer" || got[1] != "store" {
+		t.Fatalf("closed %v, want [producer store]", got)
+	}
+}
+
+func TestDrainDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	code := runAsync(t, 100*time.Millisecond, func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+
+	sendSignal(t)
+	if c := exitCode(t, code); c != ExitDrainIncomplete {
+		t.Fatalf("exit code %d, want %d", c, ExitDrainIncomplete)
+	}
+}
+
+func TestSecondSignal(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	draining := make(chan struct{})
+	code := runAsync(t, time.Minute, func(ctx context.Context) error {
+		<-ctx.Done()
+		close(draining)
+		<-release
+		return nil
+	})
+
+	sendSignal(t)
+	<-draining
+	sendSignal(t)
+	if c := exitCode(t, code); c != ExitDrainIncomplete {
+		t.Fatalf("exit code %d, want %d", c, ExitDrainIncomplete)
+	}
+}
+
+func TestServiceFailure(t *testing.T) {
+	list, closed := closers("producer")
+	code := Run(context.Background(), time.Second, func(context.Context) error {
+		return errors.New("broker unreachable")
+	}, list...)
+	if code != ExitFailed {
+		t.Fatalf("exit code %d, want %d", code, ExitFailed)
+	}
+	if got := closed(); len(got) != 1 {
+		t.Fatalf("closed %v, want the closers closed after a failure", got)
+	}
+}
+
+func TestCloseFailure(t *testing.T) {
+	var mu sync.Mutex
+	var closed []string
+	failing := Closer{Name: "producer", Closer: fakeCloser{name: "producer", err: errors.New("flush failed"), closed: &closed, mu: &mu}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	code := Run(ctx, time.Second, func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	}, failing)
+	if code != ExitFailed {
+		t.Fatalf("exit code %d, want %d", code, ExitFailed)
+	}
+}
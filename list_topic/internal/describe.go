@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"text/tabwriter"
+
+	"kafka_test/admin"
+
+	"github.com/Shopify/sarama"
+)
+
+// PartitionInfo describes the replicas and offsets of one partition
+type PartitionInfo struct {
+	ID              int32   `json:"id" yaml:"id"`
+	Leader          int32   `json:"leader" yaml:"leader"`
+	Replicas        []int32 `json:"replicas" yaml:"replicas"`
+	ISR             []int32 `json:"isr" yaml:"isr"`
+	OfflineReplicas []int32 `json:"offline_replicas" yaml:"offline_replicas"`
+	EarliestOffset  int64   `json:"earliest_offset" yaml:"earliest_offset"`
+	LatestOffset    int64   `json:"latest_offset" yaml:"latest_offset"`
+	Messages        int64   `json:"messages" yaml:"messages"`
+	UnderReplicated bool    `json:"under_replicated" yaml:"under_replicated"`
+	Error           string  `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// ConfigValue is an effective topic config entry
+type ConfigValue struct {
+	Name      string `json:"name" yaml:"name"`
+	Value     string `json:"value" yaml:"value"`
+	Source    string `json:"source" yaml:"source"`
+	Default   bool   `json:"default" yaml:"default"`
+	ReadOnly  bool   `json:"read_only" yaml:"read_only"`
+	Sensitive bool   `json:"sensitive" yaml:"sensitive"`
+}
+
+// TopicDescription is the full description of one topic
+type TopicDescription struct {
+	Name              string          `json:"name" yaml:"name"`
+	Internal          bool            `json:"internal" yaml:"internal"`
+	ReplicationFactor int             `json:"replication_factor" yaml:"replication_factor"`
+	Messages          int64           `json:"messages" yaml:"messages"`
+	UnderReplicated   int             `json:"under_replicated_partitions" yaml:"under_replicated_partitions"`
+	Partitions        []PartitionInfo `json:"partitions" yaml:"partitions"`
+	Configs           []ConfigValue   `json:"configs" yaml:"configs"`
+}
+
+// Description is the result of describing a set of topics
+type Description struct {
+	Topics []TopicDescription `json:"topics" yaml:"topics"`
+}
+
+// Describe describes the given topics, or every topic when none are given.
+// Topics and partitions are sorted, configs are sorted by name.
+func Describe(conn *admin.Conn, names []string) (*Description, error) {
+	if len(names) == 0 {
+		topics, err := conn.Admin.ListTopics()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list topics: %w", err)
+		}
+		for name := range topics {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	metadata, err := conn.Admin.DescribeTopics(names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe topics: %w", err)
+	}
+	sort.Slice(metadata, func(i, j int) bool { return metadata[i].Name < metadata[j].Name })
+
+	description := &Description{Topics: make([]TopicDescription, 0, len(metadata))}
+	for _, topic := range metadata {
+		if topic.Err != sarama.ErrNoError {
+			return nil, fmt.Errorf("failed to describe topic %s: %w", topic.Name, topic.Err)
+		}
+		topicDescription, err := describeTopic(conn, topic)
+		if err != nil {
+			return nil, err
+		}
+		description.Topics = append(description.Topics, *topicDescription)
+	}
+	return description, nil
+}
+
+func describeTopic(conn *admin.Conn, topic *sarama.TopicMetadata) (*TopicDescription, error) {
+	sort.Slice(topic.Partitions, func(i, j int) bool { return topic.Partitions[i].ID < topic.Partitions[j].ID })
+
+	ids := make([]int32, len(topic.Partitions))
+	for i, partition := range topic.Partitions {
+		ids[i] = partition.ID
+	}
+	earliest, latest, offsetErrs := conn.LogEndOffsets(topic.Name, ids)
+
+	description := &TopicDescription{
+		Name:       topic.Name,
+		Internal:   topic.IsInternal,
+		Partitions: make([]PartitionInfo, 0, len(topic.Partitions)),
+	}
+	for _, partition := range topic.Partitions {
+		info := PartitionInfo{
+			ID:              partition.ID,
+			Leader:          partition.Leader,
+			Replicas:        partition.Replicas,
+			ISR:             partition.Isr,
+			OfflineReplicas: partition.OfflineReplicas,
+			EarliestOffset:  earliest[partition.ID],
+			LatestOffset:    latest[partition.ID],
+			Messages:        latest[partition.ID] - earliest[partition.ID],
+			UnderReplicated: len(partition.Isr) < len(partition.Replicas),
+		}
+		if err := offsetErrs[partition.ID]; err != nil {
+			info.EarliestOffset, info.LatestOffset, info.Messages = -1, -1, 0
+			info.Error = err.Error()
+		}
+		if partition.Err != sarama.ErrNoError {
+			info.Error = partition.Err.Error()
+		}
+		if info.UnderReplicated {
+			description.UnderReplicated++
+		}
+		if len(partition.Replicas) > description.ReplicationFactor {
+			description.ReplicationFactor = len(partition.Replicas)
+		}
+		description.Messages += info.Messages
+		description.Partitions = append(description.Partitions, info)
+	}
+
+	entries, err := conn.Admin.DescribeConfig(sarama.ConfigResource{Type: sarama.TopicResource, Name: topic.Name})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe configs of topic %s: %w", topic.Name, err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	for _, entry := range entries {
+		description.Configs = append(description.Configs, ConfigValue{
+			Name:      entry.Name,
+			Value:     entry.Value,
+			Source:    entry.Source.String(),
+			Default:   entry.Default,
+			ReadOnly:  entry.ReadOnly,
+			Sensitive: entry.Sensitive,
+		})
+	}
+	return description, nil
+}
+
+// WriteTable prints every topic with its partitions and non-default configs.
+// Default configs are summarized to keep the output short; use JSON or YAML to see them.
+func (d *Description) WriteTable(tw *tabwriter.Writer) {
+	for i, topic := range d.Topics {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "Topic: %s  Partitions: %d  Replication Factor: %d  Messages: %d  Under-replicated: %d\n",
+			topic.Name, len(topic.Partitions), topic.ReplicationFactor, topic.Messages, topic.UnderReplicated)
+
+		admin.Row(tw, "PARTITION", "LEADER", "REPLICAS", "ISR", "OFFLINE", "EARLIEST", "LATEST", "MESSAGES", "STATUS")
+		for _, partition := range topic.Partitions {
+			status := "ok"
+			switch {
+			case partition.Error != "":
+				status = partition.Error
+			case partition.Leader < 0:
+				status = "OFFLINE"
+			case partition.UnderReplicated:
+				status = "UNDER-REPLICATED"
+			}
+			admin.Row(tw, partition.ID, partition.Leader, admin.JoinInt32(partition.Replicas), admin.JoinInt32(partition.ISR),
+				admin.JoinInt32(partition.OfflineReplicas), partition.EarliestOffset, partition.LatestOffset, partition.Messages, status)
+		}
+
+		defaults := 0
+		var overrides []ConfigValue
+		for _, entry := range topic.Configs {
+			if entry.Default {
+				defaults++
+			} else {
+				overrides = append(overrides, entry)
+			}
+		}
+		fmt.Fprintf(tw, "Configs: %d non-default, %d default\n", len(overrides), defaults)
+		for _, entry := range overrides {
+			value := entry.Value
+			if entry.Sensitive {
+				value = "(sensitive)"
+			}
+			admin.Row(tw, "  "+entry.Name, value, entry.Source)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"kafka_test/admin"
+
+	"github.com/Shopify/sarama"
+)
+
+// ErrDestructive is returned when applying a plan with destructive actions that were not allowed
+var ErrDestructive = errors.New("plan contains destructive changes")
+
+// ErrUnsupported is returned when applying a plan with changes that cannot be made
+var ErrUnsupported = errors.New("plan contains unsupported changes")
+
+// Action types of a topic plan
+const (
+	ActionCreate        = "create"
+	ActionAddPartitions = "add-partitions"
+	ActionAlterConfigs  = "alter-configs"
+	ActionDelete        = "delete"
+	// ActionUnsupported marks differences Kafka cannot reconcile in place,
+	// such as fewer partitions or another replication factor
+	ActionUnsupported = "unsupported"
+)
+
+// ConfigChange is the change of one topic config override
+type ConfigChange struct {
+	Name string `json:"name" yaml:"name"`
+	Old  string `json:"old" yaml:"old"`
+	New  string `json:"new" yaml:"new"`
+	// Remove is set when the override is deleted and the config falls back to its default
+	Remove bool `json:"remove,omitempty" yaml:"remove,omitempty"`
+}
+
+// Action is one change needed to bring a topic to its spec
+type Action struct {
+	Topic       string         `json:"topic" yaml:"topic"`
+	Type        string         `json:"type" yaml:"type"`
+	Description string         `json:"description" yaml:"description"`
+	Destructive bool           `json:"destructive" yaml:"destructive"`
+	Partitions  int32          `json:"partitions,omitempty" yaml:"partitions,omitempty"`
+	Configs     []ConfigChange `json:"configs,omitempty" yaml:"configs,omitempty"`
+
+	spec *TopicSpec
+}
+
+// Plan is the list of actions that reconcile the cluster with a spec
+type Plan struct {
+	Actions []Action `json:"actions" yaml:"actions"`
+	Applied bool     `json:"applied" yaml:"applied"`
+}
+
+// HasDestructive reports whether any action deletes data or config
+func (p *Plan) HasDestructive() bool {
+	for _, action := range p.Actions {
+		if action.Destructive {
+			return true
+		}
+	}
+	return false
+}
+
+// HasUnsupported reports whether any difference cannot be applied
+func (p *Plan) HasUnsupported() bool {
+	for _, action := range p.Actions {
+		if action.Type == ActionUnsupported {
+			return true
+		}
+	}
+	return false
+}
+
+// destructiveConfigs lists configs whose change can remove data: lower
+// retention deletes older records and another cleanup policy compacts or deletes them
+var destructiveConfigs = map[string]bool{
+	"cleanup.policy":  true,
+	"retention.ms":    true,
+	"retention.bytes": true,
+}
+
+// ComputePlan diffs the spec against the cluster. With prune, topics missing
+// from the spec are deleted; internal topics and topics starting with "_" are never pruned.
+func ComputePlan(conn *admin.Conn, spec *Spec, prune bool) (*Plan, error) {
+	existing, err := conn.Admin.ListTopics()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list topics: %w", err)
+	}
+
+	var names []string
+	for _, topic := range spec.Topics {
+		if _, ok := existing[topic.Name]; ok {
+			names = append(names, topic.Name)
+		}
+	}
+	current := make(map[string]TopicDescription)
+	if len(names) > 0 {
+		description, err := Describe(conn, names)
+		if err != nil {
+			return nil, err
+		}
+		for _, topic := range description.Topics {
+			current[topic.Name] = topic
+		}
+	}
+
+	plan := &Plan{}
+	topics := append([]TopicSpec(nil), spec.Topics...)
+	sort.Slice(topics, func(i, j int) bool { return topics[i].Name < topics[j].Name })
+	for i := range topics {
+		topic := &topics[i]
+		description, ok := current[topic.Name]
+		if !ok {
+			plan.Actions = append(plan.Actions, Action{
+				Topic:       topic.Name,
+				Type:        ActionCreate,
+				Description: fmt.Sprintf("create with %d partitions, replication factor %d", topic.Partitions, topic.ReplicationFactor),
+				Partitions:  topic.Partitions,
+				Configs:     newConfigs(topic.Configs),
+				spec:        topic,
+			})
+			continue
+		}
+
+		actions, err := diffTopic(topic, description)
+		if err != nil {
+			return nil, err
+		}
+		plan.Actions = append(plan.Actions, actions...)
+	}
+
+	if prune {
+		managed := make(map[string]bool, len(spec.Topics))
+		for _, topic := range spec.Topics {
+			managed[topic.Name] = true
+		}
+		var unmanaged []string
+		for name := range existing {
+			if managed[name] || strings.HasPrefix(name, "_") {
+				continue
+			}
+			unmanaged = append(unmanaged, name)
+		}
+		sort.Strings(unmanaged)
+		for _, name := range unmanaged {
+			plan.Actions = append(plan.Actions, Action{
+				Topic:       name,
+				Type:        ActionDelete,
+				Description: "delete topic not in spec",
+				Destructive: true,
+			})
+		}
+	}
+	return plan, nil
+}
+
+// diffTopic returns the actions that bring an existing topic to its spec
+func diffTopic(topic *TopicSpec, description TopicDescription) ([]Action, error) {
+	var actions []Action
+	partitions := int32(len(description.Partitions))
+	switch {
+	case topic.Partitions > partitions:
+		actions = append(actions, Action{
+			Topic:       topic.Name,
+			Type:        ActionAddPartitions,
+			Description: fmt.Sprintf("partitions %d -> %d", partitions, topic.Partitions),
+			Partitions:  topic.Partitions,
+			spec:        topic,
+		})
+	case topic.Partitions < partitions:
+		actions = append(actions, Action{
+			Topic:       topic.Name,
+			Type:        ActionUnsupported,
+			Description: fmt.Sprintf("partitions %d -> %d: partitions cannot be removed, recreate the topic instead", partitions, topic.Partitions),
+		})
+	}
+
+	if int(topic.ReplicationFactor) != description.ReplicationFactor {
+		actions = append(actions, Action{
+			Topic: topic.Name,
+			Type:  ActionUnsupported,
+			Description: fmt.Sprintf("replication factor %d -> %d: change it with a partition reassignment",
+				description.ReplicationFactor, topic.ReplicationFactor),
+		})
+	}
+
+	effective := make(map[string]ConfigValue, len(description.Configs))
+	for _, entry := range description.Configs {
+		effective[entry.Name] = entry
+	}
+
+	var changes []ConfigChange
+	destructive := false
+	for _, name := range sortedNames(topic.Configs) {
+		entry, ok := effective[name]
+		if !ok {
+			return nil, fmt.Errorf("topic %s: unknown config %s", topic.Name, name)
+		}
+		value := topic.Configs[name]
+		if entry.Value == value && entry.Source == sarama.SourceTopic.String() {
+			continue
+		}
+		if entry.Value != value && isDestructiveConfigChange(name, entry.Value, value) {
+			destructive = true
+		}
+		changes = append(changes, ConfigChange{Name: name, Old: entry.Value, New: value})
+	}
+	for _, entry := range description.Configs {
+		if entry.Source != sarama.SourceTopic.String() {
+			continue
+		}
+		if _, managed := topic.Configs[entry.Name]; managed {
+			continue
+		}
+		if destructiveConfigs[entry.Name] {
+			destructive = true
+		}
+		changes = append(changes, ConfigChange{Name: entry.Name, Old: entry.Value, Remove: true})
+	}
+
+	if len(changes) > 0 {
+		descriptions := make([]string, 0, len(changes))
+		for _, change := range changes {
+			if change.Remove {
+				descriptions = append(descriptions, fmt.Sprintf("%s: remove override %q", change.Name, change.Old))
+			} else {
+				descriptions = append(descriptions, fmt.Sprintf("%s: %q -> %q", change.Name, change.Old, change.New))
+			}
+		}
+		actions = append(actions, Action{
+			Topic:       topic.Name,
+			Type:        ActionAlterConfigs,
+			Description: strings.Join(descriptions, ", "),
+			Destructive: destructive,
+			Configs:     changes,
+			spec:        topic,
+		})
+	}
+	return actions, nil
+}
+
+// isDestructiveConfigChange reports whether changing a config can remove records.
+// Higher retention is safe, lower or unlimited-to-limited retention is not.
+func isDestructiveConfigChange(name, old, new string) bool {
+	if !destructiveConfigs[name] {
+		return false
+	}
+	if name == "cleanup.policy" {
+		return true
+	}
+	oldValue, oldErr := strconv.ParseInt(old, 10, 64)
+	newValue, newErr := strconv.ParseInt(new, 10, 64)
+	if oldErr != nil || newErr != nil {
+		return true
+	}
+	if newValue < 0 {
+		return false
+	}
+	return oldValue < 0 || newValue < oldValue
+}
+
+// Apply executes the plan. Destructive actions require allowDestructive and
+// unsupported differences always stop the apply before anything is changed.
+func Apply(conn *admin.Conn, plan *Plan, allowDestructive bool) error {
+	if plan.HasUnsupported() {
+		return fmt.Errorf("%w, see the plan for details", ErrUnsupported)
+	}
+	if plan.HasDestructive() && !allowDestructive {
+		return fmt.Errorf("%w, rerun with -allow-destructive to apply them", ErrDestructive)
+	}
+
+	for _, action := range plan.Actions {
+		var err error
+		switch action.Type {
+		case ActionCreate:
+			err = conn.Admin.CreateTopic(action.Topic, &sarama.TopicDetail{
+				NumPartitions:     action.spec.Partitions,
+				ReplicationFactor: action.spec.ReplicationFactor,
+				ConfigEntries:     configEntries(action.spec.Configs),
+			}, false)
+		case ActionAddPartitions:
+			err = conn.Admin.CreatePartitions(action.Topic, action.Partitions, nil, false)
+		case ActionAlterConfigs:
+			entries := make(map[string]sarama.IncrementalAlterConfigsEntry, len(action.Configs))
+			for _, change := range action.Configs {
+				if change.Remove {
+					entries[change.Name] = sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationDelete}
+					continue
+				}
+				value := change.New
+				entries[change.Name] = sarama.IncrementalAlterConfigsEntry{Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &value}
+			}
+			err = conn.Admin.IncrementalAlterConfig(sarama.TopicResource, action.Topic, entries, false)
+		case ActionDelete:
+			err = conn.Admin.DeleteTopic(action.Topic)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to %s topic %s: %w", action.Type, action.Topic, err)
+		}
+		log.Printf("Applied %s on topic %s: %s", action.Type, action.Topic, action.Description)
+	}
+	plan.Applied = true
+	return nil
+}
+
+// WriteTable prints one line per action
+func (p *Plan) WriteTable(tw *tabwriter.Writer) {
+	if len(p.Actions) == 0 {
+		fmt.Fprintln(tw, "No changes. The cluster matches the spec.")
+		return
+	}
+	admin.Row(tw, "TOPIC", "ACTION", "DESTRUCTIVE", "DETAILS")
+	for _, action := range p.Actions {
+		destructive := ""
+		if action.Destructive {
+			destructive = "yes"
+		}
+		admin.Row(tw, action.Topic, action.Type, destructive, action.Description)
+	}
+}
+
+func newConfigs(configs map[string]string) []ConfigChange {
+	var changes []ConfigChange
+	for _, name := range sortedNames(configs) {
+		changes = append(changes, ConfigChange{Name: name, New: configs[name]})
+	}
+	return changes
+}
+
+func configEntries(configs map[string]string) map[string]*string {
+	entries := make(map[string]*string, len(configs))
+	for name, value := range configs {
+		value := value
+		entries[name] = &value
+	}
+	return entries
+}
+
+func sortedNames(configs map[string]string) []string {
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
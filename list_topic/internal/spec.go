@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Spec is the desired state of the managed topics
+type Spec struct {
+	Topics []TopicSpec `yaml:"topics"`
+}
+
+// TopicSpec is the desired state of one topic.
+// Configs lists the topic level overrides; any other override is removed.
+type TopicSpec struct {
+	Name              string            `yaml:"name"`
+	Partitions        int32             `yaml:"partitions"`
+	ReplicationFactor int16             `yaml:"replication_factor"`
+	Configs           map[string]string `yaml:"configs"`
+}
+
+// LoadSpec reads and validates a topic spec file
+func LoadSpec(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read topic spec: %w", err)
+	}
+
+	var spec Spec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse topic spec %s: %w", path, err)
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid topic spec %s: %w", path, err)
+	}
+	return &spec, nil
+}
+
+// Validate checks that every topic has a unique name, partitions and a replication factor
+func (s *Spec) Validate() error {
+	seen := make(map[string]bool, len(s.Topics))
+	for _, topic := range s.Topics {
+		switch {
+		case topic.Name == "":
+			return fmt.Errorf("topic without name")
+		case seen[topic.Name]:
+			return fmt.Errorf("topic %s is declared twice", topic.Name)
+		case topic.Partitions < 1:
+			return fmt.Errorf("topic %s: partitions must be at least 1", topic.Name)
+		case topic.ReplicationFactor < 1:
+			return fmt.Errorf("topic %s: replication_factor must be at least 1", topic.Name)
+		}
+		seen[topic.Name] = true
+	}
+	return nil
+}
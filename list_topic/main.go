@@ -1,46 +1,168 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"sort"
 	"strings"
 
-	"kafka_test/config"
-
-	"github.com/Shopify/sarama"
+	"kafka_test/admin"
+	"kafka_test/list_topic/internal"
 )
 
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: list_topic [command] [flags]
+
+Commands:
+  list      List topics with partition count and replication factor (default)
+  describe  Show partitions, leaders, replicas, ISR, offsets and configs of topics
+  plan      Show the changes needed to bring topics to a YAML spec
+  apply     Create topics, add partitions and alter configs to match a YAML spec
+
+Run "list_topic <command> -h" for command flags.
+`)
+}
+
 func main() {
-	// Get broker configuration
-	brokers := config.GetBrokers()
-	log.Printf("Connecting to brokers: %v", brokers)
+	command, args := "list", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+	switch command {
+	case "list":
+		err = runList(args)
+	case "describe":
+		err = runDescribe(args)
+	case "plan":
+		err = runPlan(args, false)
+	case "apply":
+		err = runPlan(args, true)
+	case "help":
+		usage()
+		return
+	default:
+		usage()
+		os.Exit(2)
+	}
 
-	// Create admin client
-	admin, err := sarama.NewClusterAdmin(brokers, config.GetProducerConfig())
 	if err != nil {
-		log.Fatalf("Failed to create admin client: %v", err)
+		log.Fatalf("list_topic %s failed: %v", command, err)
 	}
-	defer admin.Close()
+}
+
+// runList prints every topic with its partition count and replication factor
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	fs.Parse(args)
+
+	conn, err := admin.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
 
 	// List all topics
-	topics, err := admin.ListTopics()
+	topics, err := conn.Admin.ListTopics()
 	if err != nil {
-		log.Fatalf("Failed to list topics: %v", err)
+		return fmt.Errorf("failed to list topics: %w", err)
 	}
 
 	if len(topics) == 0 {
 		fmt.Println("No topics found.")
-		return
+		return nil
 	}
 
+	names := make([]string, 0, len(topics))
+	for name := range topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
 	fmt.Printf("\nFound %d topic(s):\n", len(topics))
 	fmt.Println(strings.Repeat("=", 50))
 
-	for topicName, topicDetail := range topics {
+	for _, topicName := range names {
+		topicDetail := topics[topicName]
 		fmt.Printf("Topic: %s\n", topicName)
 		fmt.Printf("  Partitions: %d\n", topicDetail.NumPartitions)
 		fmt.Printf("  Replication Factor: %d\n", topicDetail.ReplicationFactor)
-		fmt.Printf("  Config: %v\n", topicDetail.ConfigEntries)
 		fmt.Println(strings.Repeat("-", 30))
 	}
+	return nil
+}
+
+// runDescribe prints a full description of the given topics, or of every topic
+func runDescribe(args []string) error {
+	fs := flag.NewFlagSet("describe", flag.ExitOnError)
+	format := fs.String("format", admin.FormatTable, "output format: table, json or yaml")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: list_topic describe [flags] [topic...]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if err := admin.ValidateFormat(*format); err != nil {
+		return err
+	}
+
+	conn, err := admin.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	description, err := internal.Describe(conn, fs.Args())
+	if err != nil {
+		return err
+	}
+	return admin.Write(os.Stdout, *format, description)
+}
+
+// runPlan diffs a topic spec against the cluster and, for apply, executes the plan
+func runPlan(args []string, apply bool) error {
+	name := "plan"
+	if apply {
+		name = "apply"
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	specPath := fs.String("spec", "topics.yaml", "topic spec YAML file")
+	prune := fs.Bool("prune", false, "delete topics that are not in the spec (destructive)")
+	format := fs.String("format", admin.FormatTable, "output format: table, json or yaml")
+	var allowDestructive *bool
+	if apply {
+		allowDestructive = fs.Bool("allow-destructive", false, "apply changes that delete topics, lower retention or change cleanup.policy")
+	}
+	fs.Parse(args)
+
+	if err := admin.ValidateFormat(*format); err != nil {
+		return err
+	}
+
+	spec, err := internal.LoadSpec(*specPath)
+	if err != nil {
+		return err
+	}
+
+	conn, err := admin.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	plan, err := internal.ComputePlan(conn, spec, *prune)
+	if err != nil {
+		return err
+	}
+
+	if apply {
+		if err := internal.Apply(conn, plan, *allowDestructive); err != nil {
+			admin.Write(os.Stdout, *format, plan)
+			return err
+		}
+	}
+	return admin.Write(os.Stdout, *format, plan)
 }
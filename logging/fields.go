@@ -0,0 +1,49 @@
+package logging
+
+import (
+	"log/slog"
+
+	"kafka_test/models"
+
+	"github.com/Shopify/sarama"
+)
+
+// Message returns the fields identifying a consumed message
+func Message(msg *sarama.ConsumerMessage) []any {
+	return []any{
+		"topic", msg.Topic,
+		"partition", msg.Partition,
+		"offset", msg.Offset,
+		"key", string(msg.Key),
+	}
+}
+
+// Produced returns the fields identifying a message once the brokers acknowledged it
+func Produced(topic string, partition int32, offset int64) []any {
+	return []any{
+		"topic", topic,
+		"partition", partition,
+		"offset", offset,
+	}
+}
+
+// ChangeData returns the fields identifying the request a ChangeDataMessage is part of
+func ChangeData(msg *models.ChangeDataMessage) []any {
+	return []any{
+		"request_id", msg.RequestId,
+		"tenant_uid", msg.TenantUid,
+		"message_number", msg.MessageNumber,
+		"total_message_count", msg.TotalMessageCount,
+	}
+}
+
+// Headers returns the headers of a consumed message as one field
+func Headers(msg *sarama.ConsumerMessage) slog.Attr {
+	headers := make(map[string]string, len(msg.Headers))
+	for _, header := range msg.Headers {
+		if header != nil {
+			headers[string(header.Key)] = string(header.Value)
+		}
+	}
+	return slog.Any("headers", headers)
+}
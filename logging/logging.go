@@ -0,0 +1,102 @@
+// Package logging sets up structured logging with log/slog and provides the
+// fields identifying the messages a log record is about
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"kafka_test/config"
+)
+
+// Formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Options configures the default logger
+type Options struct {
+	// Format is text or json
+	Format string
+	// Level is debug, info, warn or error
+	Level string
+	// Payloads logs message values, which may be large or sensitive
+	Payloads bool
+	// SampleFirst is how many records with the same message the sampled logger
+	// writes each second before sampling them, 0 disables sampling
+	SampleFirst int
+	// SampleThereafter writes every nth record after the first ones, 0 drops them
+	SampleThereafter int
+}
+
+var (
+	payloads bool
+	sampled  = slog.Default()
+)
+
+// Setup installs the default slog logger, which the log package writes through
+// as well, and the sampled logger for high-volume paths
+func Setup(service string, opts Options) error {
+	var level slog.Level
+	if opts.Level != "" {
+		if err := level.UnmarshalText([]byte(opts.Level)); err != nil {
+			return fmt.Errorf("invalid log level %q: %w", opts.Level, err)
+		}
+	}
+
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "", FormatText:
+		handler = slog.NewTextHandler(os.Stderr, handlerOpts)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(os.Stderr, handlerOpts)
+	default:
+		return fmt.Errorf("unknown log format %q, expected %s or %s", opts.Format, FormatText, FormatJSON)
+	}
+	handler = handler.WithAttrs([]slog.Attr{slog.String("service", service)})
+
+	slog.SetDefault(slog.New(handler))
+	payloads = opts.Payloads
+	sampled = slog.Default()
+	if opts.SampleFirst > 0 {
+		sampled = slog.New(newSamplingHandler(handler, opts.SampleFirst, opts.SampleThereafter))
+	}
+	return nil
+}
+
+// SetupFromEnv installs the loggers of a service configured by the KAFKA_LOG_*
+// environment variables
+func SetupFromEnv(service string) error {
+	sampleFirst, sampleThereafter := config.GetLogSampling()
+	return Setup(service, Options{
+		Format:           config.GetLogFormat(),
+		Level:            config.GetLogLevel(),
+		Payloads:         config.GetLogPayloads(),
+		SampleFirst:      sampleFirst,
+		SampleThereafter: sampleThereafter,
+	})
+}
+
+// Sampled returns the logger for records written for every message, which drops
+// repeated debug and info records beyond the configured rate
+func Sampled() *slog.Logger {
+	return sampled
+}
+
+// Payloads reports whether message values are logged
+func Payloads() bool {
+	return payloads
+}
+
+// Payload returns the attribute logging a message value, or an empty attribute,
+// which handlers ignore, unless payload logging is enabled
+func Payload(value []byte) slog.Attr {
+	if !payloads {
+		return slog.Attr{}
+	}
+	return slog.String("payload", string(value))
+}
@@ -0,0 +1,214 @@
+// Package metrics exports producer, consumer and Sarama client metrics in
+// the Prometheus format on an HTTP /metrics endpoint
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const namespace = "kafka"
+
+// latencyBuckets cover broker acks and commits, from a local broker to a slow remote cluster
+var latencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds every metric served on /metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	messagesProduced = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_produced_total",
+		Help:      "Messages acknowledged by the brokers.",
+	}, []string{"topic"})
+
+	produceErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "produce_errors_total",
+		Help:      "Messages the brokers did not acknowledge.",
+	}, []string{"topic"})
+
+	produceLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "produce_ack_latency_seconds",
+		Help:      "Time from sending a message until the brokers acknowledged it.",
+		Buckets:   latencyBuckets,
+	}, []string{"topic"})
+
+	messagesConsumed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_consumed_total",
+		Help:      "Messages received from the brokers.",
+	}, []string{"group", "topic"})
+
+	consumeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "consume_errors_total",
+		Help:      "Messages that failed processing and consumer group errors.",
+	}, []string{"group", "topic"})
+
+	processingLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "processing_step_seconds",
+		Help:      "Time spent in each step of processing a consumed message.",
+		Buckets:   latencyBuckets,
+	}, []string{"group", "step"})
+
+	commitLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "commit_latency_seconds",
+		Help:      "Time taken to commit the marked offsets of a consumer group session.",
+		Buckets:   latencyBuckets,
+	}, []string{"group"})
+
+	consumerLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "consumer_lag",
+		Help:      "Messages between the last consumed offset and the high water mark of a claimed partition.",
+	}, []string{"group", "topic", "partition"})
+
+	duplicates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "duplicates_skipped_total",
+		Help:      "Messages skipped because a message with the same identity was processed already.",
+	}, []string{"group", "topic"})
+
+	batches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "batches_total",
+		Help:      "Batches handed to batch handlers by outcome, succeeded or failed; retries count again.",
+	}, []string{"group", "outcome"})
+
+	batchSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "batch_size",
+		Help:      "Messages in the batches handed to batch handlers.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+	}, []string{"group"})
+
+	batchLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "batch_seconds",
+		Help:      "Time batch handlers took to handle a batch.",
+		Buckets:   latencyBuckets,
+	}, []string{"group"})
+
+	deadLettered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dead_lettered_total",
+		Help:      "Messages that failed handling and were sent to the dead letter topic.",
+	}, []string{"group", "topic"})
+
+	transactions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transactions_total",
+		Help:      "Producer transactions by outcome, committed or aborted.",
+	}, []string{"group", "outcome"})
+
+	transactionLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "transaction_seconds",
+		Help:      "Time from beginning a producer transaction until it was committed.",
+		Buckets:   latencyBuckets,
+	}, []string{"group"})
+
+	rebalances = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "consumer_rebalances_total",
+		Help:      "Consumer group sessions started, one per rebalance.",
+	}, []string{"group"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		messagesProduced, produceErrors, produceLatency,
+		messagesConsumed, consumeErrors, processingLatency, commitLatency,
+		consumerLag, rebalances, duplicates, transactions, transactionLatency,
+		batches, batchSize, batchLatency, deadLettered,
+		saramaBridge,
+	)
+}
+
+// ObserveProduce records the outcome and ack latency of sending one message
+func ObserveProduce(topic string, start time.Time, err error) {
+	if err != nil {
+		produceErrors.WithLabelValues(topic).Inc()
+		return
+	}
+	messagesProduced.WithLabelValues(topic).Inc()
+	produceLatency.WithLabelValues(topic).Observe(time.Since(start).Seconds())
+}
+
+// ObserveConsume counts a consumed message and updates the lag of its partition
+func ObserveConsume(group, topic string, partition int32, offset, highWaterMark int64) {
+	messagesConsumed.WithLabelValues(group, topic).Inc()
+	// The high water mark is the offset of the next message to be written
+	lag := max(highWaterMark-offset-1, 0)
+	consumerLag.WithLabelValues(group, topic, strconv.Itoa(int(partition))).Set(float64(lag))
+}
+
+// ConsumeError counts a message that failed processing or a consumer group error
+func ConsumeError(group, topic string) {
+	consumeErrors.WithLabelValues(group, topic).Inc()
+}
+
+// ObserveStep records the duration of one processing step
+func ObserveStep(group, step string, elapsed time.Duration) {
+	processingLatency.WithLabelValues(group, step).Observe(elapsed.Seconds())
+}
+
+// DuplicateSkipped counts a message skipped as a duplicate
+func DuplicateSkipped(group, topic string) {
+	duplicates.WithLabelValues(group, topic).Inc()
+}
+
+// ObserveCommit records the duration of an offset commit
+func ObserveCommit(group string, start time.Time) {
+	commitLatency.WithLabelValues(group).Observe(time.Since(start).Seconds())
+}
+
+// ObserveBatch records the size, duration and outcome of handing a batch to a batch handler
+func ObserveBatch(group string, size int, start time.Time, err error) {
+	outcome := "succeeded"
+	if err != nil {
+		outcome = "failed"
+	}
+	batches.WithLabelValues(group, outcome).Inc()
+	batchSize.WithLabelValues(group).Observe(float64(size))
+	batchLatency.WithLabelValues(group).Observe(time.Since(start).Seconds())
+}
+
+// DeadLettered counts a message sent to the dead letter topic
+func DeadLettered(group, topic string) {
+	deadLettered.WithLabelValues(group, topic).Inc()
+}
+
+// ObserveTransaction records whether a transaction committed and, if so, how long it took
+func ObserveTransaction(group string, start time.Time, err error) {
+	if err != nil {
+		transactions.WithLabelValues(group, "aborted").Inc()
+		return
+	}
+	transactions.WithLabelValues(group, "committed").Inc()
+	transactionLatency.WithLabelValues(group).Observe(time.Since(start).Seconds())
+}
+
+// SessionStarted counts a rebalance; call it from the Setup of a consumer group handler
+func SessionStarted(group string) {
+	rebalances.WithLabelValues(group).Inc()
+}
+
+// SessionEnded drops the lag of the partitions a session claimed, they may move to
+// another member; call it from the Cleanup of a consumer group handler
+func SessionEnded(group string, claims map[string][]int32) {
+	for topic, partitions := range claims {
+		for _, partition := range partitions {
+			consumerLag.DeleteLabelValues(group, topic, strconv.Itoa(int(partition)))
+		}
+	}
+}
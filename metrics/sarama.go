@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	gometrics "github.com/rcrowley/go-metrics"
+)
+
+// saramaQuantiles are the quantiles exported for Sarama histograms
+var saramaQuantiles = []float64{0.5, 0.75, 0.95, 0.99}
+
+var (
+	brokerSuffix = regexp.MustCompile(`^(.+)-for-broker-(-?\d+)$`)
+	topicSuffix  = regexp.MustCompile(`^(.+)-for-topic-(.+)$`)
+	invalidChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// saramaCollector exports the go-metrics registries of Sarama clients. It
+// reads them on every scrape, so the metrics Sarama adds as it connects to
+// brokers and topics appear without registering them.
+type saramaCollector struct {
+	mu         sync.Mutex
+	registries map[string]gometrics.Registry
+}
+
+var saramaBridge = &saramaCollector{registries: map[string]gometrics.Registry{}}
+
+// RegisterSarama exports the metrics of the Sarama clients using the registry,
+// config.MetricRegistry, with a client label. Registering a client name again
+// replaces its registry.
+func RegisterSarama(client string, registry gometrics.Registry) {
+	saramaBridge.mu.Lock()
+	defer saramaBridge.mu.Unlock()
+	saramaBridge.registries[client] = registry
+}
+
+// Describe sends no descriptors, the metrics of Sarama are only known once collected
+func (c *saramaCollector) Describe(chan<- *prometheus.Desc) {}
+
+// Collect converts every metric of the registered registries
+func (c *saramaCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clients := make([]string, 0, len(c.registries))
+	for client := range c.registries {
+		clients = append(clients, client)
+	}
+	sort.Strings(clients)
+
+	for _, client := range clients {
+		c.registries[client].Each(func(name string, metric interface{}) {
+			collectSarama(ch, client, name, metric)
+		})
+	}
+}
+
+// collectSarama converts one go-metrics metric. Per-broker and per-topic
+// metrics get their own family with a broker or topic label, e.g.
+// request-rate-for-broker-1 becomes sarama_broker_request_rate_total{broker="1"}.
+func collectSarama(ch chan<- prometheus.Metric, client, name string, metric interface{}) {
+	labels := []string{"client"}
+	values := []string{client}
+	prefix := "sarama_"
+	if match := brokerSuffix.FindStringSubmatch(name); match != nil {
+		name, prefix = match[1], "sarama_broker_"
+		labels, values = append(labels, "broker"), append(values, match[2])
+	} else if match := topicSuffix.FindStringSubmatch(name); match != nil {
+		name, prefix = match[1], "sarama_topic_"
+		labels, values = append(labels, "topic"), append(values, match[2])
+	}
+	name = prefix + strings.ToLower(invalidChars.ReplaceAllString(name, "_"))
+
+	desc := func(suffix, help string) *prometheus.Desc {
+		return prometheus.NewDesc(name+suffix, help, labels, nil)
+	}
+
+	switch m := metric.(type) {
+	case gometrics.Meter:
+		snapshot := m.Snapshot()
+		ch <- prometheus.MustNewConstMetric(desc("_total", "Sarama meter count."), prometheus.CounterValue, float64(snapshot.Count()), values...)
+		ch <- prometheus.MustNewConstMetric(desc("_rate1", "Sarama meter one-minute rate per second."), prometheus.GaugeValue, snapshot.Rate1(), values...)
+	case gometrics.Histogram:
+		snapshot := m.Snapshot()
+		percentiles := snapshot.Percentiles(saramaQuantiles)
+		quantiles := make(map[float64]float64, len(saramaQuantiles))
+		for i, quantile := range saramaQuantiles {
+			quantiles[quantile] = percentiles[i]
+		}
+		ch <- prometheus.MustNewConstSummary(desc("", "Sarama histogram over a sample of recent values."),
+			uint64(snapshot.Count()), float64(snapshot.Sum()), quantiles, values...)
+	case gometrics.Counter:
+		ch <- prometheus.MustNewConstMetric(desc("", "Sarama counter."), prometheus.GaugeValue, float64(m.Count()), values...)
+	case gometrics.Gauge:
+		ch <- prometheus.MustNewConstMetric(desc("", "Sarama gauge."), prometheus.GaugeValue, float64(m.Value()), values...)
+	case gometrics.GaugeFloat64:
+		ch <- prometheus.MustNewConstMetric(desc("", "Sarama gauge."), prometheus.GaugeValue, m.Value(), values...)
+	}
+}
@@ -0,0 +1,248 @@
+// Package partitionrange reads a range of offsets of a partition up to its end, also when
+// its last offsets hold no message. Transaction markers, aborted transactions and
+// compacted records take offsets but are never delivered by a partition consumer, so
+// waiting for the message before the end of the range would never return.
+package partitionrange
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// defaultIdleTimeout is how long a read waits for the next message before fetching the
+// rest of its range directly, to find out whether any message is left in it
+const defaultIdleTimeout = 2 * time.Second
+
+// Range is the range of a partition to read, [From, To). To is -1 to follow the
+// partition until the read is stopped.
+type Range struct {
+	Topic     string
+	Partition int32
+	From      int64
+	To        int64
+}
+
+// Reader reads ranges of the partitions of a client
+type Reader struct {
+	idleTimeout time.Duration
+	// remaining reports whether a message can still be delivered in [offset, to)
+	remaining func(topic string, partition int32, offset, to int64) (bool, error)
+}
+
+// NewReader creates a Reader fetching from the leaders known to the client
+func NewReader(client sarama.Client) *Reader {
+	return &Reader{
+		idleTimeout: defaultIdleTimeout,
+		remaining: func(topic string, partition int32, offset, to int64) (bool, error) {
+			return remaining(client, topic, partition, offset, to)
+		},
+	}
+}
+
+// Read hands the messages of the range consumed by the partition consumer to handle in
+// offset order, and returns the next offset to read. That is the end of the range once
+// it was read completely, an earlier offset when ctx is cancelled, the partition consumer
+// closes or handle returns an error, which Read returns.
+//
+// The range ends at the first message at or past its end, or once a fetch of the rest of
+// the range, made after no message arrived for a while, finds no message left in it.
+func (r *Reader) Read(ctx context.Context, consumer sarama.PartitionConsumer, rng Range, handle func(*sarama.ConsumerMessage) error) (int64, error) {
+	next := rng.From
+	if rng.To >= 0 && next >= rng.To {
+		return next, nil
+	}
+
+	idle := time.NewTimer(r.idleTimeout)
+	defer idle.Stop()
+	if rng.To < 0 {
+		idle.Stop()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return next, ctx.Err()
+
+		case err := <-consumer.Errors():
+			return next, fmt.Errorf("error consuming partition %d: %w", rng.Partition, err)
+
+		case message, ok := <-consumer.Messages():
+			if !ok {
+				return next, nil
+			}
+			if rng.To >= 0 && message.Offset >= rng.To {
+				return rng.To, nil
+			}
+			if err := handle(message); err != nil {
+				return next, err
+			}
+			next = message.Offset + 1
+			if rng.To >= 0 {
+				if next >= rng.To {
+					return next, nil
+				}
+				idle.Reset(r.idleTimeout)
+			}
+
+		case <-idle.C:
+			left, err := r.remaining(rng.Topic, rng.Partition, next, rng.To)
+			if err != nil {
+				slog.Warn("Failed to fetch the rest of the range, waiting for its messages",
+					"topic", rng.Topic, "partition", rng.Partition, "from", next, "to", rng.To, "error", err)
+			} else if !left {
+				slog.Info("No message left in the range, only markers, aborted or compacted records",
+					"topic", rng.Topic, "partition", rng.Partition, "from", next, "to", rng.To)
+				return rng.To, nil
+			}
+			idle.Reset(r.idleTimeout)
+		}
+	}
+}
+
+// remaining fetches [offset, to) from the leader of the partition, as the consumer of the
+// client would, and reports whether it holds a message the consumer delivers. An empty
+// fetch, e.g. of records of a transaction still open, counts as messages left.
+func remaining(client sarama.Client, topic string, partition int32, offset, to int64) (bool, error) {
+	conf := client.Config()
+	for offset < to {
+		broker, err := client.Leader(topic, partition)
+		if err != nil {
+			return false, fmt.Errorf("failed to find leader of partition %d: %w", partition, err)
+		}
+		request := fetchRequest(conf)
+		request.AddBlock(topic, partition, offset, conf.Consumer.Fetch.Default, -1)
+		response, err := broker.Fetch(request)
+		if err != nil {
+			return false, fmt.Errorf("failed to fetch partition %d: %w", partition, err)
+		}
+		block := response.GetBlock(topic, partition)
+		if block == nil {
+			return false, sarama.ErrIncompleteResponse
+		}
+		if block.Err != sarama.ErrNoError {
+			return false, fmt.Errorf("failed to fetch partition %d: %w", partition, block.Err)
+		}
+
+		found, last := scan(block, offset, to, conf.Consumer.IsolationLevel)
+		if found {
+			return true, nil
+		}
+		if last < offset {
+			return true, nil
+		}
+		offset = last + 1
+	}
+	return false, nil
+}
+
+// scan reports whether a fetched block holds a message the consumer delivers in
+// [offset, to), and returns the last offset it accounted for, less than offset if none.
+// A message at or past to accounts for every offset before it.
+func scan(block *sarama.FetchResponseBlock, offset, to int64, isolation sarama.IsolationLevel) (bool, int64) {
+	last := offset - 1
+
+	aborted := make([]*sarama.AbortedTransaction, len(block.AbortedTransactions))
+	copy(aborted, block.AbortedTransactions)
+	sort.Slice(aborted, func(i, j int) bool { return aborted[i].FirstOffset < aborted[j].FirstOffset })
+	abortedProducers := make(map[int64]bool)
+
+	for _, records := range block.RecordsSet {
+		if records.MsgSet != nil {
+			for _, message := range records.MsgSet.Messages {
+				switch {
+				case message.Offset < offset:
+				case message.Offset < to:
+					return true, last
+				case message.Msg != nil && message.Msg.Set != nil:
+					// A compressed wrapper carries the offset of its last message, the
+					// ones before may be in the range
+					return true, last
+				default:
+					return false, to - 1
+				}
+			}
+			continue
+		}
+
+		batch := records.RecordBatch
+		if batch == nil || batch.PartialTrailingRecord {
+			break
+		}
+		for len(aborted) > 0 && aborted[0].FirstOffset <= batch.LastOffset() {
+			abortedProducers[aborted[0].ProducerID] = true
+			aborted = aborted[1:]
+		}
+
+		switch {
+		case batch.Control:
+			if len(batch.Records) > 0 && controlType(batch.Records[0]) == sarama.ControlRecordAbort {
+				delete(abortedProducers, batch.ProducerID)
+			}
+		case isolation == sarama.ReadCommitted && batch.IsTransactional && abortedProducers[batch.ProducerID]:
+		default:
+			for _, record := range batch.Records {
+				recordOffset := batch.FirstOffset + record.OffsetDelta
+				if recordOffset < offset {
+					continue
+				}
+				if recordOffset < to {
+					return true, last
+				}
+				return false, to - 1
+			}
+		}
+		if batch.LastOffset() > last {
+			last = batch.LastOffset()
+		}
+	}
+	return false, last
+}
+
+// controlType decodes the type of a control record from its key, a version and a type
+func controlType(record *sarama.Record) sarama.ControlRecordType {
+	if len(record.Key) < 4 {
+		return sarama.ControlRecordUnknown
+	}
+	switch binary.BigEndian.Uint16(record.Key[2:4]) {
+	case 0:
+		return sarama.ControlRecordAbort
+	case 1:
+		return sarama.ControlRecordCommit
+	}
+	return sarama.ControlRecordUnknown
+}
+
+// fetchRequest creates a fetch request of the version the consumer of the config uses
+func fetchRequest(conf *sarama.Config) *sarama.FetchRequest {
+	request := &sarama.FetchRequest{MinBytes: 1}
+	if conf.Version.IsAtLeast(sarama.V0_9_0_0) {
+		request.Version = 1
+	}
+	if conf.Version.IsAtLeast(sarama.V0_10_0_0) {
+		request.Version = 2
+	}
+	if conf.Version.IsAtLeast(sarama.V0_10_1_0) {
+		request.Version = 3
+		request.MaxBytes = sarama.MaxResponseSize
+	}
+	if conf.Version.IsAtLeast(sarama.V0_11_0_0) {
+		request.Version = 4
+		request.Isolation = conf.Consumer.IsolationLevel
+	}
+	if conf.Version.IsAtLeast(sarama.V1_1_0_0) {
+		request.Version = 7
+		// No fetch session, the broker does not create one for session ID 0 and epoch -1
+		request.SessionID = 0
+		request.SessionEpoch = -1
+	}
+	if conf.Version.IsAtLeast(sarama.V2_1_0_0) {
+		request.Version = 10
+	}
+	return request
+}
@@ -0,0 +1,228 @@
+package partitionrange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+)
+
+// fakeRemaining answers remaining with the results in order, the last one repeated, and
+// records the offsets asked for
+type fakeRemaining struct {
+	mu      sync.Mutex
+	results []error
+	left    []bool
+	asked   []int64
+}
+
+func (f *fakeRemaining) remaining(topic string, partition int32, offset, to int64) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.asked = append(f.asked, offset)
+	i := min(len(f.asked), len(f.left)) - 1
+	return f.left[i], f.results[i]
+}
+
+func newTestReader(left []bool, results []error) (*Reader, *fakeRemaining) {
+	fake := &fakeRemaining{left: left, results: results}
+	return &Reader{idleTimeout: 20 * time.Millisecond, remaining: fake.remaining}, fake
+}
+
+// consume returns a partition consumer of the mock consumer starting at from, with the given
+// number of messages yielded already
+func consume(t *testing.T, from int64, messages int) (*mocks.PartitionConsumer, sarama.PartitionConsumer) {
+	t.Helper()
+	consumer := mocks.NewConsumer(t, nil)
+	t.Cleanup(func() { consumer.Close() })
+	expectation := consumer.ExpectConsumePartition("cdc", 0, from)
+	for i := 0; i < messages; i++ {
+		expectation.YieldMessage(&sarama.ConsumerMessage{Value: []byte("value")})
+	}
+	pc, err := consumer.ConsumePartition("cdc", 0, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return expectation, pc
+}
+
+// read reads the range and returns the next offset and the offsets handled
+func read(t *testing.T, reader *Reader, ctx context.Context, pc sarama.PartitionConsumer, rng Range) (int64, []int64, error) {
+	t.Helper()
+	var handled []int64
+	type result struct {
+		next int64
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		next, err := reader.Read(ctx, pc, rng, func(message *sarama.ConsumerMessage) error {
+			handled = append(handled, message.Offset)
+			return nil
+		})
+		done <- result{next, err}
+	}()
+	select {
+	case r := <-done:
+		return r.next, handled, r.err
+	case <-time.After(10 * time.Second):
+		t.Fatal("the read did not end")
+		return 0, nil, nil
+	}
+}
+
+func TestReadStopsAtEndOfRange(t *testing.T) {
+	reader, fake := newTestReader([]bool{true}, []error{nil})
+	_, pc := consume(t, 0, 3)
+
+	next, handled, err := read(t, reader, context.Background(), pc, Range{Topic: "cdc", From: 0, To: 2})
+	if err != nil || next != 2 || fmt.Sprint(handled) != "[0 1]" {
+		t.Fatalf("read %v up to %d (%v), want [0 1] up to 2", handled, next, err)
+	}
+	if len(fake.asked) != 0 {
+		t.Fatalf("fetched the rest of a range read completely from %v", fake.asked)
+	}
+}
+
+func TestReadEndsWhenNoMessageIsLeft(t *testing.T) {
+	// Offset 2 is a transaction marker, which is never delivered
+	reader, fake := newTestReader([]bool{false}, []error{nil})
+	_, pc := consume(t, 0, 2)
+
+	next, handled, err := read(t, reader, context.Background(), pc, Range{Topic: "cdc", From: 0, To: 3})
+	if err != nil || next != 3 || len(handled) != 2 {
+		t.Fatalf("read %v up to %d (%v), want 2 messages up to 3", handled, next, err)
+	}
+	if fmt.Sprint(fake.asked) != "[2]" {
+		t.Fatalf("fetched the rest of the range from %v, want [2]", fake.asked)
+	}
+}
+
+func TestReadWaitsForDelayedFetch(t *testing.T) {
+	// The high water mark is at the end of the range before the first fetch, but the
+	// messages only arrive after several idle timeouts; a failed fetch also waits
+	reader, fake := newTestReader([]bool{true, true}, []error{errors.New("leader not available"), nil})
+	expectation, pc := consume(t, 0, 0)
+	go func() {
+		time.Sleep(10 * reader.idleTimeout)
+		for i := 0; i < 3; i++ {
+			expectation.YieldMessage(&sarama.ConsumerMessage{Value: []byte("value")})
+		}
+	}()
+
+	next, handled, err := read(t, reader, context.Background(), pc, Range{Topic: "cdc", From: 0, To: 3})
+	if err != nil || next != 3 || fmt.Sprint(handled) != "[0 1 2]" {
+		t.Fatalf("read %v up to %d (%v), want [0 1 2] up to 3", handled, next, err)
+	}
+	if len(fake.asked) < 2 {
+		t.Fatalf("fetched the rest of the range %d times, want it checked while waiting", len(fake.asked))
+	}
+}
+
+func TestReadStopsOnHandleError(t *testing.T) {
+	reader, _ := newTestReader([]bool{true}, []error{nil})
+	_, pc := consume(t, 5, 3)
+
+	stop := errors.New("stop")
+	next, err := reader.Read(context.Background(), pc, Range{Topic: "cdc", From: 5, To: 8}, func(message *sarama.ConsumerMessage) error {
+		if message.Offset == 6 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || next != 6 {
+		t.Fatalf("read up to %d (%v), want up to 6 with the handle error", next, err)
+	}
+}
+
+func TestReadFollowsUntilCancelled(t *testing.T) {
+	reader, fake := newTestReader([]bool{false}, []error{nil})
+	_, pc := consume(t, 0, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*reader.idleTimeout)
+	defer cancel()
+	next, handled, err := read(t, reader, ctx, pc, Range{Topic: "cdc", From: 0, To: -1})
+	if !errors.Is(err, context.DeadlineExceeded) || next != 2 || len(handled) != 2 {
+		t.Fatalf("read %v up to %d (%v), want 2 messages until cancelled", handled, next, err)
+	}
+	if len(fake.asked) != 0 {
+		t.Fatalf("fetched the rest of a range without end from %v", fake.asked)
+	}
+}
+
+// newFetchClient returns a client of a mock broker leading partition 0 of cdc, answering
+// every fetch with the response
+func newFetchClient(t *testing.T, isolation sarama.IsolationLevel, response *sarama.FetchResponse) sarama.Client {
+	t.Helper()
+	broker := sarama.NewMockBroker(t, 1)
+	t.Cleanup(broker.Close)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("cdc", 0, broker.BrokerID()),
+		"FetchRequest": sarama.NewMockWrapper(response),
+	})
+
+	conf := sarama.NewConfig()
+	conf.Version = sarama.V2_1_0_0
+	conf.Consumer.IsolationLevel = isolation
+	client, err := sarama.NewClient([]string{broker.Addr()}, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestRemaining(t *testing.T) {
+	value := sarama.StringEncoder("value")
+	tests := []struct {
+		name      string
+		isolation sarama.IsolationLevel
+		to        int64
+		fill      func(*sarama.FetchResponse)
+		want      bool
+	}{
+		{"transaction marker", sarama.ReadCommitted, 3, func(r *sarama.FetchResponse) {
+			r.AddControlRecord("cdc", 0, 2, 7, sarama.ControlRecordCommit)
+		}, false},
+		{"message", sarama.ReadCommitted, 4, func(r *sarama.FetchResponse) {
+			r.AddRecordBatch("cdc", 0, nil, value, 2, 0, false)
+		}, true},
+		{"aborted transaction", sarama.ReadCommitted, 4, func(r *sarama.FetchResponse) {
+			r.AddRecordBatch("cdc", 0, nil, value, 2, 7, true)
+			r.AddControlRecord("cdc", 0, 3, 7, sarama.ControlRecordAbort)
+			r.GetBlock("cdc", 0).AbortedTransactions = []*sarama.AbortedTransaction{{ProducerID: 7, FirstOffset: 2}}
+		}, false},
+		{"aborted transaction read uncommitted", sarama.ReadUncommitted, 4, func(r *sarama.FetchResponse) {
+			r.AddRecordBatch("cdc", 0, nil, value, 2, 7, true)
+			r.AddControlRecord("cdc", 0, 3, 7, sarama.ControlRecordAbort)
+		}, true},
+		{"compacted up to a message past the range", sarama.ReadCommitted, 4, func(r *sarama.FetchResponse) {
+			r.AddRecordBatch("cdc", 0, nil, value, 6, 0, false)
+		}, false},
+		{"nothing fetched", sarama.ReadCommitted, 4, func(r *sarama.FetchResponse) {
+			r.AddError("cdc", 0, sarama.ErrNoError)
+		}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := &sarama.FetchResponse{Version: 10}
+			test.fill(response)
+			client := newFetchClient(t, test.isolation, response)
+
+			left, err := remaining(client, "cdc", 0, 2, test.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if left != test.want {
+				t.Fatalf("messages left %t, want %t", left, test.want)
+			}
+		})
+	}
+}
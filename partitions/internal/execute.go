@@ -0,0 +1,328 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"kafka_test/admin"
+
+	"github.com/Shopify/sarama"
+)
+
+// ErrReassignmentInProgress is returned when executing while partitions of the topics are still moving
+var ErrReassignmentInProgress = errors.New("reassignment in progress")
+
+// Replication throttle configs, see the Kafka docs on throttling replication
+const (
+	leaderThrottledRate       = "leader.replication.throttled.rate"
+	followerThrottledRate     = "follower.replication.throttled.rate"
+	leaderThrottledReplicas   = "leader.replication.throttled.replicas"
+	followerThrottledReplicas = "follower.replication.throttled.replicas"
+)
+
+// Prepare checks that the partitions of the moves exist and are not moving,
+// and fills in their current replicas so they can be saved for a rollback
+func Prepare(conn *admin.Conn, moves []PartitionMove) error {
+	byTopic := groupByTopic(moves)
+	replicas := make(map[string]map[int32][]int32, len(byTopic))
+	for _, topic := range sortedTopics(byTopic) {
+		current, err := currentReplicas(conn, topic)
+		if err != nil {
+			return err
+		}
+		replicas[topic] = current
+
+		ids := make([]int32, 0, len(current))
+		for id := range current {
+			ids = append(ids, id)
+		}
+		inProgress, err := conn.Admin.ListPartitionReassignments(topic, ids)
+		if err != nil {
+			return fmt.Errorf("failed to list reassignments of %s: %w", topic, err)
+		}
+		if len(inProgress[topic]) > 0 {
+			return fmt.Errorf("%w: %d partition(s) of %s are moving, wait for them with status", ErrReassignmentInProgress, len(inProgress[topic]), topic)
+		}
+	}
+
+	for i := range moves {
+		current, ok := replicas[moves[i].Topic][moves[i].Partition]
+		if !ok {
+			return fmt.Errorf("partition %s/%d does not exist", moves[i].Topic, moves[i].Partition)
+		}
+		moves[i].Current = current
+	}
+	return nil
+}
+
+// Execute starts moving the prepared partitions to their proposed replicas.
+// With a throttle in bytes per second, replication of the moving partitions
+// is limited on every broker involved until the throttle is cleared.
+func Execute(conn *admin.Conn, moves []PartitionMove, throttle int64) error {
+	if throttle > 0 {
+		if err := setThrottle(conn, moves, throttle); err != nil {
+			return err
+		}
+	}
+
+	byTopic := groupByTopic(moves)
+	for _, topic := range sortedTopics(byTopic) {
+		current, err := currentReplicas(conn, topic)
+		if err != nil {
+			return err
+		}
+
+		// The admin API reassigns every partition of a topic, partitions that
+		// do not move keep their current replicas, which Kafka treats as a no-op
+		assignment := make([][]int32, len(current))
+		for partition, replicas := range current {
+			assignment[partition] = replicas
+		}
+		for _, move := range byTopic[topic] {
+			assignment[move.Partition] = move.Proposed
+		}
+		if err := conn.Admin.AlterPartitionReassignments(topic, assignment); err != nil {
+			return fmt.Errorf("failed to reassign partitions of %s: %w", topic, err)
+		}
+		log.Printf("Started reassignment of %d partition(s) of %s", len(byTopic[topic]), topic)
+	}
+	return nil
+}
+
+// currentReplicas returns the replicas of every partition of a topic
+func currentReplicas(conn *admin.Conn, topic string) (map[int32][]int32, error) {
+	partitions, err := describePartitions(conn, []string{topic})
+	if err != nil {
+		return nil, err
+	}
+	replicas := make(map[int32][]int32, len(partitions))
+	for _, partition := range partitions {
+		if partition.Topic == topic {
+			replicas[partition.Partition] = partition.Current
+		}
+	}
+	return replicas, nil
+}
+
+// setThrottle limits the replication rate of the brokers involved in the moves
+// and marks the replicas that send and receive data as throttled
+func setThrottle(conn *admin.Conn, moves []PartitionMove, throttle int64) error {
+	rate := strconv.FormatInt(throttle, 10)
+	for _, id := range involvedBrokers(moves) {
+		entries := map[string]sarama.IncrementalAlterConfigsEntry{
+			leaderThrottledRate:   {Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &rate},
+			followerThrottledRate: {Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &rate},
+		}
+		if err := conn.Admin.IncrementalAlterConfig(sarama.BrokerResource, strconv.Itoa(int(id)), entries, false); err != nil {
+			return fmt.Errorf("failed to set replication throttle on broker %d: %w", id, err)
+		}
+	}
+
+	byTopic := groupByTopic(moves)
+	for _, topic := range sortedTopics(byTopic) {
+		var leaders, followers []string
+		for _, move := range byTopic[topic] {
+			for _, id := range move.Current {
+				leaders = append(leaders, fmt.Sprintf("%d:%d", move.Partition, id))
+			}
+			for _, id := range move.Proposed {
+				if !slices.Contains(move.Current, id) {
+					followers = append(followers, fmt.Sprintf("%d:%d", move.Partition, id))
+				}
+			}
+		}
+		leaderReplicas, followerReplicas := strings.Join(leaders, ","), strings.Join(followers, ",")
+		entries := map[string]sarama.IncrementalAlterConfigsEntry{
+			leaderThrottledReplicas:   {Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &leaderReplicas},
+			followerThrottledReplicas: {Operation: sarama.IncrementalAlterConfigsOperationSet, Value: &followerReplicas},
+		}
+		if err := conn.Admin.IncrementalAlterConfig(sarama.TopicResource, topic, entries, false); err != nil {
+			return fmt.Errorf("failed to set throttled replicas of %s: %w", topic, err)
+		}
+	}
+	log.Printf("Throttled replication to %s bytes/s on brokers %s", rate, admin.JoinInt32(involvedBrokers(moves)))
+	return nil
+}
+
+// ClearThrottle removes the replication throttle set by Execute from the
+// topics of the moves and from every broker
+func ClearThrottle(conn *admin.Conn, moves []PartitionMove) error {
+	brokers := conn.Client.Brokers()
+	sort.Slice(brokers, func(i, j int) bool { return brokers[i].ID() < brokers[j].ID() })
+	for _, broker := range brokers {
+		entries := map[string]sarama.IncrementalAlterConfigsEntry{
+			leaderThrottledRate:   {Operation: sarama.IncrementalAlterConfigsOperationDelete},
+			followerThrottledRate: {Operation: sarama.IncrementalAlterConfigsOperationDelete},
+		}
+		if err := conn.Admin.IncrementalAlterConfig(sarama.BrokerResource, strconv.Itoa(int(broker.ID())), entries, false); err != nil {
+			return fmt.Errorf("failed to clear replication throttle on broker %d: %w", broker.ID(), err)
+		}
+	}
+	for _, topic := range sortedTopics(groupByTopic(moves)) {
+		entries := map[string]sarama.IncrementalAlterConfigsEntry{
+			leaderThrottledReplicas:   {Operation: sarama.IncrementalAlterConfigsOperationDelete},
+			followerThrottledReplicas: {Operation: sarama.IncrementalAlterConfigsOperationDelete},
+		}
+		if err := conn.Admin.IncrementalAlterConfig(sarama.TopicResource, topic, entries, false); err != nil {
+			return fmt.Errorf("failed to clear throttled replicas of %s: %w", topic, err)
+		}
+	}
+	return nil
+}
+
+// Reassignment states
+const (
+	StateDone       = "done"
+	StateInProgress = "in progress"
+	// StateDiffers means nothing is moving but the replicas are not the proposed ones,
+	// the reassignment was not started, was cancelled or was replaced by another one
+	StateDiffers = "differs"
+)
+
+// PartitionStatus is the progress of one partition of a reassignment
+type PartitionStatus struct {
+	Topic     string  `json:"topic" yaml:"topic"`
+	Partition int32   `json:"partition" yaml:"partition"`
+	Target    []int32 `json:"target" yaml:"target"`
+	Replicas  []int32 `json:"replicas" yaml:"replicas"`
+	Adding    []int32 `json:"adding,omitempty" yaml:"adding,omitempty"`
+	Removing  []int32 `json:"removing,omitempty" yaml:"removing,omitempty"`
+	State     string  `json:"state" yaml:"state"`
+}
+
+// ReassignmentStatus is the progress of a reassignment
+type ReassignmentStatus struct {
+	Partitions      []PartitionStatus `json:"partitions" yaml:"partitions"`
+	Done            bool              `json:"done" yaml:"done"`
+	ThrottleCleared bool              `json:"throttle_cleared" yaml:"throttle_cleared"`
+}
+
+// Status compares the replicas of the moved partitions with their targets
+func Status(conn *admin.Conn, moves []PartitionMove) (*ReassignmentStatus, error) {
+	byTopic := groupByTopic(moves)
+	status := &ReassignmentStatus{Done: true}
+	for _, topic := range sortedTopics(byTopic) {
+		replicas, err := currentReplicas(conn, topic)
+		if err != nil {
+			return nil, err
+		}
+
+		ids := make([]int32, 0, len(byTopic[topic]))
+		for _, move := range byTopic[topic] {
+			ids = append(ids, move.Partition)
+		}
+		inProgress, err := conn.Admin.ListPartitionReassignments(topic, ids)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list reassignments of %s: %w", topic, err)
+		}
+
+		for _, move := range byTopic[topic] {
+			partition := PartitionStatus{
+				Topic:     topic,
+				Partition: move.Partition,
+				Target:    move.Proposed,
+				Replicas:  replicas[move.Partition],
+				State:     StateDone,
+			}
+			if progress, ok := inProgress[topic][move.Partition]; ok {
+				partition.Replicas = progress.Replicas
+				partition.Adding = progress.AddingReplicas
+				partition.Removing = progress.RemovingReplicas
+				partition.State = StateInProgress
+			} else if !slices.Equal(partition.Replicas, move.Proposed) {
+				partition.State = StateDiffers
+			}
+			if partition.State != StateDone {
+				status.Done = false
+			}
+			status.Partitions = append(status.Partitions, partition)
+		}
+	}
+	return status, nil
+}
+
+// WaitForReassignment polls Status until no partition is in progress, reporting
+// every poll. It returns the last status.
+func WaitForReassignment(ctx context.Context, conn *admin.Conn, moves []PartitionMove, interval time.Duration, report func(*ReassignmentStatus) error) (*ReassignmentStatus, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status, err := Status(conn, moves)
+		if err != nil {
+			return nil, err
+		}
+		if err := report(status); err != nil {
+			return nil, err
+		}
+		if !status.InProgress() {
+			return status, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// InProgress reports whether any partition is still moving
+func (s *ReassignmentStatus) InProgress() bool {
+	for _, partition := range s.Partitions {
+		if partition.State == StateInProgress {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteTable prints one line per partition
+func (s *ReassignmentStatus) WriteTable(tw *tabwriter.Writer) {
+	admin.Row(tw, "TOPIC", "PARTITION", "TARGET", "REPLICAS", "ADDING", "REMOVING", "STATE")
+	for _, partition := range s.Partitions {
+		admin.Row(tw, partition.Topic, partition.Partition, admin.JoinInt32(partition.Target), admin.JoinInt32(partition.Replicas),
+			admin.JoinInt32(partition.Adding), admin.JoinInt32(partition.Removing), partition.State)
+	}
+	if s.ThrottleCleared {
+		fmt.Fprintln(tw, "\nReassignment complete, replication throttle removed.")
+	}
+}
+
+func groupByTopic(moves []PartitionMove) map[string][]PartitionMove {
+	byTopic := make(map[string][]PartitionMove)
+	for _, move := range moves {
+		byTopic[move.Topic] = append(byTopic[move.Topic], move)
+	}
+	return byTopic
+}
+
+func sortedTopics(byTopic map[string][]PartitionMove) []string {
+	topics := make([]string, 0, len(byTopic))
+	for topic := range byTopic {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// involvedBrokers returns the brokers holding a current or proposed replica of the moves
+func involvedBrokers(moves []PartitionMove) []int32 {
+	var ids []int32
+	for _, move := range moves {
+		for _, id := range append(slices.Clone(move.Current), move.Proposed...) {
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
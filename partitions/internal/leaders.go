@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"text/tabwriter"
+
+	"kafka_test/admin"
+
+	"github.com/Shopify/sarama"
+)
+
+// LeaderInfo is a partition whose leader is not its preferred replica
+type LeaderInfo struct {
+	Topic     string  `json:"topic" yaml:"topic"`
+	Partition int32   `json:"partition" yaml:"partition"`
+	Leader    int32   `json:"leader" yaml:"leader"`
+	Preferred int32   `json:"preferred" yaml:"preferred"`
+	Replicas  []int32 `json:"replicas" yaml:"replicas"`
+	ISR       []int32 `json:"isr" yaml:"isr"`
+	// Electable is false when the preferred replica is out of sync, an election would fail
+	Electable bool `json:"electable" yaml:"electable"`
+}
+
+// LeaderReport lists the partitions not led by their preferred replica
+type LeaderReport struct {
+	Partitions []LeaderInfo `json:"partitions" yaml:"partitions"`
+}
+
+// PreferredLeaders returns the partitions of the topics, or of every topic,
+// whose leader is not the first of their replicas
+func PreferredLeaders(conn *admin.Conn, topics []string) (*LeaderReport, error) {
+	if len(topics) == 0 {
+		all, err := conn.Admin.ListTopics()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list topics: %w", err)
+		}
+		for name := range all {
+			topics = append(topics, name)
+		}
+	}
+
+	metadata, err := conn.Admin.DescribeTopics(topics)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe topics: %w", err)
+	}
+
+	report := &LeaderReport{Partitions: []LeaderInfo{}}
+	for _, topic := range metadata {
+		if topic.Err != sarama.ErrNoError {
+			return nil, fmt.Errorf("failed to describe topic %s: %w", topic.Name, topic.Err)
+		}
+		for _, partition := range topic.Partitions {
+			if len(partition.Replicas) == 0 || partition.Leader == partition.Replicas[0] {
+				continue
+			}
+			report.Partitions = append(report.Partitions, LeaderInfo{
+				Topic:     topic.Name,
+				Partition: partition.ID,
+				Leader:    partition.Leader,
+				Preferred: partition.Replicas[0],
+				Replicas:  partition.Replicas,
+				ISR:       partition.Isr,
+				Electable: slices.Contains(partition.Isr, partition.Replicas[0]),
+			})
+		}
+	}
+	sort.Slice(report.Partitions, func(i, j int) bool {
+		a, b := report.Partitions[i], report.Partitions[j]
+		if a.Topic != b.Topic {
+			return a.Topic < b.Topic
+		}
+		return a.Partition < b.Partition
+	})
+	return report, nil
+}
+
+// electionFile is the JSON format of kafka-leader-election.sh --path-to-json-file
+type electionFile struct {
+	Partitions []electionPartition `json:"partitions"`
+}
+
+type electionPartition struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+}
+
+// WriteElectionFile saves the electable partitions for kafka-leader-election.sh.
+// The ElectLeaders API is missing from the sarama version this project uses, so
+// the election itself is left to that tool.
+func (r *LeaderReport) WriteElectionFile(path string) error {
+	file := electionFile{Partitions: []electionPartition{}}
+	for _, partition := range r.Partitions {
+		if partition.Electable {
+			file.Partitions = append(file.Partitions, electionPartition{Topic: partition.Topic, Partition: partition.Partition})
+		}
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode election file: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write election file: %w", err)
+	}
+	return nil
+}
+
+// WriteTable prints one line per partition
+func (r *LeaderReport) WriteTable(tw *tabwriter.Writer) {
+	if len(r.Partitions) == 0 {
+		fmt.Fprintln(tw, "Every partition is led by its preferred replica.")
+		return
+	}
+	admin.Row(tw, "TOPIC", "PARTITION", "LEADER", "PREFERRED", "REPLICAS", "ISR", "ELECTABLE")
+	for _, partition := range r.Partitions {
+		electable := "yes"
+		if !partition.Electable {
+			electable = "no, preferred replica out of sync"
+		}
+		admin.Row(tw, partition.Topic, partition.Partition, partition.Leader, partition.Preferred,
+			admin.JoinInt32(partition.Replicas), admin.JoinInt32(partition.ISR), electable)
+	}
+}
@@ -0,0 +1,441 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"text/tabwriter"
+
+	"kafka_test/admin"
+
+	"github.com/Shopify/sarama"
+)
+
+// BrokerLoad is the number of replicas and leaders of the planned topics on one broker
+type BrokerLoad struct {
+	ID             int32  `json:"id" yaml:"id"`
+	Rack           string `json:"rack,omitempty" yaml:"rack,omitempty"`
+	ReplicasBefore int    `json:"replicas_before" yaml:"replicas_before"`
+	ReplicasAfter  int    `json:"replicas_after" yaml:"replicas_after"`
+	LeadersBefore  int    `json:"leaders_before" yaml:"leaders_before"`
+	LeadersAfter   int    `json:"leaders_after" yaml:"leaders_after"`
+}
+
+// PartitionMove is the current and proposed replicas of a partition.
+// The first proposed replica is the preferred leader.
+type PartitionMove struct {
+	Topic     string  `json:"topic" yaml:"topic"`
+	Partition int32   `json:"partition" yaml:"partition"`
+	Current   []int32 `json:"current" yaml:"current"`
+	Proposed  []int32 `json:"proposed" yaml:"proposed"`
+	Moved     bool    `json:"moved" yaml:"moved"`
+}
+
+// ReassignmentPlan is a balanced assignment of the replicas of some topics
+type ReassignmentPlan struct {
+	RackAware  bool            `json:"rack_aware" yaml:"rack_aware"`
+	Brokers    []BrokerLoad    `json:"brokers" yaml:"brokers"`
+	Partitions []PartitionMove `json:"partitions" yaml:"partitions"`
+}
+
+// Moves returns the partitions whose replicas change
+func (p *ReassignmentPlan) Moves() []PartitionMove {
+	var moves []PartitionMove
+	for _, partition := range p.Partitions {
+		if partition.Moved {
+			moves = append(moves, partition)
+		}
+	}
+	return moves
+}
+
+// GeneratePlan spreads the replicas and preferred leaders of the topics evenly
+// over the brokers, keeping the replication factor of every partition and
+// moving as few replicas as possible. When every broker has a rack, the
+// replicas of a partition are put on as many racks as possible.
+// Only the replicas of the given topics are balanced, other topics are ignored.
+func GeneratePlan(conn *admin.Conn, topics []string, brokerIDs []int32) (*ReassignmentPlan, error) {
+	brokers, err := selectBrokers(conn, brokerIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := describePartitions(conn, topics)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &ReassignmentPlan{RackAware: true}
+	for _, broker := range brokers {
+		plan.Brokers = append(plan.Brokers, BrokerLoad{ID: broker.ID(), Rack: broker.Rack()})
+		if broker.Rack() == "" {
+			plan.RackAware = false
+		}
+	}
+	if err := plan.balance(current); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// balance assigns the replicas of the partitions to the brokers of the plan.
+// Starting from the current assignment it moves replicas off brokers that were
+// not selected, spreads the replicas of each partition over as many racks as
+// possible, then moves one replica at a time from the most to the least loaded
+// broker until their replica counts differ by at most one. Finally it balances
+// the preferred leaders by reordering the replicas of partitions.
+func (p *ReassignmentPlan) balance(current []PartitionMove) error {
+	load := make(map[int32]*BrokerLoad, len(p.Brokers))
+	racks := make(map[string]bool)
+	for i := range p.Brokers {
+		load[p.Brokers[i].ID] = &p.Brokers[i]
+		racks[p.Brokers[i].Rack] = true
+	}
+
+	assignments := make([][]int32, len(current))
+	for i, partition := range current {
+		if len(partition.Current) > len(p.Brokers) {
+			return fmt.Errorf("%s/%d has %d replicas but only %d brokers were selected",
+				partition.Topic, partition.Partition, len(partition.Current), len(p.Brokers))
+		}
+		assignments[i] = slices.Clone(partition.Current)
+		for _, id := range partition.Current {
+			if broker, ok := load[id]; ok {
+				broker.ReplicasBefore++
+				broker.ReplicasAfter++
+			}
+		}
+		if broker, ok := load[partition.Current[0]]; ok {
+			broker.LeadersBefore++
+		}
+	}
+
+	b := &balancer{load: load, brokers: p.Brokers, rackAware: p.RackAware}
+	for _, replicas := range assignments {
+		for j, id := range replicas {
+			if _, ok := load[id]; !ok {
+				b.replace(replicas, j, b.leastLoaded(replicas, j))
+			}
+		}
+		if p.RackAware {
+			for spread(replicas, load) < min(len(replicas), len(racks)) {
+				j := duplicateRack(replicas, load)
+				b.replace(replicas, j, b.leastLoaded(replicas, j))
+			}
+		}
+	}
+	for b.moveReplica(assignments) {
+	}
+
+	for _, replicas := range assignments {
+		load[replicas[0]].LeadersAfter++
+	}
+	for b.moveLeader(assignments) {
+	}
+
+	for i, partition := range current {
+		partition.Proposed = assignments[i]
+		partition.Moved = !slices.Equal(partition.Current, partition.Proposed)
+		p.Partitions = append(p.Partitions, partition)
+	}
+	return nil
+}
+
+// balancer moves replicas between the brokers of a plan, keeping their load up to date
+type balancer struct {
+	load      map[int32]*BrokerLoad
+	brokers   []BrokerLoad
+	rackAware bool
+}
+
+// replace puts broker id in place of replica j
+func (b *balancer) replace(replicas []int32, j int, id int32) {
+	if old, ok := b.load[replicas[j]]; ok {
+		old.ReplicasAfter--
+	}
+	b.load[id].ReplicasAfter++
+	replicas[j] = id
+}
+
+// allowed reports whether broker id can replace replica j without putting
+// the partition on fewer racks
+func (b *balancer) allowed(replicas []int32, j int, id int32) bool {
+	if slices.Contains(replicas, id) {
+		return false
+	}
+	if !b.rackAware {
+		return true
+	}
+	replaced := slices.Clone(replicas)
+	replaced[j] = id
+	return spread(replaced, b.load) >= spread(replicas, b.load)
+}
+
+// leastLoaded returns the broker with the fewest replicas that can replace replica j,
+// preferring brokers that put the partition on as many racks as possible, so that a
+// replica moved off a deselected broker does not need a second move to spread the racks
+func (b *balancer) leastLoaded(replicas []int32, j int) int32 {
+	return pickBroker(b.brokers, func(broker BrokerLoad) []int {
+		if slices.Contains(replicas, broker.ID) {
+			return nil
+		}
+		replaced := slices.Clone(replicas)
+		replaced[j] = broker.ID
+		return []int{boolScore(!b.allowed(replicas, j, broker.ID)), -spread(replaced, b.load), b.load[broker.ID].ReplicasAfter}
+	})
+}
+
+// moveReplica moves one replica from a broker with more replicas to one with at
+// least two fewer, and reports whether it found such a move
+func (b *balancer) moveReplica(assignments [][]int32) bool {
+	byLoad := slices.Clone(b.brokers)
+	sort.SliceStable(byLoad, func(i, j int) bool {
+		return b.load[byLoad[i].ID].ReplicasAfter > b.load[byLoad[j].ID].ReplicasAfter
+	})
+	for _, from := range byLoad {
+		for k := len(byLoad) - 1; k >= 0; k-- {
+			to := byLoad[k]
+			if b.load[from.ID].ReplicasAfter-b.load[to.ID].ReplicasAfter <= 1 {
+				break
+			}
+			for _, replicas := range assignments {
+				j := slices.Index(replicas, from.ID)
+				if j >= 0 && b.allowed(replicas, j, to.ID) {
+					b.replace(replicas, j, to.ID)
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// moveLeader makes another replica the preferred leader of a partition whose
+// leader has at least two more leaders than that replica, and reports whether it did
+func (b *balancer) moveLeader(assignments [][]int32) bool {
+	for _, replicas := range assignments {
+		leader := b.load[replicas[0]]
+		for j := 1; j < len(replicas); j++ {
+			follower := b.load[replicas[j]]
+			if leader.LeadersAfter-follower.LeadersAfter > 1 {
+				leader.LeadersAfter--
+				follower.LeadersAfter++
+				replicas[0], replicas[j] = replicas[j], replicas[0]
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// spread returns the number of racks the replicas are on
+func spread(replicas []int32, load map[int32]*BrokerLoad) int {
+	racks := make(map[string]bool, len(replicas))
+	for _, id := range replicas {
+		if broker, ok := load[id]; ok {
+			racks[broker.Rack] = true
+		}
+	}
+	return len(racks)
+}
+
+// duplicateRack returns the last replica on a rack that holds an earlier replica
+func duplicateRack(replicas []int32, load map[int32]*BrokerLoad) int {
+	seen := make(map[string]bool, len(replicas))
+	duplicate := len(replicas) - 1
+	for j, id := range replicas {
+		if seen[load[id].Rack] {
+			duplicate = j
+		}
+		seen[load[id].Rack] = true
+	}
+	return duplicate
+}
+
+// pickBroker returns the broker with the lowest score, compared element by element.
+// Brokers with a nil score are skipped. Ties go to the lowest broker ID.
+func pickBroker(brokers []BrokerLoad, score func(BrokerLoad) []int) int32 {
+	best, bestScore := int32(-1), []int(nil)
+	for _, broker := range brokers {
+		s := score(broker)
+		if s == nil {
+			continue
+		}
+		if bestScore == nil || slices.Compare(s, bestScore) < 0 {
+			best, bestScore = broker.ID, s
+		}
+	}
+	return best
+}
+
+func boolScore(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// selectBrokers returns the brokers with the given IDs, or every broker, sorted by ID
+func selectBrokers(conn *admin.Conn, ids []int32) ([]*sarama.Broker, error) {
+	if err := conn.Client.RefreshMetadata(); err != nil {
+		return nil, fmt.Errorf("failed to refresh metadata: %w", err)
+	}
+	all := conn.Client.Brokers()
+	byID := make(map[int32]*sarama.Broker, len(all))
+	for _, broker := range all {
+		byID[broker.ID()] = broker
+	}
+
+	var brokers []*sarama.Broker
+	if len(ids) == 0 {
+		brokers = all
+	}
+	for _, id := range ids {
+		broker, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("broker %d is not in the cluster", id)
+		}
+		brokers = append(brokers, broker)
+	}
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("no brokers found")
+	}
+	sort.Slice(brokers, func(i, j int) bool { return brokers[i].ID() < brokers[j].ID() })
+	return brokers, nil
+}
+
+// describePartitions returns the current replicas of every partition of the topics, sorted
+func describePartitions(conn *admin.Conn, topics []string) ([]PartitionMove, error) {
+	metadata, err := conn.Admin.DescribeTopics(topics)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe topics: %w", err)
+	}
+
+	var partitions []PartitionMove
+	for _, topic := range metadata {
+		if topic.Err != sarama.ErrNoError {
+			return nil, fmt.Errorf("failed to describe topic %s: %w", topic.Name, topic.Err)
+		}
+		for _, partition := range topic.Partitions {
+			if len(partition.Replicas) == 0 {
+				return nil, fmt.Errorf("%s/%d has no replicas in the metadata", topic.Name, partition.ID)
+			}
+			partitions = append(partitions, PartitionMove{
+				Topic:     topic.Name,
+				Partition: partition.ID,
+				Current:   partition.Replicas,
+			})
+		}
+	}
+	sort.Slice(partitions, func(i, j int) bool {
+		if partitions[i].Topic != partitions[j].Topic {
+			return partitions[i].Topic < partitions[j].Topic
+		}
+		return partitions[i].Partition < partitions[j].Partition
+	})
+	return partitions, nil
+}
+
+// reassignmentFile is the JSON format of kafka-reassign-partitions.sh
+type reassignmentFile struct {
+	Version    int                     `json:"version"`
+	Partitions []reassignmentPartition `json:"partitions"`
+}
+
+type reassignmentPartition struct {
+	Topic     string  `json:"topic"`
+	Partition int32   `json:"partition"`
+	Replicas  []int32 `json:"replicas"`
+}
+
+// WriteReassignmentFile saves the replicas of the partitions in the format of
+// kafka-reassign-partitions.sh; proposed selects the proposed or the current replicas
+func WriteReassignmentFile(path string, partitions []PartitionMove, proposed bool) error {
+	file := reassignmentFile{Version: 1, Partitions: []reassignmentPartition{}}
+	for _, partition := range partitions {
+		replicas := partition.Current
+		if proposed {
+			replicas = partition.Proposed
+		}
+		file.Partitions = append(file.Partitions, reassignmentPartition{
+			Topic:     partition.Topic,
+			Partition: partition.Partition,
+			Replicas:  replicas,
+		})
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode reassignment: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write reassignment file: %w", err)
+	}
+	return nil
+}
+
+// LoadReassignmentFile reads a reassignment written by plan or by kafka-reassign-partitions.sh
+func LoadReassignmentFile(path string) ([]PartitionMove, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reassignment file: %w", err)
+	}
+
+	var file reassignmentFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse reassignment file %s: %w", path, err)
+	}
+	if len(file.Partitions) == 0 {
+		return nil, fmt.Errorf("reassignment file %s has no partitions", path)
+	}
+
+	moves := make([]PartitionMove, 0, len(file.Partitions))
+	for _, partition := range file.Partitions {
+		if partition.Topic == "" || len(partition.Replicas) == 0 {
+			return nil, fmt.Errorf("reassignment file %s: every partition needs a topic and replicas", path)
+		}
+		moves = append(moves, PartitionMove{
+			Topic:     partition.Topic,
+			Partition: partition.Partition,
+			Proposed:  partition.Replicas,
+			Moved:     true,
+		})
+	}
+	return moves, nil
+}
+
+// WriteTable prints the load of every broker and the partitions that move
+func (p *ReassignmentPlan) WriteTable(tw *tabwriter.Writer) {
+	rackAware := "no, some brokers have no rack"
+	if p.RackAware {
+		rackAware = "yes"
+	}
+	fmt.Fprintf(tw, "Rack aware: %s\n\n", rackAware)
+
+	admin.Row(tw, "BROKER", "RACK", "REPLICAS", "PREFERRED LEADERS")
+	for _, broker := range p.Brokers {
+		admin.Row(tw, broker.ID, orDash(broker.Rack),
+			fmt.Sprintf("%d -> %d", broker.ReplicasBefore, broker.ReplicasAfter),
+			fmt.Sprintf("%d -> %d", broker.LeadersBefore, broker.LeadersAfter))
+	}
+
+	moves := p.Moves()
+	fmt.Fprintln(tw)
+	if len(moves) == 0 {
+		fmt.Fprintln(tw, "No partitions move. The topics are balanced.")
+		return
+	}
+	admin.Row(tw, "TOPIC", "PARTITION", "CURRENT", "PROPOSED")
+	for _, move := range moves {
+		admin.Row(tw, move.Topic, move.Partition, admin.JoinInt32(move.Current), admin.JoinInt32(move.Proposed))
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+)
+
+// threeBrokers returns brokers 1 and 2 on rack a and broker 3 on rack b
+func threeBrokers() []BrokerLoad {
+	return []BrokerLoad{{ID: 1, Rack: "a"}, {ID: 2, Rack: "a"}, {ID: 3, Rack: "b"}}
+}
+
+func partitionsOf(assignments ...[]int32) []PartitionMove {
+	partitions := make([]PartitionMove, len(assignments))
+	for i, replicas := range assignments {
+		partitions[i] = PartitionMove{Topic: "cdc", Partition: int32(i), Current: replicas}
+	}
+	return partitions
+}
+
+// replicaMoves counts the replicas that move to another broker, reordering is not a move
+func replicaMoves(partitions []PartitionMove) int {
+	moves := 0
+	for _, partition := range partitions {
+		for _, id := range partition.Proposed {
+			if !slices.Contains(partition.Current, id) {
+				moves++
+			}
+		}
+	}
+	return moves
+}
+
+func TestBalance(t *testing.T) {
+	tests := []struct {
+		name    string
+		current []PartitionMove
+		moves   int
+		moved   int
+	}{
+		{
+			// Broker 4 is not selected; its replicas move without a second move to
+			// spread the racks, and broker 3 keeps every partition as the only broker of rack b
+			name:    "deselected broker",
+			current: partitionsOf([]int32{4, 1}, []int32{4, 3}, []int32{1, 3}, []int32{2, 3}),
+			moves:   2,
+			moved:   2,
+		},
+		{
+			name:    "replication factor 3",
+			current: partitionsOf([]int32{1, 2, 3}, []int32{1, 2, 3}, []int32{1, 2, 3}),
+			moves:   0,
+			moved:   2,
+		},
+		{
+			name:    "already balanced",
+			current: partitionsOf([]int32{1, 3}, []int32{3, 2}, []int32{2, 3}, []int32{3, 1}),
+			moves:   0,
+			moved:   0,
+		},
+		{
+			// Every partition needs broker 3 to be on both racks
+			name:    "one rack",
+			current: partitionsOf([]int32{1, 2}, []int32{2, 1}, []int32{1, 2}, []int32{2, 1}),
+			moves:   4,
+			moved:   4,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plan := &ReassignmentPlan{RackAware: true, Brokers: threeBrokers()}
+			if err := plan.balance(test.current); err != nil {
+				t.Fatal(err)
+			}
+
+			replicas := make(map[int32]int)
+			leaders := make(map[int32]int)
+			for _, partition := range plan.Partitions {
+				proposed := partition.Proposed
+				if len(proposed) != len(partition.Current) {
+					t.Fatalf("%d: replication factor %d became %d", partition.Partition, len(partition.Current), len(proposed))
+				}
+				racks := make(map[string]bool)
+				for _, id := range proposed {
+					if id < 1 || id > 3 {
+						t.Fatalf("%d: replica on broker %d, which is not selected", partition.Partition, id)
+					}
+					racks[plan.Brokers[id-1].Rack] = true
+					replicas[id]++
+				}
+				if len(racks) != min(len(proposed), 2) {
+					t.Fatalf("%d: replicas %v on %d racks", partition.Partition, proposed, len(racks))
+				}
+				if partition.Moved != !slices.Equal(partition.Current, proposed) {
+					t.Fatalf("%d: moved %t for %v -> %v", partition.Partition, partition.Moved, partition.Current, proposed)
+				}
+				leaders[proposed[0]]++
+			}
+
+			if moves := replicaMoves(plan.Partitions); moves != test.moves {
+				t.Fatalf("%d replicas moved, want %d", moves, test.moves)
+			}
+			if moved := len(plan.Moves()); moved != test.moved {
+				t.Fatalf("%d partitions changed, want %d", moved, test.moved)
+			}
+			for _, broker := range plan.Brokers {
+				if broker.ReplicasAfter != replicas[broker.ID] || broker.LeadersAfter != leaders[broker.ID] {
+					t.Fatalf("broker %d load %d/%d, counted %d/%d", broker.ID,
+						broker.ReplicasAfter, broker.LeadersAfter, replicas[broker.ID], leaders[broker.ID])
+				}
+			}
+			counts := []int{leaders[1], leaders[2], leaders[3]}
+			if slices.Max(counts)-slices.Min(counts) > 1 {
+				t.Fatalf("preferred leaders %v not balanced", counts)
+			}
+		})
+	}
+}
+
+func TestBalanceWithoutRacks(t *testing.T) {
+	plan := &ReassignmentPlan{Brokers: []BrokerLoad{{ID: 1}, {ID: 2}, {ID: 3}}}
+	current := partitionsOf([]int32{1, 2}, []int32{2, 1}, []int32{1, 2}, []int32{2, 1}, []int32{1, 2}, []int32{2, 1})
+	if err := plan.balance(current); err != nil {
+		t.Fatal(err)
+	}
+	for _, broker := range plan.Brokers {
+		if broker.ReplicasAfter != 4 || broker.LeadersAfter != 2 {
+			t.Fatalf("broker %d has %d replicas and %d leaders, want 4 and 2", broker.ID, broker.ReplicasAfter, broker.LeadersAfter)
+		}
+	}
+	// Broker 3 takes 4 replicas, each moved once
+	if moves := replicaMoves(plan.Partitions); moves != 4 {
+		t.Fatalf("%d replicas moved, want 4", moves)
+	}
+}
+
+func TestBalanceNeedsEnoughBrokers(t *testing.T) {
+	plan := &ReassignmentPlan{Brokers: []BrokerLoad{{ID: 1}, {ID: 2}}}
+	if err := plan.balance(partitionsOf([]int32{1, 2, 3})); err == nil {
+		t.Fatal("replication factor 3 planned on 2 brokers")
+	}
+}
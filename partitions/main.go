@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"kafka_test/admin"
+	"kafka_test/partitions/internal"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: partitions <command> [flags]
+
+Commands:
+  plan            Generate a balanced, rack aware reassignment of the replicas of topics
+  execute         Start a reassignment from a plan file, optionally throttled
+  status          Show the progress of a reassignment and remove its throttle when done
+  leaders         List partitions that are not led by their preferred replica
+  leaders election-file
+                  Write the partitions to elect to a file for kafka-leader-election.sh
+
+Run "partitions <command> -h" for command flags.
+`)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	// Handle graceful shutdown
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	var err error
+	switch os.Args[1] {
+	case "plan":
+		err = runPlan(os.Args[2:])
+	case "execute":
+		err = runExecute(os.Args[2:])
+	case "status":
+		err = runStatus(ctx, os.Args[2:])
+	case "leaders":
+		err = runLeaders(os.Args[2:])
+	case "-h", "--help", "help":
+		usage()
+		return
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("partitions %s failed: %v", os.Args[1], err)
+	}
+}
+
+// runPlan prints a balanced assignment of the topics and saves the moves to a file
+func runPlan(args []string) error {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	topics := fs.String("topics", "", "comma-separated topics to balance (required)")
+	brokers := fs.String("brokers", "", "comma-separated broker IDs to spread the replicas over (default all)")
+	output := fs.String("output", "reassignment.json", "file receiving the partitions to move, empty to skip")
+	format := fs.String("format", admin.FormatTable, "output format: table, json or yaml")
+	fs.Parse(args)
+
+	if err := admin.ValidateFormat(*format); err != nil {
+		return err
+	}
+	if *topics == "" {
+		return fmt.Errorf("-topics is required")
+	}
+	brokerIDs, err := admin.ParsePartitions(*brokers)
+	if err != nil {
+		return fmt.Errorf("invalid -brokers: %w", err)
+	}
+
+	conn, err := admin.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	plan, err := internal.GeneratePlan(conn, splitList(*topics), brokerIDs)
+	if err != nil {
+		return err
+	}
+	if err := admin.Write(os.Stdout, *format, plan); err != nil {
+		return err
+	}
+
+	moves := plan.Moves()
+	if *output != "" && len(moves) > 0 {
+		if err := internal.WriteReassignmentFile(*output, moves, true); err != nil {
+			return err
+		}
+		log.Printf("Saved %d partition move(s) to %s, start them with: partitions execute -file %s", len(moves), *output, *output)
+	}
+	return nil
+}
+
+// runExecute starts the reassignment of a plan file, saving the current replicas for a rollback
+func runExecute(args []string) error {
+	fs := flag.NewFlagSet("execute", flag.ExitOnError)
+	file := fs.String("file", "reassignment.json", "reassignment file written by plan or kafka-reassign-partitions.sh")
+	rollback := fs.String("rollback", "rollback.json", "file receiving the current replicas, execute it to undo the reassignment")
+	throttle := fs.Int64("throttle", 0, "replication throttle in bytes per second while partitions move, 0 for none")
+	fs.Parse(args)
+
+	if *throttle < 0 {
+		return fmt.Errorf("-throttle must not be negative")
+	}
+
+	moves, err := internal.LoadReassignmentFile(*file)
+	if err != nil {
+		return err
+	}
+
+	conn, err := admin.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := internal.Prepare(conn, moves); err != nil {
+		return err
+	}
+	if *rollback != "" {
+		if err := internal.WriteReassignmentFile(*rollback, moves, false); err != nil {
+			return err
+		}
+		log.Printf("Saved the current replicas to %s", *rollback)
+	}
+	if err := internal.Execute(conn, moves, *throttle); err != nil {
+		return err
+	}
+	log.Printf("Follow the progress with: partitions status -file %s -wait", *file)
+	return nil
+}
+
+// runStatus prints the progress of a reassignment, polling until it is done with -wait
+func runStatus(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	file := fs.String("file", "reassignment.json", "reassignment file passed to execute")
+	wait := fs.Bool("wait", false, "poll until no partition is moving")
+	interval := fs.Duration("interval", 10*time.Second, "poll interval with -wait")
+	keepThrottle := fs.Bool("keep-throttle", false, "keep the replication throttle after the reassignment is done")
+	format := fs.String("format", admin.FormatTable, "output format: table, json or yaml")
+	fs.Parse(args)
+
+	if err := admin.ValidateFormat(*format); err != nil {
+		return err
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("-interval must be positive")
+	}
+
+	moves, err := internal.LoadReassignmentFile(*file)
+	if err != nil {
+		return err
+	}
+
+	conn, err := admin.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var status *internal.ReassignmentStatus
+	if *wait {
+		status, err = internal.WaitForReassignment(ctx, conn, moves, *interval, func(status *internal.ReassignmentStatus) error {
+			if !status.InProgress() {
+				return nil
+			}
+			if *format == admin.FormatTable {
+				fmt.Printf("--- %s\n", time.Now().Format(time.RFC3339))
+			}
+			return admin.Write(os.Stdout, *format, status)
+		})
+	} else {
+		status, err = internal.Status(conn, moves)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !status.InProgress() && !*keepThrottle {
+		if err := internal.ClearThrottle(conn, moves); err != nil {
+			return err
+		}
+		status.ThrottleCleared = true
+	}
+	return admin.Write(os.Stdout, *format, status)
+}
+
+// runLeaders lists partitions not led by their preferred replica; "leaders election-file"
+// also writes the electable ones to a file for kafka-leader-election.sh
+func runLeaders(args []string) error {
+	electionFile := len(args) > 0 && args[0] == "election-file"
+	if electionFile {
+		args = args[1:]
+	}
+
+	fs := flag.NewFlagSet("leaders", flag.ExitOnError)
+	topics := fs.String("topics", "", "comma-separated topics (default all)")
+	format := fs.String("format", admin.FormatTable, "output format: table, json or yaml")
+	var output *string
+	if electionFile {
+		output = fs.String("output", "election.json", "file receiving the partitions to elect, for kafka-leader-election.sh")
+	}
+	fs.Parse(args)
+
+	if err := admin.ValidateFormat(*format); err != nil {
+		return err
+	}
+
+	conn, err := admin.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	report, err := internal.PreferredLeaders(conn, splitList(*topics))
+	if err != nil {
+		return err
+	}
+	if err := admin.Write(os.Stdout, *format, report); err != nil {
+		return err
+	}
+	if !electionFile || len(report.Partitions) == 0 {
+		return nil
+	}
+
+	if err := report.WriteElectionFile(*output); err != nil {
+		return err
+	}
+	log.Printf("Saved the electable partitions to %s, elect their preferred leaders with: kafka-leader-election.sh --bootstrap-server <broker> --election-type preferred --path-to-json-file %s",
+		*output, *output)
+	return nil
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,293 @@
+// Package pipeline runs exactly-once consume-transform-produce pipelines: the messages
+// produced for a batch of consumed messages and the offsets of that batch are
+// committed in one Kafka transaction, or not at all
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"kafka_test/adminhttp"
+	"kafka_test/config"
+	"kafka_test/lifecycle"
+	"kafka_test/logging"
+	"kafka_test/metrics"
+	"kafka_test/rebalance"
+	"kafka_test/tracing"
+
+	"github.com/Shopify/sarama"
+)
+
+// Transform returns the messages to produce for a consumed message, none to only
+// commit its offset. An error aborts the transaction of the batch.
+type Transform func(ctx context.Context, msg *sarama.ConsumerMessage) ([]*sarama.ProducerMessage, error)
+
+// Options configures a pipeline
+type Options struct {
+	GroupID string
+	Topics  []string
+	// TransactionalID prefixes the transactional IDs of the producers, one per
+	// consumed partition, so a new owner of a partition fences the previous one
+	TransactionalID string
+	// BatchSize is the most messages of a partition consumed in one transaction
+	BatchSize int
+	// BatchTimeout commits a partial batch once its first message waited this long
+	BatchTimeout time.Duration
+	// RetryBackoff is the wait after an aborted transaction before consuming again
+	RetryBackoff time.Duration
+}
+
+// Pipeline consumes the topics of a group, transforms each message and produces the
+// results with a transactional producer per claimed partition. On failure the
+// transaction is aborted and the session ends, so the next one consumes the
+// partitions again from their last committed offsets.
+type Pipeline struct {
+	// Hooks are called when partitions are assigned and revoked
+	rebalance.Hooks
+
+	opts      Options
+	transform Transform
+	state     *adminhttp.State
+
+	// newProducer creates the transactional producer of a claimed partition
+	newProducer func(transactionalID string) (sarama.AsyncProducer, error)
+
+	mu        sync.Mutex
+	producers map[string]sarama.AsyncProducer
+}
+
+// New creates a pipeline running the transform
+func New(opts Options, transform Transform) *Pipeline {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.BatchTimeout <= 0 {
+		opts.BatchTimeout = 100 * time.Millisecond
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = time.Second
+	}
+	return &Pipeline{
+		opts:      opts,
+		transform: transform,
+		state:     adminhttp.NewState(opts.GroupID),
+		producers: make(map[string]sarama.AsyncProducer),
+
+		newProducer: func(transactionalID string) (sarama.AsyncProducer, error) {
+			return sarama.NewAsyncProducer(config.GetBrokers(), config.GetTransactionalProducerConfig(transactionalID))
+		},
+	}
+}
+
+// State returns the state served by the admin endpoints
+func (p *Pipeline) State() *adminhttp.State {
+	return p.state
+}
+
+// Setup creates a transactional producer for each claimed partition, fencing the
+// producers of the previous owners
+func (p *Pipeline) Setup(session sarama.ConsumerGroupSession) error {
+	metrics.SessionStarted(p.opts.GroupID)
+	p.state.SessionStarted(session)
+
+	for topic, partitions := range session.Claims() {
+		for _, partition := range partitions {
+			id := transactionalID(p.opts.TransactionalID, topic, partition)
+			producer, err := p.newProducer(id)
+			if err != nil {
+				p.closeProducers()
+				return fmt.Errorf("failed to create transactional producer %s: %w", id, err)
+			}
+			p.mu.Lock()
+			p.producers[claimKey(topic, partition)] = producer
+			p.mu.Unlock()
+		}
+	}
+	return p.Assigned(session)
+}
+
+// Cleanup closes the producers of the session; their transactions are committed or aborted already
+func (p *Pipeline) Cleanup(session sarama.ConsumerGroupSession) error {
+	metrics.SessionEnded(p.opts.GroupID, session.Claims())
+	p.state.SessionEnded()
+	err := p.Revoked(session)
+	return errors.Join(err, p.closeProducers())
+}
+
+// ConsumeClaim batches the messages of a partition and processes each batch in a transaction
+func (p *Pipeline) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	p.state.ClaimStarted(claim.Topic(), claim.Partition())
+
+	p.mu.Lock()
+	producer := p.producers[claimKey(claim.Topic(), claim.Partition())]
+	p.mu.Unlock()
+
+	batch := make([]*sarama.ConsumerMessage, 0, p.opts.BatchSize)
+	linger := time.NewTimer(p.opts.BatchTimeout)
+	linger.Stop()
+	defer linger.Stop()
+
+	for {
+		select {
+		case message := <-claim.Messages():
+			if message == nil {
+				return p.flush(session, producer, batch)
+			}
+			metrics.ObserveConsume(p.opts.GroupID, message.Topic, message.Partition, message.Offset, claim.HighWaterMarkOffset())
+			if len(batch) == 0 {
+				linger.Reset(p.opts.BatchTimeout)
+			}
+			batch = append(batch, message)
+			if len(batch) < p.opts.BatchSize {
+				continue
+			}
+
+		case <-linger.C:
+			if len(batch) == 0 {
+				continue
+			}
+
+		case <-session.Context().Done():
+			// Commit the messages batched so far before the partition is released
+			return p.flush(session, producer, batch)
+		}
+
+		linger.Stop()
+		if err := p.process(session.Context(), producer, batch); err != nil {
+			metrics.ConsumeError(p.opts.GroupID, claim.Topic())
+			slog.Error("Transaction aborted, rewinding to the last committed offset",
+				"topic", claim.Topic(), "partition", claim.Partition(), "from_offset", batch[0].Offset, "error", err)
+			// Ending the claim ends the session; the next session starts from the committed offsets
+			select {
+			case <-time.After(p.opts.RetryBackoff):
+			case <-session.Context().Done():
+			}
+			return nil
+		}
+		batch = batch[:0]
+	}
+}
+
+// flush processes the last batch of a claim, if any, and reports an aborted transaction
+func (p *Pipeline) flush(session sarama.ConsumerGroupSession, producer sarama.AsyncProducer, batch []*sarama.ConsumerMessage) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := p.process(context.WithoutCancel(session.Context()), producer, batch); err != nil {
+		metrics.ConsumeError(p.opts.GroupID, batch[0].Topic)
+		return fmt.Errorf("failed to commit the last batch of %s/%d: %w", batch[0].Topic, batch[0].Partition, err)
+	}
+	return nil
+}
+
+// process transforms and produces a batch of messages of one partition and commits
+// the results with the offset after the batch in one transaction, aborting it on failure
+func (p *Pipeline) process(ctx context.Context, producer sarama.AsyncProducer, batch []*sarama.ConsumerMessage) (err error) {
+	start := time.Now()
+	defer func() { metrics.ObserveTransaction(p.opts.GroupID, start, err) }()
+
+	if err := producer.BeginTxn(); err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	produced := 0
+	for _, message := range batch {
+		outputs, err := p.transformMessage(ctx, message)
+		if err != nil {
+			return p.abort(producer, err)
+		}
+		for _, output := range outputs {
+			producer.Input() <- output
+		}
+		produced += len(outputs)
+	}
+
+	// Commit the offset of the next message with the output, as part of the transaction
+	last := batch[len(batch)-1]
+	if err := producer.AddMessageToTxn(last, p.opts.GroupID, nil); err != nil {
+		return p.abort(producer, fmt.Errorf("failed to add offsets to transaction: %w", err))
+	}
+	if err := producer.CommitTxn(); err != nil {
+		return p.abort(producer, fmt.Errorf("failed to commit transaction: %w", err))
+	}
+
+	logging.Sampled().Info("Transaction committed", "topic", last.Topic, "partition", last.Partition,
+		"from_offset", batch[0].Offset, "to_offset", last.Offset, "produced", produced)
+	return nil
+}
+
+// transformMessage runs the transform in a span continuing the trace of the message,
+// and starts the trace of each output as its child
+func (p *Pipeline) transformMessage(ctx context.Context, message *sarama.ConsumerMessage) (outputs []*sarama.ProducerMessage, err error) {
+	ctx, span := tracing.StartConsume(ctx, p.opts.GroupID, message)
+	defer func() { tracing.End(span, err) }()
+
+	outputs, err = p.transform(ctx, message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to transform %s/%d at offset %d: %w", message.Topic, message.Partition, message.Offset, err)
+	}
+	for _, output := range outputs {
+		_, outputSpan := tracing.StartProduce(ctx, output)
+		outputSpan.End()
+	}
+	return outputs, nil
+}
+
+// abort aborts the transaction after a failure. A producer in a fatal state cannot
+// abort; the transaction is then aborted when the next session fences it.
+func (p *Pipeline) abort(producer sarama.AsyncProducer, cause error) error {
+	if producer.TxnStatus()&sarama.ProducerTxnFlagFatalError != 0 {
+		return fmt.Errorf("%w (producer failed fatally)", cause)
+	}
+	if err := producer.AbortTxn(); err != nil {
+		return errors.Join(cause, fmt.Errorf("failed to abort transaction: %w", err))
+	}
+	return cause
+}
+
+// closeProducers closes and forgets the producers of the session
+func (p *Pipeline) closeProducers() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errs []error
+	for key, producer := range p.producers {
+		if err := producer.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close producer for %s: %w", key, err))
+		}
+		delete(p.producers, key)
+	}
+	return errors.Join(errs...)
+}
+
+// Run consumes until the context is cancelled, then finishes the current transactions and leaves the group
+func (p *Pipeline) Run(ctx context.Context) error {
+	kafkaConfig := config.GetConsumerConfig()
+	// Skip the output of aborted transactions upstream, e.g. of another pipeline
+	kafkaConfig.Consumer.IsolationLevel = sarama.ReadCommitted
+	metrics.RegisterSarama("consumer", kafkaConfig.MetricRegistry)
+
+	group, err := sarama.NewConsumerGroup(config.GetBrokers(), p.opts.GroupID, kafkaConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create consumer group: %w", err)
+	}
+	p.state.Attach(group, kafkaConfig)
+
+	slog.Info("Pipeline started", "topics", p.opts.Topics, "group", p.opts.GroupID)
+
+	return lifecycle.ConsumeGroup(ctx, group, p.opts.GroupID, p.opts.Topics, p, p.opts.RetryBackoff)
+}
+
+// transactionalID returns the transactional ID of the producer of a partition, the same
+// for every owner of the partition
+func transactionalID(prefix, topic string, partition int32) string {
+	return fmt.Sprintf("%s-%s-%d", prefix, topic, partition)
+}
+
+func claimKey(topic string, partition int32) string {
+	return fmt.Sprintf("%s/%d", topic, partition)
+}
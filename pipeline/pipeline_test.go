@@ -0,0 +1,335 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"kafka_test/config"
+
+	"github.com/Shopify/sarama"
+)
+
+// flushMarker is sent through the input of a fakeProducer to wait for the messages before it
+var flushMarker = &sarama.ProducerMessage{}
+
+// fakeProducer is a transactional producer recording what its transactions commit
+type fakeProducer struct {
+	id      string
+	input   chan *sarama.ProducerMessage
+	flushed chan struct{}
+
+	mu      sync.Mutex
+	status  sarama.ProducerTxnStatusFlag
+	pending []*sarama.ProducerMessage
+	offset  *sarama.ConsumerMessage
+	group   string
+	// committed holds the messages of the committed transactions, offsets the offset
+	// committed with each of them
+	committed []*sarama.ProducerMessage
+	offsets   []int64
+	aborted   int
+	closed    bool
+}
+
+func newFakeProducer(id string) *fakeProducer {
+	f := &fakeProducer{
+		id:      id,
+		input:   make(chan *sarama.ProducerMessage),
+		flushed: make(chan struct{}),
+		status:  sarama.ProducerTxnFlagReady,
+	}
+	go func() {
+		for msg := range f.input {
+			if msg == flushMarker {
+				f.flushed <- struct{}{}
+				continue
+			}
+			f.mu.Lock()
+			f.pending = append(f.pending, msg)
+			f.mu.Unlock()
+		}
+	}()
+	return f
+}
+
+func (f *fakeProducer) flush() {
+	f.input <- flushMarker
+	<-f.flushed
+}
+
+func (f *fakeProducer) Input() chan<- *sarama.ProducerMessage     { return f.input }
+func (f *fakeProducer) Successes() <-chan *sarama.ProducerMessage { return nil }
+func (f *fakeProducer) Errors() <-chan *sarama.ProducerError      { return nil }
+func (f *fakeProducer) IsTransactional() bool                     { return true }
+
+func (f *fakeProducer) TxnStatus() sarama.ProducerTxnStatusFlag {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.status
+}
+
+func (f *fakeProducer) BeginTxn() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = sarama.ProducerTxnFlagInTransaction
+	f.pending, f.offset = nil, nil
+	return nil
+}
+
+func (f *fakeProducer) AddMessageToTxn(msg *sarama.ConsumerMessage, groupID string, metadata *string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.offset, f.group = msg, groupID
+	return nil
+}
+
+func (f *fakeProducer) AddOffsetsToTxn(offsets map[string][]*sarama.PartitionOffsetMetadata, groupID string) error {
+	return errors.New("not used by the pipeline")
+}
+
+func (f *fakeProducer) CommitTxn() error {
+	f.flush()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.committed = append(f.committed, f.pending...)
+	if f.offset != nil {
+		f.offsets = append(f.offsets, f.offset.Offset+1)
+	}
+	f.pending, f.offset = nil, nil
+	f.status = sarama.ProducerTxnFlagReady
+	return nil
+}
+
+func (f *fakeProducer) AbortTxn() error {
+	f.flush()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pending, f.offset = nil, nil
+	f.aborted++
+	f.status = sarama.ProducerTxnFlagReady
+	return nil
+}
+
+func (f *fakeProducer) AsyncClose() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.closed = true
+		close(f.input)
+	}
+}
+
+func (f *fakeProducer) Close() error {
+	f.AsyncClose()
+	return nil
+}
+
+// fakeSession is a consumer group session whose offsets are only committed by transactions
+type fakeSession struct {
+	ctx    context.Context
+	claims map[string][]int32
+	marked int
+}
+
+func (s *fakeSession) Claims() map[string][]int32 { return s.claims }
+func (s *fakeSession) MemberID() string           { return "member" }
+func (s *fakeSession) GenerationID() int32        { return 1 }
+func (s *fakeSession) Context() context.Context   { return s.ctx }
+func (s *fakeSession) Commit()                    {}
+
+func (s *fakeSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.marked++
+}
+
+func (s *fakeSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+	s.marked++
+}
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.marked++
+}
+
+// fakeClaim hands over the messages of a partition from an offset, then ends
+type fakeClaim struct {
+	topic     string
+	partition int32
+	from      int64
+	messages  chan *sarama.ConsumerMessage
+}
+
+func newFakeClaim(topic string, partition int32, from int64, values ...string) *fakeClaim {
+	c := &fakeClaim{topic: topic, partition: partition, from: from, messages: make(chan *sarama.ConsumerMessage, len(values))}
+	for i, value := range values {
+		c.messages <- &sarama.ConsumerMessage{Topic: topic, Partition: partition, Offset: from + int64(i), Value: []byte(value)}
+	}
+	close(c.messages)
+	return c
+}
+
+func (c *fakeClaim) Topic() string                            { return c.topic }
+func (c *fakeClaim) Partition() int32                         { return c.partition }
+func (c *fakeClaim) InitialOffset() int64                     { return c.from }
+func (c *fakeClaim) HighWaterMarkOffset() int64               { return c.from + int64(cap(c.messages)) }
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+// upper produces the value of each message to the output topic, failing on the values in fail
+func upper(fail map[string]bool) Transform {
+	return func(ctx context.Context, msg *sarama.ConsumerMessage) ([]*sarama.ProducerMessage, error) {
+		if fail[string(msg.Value)] {
+			return nil, fmt.Errorf("cannot transform %s", msg.Value)
+		}
+		return []*sarama.ProducerMessage{{Topic: "output", Value: sarama.StringEncoder(msg.Value)}}, nil
+	}
+}
+
+// newTestPipeline creates a pipeline whose producers are fakes, returned by transactional ID
+func newTestPipeline(opts Options, transform Transform) (*Pipeline, map[string]*fakeProducer) {
+	p := New(opts, transform)
+	producers := make(map[string]*fakeProducer)
+	var mu sync.Mutex
+	p.newProducer = func(id string) (sarama.AsyncProducer, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		producer := newFakeProducer(id)
+		producers[id] = producer
+		return producer, nil
+	}
+	return p, producers
+}
+
+// consume runs one session of the pipeline over a single claim
+func consume(t *testing.T, p *Pipeline, claim *fakeClaim) *fakeSession {
+	t.Helper()
+	session := &fakeSession{ctx: context.Background(), claims: map[string][]int32{claim.topic: {claim.partition}}}
+	if err := p.Setup(session); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ConsumeClaim(session, claim); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Cleanup(session); err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+func values(messages []*sarama.ProducerMessage) []string {
+	result := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		value, _ := msg.Value.Encode()
+		result = append(result, string(value))
+	}
+	return result
+}
+
+func TestBatchCommitsWithOffsets(t *testing.T) {
+	p, producers := newTestPipeline(Options{GroupID: "group", TransactionalID: "tx", BatchSize: 2}, upper(nil))
+
+	session := consume(t, p, newFakeClaim("input", 0, 10, "a", "b", "c"))
+
+	producer := producers["tx-input-0"]
+	if producer == nil {
+		t.Fatalf("no producer for tx-input-0, have %v", producers)
+	}
+	// A full batch of two, then the last message flushed as its own batch
+	if got := fmt.Sprint(values(producer.committed)); got != "[a b c]" {
+		t.Fatalf("committed %s, want [a b c]", got)
+	}
+	if got := fmt.Sprint(producer.offsets); got != "[12 13]" {
+		t.Fatalf("committed offsets %s, want [12 13]", got)
+	}
+	if producer.group != "group" {
+		t.Fatalf("offsets committed for group %q, want group", producer.group)
+	}
+	if session.marked != 0 {
+		t.Fatalf("%d offsets marked on the session, want them committed by the transactions only", session.marked)
+	}
+	if !producer.closed {
+		t.Fatal("producer not closed at the end of the session")
+	}
+}
+
+func TestFailedTransformAbortsAndRewinds(t *testing.T) {
+	fail := map[string]bool{"b": true}
+	p, producers := newTestPipeline(Options{GroupID: "group", TransactionalID: "tx", BatchSize: 3, RetryBackoff: time.Millisecond}, upper(fail))
+
+	session := consume(t, p, newFakeClaim("input", 0, 10, "a", "b", "c"))
+
+	producer := producers["tx-input-0"]
+	if producer.aborted != 1 {
+		t.Fatalf("%d transactions aborted, want 1", producer.aborted)
+	}
+	if len(producer.committed) != 0 || len(producer.offsets) != 0 {
+		t.Fatalf("committed %v with offsets %v, want nothing", values(producer.committed), producer.offsets)
+	}
+	if session.marked != 0 {
+		t.Fatalf("%d offsets marked on the session, want none", session.marked)
+	}
+
+	// The next session consumes again from the last committed offset, 10
+	delete(fail, "b")
+	consume(t, p, newFakeClaim("input", 0, 10, "a", "b", "c"))
+
+	producer = producers["tx-input-0"]
+	if got := fmt.Sprint(values(producer.committed)); got != "[a b c]" {
+		t.Fatalf("committed %s after the rewind, want [a b c]", got)
+	}
+	if got := fmt.Sprint(producer.offsets); got != "[13]" {
+		t.Fatalf("committed offsets %s after the rewind, want [13]", got)
+	}
+}
+
+func TestTransactionalIDPerPartition(t *testing.T) {
+	p, producers := newTestPipeline(Options{GroupID: "group", TransactionalID: "tx", BatchSize: 1}, upper(nil))
+
+	session := &fakeSession{ctx: context.Background(), claims: map[string][]int32{"input": {0, 2}, "audit": {1}}}
+	if err := p.Setup(session); err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(producers))
+	for id := range producers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if got := fmt.Sprint(ids); got != "[tx-audit-1 tx-input-0 tx-input-2]" {
+		t.Fatalf("transactional IDs %s, want [tx-audit-1 tx-input-0 tx-input-2]", got)
+	}
+
+	// Each claim produces with the producer of its partition
+	if err := p.ConsumeClaim(session, newFakeClaim("input", 2, 0, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if len(producers["tx-input-2"].committed) != 1 || len(producers["tx-input-0"].committed) != 0 {
+		t.Fatal("the claim of input/2 did not produce with tx-input-2 only")
+	}
+
+	if err := p.Cleanup(session); err != nil {
+		t.Fatal(err)
+	}
+	for id, producer := range producers {
+		if !producer.closed {
+			t.Fatalf("producer %s not closed at the end of the session", id)
+		}
+	}
+}
+
+func TestTransactionalProducerConfig(t *testing.T) {
+	t.Setenv("KAFKA_SECURITY_PROTOCOL", "PLAINTEXT")
+	t.Setenv("KAFKA_VERSION", "2.8.0")
+
+	kafkaConfig := config.GetTransactionalProducerConfig(transactionalID("tx", "input", 2))
+	if kafkaConfig.Producer.Transaction.ID != "tx-input-2" {
+		t.Fatalf("transactional ID %q, want tx-input-2", kafkaConfig.Producer.Transaction.ID)
+	}
+	if !kafkaConfig.Producer.Idempotent || kafkaConfig.Producer.RequiredAcks != sarama.WaitForAll || kafkaConfig.Net.MaxOpenRequests != 1 {
+		t.Fatal("transactional producer is not idempotent with acks=all and one in-flight request")
+	}
+	if err := kafkaConfig.Validate(); err != nil {
+		t.Fatalf("invalid transactional producer config: %v", err)
+	}
+}
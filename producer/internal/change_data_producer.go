@@ -3,29 +3,43 @@ package producer
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"strconv"
 	"time"
 
 	"kafka_test/config"
+	"kafka_test/fixtures"
+	"kafka_test/logging"
 	"kafka_test/models"
 
 	"github.com/Shopify/sarama"
-	"github.com/google/uuid"
-	"google.golang.org/genproto/googleapis/type/date"
 	"google.golang.org/protobuf/proto"
-	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // ChangeDataProducer represents a Kafka producer for ChangeDataMessage
 type ChangeDataProducer struct {
-	producer sarama.SyncProducer
-	topic    string
-	counter  uint32
+	producer  sarama.SyncProducer
+	topic     string
+	generator *fixtures.Generator
 }
 
 // NewChangeDataProducer creates a new ChangeDataMessage producer
 func NewChangeDataProducer() (*ChangeDataProducer, error) {
+	scenario := fixtures.DefaultScenario()
+	if scenarioPath := config.GetScenarioPath(); scenarioPath != "" {
+		var err error
+		scenario, err = fixtures.LoadScenario(scenarioPath)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info("Generating ChangeDataMessages", "scenario", scenarioPath)
+	}
+
+	generator, err := fixtures.NewGenerator(scenario)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create generator: %w", err)
+	}
+
 	kafkaConfig := config.GetProducerConfig()
 
 	producer, err := sarama.NewSyncProducer(config.GetBrokers(), kafkaConfig)
@@ -34,9 +48,9 @@ func NewChangeDataProducer() (*ChangeDataProducer, error) {
 	}
 
 	return &ChangeDataProducer{
-		producer: producer,
-		topic:    config.GetTopicName(),
-		counter:  0,
+		producer:  producer,
+		topic:     config.GetTopicName(),
+		generator: generator,
 	}, nil
 }
 
@@ -45,99 +59,18 @@ func (p *ChangeDataProducer) Close() error {
 	return p.producer.Close()
 }
 
-// createSampleDepartment creates a sample department record
-func (p *ChangeDataProducer) createSampleDepartment() *models.Department {
-	now := time.Now()
-	today := &date.Date{
-		Year:  int32(now.Year()),
-		Month: int32(now.Month()),
-		Day:   int32(now.Day()),
-	}
-
-	return &models.Department{
-		Id:                    fmt.Sprintf("dept-%d", p.counter),
-		Biid:                  fmt.Sprintf("biid-%d", p.counter),
-		Type:                  "department",
-		TenantUid:             12345,
-		Code:                  fmt.Sprintf("DEPT%03d", p.counter),
-		Name:                  fmt.Sprintf("Department %d", p.counter),
-		ShortName:             fmt.Sprintf("Dept%d", p.counter),
-		ValidFrom:             today,
-		ValidTo:               nil, // No end date
-		TransactionFrom:       timestamppb.Now(),
-		TransactionTo:         nil,
-		TransactionFromBy:     1001,
-		TransactionToBy:       0,
-		TransactionFromByName: "System User",
-		TransactionToByName:   "",
-		TransactionFromBySrv:  "kafka-producer",
-		TransactionToBySrv:    "",
-		DispOrder:             p.counter,
-		SearchKey:             fmt.Sprintf("dept%d", p.counter),
-		Version:               1,
-		ParentBiid:            nil,
-		ParentCode:            nil,
-		ParentName:            nil,
-	}
-}
-
-// createSampleAggregationPattern creates a sample aggregation pattern
-func (p *ChangeDataProducer) createSampleAggregationPattern() *models.AggregationPattern {
-	return &models.AggregationPattern{
-		Id:                    fmt.Sprintf("agg-%d", p.counter),
-		Biid:                  fmt.Sprintf("agg-biid-%d", p.counter),
-		TenantUid:             12345,
-		Name:                  fmt.Sprintf("Aggregation Pattern %d", p.counter),
-		TransactionFrom:       timestamppb.Now(),
-		TransactionTo:         nil,
-		TransactionFromBy:     1001,
-		TransactionToBy:       0,
-		TransactionFromByName: "System User",
-		TransactionToByName:   "",
-		TransactionFromBySrv:  "kafka-producer",
-		TransactionToBySrv:    "",
-		Version:               1,
-	}
-}
-
-// SendChangeDataMessage sends a ChangeDataMessage to Kafka
+// SendChangeDataMessage generates the next request and sends all of its message parts to Kafka
 func (p *ChangeDataProducer) SendChangeDataMessage(ctx context.Context) error {
-	p.counter++
-
-	// Create sample records
-	var records []*models.Record
-
-	// Add a department record
-	dept := p.createSampleDepartment()
-	deptRecord := &models.Record{
-		OperationType: models.Record_CREATE,
-		Data: &models.Record_Department{
-			Department: dept,
-		},
-	}
-	records = append(records, deptRecord)
-
-	// Add an aggregation pattern record (every 3rd message)
-	if p.counter%3 == 0 {
-		agg := p.createSampleAggregationPattern()
-		aggRecord := &models.Record{
-			OperationType: models.Record_UPDATE,
-			Data: &models.Record_AggregationPattern{
-				AggregationPattern: agg,
-			},
+	for _, changeDataMsg := range p.generator.NextRequest() {
+		if err := p.sendMessagePart(changeDataMsg); err != nil {
+			return err
 		}
-		records = append(records, aggRecord)
-	}
-
-	// Create ChangeDataMessage
-	changeDataMsg := &models.ChangeDataMessage{
-		RequestId:         uuid.New().String(),
-		MessageNumber:     p.counter,
-		TotalMessageCount: p.counter + 1,
-		TenantUid:         12345,
-		Records:           records,
 	}
+	return nil
+}
 
+// sendMessagePart sends a single ChangeDataMessage part
+func (p *ChangeDataProducer) sendMessagePart(changeDataMsg *models.ChangeDataMessage) error {
 	// Serialize to protobuf
 	protoData, err := proto.Marshal(changeDataMsg)
 	if err != nil {
@@ -152,6 +85,7 @@ func (p *ChangeDataProducer) SendChangeDataMessage(ctx context.Context) error {
 		Headers: []sarama.RecordHeader{
 			{Key: []byte("request-id"), Value: []byte(changeDataMsg.RequestId)},
 			{Key: []byte("message-number"), Value: []byte(strconv.FormatUint(uint64(changeDataMsg.MessageNumber), 10))},
+			{Key: []byte("total-message-count"), Value: []byte(strconv.FormatUint(uint64(changeDataMsg.TotalMessageCount), 10))},
 			{Key: []byte("tenant-uid"), Value: []byte(strconv.FormatUint(changeDataMsg.TenantUid, 10))},
 			{Key: []byte("content-type"), Value: []byte("application/x-protobuf")},
 			{Key: []byte("message-type"), Value: []byte("ChangeDataMessage")},
@@ -164,15 +98,15 @@ func (p *ChangeDataProducer) SendChangeDataMessage(ctx context.Context) error {
 		return fmt.Errorf("failed to send message: %w", err)
 	}
 
-	log.Printf("ChangeDataMessage sent successfully - Partition: %d, Offset: %d, RequestID: %s, Records: %d",
-		partition, offset, changeDataMsg.RequestId, len(changeDataMsg.Records))
+	logging.Sampled().Info("ChangeDataMessage sent", append(append(logging.Produced(p.topic, partition, offset),
+		logging.ChangeData(changeDataMsg)...), "records", len(changeDataMsg.Records))...)
 
 	return nil
 }
 
 // Start starts the producer loop
 func (p *ChangeDataProducer) Start(ctx context.Context, interval time.Duration) error {
-	log.Printf("ChangeDataProducer started. Sending messages to topic: %s every %v", p.topic, interval)
+	slog.Info("ChangeDataProducer started", "topic", p.topic, "interval", interval)
 
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
@@ -180,11 +114,11 @@ func (p *ChangeDataProducer) Start(ctx context.Context, interval time.Duration)
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("ChangeDataProducer context cancelled, stopping...")
+			slog.Info("ChangeDataProducer context cancelled, stopping")
 			return nil
 		case <-ticker.C:
 			if err := p.SendChangeDataMessage(ctx); err != nil {
-				log.Printf("Error sending ChangeDataMessage: %v", err)
+				slog.Error("Error sending ChangeDataMessage", "error", err)
 			}
 		}
 	}
@@ -3,12 +3,15 @@ package producer
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"strconv"
 	"time"
 
 	"kafka_test/config"
+	"kafka_test/logging"
+	"kafka_test/metrics"
 	"kafka_test/models"
+	"kafka_test/tracing"
 
 	"github.com/Shopify/sarama"
 	"github.com/google/uuid"
@@ -24,6 +27,7 @@ type Producer struct {
 // NewProducer creates a new Kafka producer
 func NewProducer() (*Producer, error) {
 	kafkaConfig := config.GetProducerConfig()
+	metrics.RegisterSarama("producer", kafkaConfig.MetricRegistry)
 
 	producer, err := sarama.NewSyncProducer(config.GetBrokers(), kafkaConfig)
 	if err != nil {
@@ -65,13 +69,13 @@ func (p *Producer) SendMessage(ctx context.Context) error {
 			},
 		}
 
-		partition, offset, err := p.producer.SendMessage(kafkaMsg)
+		partition, offset, err := p.send(ctx, kafkaMsg)
 		if err != nil {
 			return fmt.Errorf("failed to send message: %w", err)
 		}
 
-		log.Printf("Plain text message sent successfully - Partition: %d, Offset: %d, Message: %s",
-			partition, offset, content)
+		logging.Sampled().Info("Plain text message sent", append(logging.Produced(p.topic, partition, offset),
+			"key", fmt.Sprintf("key-%d", p.counter), logging.Payload([]byte(content)))...)
 	} else {
 		// Send JSON message
 		msg := models.NewMessage(p.counter, fmt.Sprintf("JSON message content #%d", p.counter))
@@ -100,21 +104,31 @@ func (p *Producer) SendMessage(ctx context.Context) error {
 		}
 
 		// Send the message
-		partition, offset, err := p.producer.SendMessage(kafkaMsg)
+		partition, offset, err := p.send(ctx, kafkaMsg)
 		if err != nil {
 			return fmt.Errorf("failed to send message: %w", err)
 		}
 
-		log.Printf("JSON message sent successfully - Partition: %d, Offset: %d, Message: %s",
-			partition, offset, msg.String())
+		logging.Sampled().Info("JSON message sent", append(logging.Produced(p.topic, partition, offset),
+			"key", msg.ID, logging.Payload([]byte(jsonData)))...)
 	}
 
 	return nil
 }
 
+// send sends a message, recording its metrics and a producer span whose context goes into the headers
+func (p *Producer) send(ctx context.Context, msg *sarama.ProducerMessage) (int32, int64, error) {
+	_, span := tracing.StartProduce(ctx, msg)
+	start := time.Now()
+	partition, offset, err := p.producer.SendMessage(msg)
+	metrics.ObserveProduce(msg.Topic, start, err)
+	tracing.EndProduce(span, partition, offset, err)
+	return partition, offset, err
+}
+
 // Start starts the producer loop
 func (p *Producer) Start(ctx context.Context, interval time.Duration) error {
-	log.Printf("Producer started. Sending messages to topic: %s every %v", p.topic, interval)
+	slog.Info("Producer started", "topic", p.topic, "interval", interval)
 
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
@@ -122,11 +136,11 @@ func (p *Producer) Start(ctx context.Context, interval time.Duration) error {
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Producer context cancelled, stopping...")
+			slog.Info("Producer context cancelled, stopping")
 			return nil
 		case <-ticker.C:
 			if err := p.SendMessage(ctx); err != nil {
-				log.Printf("Error sending message: %v", err)
+				slog.Error("Error sending message", "error", err)
 			}
 		}
 	}
@@ -4,36 +4,42 @@ import (
 	"context"
 	"log"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
+	"kafka_test/config"
+	"kafka_test/lifecycle"
+	"kafka_test/logging"
+	"kafka_test/metrics"
 	"kafka_test/producer/internal"
+	"kafka_test/tracing"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run runs the producer until SIGINT or SIGTERM and returns the exit code of draining it
+func run() int {
+	if err := logging.SetupFromEnv("producer"); err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+
 	// Create producer
 	p, err := producer.NewProducer()
 	if err != nil {
 		log.Fatalf("Failed to create producer: %v", err)
 	}
-	defer p.Close()
-
-	// Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Start producer in a goroutine
-	go func() {
-		if err := p.Start(ctx, 2*time.Second); err != nil {
-			log.Printf("Producer error: %v", err)
-		}
-	}()
+	metrics.Start(ctx, config.GetMetricsAddr(":9101"))
+
+	shutdownTracing, err := tracing.Setup(ctx, "producer", tracing.Options{Exporter: config.GetTracingExporter(), File: config.GetTracingFile()})
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
 
-	// Wait for shutdown signal
-	<-sigChan
-	log.Println("Shutting down producer...")
+	return lifecycle.Run(ctx, config.GetDrainTimeout(), func(ctx context.Context) error { return p.Start(ctx, 2*time.Second) }, lifecycle.Closer{Name: "producer", Closer: p})
 }
@@ -0,0 +1,85 @@
+// Package rebalance calls hooks when a consumer group member is assigned partitions
+// and when they are revoked, so handlers can load and flush per-partition state
+package rebalance
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// Hook receives partitions by topic
+type Hook func(ctx context.Context, partitions map[string][]int32) error
+
+// Hooks holds the hooks of a consumer. Sarama rebalances eagerly: every rebalance
+// revokes all partitions of the member, then assigns its new partitions, even if
+// they are the same ones.
+type Hooks struct {
+	mu       sync.Mutex
+	assigned []Hook
+	revoked  []Hook
+}
+
+// OnAssigned adds a hook called with the partitions assigned in a new session,
+// before any of their messages is consumed. An error aborts the session, which
+// is retried with a new rebalance.
+func (h *Hooks) OnAssigned(hook Hook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.assigned = append(h.assigned, hook)
+}
+
+// OnRevoked adds a hook called with the partitions of an ending session, after
+// their last message was processed and before the final offset commit
+func (h *Hooks) OnRevoked(hook Hook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.revoked = append(h.revoked, hook)
+}
+
+// Assigned runs the OnAssigned hooks for the claims of the session, call it from Setup
+func (h *Hooks) Assigned(session sarama.ConsumerGroupSession) error {
+	partitions := sorted(session.Claims())
+	slog.Info("Partitions assigned", "partitions", partitions,
+		"member_id", session.MemberID(), "generation_id", session.GenerationID())
+	return h.run(session.Context(), h.hooks(&h.assigned), partitions)
+}
+
+// Revoked runs the OnRevoked hooks for the claims of the session, call it from Cleanup.
+// The session context is already done, so hooks get a context of their own.
+func (h *Hooks) Revoked(session sarama.ConsumerGroupSession) error {
+	partitions := sorted(session.Claims())
+	slog.Info("Partitions revoked", "partitions", partitions,
+		"member_id", session.MemberID(), "generation_id", session.GenerationID())
+	return h.run(context.WithoutCancel(session.Context()), h.hooks(&h.revoked), partitions)
+}
+
+func (h *Hooks) hooks(list *[]Hook) []Hook {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Clone(*list)
+}
+
+// run runs every hook, even if one fails, and joins their errors
+func (h *Hooks) run(ctx context.Context, hooks []Hook, partitions map[string][]int32) error {
+	var errs []error
+	for _, hook := range hooks {
+		if err := hook(ctx, partitions); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sorted copies the claims with sorted partitions, hooks may keep them
+func sorted(claims map[string][]int32) map[string][]int32 {
+	partitions := make(map[string][]int32, len(claims))
+	for topic, claimed := range claims {
+		partitions[topic] = slices.Sorted(slices.Values(claimed))
+	}
+	return partitions
+}
@@ -0,0 +1,96 @@
+package render
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"gopkg.in/yaml.v3"
+)
+
+// fieldMask is a tree of protobuf field names; a nil subtree keeps the whole field
+type fieldMask map[protoreflect.Name]fieldMask
+
+// parseFieldMask builds a mask from dotted paths of protobuf field names,
+// e.g. "request_id" or "records.department.code". Paths may traverse
+// repeated message fields, in which case they apply to every element.
+func parseFieldMask(desc protoreflect.MessageDescriptor, paths []string) (fieldMask, error) {
+	mask := fieldMask{}
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		node := mask
+		current := desc
+		parts := strings.Split(path, ".")
+		for i, part := range parts {
+			if current == nil {
+				return nil, fmt.Errorf("invalid field path %q: %s is not a message", path, parts[i-1])
+			}
+			fd := current.Fields().ByName(protoreflect.Name(part))
+			if fd == nil {
+				return nil, fmt.Errorf("invalid field path %q: unknown field %s in %s", path, part, current.FullName())
+			}
+
+			name := fd.Name()
+			last := i == len(parts)-1
+			child, seen := node[name]
+			switch {
+			case last:
+				// Selecting a whole field overrides narrower selections
+				node[name] = nil
+			case seen && child == nil:
+				// Already fully selected
+				node = nil
+			default:
+				if child == nil {
+					child = fieldMask{}
+					node[name] = child
+				}
+				node = child
+			}
+			if node == nil {
+				break
+			}
+
+			current = nil
+			if fd.Kind() == protoreflect.MessageKind && !fd.IsMap() {
+				current = fd.Message()
+			}
+		}
+	}
+	return mask, nil
+}
+
+// prune removes the mapping entries of a decoded protojson document that
+// are not selected by the mask. An empty mask selects everything.
+// Masking the document rather than the message keeps unselected fields out
+// of the output even when default values are emitted.
+func (mask fieldMask) prune(node *yaml.Node) {
+	if len(mask) == 0 {
+		return
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			mask.prune(child)
+		}
+	case yaml.MappingNode:
+		content := node.Content[:0]
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			child, selected := mask[protoreflect.Name(key.Value)]
+			if !selected {
+				continue
+			}
+			if child != nil {
+				child.prune(value)
+			}
+			content = append(content, key, value)
+		}
+		node.Content = content
+	}
+}
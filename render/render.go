@@ -0,0 +1,278 @@
+// Package render formats ChangeDataMessages for people and tools: protojson, YAML, a
+// one line summary per record or a table. The protojson and YAML formats marshal the
+// message with protojson, decode it into a yaml.Node tree, which keeps the field order,
+// and drop the fields outside the field mask before writing the tree back out.
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"kafka_test/models"
+
+	"google.golang.org/genproto/googleapis/type/date"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported render formats
+const (
+	FormatProtoJSON = "protojson"
+	FormatSummary   = "summary"
+	FormatTable     = "table"
+	FormatYAML      = "yaml"
+)
+
+// Formats lists the supported render formats
+var Formats = []string{FormatProtoJSON, FormatSummary, FormatTable, FormatYAML}
+
+// Renderer writes a human or machine readable form of a ChangeDataMessage
+type Renderer interface {
+	Render(w io.Writer, msg *models.ChangeDataMessage) error
+}
+
+// Options configures a Renderer
+type Options struct {
+	// Fields is a list of dotted protobuf field paths to show, e.g.
+	// "request_id" or "records.department.code". Empty shows every field.
+	// The summary format always shows its fixed set of fields.
+	Fields []string
+	// EmitDefaults also prints fields holding their default value (protojson and yaml)
+	EmitDefaults bool
+}
+
+// New creates the renderer for the given format
+func New(format string, opts Options) (Renderer, error) {
+	mask, err := parseFieldMask((&models.ChangeDataMessage{}).ProtoReflect().Descriptor(), opts.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	marshal := protojson.MarshalOptions{
+		Multiline:       true,
+		Indent:          "  ",
+		UseProtoNames:   true,
+		EmitUnpopulated: opts.EmitDefaults,
+	}
+
+	switch format {
+	case FormatProtoJSON:
+		return &protoJSONRenderer{mask: mask, marshal: marshal}, nil
+	case FormatYAML:
+		return &yamlRenderer{mask: mask, marshal: marshal}, nil
+	case FormatSummary:
+		return &summaryRenderer{}, nil
+	case FormatTable:
+		return newTableRenderer(opts.Fields), nil
+	default:
+		return nil, fmt.Errorf("unknown render format %q, expected one of %s", format, strings.Join(Formats, ", "))
+	}
+}
+
+// RenderString renders msg to a string, for use in log lines
+func RenderString(r Renderer, msg *models.ChangeDataMessage) (string, error) {
+	var buf bytes.Buffer
+	if err := r.Render(&buf, msg); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(buf.String(), "\n"), nil
+}
+
+type protoJSONRenderer struct {
+	mask    fieldMask
+	marshal protojson.MarshalOptions
+}
+
+func (p *protoJSONRenderer) Render(w io.Writer, msg *models.ChangeDataMessage) error {
+	// Always re-encode: protojson output is deliberately unstable in whitespace
+	doc, err := document(p.marshal, p.mask, msg)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, doc.Content[0], ""); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+type yamlRenderer struct {
+	mask    fieldMask
+	marshal protojson.MarshalOptions
+}
+
+func (y *yamlRenderer) Render(w io.Writer, msg *models.ChangeDataMessage) error {
+	doc, err := document(y.marshal, y.mask, msg)
+	if err != nil {
+		return err
+	}
+	blockStyle(doc)
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "---\n")
+	return err
+}
+
+// document marshals msg with protojson and decodes it into a node tree,
+// which keeps the protojson field order, then applies the field mask.
+// JSON is valid YAML, so the YAML decoder reads it as is.
+func document(marshal protojson.MarshalOptions, mask fieldMask, msg *models.ChangeDataMessage) (*yaml.Node, error) {
+	data, err := marshal.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal ChangeDataMessage: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode ChangeDataMessage: %w", err)
+	}
+	mask.prune(&doc)
+	return &doc, nil
+}
+
+// blockStyle switches a decoded JSON document to plain YAML styles.
+// The encoder still quotes strings that would otherwise read as another type.
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// writeJSON writes a node tree decoded from JSON back as indented JSON
+func writeJSON(buf *bytes.Buffer, node *yaml.Node, indent string) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		if len(node.Content) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		buf.WriteString("{\n")
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			buf.WriteString(indent + "  ")
+			if err := writeJSONString(buf, node.Content[i].Value); err != nil {
+				return err
+			}
+			buf.WriteString(": ")
+			if err := writeJSON(buf, node.Content[i+1], indent+"  "); err != nil {
+				return err
+			}
+			if i+2 < len(node.Content) {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(indent + "}")
+	case yaml.SequenceNode:
+		if len(node.Content) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteString("[\n")
+		for i, child := range node.Content {
+			buf.WriteString(indent + "  ")
+			if err := writeJSON(buf, child, indent+"  "); err != nil {
+				return err
+			}
+			if i+1 < len(node.Content) {
+				buf.WriteByte(',')
+			}
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(indent + "]")
+	case yaml.ScalarNode:
+		if node.Style&yaml.DoubleQuotedStyle != 0 {
+			return writeJSONString(buf, node.Value)
+		}
+		buf.WriteString(node.Value)
+	default:
+		return fmt.Errorf("unexpected node kind %d", node.Kind)
+	}
+	return nil
+}
+
+// writeJSONString writes a JSON string without escaping HTML characters, as protojson does
+func writeJSONString(buf *bytes.Buffer, value string) error {
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return err
+	}
+	// Encode ends the value with a newline
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+type summaryRenderer struct{}
+
+func (s *summaryRenderer) Render(w io.Writer, msg *models.ChangeDataMessage) error {
+	records := make([]string, 0, len(msg.Records))
+	for _, record := range msg.Records {
+		records = append(records, summarizeRecord(record))
+	}
+
+	_, err := fmt.Fprintf(w, "RequestID: %s, Part: %d/%d, Tenant: %d, Records: %d [%s]\n",
+		msg.RequestId, msg.MessageNumber, msg.TotalMessageCount, msg.TenantUid, len(msg.Records), strings.Join(records, "; "))
+	return err
+}
+
+func summarizeRecord(record *models.Record) string {
+	op := record.OperationType.String()
+	switch data := record.Data.(type) {
+	case *models.Record_Department:
+		dept := data.Department
+		return fmt.Sprintf("%s department %s %s %q v%d", op, dept.Biid, dept.Code, dept.Name, dept.Version)
+	case *models.Record_AggregationPattern:
+		agg := data.AggregationPattern
+		return fmt.Sprintf("%s aggregation_pattern %s %q v%d", op, agg.Biid, agg.Name, agg.Version)
+	case *models.Record_AggregationPatternDepartment:
+		link := data.AggregationPatternDepartment
+		return fmt.Sprintf("%s aggregation_pattern_department %s -> %s", op, link.AggregationPatternId, link.DepartmentBiid)
+	default:
+		return op + " unknown"
+	}
+}
+
+// FieldString formats a single field of a message for display.
+// Dates are formatted as YYYY-MM-DD, timestamps as RFC3339 and enums by name.
+// The boolean result is false when the field has presence and is not set.
+func FieldString(m protoreflect.Message, fd protoreflect.FieldDescriptor) (string, bool) {
+	if fd.HasPresence() && !m.Has(fd) {
+		return "", false
+	}
+
+	v := m.Get(fd)
+	switch fd.Kind() {
+	case protoreflect.MessageKind:
+		switch value := v.Message().Interface().(type) {
+		case *date.Date:
+			return fmt.Sprintf("%04d-%02d-%02d", value.Year, value.Month, value.Day), true
+		case *timestamppb.Timestamp:
+			return value.AsTime().Format(time.RFC3339Nano), true
+		default:
+			return fmt.Sprint(value), true
+		}
+	case protoreflect.EnumKind:
+		if enumValue := fd.Enum().Values().ByNumber(v.Enum()); enumValue != nil {
+			return string(enumValue.Name()), true
+		}
+		return fmt.Sprint(v.Enum()), true
+	default:
+		return v.String(), true
+	}
+}
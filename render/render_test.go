@@ -0,0 +1,144 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"kafka_test/models"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"gopkg.in/yaml.v3"
+)
+
+func TestWriteJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"scalars", `{"s": "text", "i": 42, "f": -1.5e+06, "t": true, "f2": false}`},
+		{"null", `{"n": null, "list": [null, 1]}`},
+		{"quoted numbers", `{"uint64": "18446744073709551615", "bool": "true", "null": "null"}`},
+		{"escapes", `{"quote": "a \"b\"\\c", "newline": "a\nb", "html": "<a&b>", "unicode": "é€"}`},
+		{"nested", `{"a": {"b": {"c": [1, {"d": []}, {}]}}, "e": {}}`},
+		{"ordering", `{"z": 1, "a": 2, "m": {"y": 1, "b": 2}}`},
+		{"sequence", `[{"b": 1}, {"a": 2}]`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var doc yaml.Node
+			if err := yaml.Unmarshal([]byte(test.input), &doc); err != nil {
+				t.Fatal(err)
+			}
+			var got bytes.Buffer
+			if err := writeJSON(&got, doc.Content[0], ""); err != nil {
+				t.Fatal(err)
+			}
+
+			var want bytes.Buffer
+			if err := json.Indent(&want, []byte(test.input), "", "  "); err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != want.String() {
+				t.Fatalf("got\n%s\nwant\n%s", got.String(), want.String())
+			}
+		})
+	}
+}
+
+func testMessage() *models.ChangeDataMessage {
+	parent := "d-0"
+	return &models.ChangeDataMessage{
+		RequestId:         "r1",
+		TenantUid:         7,
+		MessageNumber:     1,
+		TotalMessageCount: 2,
+		Records: []*models.Record{
+			{OperationType: models.Record_UPDATE, Data: &models.Record_Department{Department: &models.Department{
+				TenantUid: 7, Biid: "d-1", Code: "D1", Name: "<One & Two>", Version: 3, ParentBiid: &parent,
+			}}},
+			{OperationType: models.Record_DELETE, Data: &models.Record_AggregationPattern{AggregationPattern: &models.AggregationPattern{
+				TenantUid: 7, Biid: "p-1", Version: 2,
+			}}},
+		},
+	}
+}
+
+func render(t *testing.T, format string, opts Options) string {
+	t.Helper()
+	renderer, err := New(format, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := RenderString(renderer, testMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return output
+}
+
+func TestProtoJSONMatchesProtojson(t *testing.T) {
+	output := render(t, FormatProtoJSON, Options{})
+
+	var got models.ChangeDataMessage
+	if err := protojson.Unmarshal([]byte(output), &got); err != nil {
+		t.Fatalf("output is not protojson: %v\n%s", err, output)
+	}
+	if !strings.Contains(output, `"name": "<One & Two>"`) {
+		t.Fatalf("strings escaped differently from protojson:\n%s", output)
+	}
+	if strings.Index(output, `"request_id"`) > strings.Index(output, `"records"`) {
+		t.Fatalf("fields not in protojson order:\n%s", output)
+	}
+}
+
+func TestFieldMask(t *testing.T) {
+	output := render(t, FormatProtoJSON, Options{Fields: []string{"request_id", "records.department.code", "records.operation_type"}})
+	want := `{
+  "request_id": "r1",
+  "records": [
+    {
+      "operation_type": "UPDATE",
+      "department": {
+        "code": "D1"
+      }
+    },
+    {
+      "operation_type": "DELETE"
+    }
+  ]
+}`
+	if output != want {
+		t.Fatalf("got\n%s\nwant\n%s", output, want)
+	}
+
+	// A whole field overrides narrower selections, in any order
+	wide := render(t, FormatYAML, Options{Fields: []string{"records.department.code", "records"}})
+	narrow := render(t, FormatYAML, Options{Fields: []string{"records", "records.department.code"}})
+	if wide != narrow || !strings.Contains(wide, "name: <One & Two>") {
+		t.Fatalf("whole field not selected:\n%s\n%s", wide, narrow)
+	}
+
+	// Unselected fields stay out even with default values emitted
+	defaults := render(t, FormatYAML, Options{Fields: []string{"request_id"}, EmitDefaults: true})
+	if defaults != "request_id: r1\n---" {
+		t.Fatalf("got %q, want only request_id", defaults)
+	}
+}
+
+func TestInvalidFieldMask(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"nope", "unknown field nope"},
+		{"records.department.nope", "unknown field nope"},
+		{"request_id.x", "request_id is not a message"},
+	}
+	for _, test := range tests {
+		if _, err := New(FormatYAML, Options{Fields: []string{test.path}}); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Fatalf("path %q: got %v, want an error containing %q", test.path, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package render
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"kafka_test/models"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// defaultTableColumns are the record fields shown when no field mask is given
+var defaultTableColumns = []string{"biid", "code", "name", "version", "valid_from", "valid_to", "parent_biid", "path"}
+
+// columnFallbacks lists fields used for a column when the entity lacks it,
+// so that aggregation pattern department rows show the department they link
+var columnFallbacks = map[string][]string{
+	"biid": {"department_biid"},
+}
+
+type tableRenderer struct {
+	columns []string
+}
+
+// newTableRenderer derives the table columns from "records.<entity>.<field>" paths
+func newTableRenderer(fields []string) *tableRenderer {
+	var columns []string
+	seen := make(map[string]bool)
+	for _, path := range fields {
+		parts := strings.Split(strings.TrimSpace(path), ".")
+		if len(parts) != 3 || parts[0] != "records" || seen[parts[2]] {
+			continue
+		}
+		seen[parts[2]] = true
+		columns = append(columns, parts[2])
+	}
+	if len(columns) == 0 {
+		columns = defaultTableColumns
+	}
+	return &tableRenderer{columns: columns}
+}
+
+func (t *tableRenderer) Render(w io.Writer, msg *models.ChangeDataMessage) error {
+	fmt.Fprintf(w, "RequestID: %s  Part: %d/%d  Tenant: %d  Records: %d\n",
+		msg.RequestId, msg.MessageNumber, msg.TotalMessageCount, msg.TenantUid, len(msg.Records))
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := []string{"OP", "ENTITY"}
+	for _, column := range t.columns {
+		header = append(header, strings.ToUpper(column))
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+
+	for _, record := range msg.Records {
+		entity, data := recordEntity(record)
+		row := []string{record.OperationType.String(), entity}
+		for _, column := range t.columns {
+			row = append(row, columnValue(data, column))
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// recordEntity returns the entity name and payload of a record
+func recordEntity(record *models.Record) (string, proto.Message) {
+	switch data := record.Data.(type) {
+	case *models.Record_Department:
+		return "department", data.Department
+	case *models.Record_AggregationPattern:
+		return "aggregation_pattern", data.AggregationPattern
+	case *models.Record_AggregationPatternDepartment:
+		return "aggregation_pattern_department", data.AggregationPatternDepartment
+	default:
+		return "unknown", nil
+	}
+}
+
+// columnValue returns the display value of a field, "-" when absent
+func columnValue(data proto.Message, column string) string {
+	if data == nil {
+		return "-"
+	}
+	m := data.ProtoReflect()
+	fd := m.Descriptor().Fields().ByName(protoreflect.Name(column))
+	for _, fallback := range columnFallbacks[column] {
+		if fd != nil {
+			break
+		}
+		fd = m.Descriptor().Fields().ByName(protoreflect.Name(fallback))
+	}
+	if fd == nil {
+		return "-"
+	}
+	value, ok := FieldString(m, fd)
+	if !ok || value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,325 @@
+// Package replay reprocesses a range of a topic, selected by offsets or timestamps, with a
+// consumer group handler. It reads the partitions directly instead of joining the group, so
+// the group's offsets are left alone unless the replay commits.
+package replay
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kafka_test/config"
+	"kafka_test/metrics"
+	"kafka_test/partitionrange"
+
+	"github.com/Shopify/sarama"
+)
+
+// errStopped ends the read of a range whose claim the handler stopped consuming
+var errStopped = errors.New("replay claim stopped")
+
+// Options selects the range to replay
+type Options struct {
+	Topic string
+	// GroupID receives the offsets of the replayed messages with Commit
+	GroupID string
+	// FromTimestamp starts each partition at its first message at or after it
+	FromTimestamp time.Time
+	// FromOffsets starts the listed partitions at an offset, only they are replayed
+	FromOffsets map[int32]int64
+	// ToTimestamp ends each partition before its first message after it
+	ToTimestamp time.Time
+	// UntilEnd ends each partition at its high water mark when the replay starts
+	UntilEnd bool
+	// Commit commits the offsets of the replayed messages to the group
+	Commit bool
+}
+
+// Enabled reports whether a replay was asked for
+func (o *Options) Enabled() bool {
+	return !o.FromTimestamp.IsZero() || len(o.FromOffsets) > 0 || !o.ToTimestamp.IsZero() || o.UntilEnd
+}
+
+// RegisterFlags registers the replay flags on fs and returns the options they set
+func RegisterFlags(fs *flag.FlagSet) *Options {
+	opts := &Options{}
+	fs.Func("from-timestamp", "Replay from this RFC3339 time or date (e.g. 2024-05-01)", timestampFlag(&opts.FromTimestamp))
+	fs.Func("from-offset", "Replay a partition from an offset, as partition:offset; repeat for more partitions", func(value string) error {
+		partition, offset, ok := strings.Cut(value, ":")
+		p, err := strconv.ParseInt(partition, 10, 32)
+		if !ok || err != nil || p < 0 {
+			return fmt.Errorf("invalid partition:offset %q", value)
+		}
+		o, err := strconv.ParseInt(offset, 10, 64)
+		if err != nil || o < 0 {
+			return fmt.Errorf("invalid partition:offset %q", value)
+		}
+		if opts.FromOffsets == nil {
+			opts.FromOffsets = make(map[int32]int64)
+		}
+		opts.FromOffsets[int32(p)] = o
+		return nil
+	})
+	fs.Func("to-timestamp", "Stop replaying each partition before its first message after this RFC3339 time or date", timestampFlag(&opts.ToTimestamp))
+	fs.BoolVar(&opts.UntilEnd, "until-end", false, "Stop replaying each partition at its end when the replay starts")
+	fs.BoolVar(&opts.Commit, "commit", false, "Commit the offsets of the replayed messages to the consumer group")
+	return opts
+}
+
+// timestampFlag parses an RFC3339 time or a date into t
+func timestampFlag(t *time.Time) func(string) error {
+	return func(value string) error {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			if parsed, err = time.Parse(time.DateOnly, value); err != nil {
+				return fmt.Errorf("invalid time %q: want RFC3339 or YYYY-MM-DD", value)
+			}
+		}
+		*t = parsed
+		return nil
+	}
+}
+
+// partitionRange is the range of a partition to replay, [From, To), and what was replayed
+type partitionRange struct {
+	partition int32
+	from      int64
+	// to is -1 for a replay following the partition until it is cancelled
+	to        int64
+	processed int64
+	// last is the offset of the last message handed over, -1 before the first
+	last int64
+	// next is the offset the replay reached, to once the whole range was replayed
+	next int64
+}
+
+// Run replays the selected range through the handler as one session and logs a summary
+// of the messages processed per partition. It returns once every partition reached the
+// end of its range, or when ctx is cancelled for a replay without an end.
+func Run(ctx context.Context, opts Options, handler sarama.ConsumerGroupHandler) error {
+	if !opts.ToTimestamp.IsZero() && opts.ToTimestamp.Before(opts.FromTimestamp) {
+		return errors.New("the replay ends before it starts: --to-timestamp is before --from-timestamp")
+	}
+
+	kafkaConfig := config.GetConsumerConfig()
+	metrics.RegisterSarama("consumer", kafkaConfig.MetricRegistry)
+
+	client, err := sarama.NewClient(config.GetBrokers(), kafkaConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create client: %w", err)
+	}
+	defer client.Close()
+
+	ranges, err := plan(client, opts)
+	if err != nil {
+		return err
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to create consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	s := &session{ctx: ctx, claims: map[string][]int32{}}
+	if opts.Commit {
+		if s.offsets, err = sarama.NewOffsetManagerFromClient(opts.GroupID, client); err != nil {
+			return fmt.Errorf("failed to create offset manager: %w", err)
+		}
+		s.partitions = make(map[int32]sarama.PartitionOffsetManager)
+	}
+	defer s.close()
+
+	claims := make([]*claim, 0, len(ranges))
+	for _, r := range ranges {
+		if r.to >= 0 && r.from >= r.to {
+			continue
+		}
+		partitionConsumer, err := consumer.ConsumePartition(opts.Topic, r.partition, r.from)
+		if err != nil {
+			return fmt.Errorf("failed to consume partition %d: %w", r.partition, err)
+		}
+		defer partitionConsumer.Close()
+		if s.offsets != nil {
+			pom, err := s.offsets.ManagePartition(opts.Topic, r.partition)
+			if err != nil {
+				return fmt.Errorf("failed to manage offsets of partition %d: %w", r.partition, err)
+			}
+			s.partitions[r.partition] = pom
+		}
+		s.claims[opts.Topic] = append(s.claims[opts.Topic], r.partition)
+		claims = append(claims, &claim{
+			topic:     opts.Topic,
+			partition: r.partition,
+			from:      r.from,
+			consumer:  partitionConsumer,
+			messages:  make(chan *sarama.ConsumerMessage),
+			replayed:  r,
+			stopped:   make(chan struct{}),
+		})
+	}
+
+	slog.Info("Replay started", "topic", opts.Topic, "partitions", len(claims), "commit", opts.Commit)
+	start := time.Now()
+
+	if err := handler.Setup(s); err != nil {
+		return fmt.Errorf("failed to set up replay: %w", err)
+	}
+
+	reader := partitionrange.NewReader(client)
+	var wg sync.WaitGroup
+	for _, c := range claims {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			forward(ctx, reader, c)
+		}()
+		go func() {
+			defer wg.Done()
+			defer close(c.stopped)
+			if err := handler.ConsumeClaim(s, c); err != nil {
+				slog.Error("Error replaying partition", "partition", c.partition, "error", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	err = handler.Cleanup(s)
+	summarize(opts.Topic, ranges, time.Since(start))
+	return err
+}
+
+// plan resolves the range to replay of each selected partition
+func plan(client sarama.Client, opts Options) ([]*partitionRange, error) {
+	partitions, err := client.Partitions(opts.Topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get partitions for topic %s: %w", opts.Topic, err)
+	}
+	if len(opts.FromOffsets) > 0 {
+		known := make(map[int32]bool, len(partitions))
+		for _, partition := range partitions {
+			known[partition] = true
+		}
+		// The client caches the partitions it returned, select into a new slice
+		partitions = make([]int32, 0, len(opts.FromOffsets))
+		for partition := range opts.FromOffsets {
+			if !known[partition] {
+				return nil, fmt.Errorf("topic %s has no partition %d", opts.Topic, partition)
+			}
+			partitions = append(partitions, partition)
+		}
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	}
+
+	ranges := make([]*partitionRange, 0, len(partitions))
+	for _, partition := range partitions {
+		oldest, err := client.GetOffset(opts.Topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get oldest offset for partition %d: %w", partition, err)
+		}
+		newest, err := client.GetOffset(opts.Topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get newest offset for partition %d: %w", partition, err)
+		}
+
+		r := &partitionRange{partition: partition, from: oldest, to: -1, last: -1}
+		if offset, ok := opts.FromOffsets[partition]; ok {
+			r.from = offset
+		} else if !opts.FromTimestamp.IsZero() {
+			if r.from, err = offsetAt(client, opts.Topic, partition, opts.FromTimestamp, newest); err != nil {
+				return nil, err
+			}
+		}
+		if r.from < oldest {
+			slog.Warn("Replay start was deleted, replaying from the oldest offset", "partition", partition, "from", r.from, "oldest", oldest)
+			r.from = oldest
+		}
+		if r.from > newest {
+			slog.Warn("Replay start is past the end of the partition, replaying from the end", "partition", partition, "from", r.from, "newest", newest)
+			r.from = newest
+		}
+
+		if opts.UntilEnd {
+			r.to = newest
+		}
+		if !opts.ToTimestamp.IsZero() {
+			// The first message after the end time ends the range
+			to, err := offsetAt(client, opts.Topic, partition, opts.ToTimestamp.Add(time.Millisecond), newest)
+			if err != nil {
+				return nil, err
+			}
+			if r.to < 0 || to < r.to {
+				r.to = to
+			}
+		}
+		r.next = r.from
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// offsetAt returns the offset of the first message of a partition at or after t, the
+// newest offset if there is none
+func offsetAt(client sarama.Client, topic string, partition int32, t time.Time, newest int64) (int64, error) {
+	offset, err := client.GetOffset(topic, partition, t.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("failed to get offset of partition %d at %s: %w", partition, t.Format(time.RFC3339), err)
+	}
+	if offset < 0 {
+		return newest, nil
+	}
+	return offset, nil
+}
+
+// forward hands the messages of the range to the claim and closes it at the end of the range,
+// when the handler stops consuming the claim or ctx is cancelled
+func forward(ctx context.Context, reader *partitionrange.Reader, c *claim) {
+	defer close(c.messages)
+	r := c.replayed
+	rng := partitionrange.Range{Topic: c.topic, Partition: c.partition, From: r.from, To: r.to}
+	next, err := reader.Read(ctx, c.consumer, rng, func(message *sarama.ConsumerMessage) error {
+		select {
+		case c.messages <- message:
+			r.processed++
+			r.last = message.Offset
+			return nil
+		case <-c.stopped:
+			return errStopped
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	r.next = next
+	if err != nil && !errors.Is(err, errStopped) && ctx.Err() == nil {
+		slog.Error("Error reading replayed partition", "partition", c.partition, "offset", next, "error", err)
+	}
+}
+
+// summarize logs the messages replayed per partition, with a warning for the ranges that
+// ended before their end
+func summarize(topic string, ranges []*partitionRange, elapsed time.Duration) {
+	var total int64
+	for _, r := range ranges {
+		total += r.processed
+		attrs := []any{"topic", topic, "partition", r.partition, "from", r.from, "processed", r.processed}
+		if r.to >= 0 {
+			attrs = append(attrs, "to", r.to)
+		}
+		if r.last >= 0 {
+			attrs = append(attrs, "last_offset", r.last)
+		}
+		if r.to >= 0 && r.next < r.to {
+			slog.Warn("Replay of partition ended before the end of its range", append(attrs, "next_offset", r.next)...)
+			continue
+		}
+		slog.Info("Replayed partition", attrs...)
+	}
+	slog.Info("Replay finished", "topic", topic, "partitions", len(ranges), "processed", total, "elapsed", elapsed.String())
+}
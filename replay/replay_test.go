@@ -0,0 +1,59 @@
+package replay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"kafka_test/partitionrange"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+)
+
+func TestForwardRecordsOffsetReachedWhenStopped(t *testing.T) {
+	consumer := mocks.NewConsumer(t, nil)
+	defer consumer.Close()
+	expectation := consumer.ExpectConsumePartition("test-topic", 0, 0)
+	for i := 0; i < 3; i++ {
+		expectation.YieldMessage(&sarama.ConsumerMessage{Value: []byte("value")})
+	}
+	pc, err := consumer.ConsumePartition("test-topic", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &partitionRange{from: 0, to: 10, last: -1}
+	c := &claim{
+		topic:    "test-topic",
+		from:     r.from,
+		consumer: pc,
+		messages: make(chan *sarama.ConsumerMessage),
+		replayed: r,
+		stopped:  make(chan struct{}),
+	}
+	go forward(context.Background(), partitionrange.NewReader(nil), c)
+
+	// The handler takes the first message and stops consuming the claim
+	timeout := time.After(5 * time.Second)
+	select {
+	case <-c.messages:
+	case <-timeout:
+		t.Fatal("no message was replayed")
+	}
+	close(c.stopped)
+	for {
+		select {
+		case _, ok := <-c.messages:
+			if !ok {
+				if r.processed != 1 || r.last != 0 || r.next != 1 {
+					t.Fatalf("processed %d up to %d, next %d, want 1 up to 0, next 1", r.processed, r.last, r.next)
+				}
+				return
+			}
+			t.Fatal("a message was replayed after the claim stopped")
+		case <-timeout:
+			t.Fatal("the claim was not closed")
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package replay
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/Shopify/sarama"
+)
+
+// memberID identifies replays in the logs of consumer group handlers
+const memberID = "replay"
+
+// session stands in for a consumer group session, so consumer group handlers replay
+// unchanged. Offsets are committed through the offset manager, or dropped without one.
+type session struct {
+	ctx     context.Context
+	claims  map[string][]int32
+	offsets sarama.OffsetManager
+	// partitions manage the offsets of the replayed partitions, nil without an offset manager
+	partitions map[int32]sarama.PartitionOffsetManager
+}
+
+func (s *session) Claims() map[string][]int32 { return s.claims }
+
+func (s *session) MemberID() string { return memberID }
+
+func (s *session) GenerationID() int32 { return 0 }
+
+func (s *session) Context() context.Context { return s.ctx }
+
+func (s *session) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	if pom := s.partitions[partition]; pom != nil {
+		pom.MarkOffset(offset, metadata)
+	}
+}
+
+func (s *session) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+	if pom := s.partitions[partition]; pom != nil {
+		pom.ResetOffset(offset, metadata)
+	}
+}
+
+func (s *session) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+func (s *session) Commit() {
+	if s.offsets != nil {
+		s.offsets.Commit()
+	}
+}
+
+// close commits the marked offsets and stops managing them
+func (s *session) close() {
+	if s.offsets == nil {
+		return
+	}
+	// Closing flushes the offsets marked since the last commit
+	if err := s.offsets.Close(); err != nil {
+		slog.Warn("Failed to close offset manager", "error", err)
+	}
+}
+
+// claim stands in for a consumer group claim, handing over the messages of the replayed range
+type claim struct {
+	topic     string
+	partition int32
+	from      int64
+	consumer  sarama.PartitionConsumer
+	messages  chan *sarama.ConsumerMessage
+	// replayed is the range of the partition, counting what was handed over
+	replayed *partitionRange
+	// stopped is closed once the handler stopped consuming the claim
+	stopped chan struct{}
+}
+
+func (c *claim) Topic() string { return c.topic }
+
+func (c *claim) Partition() int32 { return c.partition }
+
+func (c *claim) InitialOffset() int64 { return c.from }
+
+func (c *claim) HighWaterMarkOffset() int64 { return c.consumer.HighWaterMarkOffset() }
+
+func (c *claim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
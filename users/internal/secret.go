@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// ReadPassword resolves a password reference so passwords never appear in argv
+// or the shell history:
+//
+//	prompt     ask twice on the terminal (default)
+//	stdin      read the first line of stdin, for pipes and CI
+//	env:NAME   read the environment variable NAME
+//	file:PATH  read the file PATH, without its trailing newline
+func ReadPassword(ref, user string) ([]byte, error) {
+	var password []byte
+	switch {
+	case ref == "" || ref == "prompt":
+		var err error
+		if password, err = promptPassword(user); err != nil {
+			return nil, err
+		}
+	case ref == "stdin":
+		line, err := bufio.NewReader(os.Stdin).ReadBytes('\n')
+		if err != nil && len(line) == 0 {
+			return nil, fmt.Errorf("failed to read password from stdin: %w", err)
+		}
+		password = bytes.TrimRight(line, "\r\n")
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("password environment variable %s is not set", name)
+		}
+		password = []byte(value)
+	case strings.HasPrefix(ref, "file:"):
+		data, err := os.ReadFile(strings.TrimPrefix(ref, "file:"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read password file: %w", err)
+		}
+		password = bytes.TrimRight(data, "\r\n")
+	default:
+		return nil, fmt.Errorf("unknown password reference %q, use prompt, stdin, env:NAME or file:PATH", ref)
+	}
+
+	if len(password) == 0 {
+		return nil, errors.New("password is empty")
+	}
+	return password, nil
+}
+
+func promptPassword(user string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, errors.New("stdin is not a terminal, pass the password with -password stdin, env:NAME or file:PATH")
+	}
+
+	fmt.Fprintf(os.Stderr, "Password for %s: ", user)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Confirm password: ")
+	confirmation, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password: %w", err)
+	}
+	if !bytes.Equal(password, confirmation) {
+		return nil, errors.New("passwords do not match")
+	}
+	return password, nil
+}
@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"kafka_test/admin"
+
+	"github.com/Shopify/sarama"
+)
+
+// DefaultIterations is the iteration count of new credentials, the kafka-configs default
+const DefaultIterations = 4096
+
+// Credential is one SCRAM mechanism configured for a user
+type Credential struct {
+	Mechanism  string `json:"mechanism" yaml:"mechanism"`
+	Iterations int32  `json:"iterations" yaml:"iterations"`
+}
+
+// User is a SCRAM user and its credentials
+type User struct {
+	Name        string       `json:"name" yaml:"name"`
+	Credentials []Credential `json:"credentials" yaml:"credentials"`
+}
+
+// Users is a list of SCRAM users sorted by name
+type Users struct {
+	Users []User `json:"users" yaml:"users"`
+}
+
+// ParseMechanisms parses a comma separated list of SCRAM-SHA-256 and SCRAM-SHA-512
+func ParseMechanisms(list string) ([]sarama.ScramMechanismType, error) {
+	var mechanisms []sarama.ScramMechanismType
+	for _, name := range strings.Split(list, ",") {
+		switch strings.ToUpper(strings.TrimSpace(name)) {
+		case sarama.SASLTypeSCRAMSHA256:
+			mechanisms = append(mechanisms, sarama.SCRAM_MECHANISM_SHA_256)
+		case sarama.SASLTypeSCRAMSHA512:
+			mechanisms = append(mechanisms, sarama.SCRAM_MECHANISM_SHA_512)
+		default:
+			return nil, fmt.Errorf("unknown SCRAM mechanism %q, use %s or %s", name, sarama.SASLTypeSCRAMSHA256, sarama.SASLTypeSCRAMSHA512)
+		}
+	}
+	return mechanisms, nil
+}
+
+// List returns the SCRAM credentials of the given users, or of every user
+func List(conn *admin.Conn, names []string) (*Users, error) {
+	results, err := conn.Admin.DescribeUserScramCredentials(names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe SCRAM credentials: %w", err)
+	}
+
+	users := &Users{Users: []User{}}
+	for _, result := range results {
+		if result.ErrorCode != sarama.ErrNoError {
+			return nil, fmt.Errorf("failed to describe SCRAM credentials of %s: %w", result.User, resultError(result.ErrorCode, result.ErrorMessage))
+		}
+		user := User{Name: result.User, Credentials: []Credential{}}
+		for _, info := range result.CredentialInfos {
+			user.Credentials = append(user.Credentials, Credential{Mechanism: info.Mechanism.String(), Iterations: info.Iterations})
+		}
+		sort.Slice(user.Credentials, func(i, j int) bool { return user.Credentials[i].Mechanism < user.Credentials[j].Mechanism })
+		users.Users = append(users.Users, user)
+	}
+	sort.Slice(users.Users, func(i, j int) bool { return users.Users[i].Name < users.Users[j].Name })
+	return users, nil
+}
+
+// Upsert creates or replaces the credentials of a user for each mechanism, with a random salt
+func Upsert(conn *admin.Conn, name string, password []byte, mechanisms []sarama.ScramMechanismType, iterations int32) error {
+	var upserts []sarama.AlterUserScramCredentialsUpsert
+	for _, mechanism := range mechanisms {
+		salt := make([]byte, 32)
+		if _, err := rand.Read(salt); err != nil {
+			return fmt.Errorf("failed to generate salt: %w", err)
+		}
+		upserts = append(upserts, sarama.AlterUserScramCredentialsUpsert{
+			Name:       name,
+			Mechanism:  mechanism,
+			Iterations: iterations,
+			Salt:       salt,
+			Password:   password,
+		})
+	}
+
+	results, err := conn.Admin.UpsertUserScramCredentials(upserts)
+	if err != nil {
+		return fmt.Errorf("failed to upsert SCRAM credentials of %s: %w", name, err)
+	}
+	return checkResults(results, "upsert", name)
+}
+
+// Delete removes the credentials of a user for the given mechanisms, or for
+// every mechanism the user has when mechanisms is empty. It returns the
+// mechanisms that were removed.
+func Delete(conn *admin.Conn, name string, mechanisms []sarama.ScramMechanismType) ([]string, error) {
+	if len(mechanisms) == 0 {
+		users, err := List(conn, []string{name})
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users.Users {
+			for _, credential := range user.Credentials {
+				parsed, err := ParseMechanisms(credential.Mechanism)
+				if err != nil {
+					return nil, err
+				}
+				mechanisms = append(mechanisms, parsed...)
+			}
+		}
+		if len(mechanisms) == 0 {
+			return nil, fmt.Errorf("user %s has no SCRAM credentials", name)
+		}
+	}
+
+	var deletes []sarama.AlterUserScramCredentialsDelete
+	var deleted []string
+	for _, mechanism := range mechanisms {
+		deletes = append(deletes, sarama.AlterUserScramCredentialsDelete{Name: name, Mechanism: mechanism})
+		deleted = append(deleted, mechanism.String())
+	}
+
+	results, err := conn.Admin.DeleteUserScramCredentials(deletes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete SCRAM credentials of %s: %w", name, err)
+	}
+	if err := checkResults(results, "delete", name); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+func checkResults(results []*sarama.AlterUserScramCredentialsResult, operation, name string) error {
+	for _, result := range results {
+		if result.ErrorCode != sarama.ErrNoError {
+			return fmt.Errorf("failed to %s SCRAM credentials of %s: %w", operation, name, resultError(result.ErrorCode, result.ErrorMessage))
+		}
+	}
+	return nil
+}
+
+// resultError adds the broker's message, which names the actual problem, to the error code
+func resultError(code sarama.KError, message *string) error {
+	if message != nil && *message != "" {
+		return fmt.Errorf("%w: %s", code, *message)
+	}
+	return code
+}
+
+// WriteTable prints one line per user and mechanism
+func (u *Users) WriteTable(tw *tabwriter.Writer) {
+	if len(u.Users) == 0 {
+		fmt.Fprintln(tw, "No SCRAM users found.")
+		return
+	}
+	admin.Row(tw, "USER", "MECHANISM", "ITERATIONS")
+	for _, user := range u.Users {
+		if len(user.Credentials) == 0 {
+			admin.Row(tw, user.Name, "-", "-")
+		}
+		for _, credential := range user.Credentials {
+			admin.Row(tw, user.Name, credential.Mechanism, fmt.Sprint(credential.Iterations))
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"kafka_test/admin"
+	"kafka_test/users/internal"
+
+	"github.com/Shopify/sarama"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: users <command> [flags]
+
+Commands:
+  list    List SCRAM users and their mechanisms
+  upsert  Create a SCRAM user or change its password
+  delete  Delete the SCRAM credentials of a user
+
+Run "users <command> -h" for command flags.
+`)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	command, args := os.Args[1], os.Args[2:]
+
+	var err error
+	switch command {
+	case "list":
+		err = runList(args)
+	case "upsert":
+		err = runUpsert(args)
+	case "delete":
+		err = runDelete(args)
+	case "help", "-h", "-help", "--help":
+		usage()
+		return
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("users %s failed: %v", command, err)
+	}
+}
+
+// runList prints the SCRAM credentials of the given users, or of every user
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	format := fs.String("format", admin.FormatTable, "output format: table, json or yaml")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: users list [flags] [user...]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if err := admin.ValidateFormat(*format); err != nil {
+		return err
+	}
+
+	conn, err := admin.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	users, err := internal.List(conn, fs.Args())
+	if err != nil {
+		return err
+	}
+	return admin.Write(os.Stdout, *format, users)
+}
+
+// runUpsert sets the password of a user for each mechanism
+func runUpsert(args []string) error {
+	fs := flag.NewFlagSet("upsert", flag.ExitOnError)
+	name := fs.String("user", "", "user name (required)")
+	mechanisms := fs.String("mechanism", sarama.SASLTypeSCRAMSHA512, "comma separated SCRAM mechanisms: SCRAM-SHA-256, SCRAM-SHA-512")
+	iterations := fs.Int("iterations", internal.DefaultIterations, "SCRAM iterations, at least 4096")
+	password := fs.String("password", "prompt", "where to read the password: prompt, stdin, env:NAME or file:PATH")
+	fs.Parse(args)
+
+	if *name == "" {
+		return fmt.Errorf("-user is required")
+	}
+	if *iterations < internal.DefaultIterations {
+		return fmt.Errorf("-iterations must be at least %d", internal.DefaultIterations)
+	}
+	parsed, err := internal.ParseMechanisms(*mechanisms)
+	if err != nil {
+		return err
+	}
+	secret, err := internal.ReadPassword(*password, *name)
+	if err != nil {
+		return err
+	}
+
+	conn, err := admin.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := internal.Upsert(conn, *name, secret, parsed, int32(*iterations)); err != nil {
+		return err
+	}
+	log.Printf("Upserted SCRAM credentials of %s: %s", *name, *mechanisms)
+	return nil
+}
+
+// runDelete removes the credentials of a user, for every mechanism unless -mechanism is set
+func runDelete(args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ExitOnError)
+	name := fs.String("user", "", "user name (required)")
+	mechanisms := fs.String("mechanism", "", "comma separated SCRAM mechanisms to delete, all by default")
+	fs.Parse(args)
+
+	if *name == "" {
+		return fmt.Errorf("-user is required")
+	}
+	var parsed []sarama.ScramMechanismType
+	if *mechanisms != "" {
+		var err error
+		if parsed, err = internal.ParseMechanisms(*mechanisms); err != nil {
+			return err
+		}
+	}
+
+	conn, err := admin.Connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deleted, err := internal.Delete(conn, *name, parsed)
+	if err != nil {
+		return err
+	}
+	log.Printf("Deleted SCRAM credentials of %s: %v", *name, deleted)
+	return nil
+}
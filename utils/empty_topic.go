@@ -1,140 +1,205 @@
 package main
 
 import (
-	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"os/signal"
-	"syscall"
+	"sort"
+	"text/tabwriter"
+	"time"
 
+	"kafka_test/admin"
 	"kafka_test/config"
-
-	"github.com/Shopify/sarama"
 )
 
 var (
-	topicName = flag.String("topic", config.GetTopicName(), "Topic to empty")
-	broker    = flag.String("broker", config.GetBroker(), "Kafka broker address")
-	groupID   = flag.String("group", config.GetTopicEmptierGroupID(), "Consumer group ID")
-	confirm   = flag.Bool("confirm", false, "Confirm that you want to empty the topic")
+	topicName    = flag.String("topic", config.GetTopicName(), "Topic to empty")
+	partitions   = flag.String("partitions", "", "Comma-separated partitions to truncate (default all)")
+	beforeOffset = flag.Int64("before-offset", -1, "Only delete records before this offset in each partition")
+	beforeTime   = flag.String("before-time", "", "Only delete records older than this RFC3339 time")
+	format       = flag.String("format", admin.FormatTable, "Output format: table, json or yaml")
+	confirm      = flag.Bool("confirm", false, "Confirm that you want to delete the records; without it only the plan is printed")
 )
 
-// TopicEmptier represents a consumer that empties a topic
+// PartitionTruncation is the planned truncation of one partition
+type PartitionTruncation struct {
+	Partition int32 `json:"partition" yaml:"partition"`
+	Earliest  int64 `json:"earliest" yaml:"earliest"`
+	Latest    int64 `json:"latest" yaml:"latest"`
+	// DeleteBefore becomes the new earliest offset
+	DeleteBefore int64 `json:"delete_before" yaml:"delete_before"`
+	Records      int64 `json:"records" yaml:"records"`
+	// Error is set when the offsets of the partition could not be read; nothing is
+	// deleted from it and its offsets are -1
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// TruncationPlan lists the records that would be or were deleted from a topic
+type TruncationPlan struct {
+	Topic      string                `json:"topic" yaml:"topic"`
+	Executed   bool                  `json:"executed" yaml:"executed"`
+	Records    int64                 `json:"records" yaml:"records"`
+	Partitions []PartitionTruncation `json:"partitions" yaml:"partitions"`
+}
+
+// TopicEmptier deletes records from the start of a topic with DeleteRecords
 type TopicEmptier struct {
-	topic   string
-	groupID string
+	conn  *admin.Conn
+	topic string
 }
 
 // NewTopicEmptier creates a new topic emptier
-func NewTopicEmptier(topic, groupID string) *TopicEmptier {
+func NewTopicEmptier(conn *admin.Conn, topic string) *TopicEmptier {
 	return &TopicEmptier{
-		topic:   topic,
-		groupID: groupID,
+		conn:  conn,
+		topic: topic,
 	}
 }
 
-// Setup is run at the beginning of a new session
-func (t *TopicEmptier) Setup(sarama.ConsumerGroupSession) error {
-	log.Printf("Topic emptier setup completed for topic: %s", t.topic)
-	return nil
+// Plan computes how many records would be deleted from each partition.
+// Without a limit every record up to the high watermark is deleted;
+// beforeOffset < 0 and a zero beforeTime mean no limit.
+func (t *TopicEmptier) Plan(selected []int32, beforeOffset int64, beforeTime time.Time) (*TruncationPlan, error) {
+	if len(selected) == 0 {
+		var err error
+		if selected, err = t.conn.Client.Partitions(t.topic); err != nil {
+			return nil, fmt.Errorf("failed to get partitions of topic %s: %w", t.topic, err)
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i] < selected[j] })
+
+	earliest, latest, offsetErrs := t.conn.LogEndOffsets(t.topic, selected)
+
+	plan := &TruncationPlan{Topic: t.topic}
+	for _, partition := range selected {
+		if err := offsetErrs[partition]; err != nil {
+			plan.Partitions = append(plan.Partitions, PartitionTruncation{
+				Partition:    partition,
+				Earliest:     -1,
+				Latest:       -1,
+				DeleteBefore: -1,
+				Error:        err.Error(),
+			})
+			continue
+		}
+		deleteBefore := latest[partition]
+		if beforeOffset >= 0 {
+			deleteBefore = min(deleteBefore, beforeOffset)
+		}
+		if !beforeTime.IsZero() {
+			offset, err := t.conn.Client.GetOffset(t.topic, partition, beforeTime.UnixMilli())
+			if err != nil {
+				return nil, fmt.Errorf("failed to get offset of %s/%d at %s: %w", t.topic, partition, beforeTime.Format(time.RFC3339), err)
+			}
+			// A negative offset means no record is that recent, so all may go
+			if offset >= 0 {
+				deleteBefore = min(deleteBefore, offset)
+			}
+		}
+		deleteBefore = max(deleteBefore, earliest[partition])
+
+		truncation := PartitionTruncation{
+			Partition:    partition,
+			Earliest:     earliest[partition],
+			Latest:       latest[partition],
+			DeleteBefore: deleteBefore,
+			Records:      deleteBefore - earliest[partition],
+		}
+		plan.Records += truncation.Records
+		plan.Partitions = append(plan.Partitions, truncation)
+	}
+	return plan, nil
 }
 
-// Cleanup is run at the end of a session
-func (t *TopicEmptier) Cleanup(sarama.ConsumerGroupSession) error {
-	log.Printf("Topic emptier cleanup completed for topic: %s", t.topic)
+// Execute deletes the planned records
+func (t *TopicEmptier) Execute(plan *TruncationPlan) error {
+	offsets := make(map[int32]int64)
+	for _, partition := range plan.Partitions {
+		if partition.Records > 0 {
+			offsets[partition.Partition] = partition.DeleteBefore
+		}
+	}
+	if len(offsets) == 0 {
+		log.Printf("Nothing to delete from topic %s", t.topic)
+		plan.Executed = true
+		return nil
+	}
+
+	if err := t.conn.Admin.DeleteRecords(t.topic, offsets); err != nil {
+		return fmt.Errorf("failed to delete records: %w", err)
+	}
+	plan.Executed = true
+	log.Printf("Deleted %d records from topic %s", plan.Records, t.topic)
 	return nil
 }
 
-// ConsumeClaim consumes messages to empty the topic
-func (t *TopicEmptier) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
-	messageCount := 0
-
-	for {
-		select {
-		case message := <-claim.Messages():
-			if message == nil {
-				log.Printf("No more messages in partition %d, offset %d", message.Partition, message.Offset)
-				return nil
-			}
-
-			messageCount++
-			log.Printf("Consuming message #%d - Partition: %d, Offset: %d, Key: %s, Value: %s",
-				messageCount, message.Partition, message.Offset, string(message.Key), string(message.Value))
-
-			// Mark message as consumed
-			session.MarkMessage(message, "")
-			session.Commit()
-
-		case <-session.Context().Done():
-			return nil
+// WriteTable prints the records removed from each partition
+func (p *TruncationPlan) WriteTable(tw *tabwriter.Writer) {
+	status := "DRY RUN"
+	if p.Executed {
+		status = "EXECUTED"
+	}
+	fmt.Fprintf(tw, "Topic: %s  Records: %d  %s\n", p.Topic, p.Records, status)
+	admin.Row(tw, "PARTITION", "EARLIEST", "LATEST", "DELETE BEFORE", "RECORDS")
+	for _, partition := range p.Partitions {
+		if partition.Error != "" {
+			admin.Row(tw, partition.Partition, "-", "-", "-", "-")
+			continue
+		}
+		admin.Row(tw, partition.Partition, partition.Earliest, partition.Latest, partition.DeleteBefore, partition.Records)
+	}
+	for _, partition := range p.Partitions {
+		if partition.Error != "" {
+			fmt.Fprintf(tw, "Not deleting from partition %d: %s\n", partition.Partition, partition.Error)
 		}
 	}
 }
 
-// EmptyTopic empties the specified topic
-func (t *TopicEmptier) EmptyTopic(ctx context.Context) error {
-	// Configure consumer
-	saramaConfig := sarama.NewConfig()
-	saramaConfig.Consumer.Group.Rebalance.Strategy = sarama.BalanceStrategyRoundRobin
-	saramaConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
-	saramaConfig.Consumer.Offsets.AutoCommit.Enable = false
-	saramaConfig.Version = sarama.V2_8_0_0
+func main() {
+	flag.Parse()
 
-	// Use configured brokers or fallback to flag value
-	brokers := config.GetBrokers()
-	if len(brokers) == 0 {
-		brokers = []string{*broker}
+	if err := admin.ValidateFormat(*format); err != nil {
+		log.Fatal(err)
 	}
 
-	// Create consumer group
-	group, err := sarama.NewConsumerGroup(brokers, t.groupID, saramaConfig)
+	selected, err := admin.ParsePartitions(*partitions)
 	if err != nil {
-		return fmt.Errorf("failed to create consumer group: %w", err)
+		log.Fatal(err)
 	}
-	defer group.Close()
 
-	log.Printf("Starting to empty topic: %s", t.topic)
+	var before time.Time
+	if *beforeTime != "" {
+		if before, err = time.Parse(time.RFC3339, *beforeTime); err != nil {
+			log.Fatalf("Invalid -before-time: %v", err)
+		}
+	}
 
-	// Start consuming
-	topics := []string{t.topic}
-	err = group.Consume(ctx, topics, t)
+	conn, err := admin.Connect()
 	if err != nil {
-		return fmt.Errorf("error from consumer: %w", err)
+		log.Fatalf("Failed to connect: %v", err)
 	}
+	defer conn.Close()
 
-	return nil
-}
+	emptier := NewTopicEmptier(conn, *topicName)
+	plan, err := emptier.Plan(selected, *beforeOffset, before)
+	if err != nil {
+		log.Fatalf("Failed to plan truncation: %v", err)
+	}
 
-func main() {
-	flag.Parse()
+	if *confirm {
+		if err := emptier.Execute(plan); err != nil {
+			log.Fatalf("Error emptying topic: %v", err)
+		}
+	}
+
+	if err := admin.Write(os.Stdout, *format, plan); err != nil {
+		log.Fatalf("Failed to write plan: %v", err)
+	}
 
 	if !*confirm {
-		log.Printf("WARNING: This will consume and delete ALL messages from topic '%s'", *topicName)
+		log.Printf("WARNING: This will delete %d records from topic '%s'", plan.Records, *topicName)
 		log.Printf("To confirm, run with -confirm flag")
 		log.Printf("Example: go run utils/empty_topic.go -topic %s -confirm", *topicName)
-		os.Exit(1)
 	}
-
-	// Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// Create topic emptier
-	emptier := NewTopicEmptier(*topicName, *groupID)
-
-	// Start emptying in a goroutine
-	go func() {
-		if err := emptier.EmptyTopic(ctx); err != nil {
-			log.Printf("Error emptying topic: %v", err)
-		}
-	}()
-
-	// Wait for shutdown signal
-	<-sigChan
-	log.Println("Shutting down topic emptier...")
 }
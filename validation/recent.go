@@ -0,0 +1,70 @@
+package validation
+
+import (
+	"container/list"
+	"time"
+)
+
+// recent remembers values by key, forgetting the least recently used beyond its size
+// and those not used for its TTL. A zero size or TTL does not bound it.
+type recent[V any] struct {
+	size int
+	ttl  time.Duration
+	// order holds *recentEntry[V], the most recently used first
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type recentEntry[V any] struct {
+	key     string
+	value   V
+	expires time.Time
+}
+
+func newRecent[V any](size int, ttl time.Duration) *recent[V] {
+	return &recent[V]{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the value of a key and marks it used, false if unknown or expired
+func (r *recent[V]) get(key string) (V, bool) {
+	var zero V
+	element, ok := r.entries[key]
+	if !ok {
+		return zero, false
+	}
+	entry := element.Value.(*recentEntry[V])
+	if r.ttl > 0 && time.Now().After(entry.expires) {
+		r.remove(key)
+		return zero, false
+	}
+	entry.expires = time.Now().Add(r.ttl)
+	r.order.MoveToFront(element)
+	return entry.value, true
+}
+
+// put remembers the value of a key, forgetting the least recently used beyond the size
+func (r *recent[V]) put(key string, value V) {
+	expires := time.Now().Add(r.ttl)
+	if element, ok := r.entries[key]; ok {
+		entry := element.Value.(*recentEntry[V])
+		entry.value, entry.expires = value, expires
+		r.order.MoveToFront(element)
+		return
+	}
+	r.entries[key] = r.order.PushFront(&recentEntry[V]{key: key, value: value, expires: expires})
+	for r.size > 0 && r.order.Len() > r.size {
+		r.remove(r.order.Back().Value.(*recentEntry[V]).key)
+	}
+}
+
+func (r *recent[V]) remove(key string) {
+	if element, ok := r.entries[key]; ok {
+		r.order.Remove(element)
+		delete(r.entries, key)
+	}
+}
@@ -0,0 +1,71 @@
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Report aggregates the violations found over a run
+type Report struct {
+	MessagesChecked int            `json:"messages_checked"`
+	RecordsChecked  int            `json:"records_checked"`
+	Warnings        int            `json:"warnings"`
+	RuleCounts      map[string]int `json:"rule_counts"`
+	Violations      []Violation    `json:"violations"`
+}
+
+// NewReport creates an empty report
+func NewReport() *Report {
+	return &Report{
+		RuleCounts: make(map[string]int),
+	}
+}
+
+// Add records the result of validating one message
+func (r *Report) Add(recordCount int, violations []Violation) {
+	r.MessagesChecked++
+	r.RecordsChecked += recordCount
+	for _, violation := range violations {
+		r.RuleCounts[violation.Rule]++
+		if violation.Severity == SeverityWarning {
+			r.Warnings++
+		}
+	}
+	r.Violations = append(r.Violations, violations...)
+}
+
+// WriteText writes a human readable report
+func (r *Report) WriteText(w io.Writer) error {
+	for _, violation := range r.Violations {
+		if _, err := fmt.Fprintln(w, violation.String()); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(w, "\nChecked %d message(s), %d record(s), found %d violation(s), %d of them warnings\n",
+		r.MessagesChecked, r.RecordsChecked, len(r.Violations), r.Warnings)
+
+	rules := make([]string, 0, len(r.RuleCounts))
+	for rule := range r.RuleCounts {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	for _, rule := range rules {
+		fmt.Fprintf(w, "  %s: %d\n", rule, r.RuleCounts[rule])
+	}
+	return nil
+}
+
+// HasErrors reports whether any violation found is not a warning
+func (r *Report) HasErrors() bool {
+	return HasErrors(r.Violations)
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
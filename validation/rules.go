@@ -0,0 +1,154 @@
+package validation
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+
+	"kafka_test/models"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"gopkg.in/yaml.v3"
+)
+
+// Rules is the declarative rule set loaded from a YAML file
+type Rules struct {
+	Department         EntityRules `yaml:"department"`
+	AggregationPattern EntityRules `yaml:"aggregation_pattern"`
+}
+
+// EntityRules describes the checks applied to one record type.
+// Field names are protobuf field names (e.g. "parent_biid").
+type EntityRules struct {
+	Required          []string          `yaml:"required"`
+	Patterns          map[string]string `yaml:"patterns"`
+	ValidPeriod       bool              `yaml:"valid_period"`
+	TransactionPeriod bool              `yaml:"transaction_period"`
+	VersionMonotonic  bool              `yaml:"version_monotonic"`
+	ParentExists      bool              `yaml:"parent_exists"`
+	// Severity maps rule names to error or warning, rules not listed are errors
+	Severity map[string]string `yaml:"severity"`
+}
+
+// validationRules lists the rule names a severity can be set for
+var validationRules = []string{RuleRequired, RulePattern, RuleValidPeriod, RuleTransactionPeriod, RuleVersionMonotonic, RuleParentExists}
+
+// compiledRules holds the rules resolved against the protobuf descriptors
+type compiledRules struct {
+	department         *compiledEntity
+	aggregationPattern *compiledEntity
+}
+
+type compiledEntity struct {
+	rules    EntityRules
+	required []protoreflect.FieldDescriptor
+	patterns []fieldPattern
+}
+
+// entity returns the compiled rules of an entity name reported in violations
+func (c *compiledRules) entity(name string) *compiledEntity {
+	if name == "aggregation_pattern" {
+		return c.aggregationPattern
+	}
+	return c.department
+}
+
+// severity returns the severity of the violations of a rule
+func (c *compiledEntity) severity(rule string) string {
+	if severity, ok := c.rules.Severity[rule]; ok {
+		return severity
+	}
+	return SeverityError
+}
+
+type fieldPattern struct {
+	field protoreflect.FieldDescriptor
+	re    *regexp.Regexp
+}
+
+// LoadRules reads and parses a rules YAML file
+func LoadRules(path string) (*Rules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+
+	var rules Rules
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse rules file %s: %w", path, err)
+	}
+
+	// Compile once here so that typos in field names fail at load time
+	if _, err := rules.compile(); err != nil {
+		return nil, fmt.Errorf("invalid rules file %s: %w", path, err)
+	}
+	return &rules, nil
+}
+
+func (r *Rules) compile() (*compiledRules, error) {
+	if r.AggregationPattern.ValidPeriod {
+		return nil, fmt.Errorf("aggregation_pattern: valid_period is not supported, aggregation patterns have no valid time")
+	}
+	if r.AggregationPattern.ParentExists {
+		return nil, fmt.Errorf("aggregation_pattern: parent_exists is not supported, aggregation patterns have no parent")
+	}
+
+	dept, err := compileEntity("department", r.Department, (&models.Department{}).ProtoReflect().Descriptor())
+	if err != nil {
+		return nil, err
+	}
+	agg, err := compileEntity("aggregation_pattern", r.AggregationPattern, (&models.AggregationPattern{}).ProtoReflect().Descriptor())
+	if err != nil {
+		return nil, err
+	}
+
+	return &compiledRules{department: dept, aggregationPattern: agg}, nil
+}
+
+func compileEntity(entity string, rules EntityRules, desc protoreflect.MessageDescriptor) (*compiledEntity, error) {
+	compiled := &compiledEntity{rules: rules}
+
+	for rule, severity := range rules.Severity {
+		if !slices.Contains(validationRules, rule) {
+			return nil, fmt.Errorf("%s: unknown rule %q in severity", entity, rule)
+		}
+		if severity != SeverityError && severity != SeverityWarning {
+			return nil, fmt.Errorf("%s: invalid severity %q for %s, use error or warning", entity, severity, rule)
+		}
+	}
+
+	for _, name := range rules.Required {
+		fd := desc.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			return nil, fmt.Errorf("%s: unknown required field %q", entity, name)
+		}
+		compiled.required = append(compiled.required, fd)
+	}
+
+	// Sort pattern fields so violations are reported in a stable order
+	names := make([]string, 0, len(rules.Patterns))
+	for name := range rules.Patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		pattern := rules.Patterns[name]
+		fd := desc.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			return nil, fmt.Errorf("%s: unknown pattern field %q", entity, name)
+		}
+		if fd.Kind() != protoreflect.StringKind {
+			return nil, fmt.Errorf("%s: pattern field %q is not a string field", entity, name)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid pattern for %q: %w", entity, name, err)
+		}
+		compiled.patterns = append(compiled.patterns, fieldPattern{field: fd, re: re})
+	}
+
+	return compiled, nil
+}
@@ -0,0 +1,275 @@
+package validation
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"kafka_test/models"
+
+	"google.golang.org/genproto/googleapis/type/date"
+	"google.golang.org/protobuf/proto"
+)
+
+// Rule names reported in violations
+const (
+	RuleRequired          = "required"
+	RulePattern           = "pattern"
+	RuleValidPeriod       = "valid_period"
+	RuleTransactionPeriod = "transaction_period"
+	RuleVersionMonotonic  = "version_monotonic"
+	RuleParentExists      = "parent_exists"
+)
+
+// Severities of violations. Warnings are reported, but neither quarantine a message
+// nor fail cdc validate.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Violation describes a single rule failure for one record
+type Violation struct {
+	RequestID     string `json:"request_id"`
+	MessageNumber uint32 `json:"message_number"`
+	RecordIndex   int    `json:"record_index"`
+	Entity        string `json:"entity"`
+	Biid          string `json:"biid"`
+	Rule          string `json:"rule"`
+	Field         string `json:"field,omitempty"`
+	Message       string `json:"message"`
+	Severity      string `json:"severity"`
+}
+
+// String returns a formatted string representation of the violation
+func (v Violation) String() string {
+	rule := v.Rule
+	if v.Severity == SeverityWarning {
+		rule += " (warning)"
+	}
+	return fmt.Sprintf("RequestID: %s, MessageNumber: %d, Record: %d, %s %s - %s: %s",
+		v.RequestID, v.MessageNumber, v.RecordIndex, v.Entity, v.Biid, rule, v.Message)
+}
+
+// HasErrors reports whether any of the violations is not a warning
+func HasErrors(violations []Violation) bool {
+	for _, violation := range violations {
+		if violation.Severity != SeverityWarning {
+			return true
+		}
+	}
+	return false
+}
+
+// Options bounds the state of a Validator
+type Options struct {
+	// Size is the most versions and departments remembered each, the least recently
+	// used are forgotten beyond it. Zero remembers all of them.
+	Size int
+	// TTL forgets a version or department not used for this long, zero never does
+	TTL time.Duration
+}
+
+// Validator evaluates rules against ChangeDataMessages.
+// It is stateful: version monotonicity and parent existence are checked
+// against the records it has already seen, so feed messages in topic order.
+// Entities and departments it has forgotten are not checked.
+type Validator struct {
+	mu    sync.Mutex
+	rules *compiledRules
+
+	// versions holds the last seen version keyed by entity/tenant/biid
+	versions *recent[seenVersion]
+	// departments holds the department BIIDs known to exist, keyed by tenant/biid
+	departments *recent[struct{}]
+}
+
+// seenVersion is the last version of an entity and the fingerprint of its record,
+// which tells a redelivery from another record reusing the version
+type seenVersion struct {
+	version     uint32
+	fingerprint uint64
+}
+
+// NewValidator creates a new Validator from the given rules
+func NewValidator(rules *Rules, opts Options) (*Validator, error) {
+	compiled, err := rules.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Validator{
+		rules:       compiled,
+		versions:    newRecent[seenVersion](opts.Size, opts.TTL),
+		departments: newRecent[struct{}](opts.Size, opts.TTL),
+	}, nil
+}
+
+// Validate checks every record of the message and returns the violations found
+func (v *Validator) Validate(msg *models.ChangeDataMessage) []Violation {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	// Register departments created in this message first, so that a child
+	// listed before its parent in the same message is not rejected
+	for _, record := range msg.Records {
+		if dept := record.GetDepartment(); dept != nil && record.OperationType != models.Record_DELETE {
+			v.departments.put(departmentKey(dept.TenantUid, dept.Biid), struct{}{})
+		}
+	}
+
+	var violations []Violation
+	for i, record := range msg.Records {
+		report := func(entity, biid, rule, field, message string) {
+			violations = append(violations, Violation{
+				RequestID:     msg.RequestId,
+				MessageNumber: msg.MessageNumber,
+				RecordIndex:   i,
+				Entity:        entity,
+				Biid:          biid,
+				Rule:          rule,
+				Field:         field,
+				Message:       message,
+				Severity:      v.rules.entity(entity).severity(rule),
+			})
+		}
+
+		switch data := record.Data.(type) {
+		case *models.Record_Department:
+			v.validateDepartment(record.OperationType, data.Department, report)
+		case *models.Record_AggregationPattern:
+			v.validateAggregationPattern(record.OperationType, data.AggregationPattern, report)
+		}
+	}
+
+	return violations
+}
+
+type reportFunc func(entity, biid, rule, field, message string)
+
+func (v *Validator) validateDepartment(op models.Record_OperationType, dept *models.Department, report reportFunc) {
+	const entity = "department"
+	rules := v.rules.department
+
+	checkFields(entity, dept, dept.Biid, rules, report)
+
+	if rules.rules.ValidPeriod && dept.ValidFrom != nil && dept.ValidTo != nil {
+		if compareDates(dept.ValidFrom, dept.ValidTo) > 0 {
+			report(entity, dept.Biid, RuleValidPeriod, "valid_from",
+				fmt.Sprintf("valid_from %s is after valid_to %s", formatDate(dept.ValidFrom), formatDate(dept.ValidTo)))
+		}
+	}
+
+	if rules.rules.TransactionPeriod && dept.TransactionFrom != nil && dept.TransactionTo != nil {
+		if dept.TransactionFrom.AsTime().After(dept.TransactionTo.AsTime()) {
+			report(entity, dept.Biid, RuleTransactionPeriod, "transaction_from",
+				fmt.Sprintf("transaction_from %s is after transaction_to %s", dept.TransactionFrom.AsTime(), dept.TransactionTo.AsTime()))
+		}
+	}
+
+	if rules.rules.VersionMonotonic {
+		v.checkVersion(entity, dept, dept.TenantUid, dept.Biid, dept.Version, report)
+	}
+
+	if rules.rules.ParentExists && dept.GetParentBiid() != "" {
+		if _, ok := v.departments.get(departmentKey(dept.TenantUid, dept.GetParentBiid())); !ok {
+			report(entity, dept.Biid, RuleParentExists, "parent_biid",
+				fmt.Sprintf("parent department %s is unknown", dept.GetParentBiid()))
+		}
+	}
+
+	if op == models.Record_DELETE {
+		v.departments.remove(departmentKey(dept.TenantUid, dept.Biid))
+	}
+}
+
+func (v *Validator) validateAggregationPattern(op models.Record_OperationType, agg *models.AggregationPattern, report reportFunc) {
+	const entity = "aggregation_pattern"
+	rules := v.rules.aggregationPattern
+
+	checkFields(entity, agg, agg.Biid, rules, report)
+
+	if rules.rules.TransactionPeriod && agg.TransactionFrom != nil && agg.TransactionTo != nil {
+		if agg.TransactionFrom.AsTime().After(agg.TransactionTo.AsTime()) {
+			report(entity, agg.Biid, RuleTransactionPeriod, "transaction_from",
+				fmt.Sprintf("transaction_from %s is after transaction_to %s", agg.TransactionFrom.AsTime(), agg.TransactionTo.AsTime()))
+		}
+	}
+
+	if rules.rules.VersionMonotonic {
+		v.checkVersion(entity, agg, agg.TenantUid, agg.Biid, agg.Version, report)
+	}
+}
+
+// checkFields applies the required and pattern rules using protoreflect
+func checkFields(entity string, msg proto.Message, biid string, rules *compiledEntity, report reportFunc) {
+	m := msg.ProtoReflect()
+
+	for _, fd := range rules.required {
+		if !m.Has(fd) {
+			report(entity, biid, RuleRequired, string(fd.Name()),
+				fmt.Sprintf("%s is required", fd.Name()))
+		}
+	}
+
+	for _, p := range rules.patterns {
+		if !m.Has(p.field) {
+			continue
+		}
+		value := m.Get(p.field).String()
+		if !p.re.MatchString(value) {
+			report(entity, biid, RulePattern, string(p.field.Name()),
+				fmt.Sprintf("%s %q does not match %s", p.field.Name(), value, p.re))
+		}
+	}
+}
+
+// checkVersion requires each new version of a BIID to be greater than the last one seen.
+// The same record again is a redelivery, e.g. after a rebalance, and is valid.
+func (v *Validator) checkVersion(entity string, record proto.Message, tenantUID uint64, biid string, version uint32, report reportFunc) {
+	key := fmt.Sprintf("%s/%d/%s", entity, tenantUID, biid)
+	current := seenVersion{version: version, fingerprint: fingerprint(record)}
+	if previous, ok := v.versions.get(key); ok {
+		switch {
+		case version < previous.version:
+			report(entity, biid, RuleVersionMonotonic, "version",
+				fmt.Sprintf("version %d is older than previous version %d", version, previous.version))
+			return
+		case version == previous.version && current.fingerprint != previous.fingerprint:
+			report(entity, biid, RuleVersionMonotonic, "version",
+				fmt.Sprintf("version %d is not greater than previous version %d", version, previous.version))
+			return
+		}
+	}
+	v.versions.put(key, current)
+}
+
+// fingerprint hashes the deterministic encoding of a record
+func fingerprint(record proto.Message) uint64 {
+	data, _ := proto.MarshalOptions{Deterministic: true}.Marshal(record)
+	hash := fnv.New64a()
+	hash.Write(data)
+	return hash.Sum64()
+}
+
+func departmentKey(tenantUID uint64, biid string) string {
+	return fmt.Sprintf("%d/%s", tenantUID, biid)
+}
+
+// compareDates returns -1, 0 or 1 depending on whether a is before, equal to or after b
+func compareDates(a, b *date.Date) int {
+	for _, pair := range [][2]int32{{a.Year, b.Year}, {a.Month, b.Month}, {a.Day, b.Day}} {
+		switch {
+		case pair[0] < pair[1]:
+			return -1
+		case pair[0] > pair[1]:
+			return 1
+		}
+	}
+	return 0
+}
+
+func formatDate(d *date.Date) string {
+	return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+}
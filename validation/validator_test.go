@@ -0,0 +1,220 @@
+package validation
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"kafka_test/models"
+
+	"google.golang.org/genproto/googleapis/type/date"
+)
+
+func department(biid, parent string, version uint32, name string) *models.Record {
+	dept := &models.Department{
+		TenantUid: 1,
+		Biid:      biid,
+		Code:      strings.ToUpper(biid),
+		Name:      name,
+		Version:   version,
+	}
+	if parent != "" {
+		dept.ParentBiid = &parent
+	}
+	return &models.Record{OperationType: models.Record_UPDATE, Data: &models.Record_Department{Department: dept}}
+}
+
+func message(records ...*models.Record) *models.ChangeDataMessage {
+	return &models.ChangeDataMessage{RequestId: "r1", MessageNumber: 1, TotalMessageCount: 1, Records: records}
+}
+
+func newValidator(t *testing.T, rules EntityRules, opts Options) *Validator {
+	t.Helper()
+	validator, err := NewValidator(&Rules{Department: rules}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return validator
+}
+
+// rulesOf returns the rules of the violations, with the severity of warnings
+func rulesOf(violations []Violation) string {
+	names := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		name := violation.Rule
+		if violation.Severity == SeverityWarning {
+			name += "(warning)"
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestFieldRules(t *testing.T) {
+	validator := newValidator(t, EntityRules{
+		Required:          []string{"code", "valid_from"},
+		Patterns:          map[string]string{"code": "^[A-Z0-9]+$"},
+		ValidPeriod:       true,
+		TransactionPeriod: true,
+	}, Options{})
+
+	record := department("d-1", "", 1, "One")
+	dept := record.GetDepartment()
+	dept.ValidFrom = &date.Date{Year: 2024, Month: 2, Day: 1}
+	dept.ValidTo = &date.Date{Year: 2024, Month: 1, Day: 31}
+
+	if got := rulesOf(validator.Validate(message(record))); got != "pattern,valid_period" {
+		t.Fatalf("violations %s, want pattern,valid_period", got)
+	}
+
+	dept.Code = ""
+	dept.ValidTo = nil
+	if got := rulesOf(validator.Validate(message(record))); got != "required" {
+		t.Fatalf("violations %s, want required", got)
+	}
+}
+
+func TestVersionMonotonic(t *testing.T) {
+	validator := newValidator(t, EntityRules{VersionMonotonic: true}, Options{})
+
+	tests := []struct {
+		name   string
+		record *models.Record
+		want   string
+	}{
+		{"first version", department("d1", "", 2, "One"), ""},
+		{"redelivery", department("d1", "", 2, "One"), ""},
+		{"same version with other content", department("d1", "", 2, "Other"), "version_monotonic"},
+		{"older version", department("d1", "", 1, "One"), "version_monotonic"},
+		{"newer version", department("d1", "", 3, "One (rev 3)"), ""},
+		{"other entity", department("d2", "", 1, "Two"), ""},
+	}
+	for _, test := range tests {
+		if got := rulesOf(validator.Validate(message(test.record))); got != test.want {
+			t.Fatalf("%s: violations %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParentExists(t *testing.T) {
+	validator := newValidator(t, EntityRules{ParentExists: true}, Options{})
+
+	// A child listed before its parent in the same message is valid
+	if got := rulesOf(validator.Validate(message(department("d2", "d1", 1, "Two"), department("d1", "", 1, "One")))); got != "" {
+		t.Fatalf("violations %s, want none", got)
+	}
+
+	deleted := department("d1", "", 2, "One")
+	deleted.OperationType = models.Record_DELETE
+	validator.Validate(message(deleted))
+	if got := rulesOf(validator.Validate(message(department("d3", "d1", 1, "Three")))); got != "parent_exists" {
+		t.Fatalf("violations %s, want parent_exists after the parent was deleted", got)
+	}
+}
+
+func TestSeverity(t *testing.T) {
+	validator := newValidator(t, EntityRules{
+		Required:         []string{"valid_from"},
+		VersionMonotonic: true,
+		ParentExists:     true,
+		Severity:         map[string]string{RuleParentExists: SeverityWarning},
+	}, Options{})
+
+	violations := validator.Validate(message(department("d1", "unknown", 1, "One")))
+	if got := rulesOf(violations); got != "required,parent_exists(warning)" {
+		t.Fatalf("violations %s, want required,parent_exists(warning)", got)
+	}
+	if !HasErrors(violations) {
+		t.Fatal("required violation not an error")
+	}
+	if HasErrors(violations[1:]) {
+		t.Fatal("warning counted as an error")
+	}
+
+	// Rules not listed are errors, version_monotonic included
+	violations = validator.Validate(message(department("d1", "", 0, "One")))
+	if !HasErrors(violations) || rulesOf(violations) != "required,version_monotonic" {
+		t.Fatalf("violations %s, want required,version_monotonic as errors", rulesOf(violations))
+	}
+}
+
+func TestStateIsBounded(t *testing.T) {
+	validator := newValidator(t, EntityRules{VersionMonotonic: true}, Options{Size: 2, TTL: time.Hour})
+	validator.Validate(message(department("d1", "", 5, "One")))
+	validator.Validate(message(department("d2", "", 5, "Two")))
+	validator.Validate(message(department("d3", "", 5, "Three")))
+
+	if validator.versions.order.Len() != 2 || validator.departments.order.Len() != 2 {
+		t.Fatalf("validator remembers %d versions and %d departments, want 2 each",
+			validator.versions.order.Len(), validator.departments.order.Len())
+	}
+	// d1 was forgotten, so its older version is not checked
+	if got := rulesOf(validator.Validate(message(department("d1", "", 1, "One")))); got != "" {
+		t.Fatalf("violations %s for a forgotten entity, want none", got)
+	}
+	if got := rulesOf(validator.Validate(message(department("d3", "", 1, "Three")))); got != "version_monotonic" {
+		t.Fatalf("violations %s for a remembered entity, want version_monotonic", got)
+	}
+}
+
+func TestStateExpires(t *testing.T) {
+	validator := newValidator(t, EntityRules{VersionMonotonic: true, ParentExists: true}, Options{Size: 10, TTL: time.Millisecond})
+	validator.Validate(message(department("d1", "", 5, "One")))
+	time.Sleep(5 * time.Millisecond)
+
+	if got := rulesOf(validator.Validate(message(department("d1", "", 1, "One")))); got != "" {
+		t.Fatalf("violations %s for an expired entity, want none", got)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if got := rulesOf(validator.Validate(message(department("d2", "d1", 1, "Two")))); got != "parent_exists" {
+		t.Fatalf("violations %s for an expired parent, want parent_exists", got)
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	write := func(content string) string {
+		path := filepath.Join(t.TempDir(), "rules.yaml")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	rules, err := LoadRules("rules.example.yaml")
+	if err != nil {
+		t.Fatalf("example rules: %v", err)
+	}
+	if !rules.Department.VersionMonotonic || len(rules.Department.Required) == 0 {
+		t.Fatalf("example rules not loaded: %+v", rules.Department)
+	}
+
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"department:\n  required: [nope]\n", `unknown required field "nope"`},
+		{"department:\n  patterns: {code: \"[\"}\n", "invalid pattern"},
+		{"department:\n  severity: {nope: warning}\n", `unknown rule "nope"`},
+		{"department:\n  severity: {required: info}\n", `invalid severity "info"`},
+		{"aggregation_pattern:\n  parent_exists: true\n", "parent_exists is not supported"},
+	}
+	for _, test := range tests {
+		if _, err := LoadRules(write(test.content)); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Fatalf("rules %q: got %v, want an error containing %q", test.content, err, test.want)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	report := NewReport()
+	report.Add(2, []Violation{{Rule: RuleParentExists, Severity: SeverityWarning}})
+	if report.HasErrors() {
+		t.Fatal("report of warnings has errors")
+	}
+	report.Add(1, []Violation{{Rule: RuleRequired, Severity: SeverityError}})
+	if !report.HasErrors() || report.Warnings != 1 || report.RecordsChecked != 3 || report.RuleCounts[RuleRequired] != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}